@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/services"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const issuePageSize = 100
+
+// eachIssue calls fn for every issue created between from and to, oldest
+// first. Either bound may be nil.
+func eachIssue(ctx context.Context, app *app, from, to *time.Time, fn func(issue *models.Issue) error) error {
+	filters := services.SearchFilters{
+		StartDate: from,
+		EndDate:   to,
+		SortBy:    "date",
+		SortOrder: "asc",
+		Limit:     issuePageSize,
+	}
+	for filters.Page = 1; ; filters.Page++ {
+		result, err := app.searchService.Search(ctx, filters)
+		if err != nil {
+			return err
+		}
+		for i := range result.Issues {
+			if err := fn(&result.Issues[i]); err != nil {
+				return err
+			}
+		}
+		if int64(len(result.Issues)) < issuePageSize {
+			return nil
+		}
+	}
+}
+
+// reclassify asks the AI service to categorize every issue created in the
+// date range again and stores the new categories.
+func reclassify(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("reclassify", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "first creation date to include")
+	toFlag := flags.String("to", "", "last creation date to include")
+	dryRun := flags.Bool("dry-run", false, "print the new categories without storing them")
+	flags.Parse(args)
+
+	if *fromFlag == "" || *toFlag == "" {
+		return errors.New("-from and -to are required")
+	}
+	from, err := parseDate(*fromFlag, false)
+	if err != nil {
+		return err
+	}
+	to, err := parseDate(*toFlag, true)
+	if err != nil {
+		return err
+	}
+
+	var checked, changed, failed int
+	err = eachIssue(ctx, app, from, to, func(issue *models.Issue) error {
+		checked++
+		prediction, err := app.aiService.PredictCategory(ctx, issue.Title, issue.Description)
+		if err != nil {
+			failed++
+			slog.Warn("failed to classify issue", "issue_id", issue.ID.Hex(), "error", err)
+			return nil
+		}
+		if prediction.Category == issue.Category {
+			return nil
+		}
+
+		changed++
+		fmt.Printf("%s  %s -> %s  %s\n", issue.ID.Hex(), issue.Category, prediction.Category, issue.Title)
+		if *dryRun {
+			return nil
+		}
+		return app.issueService.UpdateIssue(ctx, systemActor, issue.ID, bson.M{"category": prediction.Category})
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Checked %d issues, %d changed category, %d could not be classified\n", checked, changed, failed)
+	return nil
+}
+
+// exportIssues writes issues as JSON, one per line.
+func exportIssues(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("export-issues", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "first creation date to include")
+	toFlag := flags.String("to", "", "last creation date to include")
+	output := flags.String("o", "", "output file (standard output if empty)")
+	flags.Parse(args)
+
+	from, err := parseDate(*fromFlag, false)
+	if err != nil {
+		return err
+	}
+	to, err := parseDate(*toFlag, true)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	count := 0
+	err = eachIssue(ctx, app, from, to, func(issue *models.Issue) error {
+		count++
+		return encoder.Encode(issue)
+	})
+	if err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d issues\n", count)
+	return nil
+}
+
+// importIssues reads issues written by export-issues. Issues that already
+// exist are skipped.
+func importIssues(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("import-issues", flag.ExitOnError)
+	input := flags.String("i", "", "input file (standard input if empty)")
+	flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var imported, skipped int
+	decoder := json.NewDecoder(r)
+	for {
+		var issue models.Issue
+		if err := decoder.Decode(&issue); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read issue %d: %v", imported+skipped+1, err)
+		}
+
+		err := app.issueService.ImportIssue(ctx, &issue)
+		if err == repository.ErrDuplicate {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import issue %s: %v", issue.ID.Hex(), err)
+		}
+		imported++
+	}
+
+	fmt.Printf("Imported %d issues, skipped %d that already exist\n", imported, skipped)
+	return nil
+}
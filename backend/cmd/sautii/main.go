@@ -0,0 +1,166 @@
+// Command sautii runs administrative tasks against the same databases and
+// services as the API server. Run "sautii help" for the list of commands.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/services"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"create-admin":  {"create-admin -email EMAIL -username NAME [-password PASSWORD]", createAdmin},
+	"grant-role":    {"grant-role -email EMAIL -role ROLE [-reason TEXT]", grantRole},
+	"migrate":       {"migrate [status]", migrate},
+	"reindex":       {"reindex", reindex},
+	"reclassify":    {"reclassify -from DATE -to DATE [-dry-run]", reclassify},
+	"seed":          {"seed [-password PASSWORD]", seed},
+	"purge-tokens":  {"purge-tokens", purgeTokens},
+	"export-issues": {"export-issues [-from DATE] [-to DATE] [-o FILE]", exportIssues},
+	"import-issues": {"import-issues [-i FILE]", importIssues},
+}
+
+// app holds the services commands use, built from the same environment
+// variables as the server.
+type app struct {
+	db            *mongo.Database
+	store         *repository.Store
+	authService   *services.AuthService
+	roleService   *services.RoleService
+	issueService  *services.IssueService
+	searchService *services.SearchService
+	aiService     *services.AIService
+}
+
+// systemActor performs changes made from the command line. Audit entries
+// record it with an empty actor ID and the IP "cli".
+var systemActor = services.Actor{Role: models.RoleAdmin, IP: "cli"}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		usage()
+		return
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok && os.Args[1] != "config" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	// Flags belong to the commands, so settings come from the environment
+	// and the config file only
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if os.Args[1] == "config" {
+		cfg.Print(os.Stdout)
+		return
+	}
+
+	// Command output goes to stdout; logs go to stderr as text
+	slog.SetDefault(logging.New(config.Logging{Level: cfg.Logging.Level, Format: "text"}, os.Stderr))
+
+	ctx := context.Background()
+	app, err := newApp(ctx, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer app.db.Client().Disconnect(ctx)
+	defer app.store.Close()
+
+	if err := cmd.run(ctx, app, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: sautii COMMAND [flags]\n\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "  config  (print the effective configuration)")
+}
+
+func newApp(ctx context.Context, cfg *config.Config) (*app, error) {
+	connectCtx, cancel := context.WithTimeout(ctx, cfg.Database.ConnectTimeout)
+	defer cancel()
+	client, err := mongo.Connect(connectCtx, options.Client().ApplyURI(cfg.Database.MongoURI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(connectCtx, nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %v", err)
+	}
+	db := client.Database(cfg.Database.MongoDatabase)
+
+	// Like MongoDB, PostgreSQL is only migrated by "sautii migrate"
+	storeConfig := cfg.Database
+	storeConfig.AutoMigrate = false
+	store, err := repository.Open(ctx, db, storeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commands never sign tokens, so the signing keys are not loaded
+	keyService := services.NewKeyService(db, cfg.Auth)
+	auditService := services.NewAuditService(db)
+	emailService := services.NewEmailService(cfg.Email, cfg.Server.AppBaseURL)
+	authService := services.NewAuthService(store, emailService, keyService, auditService, cfg.Auth)
+	jurisdictionService := services.NewJurisdictionService(db, store)
+
+	return &app{
+		db:            db,
+		store:         store,
+		authService:   authService,
+		roleService:   services.NewRoleService(db, authService, auditService),
+		issueService:  services.NewIssueService(store, jurisdictionService),
+		searchService: services.NewSearchService(store),
+		aiService:     services.NewAIService(cfg.AI),
+	}, nil
+}
+
+// parseDate accepts a date (2024-01-31) or an RFC 3339 timestamp. With
+// endOfDay a date means the last moment of that day, so it can be used as an
+// inclusive upper bound.
+func parseDate(value string, endOfDay bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return &t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("invalid date %q, use YYYY-MM-DD or RFC 3339", value)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/migrations"
+)
+
+// migrate applies pending migrations, or with "status" lists applied and
+// pending ones.
+func migrate(ctx context.Context, app *app, args []string) error {
+	if len(args) > 0 && args[0] == "status" {
+		applied, err := migrations.Applied(ctx, app.db)
+		if err != nil {
+			return err
+		}
+		pending, err := migrations.Pending(ctx, app.db)
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			fmt.Printf("%4d  %-28s  %s\n", migration.Version, "applied "+migration.AppliedAt.Format(time.RFC3339), migration.Description)
+		}
+		for _, migration := range pending {
+			fmt.Printf("%4d  %-28s  %s\n", migration.Version, "pending", migration.Description)
+		}
+
+		postgres, err := app.store.Migrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range postgres {
+			status := "pending"
+			if !migration.AppliedAt.IsZero() {
+				status = "applied " + migration.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4s  %-28s  %s (postgres)\n", migration.Version, status, migration.File)
+		}
+		return nil
+	}
+
+	applied, err := migrations.Run(ctx, app.db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d migrations\n", len(applied))
+
+	pending, err := app.store.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if err := app.store.Migrate(ctx); err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		fmt.Printf("Applied %d postgres migrations\n", len(pending))
+	}
+	return nil
+}
+
+func reindex(ctx context.Context, app *app, args []string) error {
+	if err := app.searchService.Reindex(ctx); err != nil {
+		return err
+	}
+	fmt.Println("Rebuilt search indexes")
+	return nil
+}
+
+func purgeTokens(ctx context.Context, app *app, args []string) error {
+	tokens, attempts, err := app.authService.PurgeExpiredTokens(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d expired tokens and %d login attempt records\n", tokens, attempts)
+	return nil
+}
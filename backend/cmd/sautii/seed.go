@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+)
+
+var demoUsers = []struct {
+	email, username, role string
+}{
+	{"citizen@demo.sautii.org", "demo_citizen", models.RoleCitizen},
+	{"moderator@demo.sautii.org", "demo_moderator", models.RoleModerator},
+	{"official@demo.sautii.org", "demo_official", models.RoleSuperOfficial},
+}
+
+var demoIssues = []models.Issue{
+	{
+		Title:       "Burst water pipe on Moi Avenue",
+		Description: "Clean water has been flowing onto the road for three days near the Kenyatta Avenue junction.",
+		Category:    "INFRASTRUCTURE",
+		Priority:    "high",
+		Location:    &models.Location{Lat: -1.2833, Lng: 36.8225, Address: "Moi Avenue, Nairobi CBD"},
+		Tags:        []string{"water", "roads"},
+	},
+	{
+		Title:       "Street lights out along Ngong Road",
+		Description: "The street lights between Prestige Plaza and Adams Arcade have been off for two weeks.",
+		Category:    "SAFETY",
+		Priority:    "medium",
+		Location:    &models.Location{Lat: -1.3003, Lng: 36.7800, Address: "Ngong Road, Nairobi"},
+		Tags:        []string{"lighting"},
+	},
+	{
+		Title:       "Uncollected garbage in Kibera",
+		Description: "Garbage has not been collected from the Olympic estate collection point this month.",
+		Category:    "ENVIRONMENT",
+		Priority:    "high",
+		Location:    &models.Location{Lat: -1.3133, Lng: 36.7876, Address: "Kibera, Nairobi"},
+		Tags:        []string{"waste", "sanitation"},
+	},
+	{
+		Title:       "Potholes on Thika Road service lane",
+		Description: "Deep potholes on the service lane near Roysambu are damaging matatus and cars.",
+		Category:    "INFRASTRUCTURE",
+		Priority:    "medium",
+		Location:    &models.Location{Lat: -1.2180, Lng: 36.8887, Address: "Roysambu, Thika Road"},
+		Tags:        []string{"roads"},
+	},
+	{
+		Title:       "Dispensary in Kayole out of medicine",
+		Description: "The Kayole dispensary has had no malaria medicine or painkillers for a week.",
+		Category:    "HEALTHCARE",
+		Priority:    "critical",
+		Location:    &models.Location{Lat: -1.2744, Lng: 36.9126, Address: "Kayole, Nairobi"},
+		Tags:        []string{"health"},
+	},
+}
+
+// seed creates demo accounts and issues around Nairobi. Running it again
+// leaves existing demo data as it is.
+func seed(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	password := flags.String("password", "demo-password", "password for the demo accounts")
+	flags.Parse(args)
+
+	var citizen *models.User
+	created := false
+	for _, demo := range demoUsers {
+		user, err := app.authService.GetUserByEmail(ctx, demo.email)
+		if err != nil {
+			if user, err = app.authService.Register(ctx, demo.email, demo.username, *password); err != nil {
+				return fmt.Errorf("failed to create %s: %v", demo.email, err)
+			}
+			verified, verifiedAt := true, time.Now()
+			err = app.authService.UpdateUser(ctx, user.ID, repository.UserUpdate{IsVerified: &verified, VerifiedAt: &verifiedAt})
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %v", demo.email, err)
+			}
+			if err := app.roleService.GrantRole(ctx, systemActor, user.ID, demo.role, "demo data"); err != nil {
+				return err
+			}
+			fmt.Printf("Created %s %s\n", demo.role, demo.email)
+			if demo.role == models.RoleCitizen {
+				created = true
+			}
+		}
+		if demo.role == models.RoleCitizen {
+			citizen = user
+		}
+	}
+
+	// The demo issues are reported by the demo citizen, so they already
+	// exist if the citizen did
+	if !created {
+		fmt.Println("Demo issues already exist")
+		return nil
+	}
+	for _, demo := range demoIssues {
+		issue := demo
+		issue.Status = "open"
+		issue.CreatedBy = citizen.ID
+		if err := app.issueService.CreateIssue(ctx, &issue); err != nil {
+			return fmt.Errorf("failed to create issue %q: %v", issue.Title, err)
+		}
+	}
+	fmt.Printf("Created %d issues\n", len(demoIssues))
+	return nil
+}
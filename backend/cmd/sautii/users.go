@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+)
+
+// createAdmin registers a verified admin account. Without -password the
+// password is read from the first line of standard input.
+func createAdmin(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "email address")
+	username := flags.String("username", "", "username")
+	password := flags.String("password", "", "password (read from stdin if empty)")
+	flags.Parse(args)
+
+	if *email == "" || *username == "" {
+		return errors.New("-email and -username are required")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read password: %v", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		return errors.New("password is empty")
+	}
+
+	user, err := app.authService.Register(ctx, *email, *username, *password)
+	if err != nil {
+		return err
+	}
+
+	verified, verifiedAt := true, time.Now()
+	err = app.authService.UpdateUser(ctx, user.ID, repository.UserUpdate{IsVerified: &verified, VerifiedAt: &verifiedAt})
+	if err != nil {
+		return fmt.Errorf("failed to verify user: %v", err)
+	}
+	if err := app.roleService.GrantRole(ctx, systemActor, user.ID, models.RoleAdmin, "created with sautii create-admin"); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created admin %s (%s)\n", user.Email, user.ID.Hex())
+	return nil
+}
+
+func grantRole(ctx context.Context, app *app, args []string) error {
+	flags := flag.NewFlagSet("grant-role", flag.ExitOnError)
+	email := flags.String("email", "", "email address of the user")
+	role := flags.String("role", "", "role to grant")
+	reason := flags.String("reason", "granted with sautii grant-role", "reason recorded in the audit log")
+	flags.Parse(args)
+
+	if *email == "" || *role == "" {
+		return errors.New("-email and -role are required")
+	}
+
+	user, err := app.authService.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if err := app.roleService.GrantRole(ctx, systemActor, user.ID, *role, *reason); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", user.Email, *role)
+	return nil
+}
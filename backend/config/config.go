@@ -0,0 +1,524 @@
+// Package config loads the backend's settings. Each setting has a default
+// and can be set in a dotenv file, in the environment or with a command-line
+// flag, each overriding the one before. The environment variable JWT_SECRET
+// is the flag -jwt-secret.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	Server    Server
+	Database  Database
+	Auth      Auth
+	Email     Email
+	AI        AI
+	Limits    Limits
+	Logging   Logging
+	Telemetry Telemetry
+
+	flags *flag.FlagSet
+}
+
+type Server struct {
+	Port string
+	// AppBaseURL is the address of the frontend, used in emails and
+	// identity provider redirects
+	AppBaseURL string
+
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds how long shutdown waits for requests and
+	// background work to finish
+	ShutdownTimeout time.Duration
+	MaxHeaderBytes  int
+	MaxBodyBytes    int64
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For
+	// entries are believed. Requests from anywhere else are attributed to
+	// their peer address.
+	TrustedProxies []netip.Prefix
+
+	// TLS is served when both files are set. Renewed certificates are
+	// picked up without a restart.
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+type Database struct {
+	MongoURI       string
+	MongoDatabase  string
+	ConnectTimeout time.Duration
+	AutoMigrate    bool
+	// StorageBackend is "mongo" or "postgres"
+	StorageBackend string
+	PostgresURL    string
+}
+
+type Auth struct {
+	JWTSecret       string
+	Issuer          string
+	Audience        string
+	SigningAlg      string
+	KeyRotation     time.Duration
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// UnverifiedRestrictions are the actions accounts may not take until
+	// they verify their email, or "none"
+	UnverifiedRestrictions []string
+	// OIDCProviders are the identity providers named in OIDC_PROVIDERS
+	OIDCProviders []OIDCProvider
+}
+
+// OIDCProvider configures one identity provider from OIDC_<NAME>_ISSUER,
+// OIDC_<NAME>_CLIENT_ID and so on. The issuer can be any URL serving OpenID
+// discovery, including a mock provider on localhost.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type Email struct {
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+type AI struct {
+	OpenAIAPIKey string
+	Model        string
+	Timeout      time.Duration
+}
+
+type Limits struct {
+	// RateLimitStore is "memory" or "mongo"
+	RateLimitStore string
+
+	// Requests allowed per user, API key or IP in each route group
+	Auth     RateLimit
+	Issues   RateLimit
+	Votes    RateLimit
+	Comments RateLimit
+	Search   RateLimit
+}
+
+// RateLimit allows Limit requests per Period, written "10/1m".
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimits returns the rate limit of each route group by name.
+func (l Limits) RateLimits() map[string]RateLimit {
+	return map[string]RateLimit{
+		"auth":     l.Auth,
+		"issues":   l.Issues,
+		"votes":    l.Votes,
+		"comments": l.Comments,
+		"search":   l.Search,
+	}
+}
+
+type Logging struct {
+	// Level is "debug", "info", "warn" or "error"
+	Level string
+	// Format is "json" or "text"
+	Format string
+}
+
+type Telemetry struct {
+	// MetricsToken, if set, must be sent as a bearer token to read /metrics
+	MetricsToken string
+
+	// OTLPEndpoint is the OTLP/HTTP collector traces are sent to, such as
+	// http://localhost:4318. Tracing is off if empty.
+	OTLPEndpoint string
+	ServiceName  string
+	// TraceSampleRatio is the share of new traces that are recorded;
+	// requests carrying a sampled parent trace are always recorded
+	TraceSampleRatio float64
+}
+
+// secrets are printed redacted, as are the client secrets of identity
+// providers.
+var secrets = map[string]bool{
+	"JWT_SECRET":     true,
+	"SMTP_PASSWORD":  true,
+	"OPENAI_API_KEY": true,
+	"METRICS_TOKEN":  true,
+}
+
+// urls are printed with their password redacted.
+var urls = map[string]bool{
+	"MONGODB_URI":   true,
+	"DB_CONNECTION": true,
+}
+
+// register defines the flags, including ones for each identity provider
+// named in providers.
+func (c *Config) register(fs *flag.FlagSet, providers []string) {
+	str := func(p *string, key, value, usage string) { fs.StringVar(p, flagName(key), value, usage) }
+	dur := func(p *time.Duration, key string, value time.Duration, usage string) {
+		fs.DurationVar(p, flagName(key), value, usage)
+	}
+	list := func(p *[]string, key string, value []string, usage string) {
+		*p = value
+		fs.Var(stringList{p}, flagName(key), usage)
+	}
+	rate := func(p *RateLimit, key string, value RateLimit, usage string) {
+		*p = value
+		fs.Var(rateLimitValue{p}, flagName(key), usage)
+	}
+
+	str(&c.Server.Port, "PORT", "8080", "port the API listens on")
+	str(&c.Server.AppBaseURL, "APP_BASE_URL", "http://localhost:5173", "address of the frontend")
+	dur(&c.Server.ReadTimeout, "HTTP_READ_TIMEOUT", 15*time.Second, "time allowed to read a request")
+	dur(&c.Server.ReadHeaderTimeout, "HTTP_READ_HEADER_TIMEOUT", 5*time.Second, "time allowed to read request headers")
+	dur(&c.Server.WriteTimeout, "HTTP_WRITE_TIMEOUT", time.Minute, "time allowed to write a response")
+	dur(&c.Server.IdleTimeout, "HTTP_IDLE_TIMEOUT", 2*time.Minute, "how long idle keep-alive connections stay open")
+	dur(&c.Server.ShutdownTimeout, "SHUTDOWN_TIMEOUT", 30*time.Second, "time allowed for requests and workers to finish on shutdown")
+	fs.IntVar(&c.Server.MaxHeaderBytes, flagName("HTTP_MAX_HEADER_BYTES"), 1<<20, "maximum size of request headers")
+	fs.Int64Var(&c.Server.MaxBodyBytes, flagName("HTTP_MAX_BODY_BYTES"), 1<<20, "maximum size of request bodies other than messages with attachments")
+	fs.Var(prefixList{&c.Server.TrustedProxies}, flagName("TRUSTED_PROXIES"), "comma-separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For is trusted")
+	str(&c.Server.TLSCertFile, "TLS_CERT_FILE", "", "TLS certificate chain (PEM); serves plain HTTP if empty")
+	str(&c.Server.TLSKeyFile, "TLS_KEY_FILE", "", "TLS private key (PEM)")
+
+	str(&c.Database.MongoURI, "MONGODB_URI", "", "MongoDB connection string")
+	str(&c.Database.MongoDatabase, "MONGODB_DATABASE", "sautii", "MongoDB database name")
+	dur(&c.Database.ConnectTimeout, "DB_CONNECT_TIMEOUT", 10*time.Second, "timeout for connecting to the databases")
+	fs.BoolVar(&c.Database.AutoMigrate, flagName("AUTO_MIGRATE"), true, "apply pending migrations on startup")
+	str(&c.Database.StorageBackend, "STORAGE_BACKEND", "mongo", `storage for users, sessions and issues: "mongo" or "postgres"`)
+	str(&c.Database.PostgresURL, "DB_CONNECTION", "", "PostgreSQL connection string")
+
+	str(&c.Auth.JWTSecret, "JWT_SECRET", "", "secret encrypting the token signing keys")
+	str(&c.Auth.Issuer, "JWT_ISSUER", "sautii", "issuer of access tokens")
+	str(&c.Auth.Audience, "JWT_AUDIENCE", "sautii-api", "audience of access tokens")
+	str(&c.Auth.SigningAlg, "JWT_SIGNING_ALG", "RS256", `access token signing algorithm: "RS256" or "EdDSA"`)
+	dur(&c.Auth.KeyRotation, "JWT_KEY_ROTATION", 30*24*time.Hour, "how often a new signing key is created")
+	dur(&c.Auth.AccessTokenTTL, "ACCESS_TOKEN_TTL", 15*time.Minute, "lifetime of access tokens")
+	dur(&c.Auth.RefreshTokenTTL, "REFRESH_TOKEN_TTL", 7*24*time.Hour, "lifetime of refresh tokens")
+	list(&c.Auth.UnverifiedRestrictions, "UNVERIFIED_RESTRICTIONS", []string{"vote"}, `actions unverified accounts may not take: "vote", "comment", "create_issue", "message" or "none"`)
+
+	var names []string
+	list(&names, "OIDC_PROVIDERS", providers, "comma-separated names of identity providers, each configured by OIDC_<NAME>_* settings")
+	c.Auth.OIDCProviders = make([]OIDCProvider, len(providers))
+	for i, name := range providers {
+		p := &c.Auth.OIDCProviders[i]
+		p.Name = name
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		str(&p.Issuer, prefix+"ISSUER", "", "issuer URL of the "+name+" identity provider")
+		str(&p.ClientID, prefix+"CLIENT_ID", "", "client ID registered with "+name)
+		str(&p.ClientSecret, prefix+"CLIENT_SECRET", "", "client secret registered with "+name)
+		str(&p.RedirectURL, prefix+"REDIRECT_URL", "", "callback URL registered with "+name)
+		list(&p.Scopes, prefix+"SCOPES", []string{"openid", "email", "profile"}, "scopes requested from "+name)
+	}
+
+	str(&c.Email.SMTPHost, "SMTP_HOST", "", "SMTP server; emails are only logged if empty")
+	str(&c.Email.SMTPPort, "SMTP_PORT", "587", "SMTP port")
+	str(&c.Email.SMTPUsername, "SMTP_USERNAME", "", "SMTP username")
+	str(&c.Email.SMTPPassword, "SMTP_PASSWORD", "", "SMTP password")
+	str(&c.Email.From, "SMTP_FROM", "", "sender address of emails")
+
+	str(&c.AI.OpenAIAPIKey, "OPENAI_API_KEY", "", "OpenAI API key for issue classification")
+	str(&c.AI.Model, "OPENAI_MODEL", "gpt-3.5-turbo", "OpenAI model for issue classification")
+	dur(&c.AI.Timeout, "OPENAI_TIMEOUT", 30*time.Second, "timeout for OpenAI requests")
+
+	str(&c.Limits.RateLimitStore, "RATE_LIMIT_STORE", "memory", `rate limit buckets: "memory" or "mongo"`)
+	rate(&c.Limits.Auth, "RATE_LIMIT_AUTH", RateLimit{10, time.Minute}, "sign-in and account requests allowed, as limit/period")
+	rate(&c.Limits.Issues, "RATE_LIMIT_ISSUES", RateLimit{10, time.Hour}, "issues that may be filed, as limit/period")
+	rate(&c.Limits.Votes, "RATE_LIMIT_VOTES", RateLimit{60, time.Minute}, "votes allowed, as limit/period")
+	rate(&c.Limits.Comments, "RATE_LIMIT_COMMENTS", RateLimit{20, time.Minute}, "comments allowed, as limit/period")
+	rate(&c.Limits.Search, "RATE_LIMIT_SEARCH", RateLimit{60, time.Minute}, "searches allowed, as limit/period")
+
+	str(&c.Logging.Level, "LOG_LEVEL", "info", `minimum log level: "debug", "info", "warn" or "error"`)
+	str(&c.Logging.Format, "LOG_FORMAT", "json", `log format: "json" or "text"`)
+
+	str(&c.Telemetry.MetricsToken, "METRICS_TOKEN", "", "bearer token required to read /metrics; open if empty")
+	str(&c.Telemetry.OTLPEndpoint, "OTEL_EXPORTER_OTLP_ENDPOINT", "", "OTLP/HTTP collector to send traces to; tracing is off if empty")
+	str(&c.Telemetry.ServiceName, "OTEL_SERVICE_NAME", "sautii", "service name traces are reported under")
+	fs.Float64Var(&c.Telemetry.TraceSampleRatio, flagName("TRACE_SAMPLE_RATIO"), 1, "share of new traces to record, from 0 to 1")
+}
+
+// prefixList is a flag holding comma-separated addresses and CIDR ranges. A
+// single address is read as a range holding only that address.
+type prefixList struct {
+	prefixes *[]netip.Prefix
+}
+
+func (l prefixList) String() string {
+	if l.prefixes == nil {
+		return ""
+	}
+	values := make([]string, len(*l.prefixes))
+	for i, prefix := range *l.prefixes {
+		values[i] = prefix.String()
+	}
+	return strings.Join(values, ",")
+}
+
+func (l prefixList) Set(value string) error {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return fmt.Errorf("%q is neither an address nor a CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	*l.prefixes = prefixes
+	return nil
+}
+
+// stringList is a flag holding a list separated by commas or spaces.
+type stringList struct {
+	values *[]string
+}
+
+func (l stringList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l stringList) Set(value string) error {
+	*l.values = splitList(value)
+	return nil
+}
+
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+}
+
+// rateLimitValue is a flag holding a rate limit such as "10/1m".
+type rateLimitValue struct {
+	limit *RateLimit
+}
+
+func (v rateLimitValue) String() string {
+	if v.limit == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", v.limit.Limit, v.limit.Period)
+}
+
+func (v rateLimitValue) Set(value string) error {
+	limit, period, found := strings.Cut(value, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if !found || err != nil || n < 1 {
+		return errors.New("must be a positive number of requests and a period, such as 10/1m")
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return errors.New("must be a positive number of requests and a period, such as 10/1m")
+	}
+	*v.limit = RateLimit{Limit: n, Period: d}
+	return nil
+}
+
+// flagName converts an environment variable name to its flag name.
+func flagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
+
+func envKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Load reads the configuration from args (usually os.Args[1:]), the
+// environment and a dotenv file, then validates it.
+//
+// The file is named by -config or CONFIG_FILE and defaults to .env, which
+// may be missing. Its variables are added to the environment without
+// replacing ones already set. The file and OIDC_PROVIDERS are read before
+// the flags are parsed, since each identity provider has its own flags.
+func Load(args []string) (*Config, error) {
+	configFile, _ := lookupArg(args, "config")
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	if configFile != "" {
+		if err := godotenv.Load(configFile); err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+	} else if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %v", err)
+	}
+
+	providerList, ok := lookupArg(args, flagName("OIDC_PROVIDERS"))
+	if !ok {
+		providerList = os.Getenv("OIDC_PROVIDERS")
+	}
+	providers, err := providerNames(providerList)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_PROVIDERS %q: %v", providerList, err)
+	}
+
+	c := &Config{flags: flag.NewFlagSet("sautii", flag.ContinueOnError)}
+	c.register(c.flags, providers)
+	c.flags.String("config", "", "dotenv file to read (default .env)")
+	if err := c.flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Flags win over the environment
+	setByFlag := make(map[string]bool)
+	c.flags.Visit(func(f *flag.Flag) { setByFlag[f.Name] = true })
+
+	var errs []error
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || setByFlag[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envKey(f.Name)); ok {
+			if err := f.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s %q: %v", envKey(f.Name), value, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lookupArg returns the last value given for the flag name in args.
+func lookupArg(args []string, name string) (string, bool) {
+	var value string
+	var found bool
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		arg := strings.TrimPrefix(strings.TrimPrefix(args[i], "-"), "-")
+		if arg == args[i] {
+			continue
+		}
+		if v, ok := strings.CutPrefix(arg, name+"="); ok {
+			value, found = v, true
+		} else if arg == name && i+1 < len(args) {
+			value, found = args[i+1], true
+			i++
+		}
+	}
+	return value, found
+}
+
+// providerNames splits OIDC_PROVIDERS into lower case names, which become
+// part of the provider's settings.
+func providerNames(value string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range splitList(strings.ToLower(value)) {
+		if !providerName.MatchString(name) {
+			return nil, fmt.Errorf("%q must be letters and digits only", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%q is listed twice", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// Print writes the effective configuration as environment variables, with
+// secrets and URL passwords redacted.
+func (c *Config) Print(w io.Writer) {
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		key := envKey(f.Name)
+		fmt.Fprintf(w, "%s=%s\n", key, redact(key, f.Value.String()))
+	})
+}
+
+// LogValue logs the configuration like Print, with settings keeping their
+// types.
+func (c *Config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	c.flags.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		key := envKey(f.Name)
+		var value any = redact(key, f.Value.String())
+		if getter, ok := f.Value.(flag.Getter); ok && !isSecret(key) && !urls[key] {
+			value = getter.Get()
+		}
+		attrs = append(attrs, slog.Any(key, value))
+	})
+	return slog.GroupValue(attrs...)
+}
+
+// dsnPassword matches the password of a keyword/value connection string,
+// quoted or not.
+var dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s']\S*|)`)
+
+func isSecret(key string) bool {
+	return secrets[key] || strings.HasPrefix(key, "OIDC_") && strings.HasSuffix(key, "_CLIENT_SECRET")
+}
+
+func redact(key, value string) string {
+	if value == "" {
+		return value
+	}
+	if isSecret(key) {
+		return "[redacted]"
+	}
+	if urls[key] {
+		// PostgreSQL also accepts keyword/value strings such as
+		// "host=db password=p"
+		if !strings.Contains(value, "://") {
+			return dsnPassword.ReplaceAllString(value, "${1}[redacted]")
+		}
+		u, err := url.Parse(value)
+		if err != nil {
+			return "[redacted]"
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+		}
+		return u.String()
+	}
+	return value
+}
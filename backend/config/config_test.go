@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactConnectionStrings(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{
+			value: "postgresql://sautii:hunter2@db:5432/sautii",
+			want:  "postgresql://sautii:redacted@db:5432/sautii",
+		},
+		{
+			value: "host=db user=sautii password=hunter2 dbname=sautii",
+			want:  "host=db user=sautii password=[redacted] dbname=sautii",
+		},
+		{
+			value: "host=db password = 'hunter2 with spaces' dbname=sautii",
+			want:  "host=db password = [redacted] dbname=sautii",
+		},
+		{
+			value: `host=db PASSWORD='it\'s' dbname=sautii`,
+			want:  "host=db PASSWORD=[redacted] dbname=sautii",
+		},
+		{
+			value: "host=db user=sautii dbname=sautii",
+			want:  "host=db user=sautii dbname=sautii",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := redact("DB_CONNECTION", tt.value); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+// baseArgs are the settings Load requires.
+var baseArgs = []string{"-mongodb-uri", "mongodb://localhost:27017", "-jwt-secret", "k3Jx9Qw2Lm8Vb5Nz7Rt4Yp6Hd1Fg0Sa3"}
+
+func TestLoadRateLimitsAndRestrictions(t *testing.T) {
+	t.Setenv("RATE_LIMIT_VOTES", "5/30s")
+	t.Setenv("UNVERIFIED_RESTRICTIONS", "comment, message")
+
+	c, err := Load(append(baseArgs, "-rate-limit-votes", "100/1h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Limits.Votes, (RateLimit{100, time.Hour}); got != want {
+		t.Errorf("votes = %+v, want the flag %+v", got, want)
+	}
+	if got, want := c.Limits.Issues, (RateLimit{10, time.Hour}); got != want {
+		t.Errorf("issues = %+v, want the default %+v", got, want)
+	}
+	if got := strings.Join(c.Auth.UnverifiedRestrictions, ","); got != "comment,message" {
+		t.Errorf("restrictions = %q, want comment,message", got)
+	}
+
+	for key, value := range map[string]string{
+		"RATE_LIMIT_SEARCH":       "60",
+		"RATE_LIMIT_COMMENTS":     "0/1m",
+		"RATE_LIMIT_AUTH":         "10/forever",
+		"UNVERIFIED_RESTRICTIONS": "vote,delete",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := Load(baseArgs); err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("Load with %s=%q = %v, want an error naming it", key, value, err)
+			}
+		})
+	}
+}
+
+func TestLoadOIDCProviders(t *testing.T) {
+	dotenv := filepath.Join(t.TempDir(), "sautii.env")
+	content := "OIDC_PROVIDERS=google,County\n" +
+		"OIDC_GOOGLE_ISSUER=https://accounts.google.com\n" +
+		"OIDC_GOOGLE_CLIENT_ID=from-file\n" +
+		"OIDC_GOOGLE_CLIENT_SECRET=google-secret\n" +
+		"OIDC_GOOGLE_REDIRECT_URL=https://sautii.example/api/auth/oidc/google/callback\n" +
+		"OIDC_COUNTY_ISSUER=https://id.county.example\n" +
+		"OIDC_COUNTY_CLIENT_ID=sautii\n" +
+		"OIDC_COUNTY_REDIRECT_URL=https://sautii.example/api/auth/oidc/county/callback\n" +
+		"OIDC_COUNTY_SCOPES=openid email\n"
+	if err := os.WriteFile(dotenv, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The file's variables are added to the environment
+	for _, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		key, _, _ := strings.Cut(line, "=")
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "from-env")
+
+	c, err := Load(append(baseArgs, "-config", dotenv, "-oidc-county-client-id", "from-flag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers := c.Auth.OIDCProviders
+	if len(providers) != 2 || providers[0].Name != "google" || providers[1].Name != "county" {
+		t.Fatalf("providers = %+v, want google and county", providers)
+	}
+	if providers[0].ClientID != "from-env" || providers[1].ClientID != "from-flag" {
+		t.Errorf("client IDs = %q, %q, want the environment and flag values", providers[0].ClientID, providers[1].ClientID)
+	}
+	if got := strings.Join(providers[0].Scopes, " "); got != "openid email profile" {
+		t.Errorf("default scopes = %q", got)
+	}
+	if got := strings.Join(providers[1].Scopes, " "); got != "openid email" {
+		t.Errorf("scopes = %q, want openid email", got)
+	}
+
+	var printed strings.Builder
+	c.Print(&printed)
+	if strings.Contains(printed.String(), "google-secret") || !strings.Contains(printed.String(), "OIDC_GOOGLE_CLIENT_SECRET=[redacted]") {
+		t.Errorf("client secret not redacted:\n%s", printed.String())
+	}
+	if logged := c.LogValue().String(); strings.Contains(logged, "google-secret") {
+		t.Errorf("client secret logged: %s", logged)
+	}
+
+	// Each provider needs an issuer, client ID and redirect URL
+	if _, err := Load(append(baseArgs, "-config", dotenv, "-oidc-county-issuer=")); err == nil || !strings.Contains(err.Error(), "OIDC_COUNTY_ISSUER") {
+		t.Errorf("Load without an issuer = %v", err)
+	}
+	for _, list := range []string{"google,google", "my-idp"} {
+		if _, err := Load(append(baseArgs, "-config", dotenv, "-oidc-providers", list)); err == nil {
+			t.Errorf("Load with OIDC_PROVIDERS=%q succeeded", list)
+		}
+	}
+}
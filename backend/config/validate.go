@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minSecretLength = 32
+
+// weakSecrets are values from examples and documentation that must never be
+// used as secrets.
+var weakSecrets = []string{"your_jwt_secret", "secret", "changeme", "change-me", "jwt_secret"}
+
+// unverifiedActions are the actions that can be restricted until an account
+// verifies its email.
+var unverifiedActions = map[string]bool{"vote": true, "comment": true, "create_issue": true, "message": true, "none": true}
+
+// Validate checks that required settings are present, that choices are
+// known and that the JWT secret is strong enough. It reports every problem
+// at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("PORT must be a port number, got %q", c.Server.Port)
+	}
+
+	if (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == "") {
+		fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.Server.MaxHeaderBytes <= 0 || c.Server.MaxBodyBytes <= 0 {
+		fail("HTTP_MAX_HEADER_BYTES and HTTP_MAX_BODY_BYTES must be positive")
+	}
+
+	if c.Database.MongoURI == "" {
+		fail("MONGODB_URI is required")
+	}
+	if c.Database.MongoDatabase == "" {
+		fail("MONGODB_DATABASE is required")
+	}
+	switch c.Database.StorageBackend {
+	case "mongo":
+	case "postgres":
+		if c.Database.PostgresURL == "" {
+			fail("DB_CONNECTION is required when STORAGE_BACKEND is postgres")
+		}
+	default:
+		fail(`STORAGE_BACKEND must be "mongo" or "postgres", got %q`, c.Database.StorageBackend)
+	}
+
+	if err := checkSecret(c.Auth.JWTSecret); err != nil {
+		fail("JWT_SECRET %v", err)
+	}
+	if c.Auth.SigningAlg != "RS256" && c.Auth.SigningAlg != "EdDSA" {
+		fail(`JWT_SIGNING_ALG must be "RS256" or "EdDSA", got %q`, c.Auth.SigningAlg)
+	}
+	if c.Auth.Issuer == "" || c.Auth.Audience == "" {
+		fail("JWT_ISSUER and JWT_AUDIENCE must not be empty")
+	}
+	if c.Auth.AccessTokenTTL > c.Auth.RefreshTokenTTL {
+		fail("ACCESS_TOKEN_TTL must not be longer than REFRESH_TOKEN_TTL")
+	}
+	for _, action := range c.Auth.UnverifiedRestrictions {
+		if !unverifiedActions[action] {
+			fail(`UNVERIFIED_RESTRICTIONS must list "vote", "comment", "create_issue", "message" or "none", got %q`, action)
+		}
+	}
+	for _, p := range c.Auth.OIDCProviders {
+		prefix := "OIDC_" + strings.ToUpper(p.Name) + "_"
+		if u, err := url.Parse(p.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("%sISSUER must be an http or https URL, got %q", prefix, p.Issuer)
+		}
+		if p.ClientID == "" {
+			fail("%sCLIENT_ID is required", prefix)
+		}
+		if u, err := url.Parse(p.RedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("%sREDIRECT_URL must be an http or https URL, got %q", prefix, p.RedirectURL)
+		}
+	}
+
+	if c.Email.SMTPHost != "" && c.Email.From == "" {
+		fail("SMTP_FROM is required when SMTP_HOST is set")
+	}
+
+	switch c.Limits.RateLimitStore {
+	case "memory", "mongo":
+	default:
+		fail(`RATE_LIMIT_STORE must be "memory" or "mongo", got %q`, c.Limits.RateLimitStore)
+	}
+	rateLimits := c.Limits.RateLimits()
+	names := make([]string, 0, len(rateLimits))
+	for name := range rateLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if limit := rateLimits[name]; limit.Limit < 1 || limit.Period <= 0 {
+			fail("RATE_LIMIT_%s must allow at least one request per positive period", strings.ToUpper(name))
+		}
+	}
+
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		fail(`LOG_LEVEL must be "debug", "info", "warn" or "error", got %q`, c.Logging.Level)
+	}
+	if c.Logging.Format != "json" && c.Logging.Format != "text" {
+		fail(`LOG_FORMAT must be "json" or "text", got %q`, c.Logging.Format)
+	}
+
+	if endpoint := c.Telemetry.OTLPEndpoint; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("OTEL_EXPORTER_OTLP_ENDPOINT must be an http or https URL, got %q", endpoint)
+		}
+		if c.Telemetry.ServiceName == "" {
+			fail("OTEL_SERVICE_NAME must not be empty")
+		}
+	}
+	if c.Telemetry.TraceSampleRatio < 0 || c.Telemetry.TraceSampleRatio > 1 {
+		fail("TRACE_SAMPLE_RATIO must be between 0 and 1")
+	}
+
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.Server.ReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.Server.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout},
+		{"DB_CONNECT_TIMEOUT", c.Database.ConnectTimeout},
+		{"JWT_KEY_ROTATION", c.Auth.KeyRotation},
+		{"ACCESS_TOKEN_TTL", c.Auth.AccessTokenTTL},
+		{"REFRESH_TOKEN_TTL", c.Auth.RefreshTokenTTL},
+		{"OPENAI_TIMEOUT", c.AI.Timeout},
+	} {
+		if d.value <= 0 {
+			fail("%s must be positive", d.key)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func checkSecret(secret string) error {
+	if secret == "" {
+		return errors.New("is required")
+	}
+	for _, weak := range weakSecrets {
+		if strings.EqualFold(secret, weak) {
+			return errors.New("is a placeholder value, generate a random secret")
+		}
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("must be at least %d characters", minSecretLength)
+	}
+
+	distinct := make(map[rune]bool)
+	for _, r := range secret {
+		distinct[r] = true
+	}
+	if len(distinct) < 10 {
+		return errors.New("is too repetitive, generate a random secret")
+	}
+	return nil
+}
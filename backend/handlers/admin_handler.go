@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RoleRequest struct {
+	Role   string `json:"role"`
+	Reason string `json:"reason"`
+}
+
+type RoleInfo struct {
+	Role        string              `json:"role"`
+	Permissions []models.Permission `json:"permissions"`
+}
+
+func ListRoles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roles := make([]RoleInfo, 0, len(models.Roles))
+		for _, role := range models.Roles {
+			roles = append(roles, RoleInfo{Role: role, Permissions: models.PermissionsFor(role)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(roles)
+	}
+}
+
+func GrantRole(roleService *services.RoleService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var req RoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		actor, err := currentActor(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := roleService.GrantRole(r.Context(), actor, userID, req.Role, req.Reason); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func RevokeRole(roleService *services.RoleService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		// The reason is optional
+		var req RoleRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		actor, err := currentActor(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := roleService.RevokeRole(r.Context(), actor, userID, req.Reason); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func GetRoleHistory(roleService *services.RoleService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		history, err := roleService.RoleHistory(r.Context(), userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// UnlockAccount lifts a login lockout on a user's account.
+func UnlockAccount(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		actor, err := currentActor(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := authService.UnlockAccount(r.Context(), actor, userID); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetSecurityLog lists failed logins, lockouts and unlocks, optionally for
+// one user with ?userId=.
+func GetSecurityLog(auditService *services.AuditService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := services.AuditFilter{Actions: services.SecurityActions}
+		if value := r.URL.Query().Get("userId"); value != "" {
+			userID, err := primitive.ObjectIDFromHex(value)
+			if err != nil {
+				http.Error(w, "Invalid user ID", http.StatusBadRequest)
+				return
+			}
+			filter.TargetUserID = userID
+		}
+		if limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil {
+			filter.Limit = limit
+		}
+
+		entries, err := auditService.List(r.Context(), filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
+
+func writeRoleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -2,8 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
+	"github.com/arnoldadero/sautii/middleware"
 	"github.com/arnoldadero/sautii/models"
 	"github.com/arnoldadero/sautii/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -29,6 +33,71 @@ type ChangePasswordRequest struct {
 	NewPassword    string `json:"newPassword"`
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// clientInfo describes the calling device for session tracking.
+func clientInfo(r *http.Request) services.ClientInfo {
+	return services.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+}
+
+func clientIP(r *http.Request) string {
+	return middleware.ClientIP(r)
+}
+
+// currentUserID returns the authenticated user's ID set by the auth middleware.
+func currentUserID(r *http.Request) (primitive.ObjectID, error) {
+	return primitive.ObjectIDFromHex(middleware.GetUserID(r.Context()))
+}
+
+// currentPrincipalID identifies who is acting: the authenticated user, or the
+// API key for machine clients.
+func currentPrincipalID(r *http.Request) (primitive.ObjectID, error) {
+	if keyID := middleware.GetAPIKeyID(r.Context()); keyID != "" {
+		return primitive.ObjectIDFromHex(keyID)
+	}
+	return currentUserID(r)
+}
+
+// currentActor describes the authenticated user for permission checks.
+func currentActor(r *http.Request) (services.Actor, error) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		return services.Actor{}, err
+	}
+	return services.Actor{
+		ID:              userID,
+		Role:            middleware.GetUserRole(r.Context()),
+		IP:              clientIP(r),
+		JurisdictionIDs: middleware.GetJurisdictionIDs(r.Context()),
+	}, nil
+}
+
+// loginError responds to a failed login step, telling throttled clients when
+// to retry.
+func loginError(w http.ResponseWriter, err error) {
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
 func Register(authService *services.AuthService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req RegisterRequest
@@ -78,15 +147,15 @@ func Login(authService *services.AuthService) http.HandlerFunc {
 		}
 
 		// Login user
-		tokens, err := authService.Login(r.Context(), req.Email, req.Password)
+		result, err := authService.Login(r.Context(), req.Email, req.Password, clientInfo(r))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
+			loginError(w, err)
 			return
 		}
 
-		// Return tokens
+		// Return tokens, or the MFA challenge
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(tokens)
+		json.NewEncoder(w).Encode(result)
 	}
 }
 
@@ -105,7 +174,7 @@ func RefreshToken(authService *services.AuthService) http.HandlerFunc {
 		}
 
 		// Refresh tokens
-		tokens, err := authService.RefreshToken(r.Context(), req.RefreshToken)
+		tokens, err := authService.RefreshToken(r.Context(), req.RefreshToken, clientInfo(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
@@ -144,10 +213,9 @@ func ChangePassword(authService *services.AuthService) http.HandlerFunc {
 		}
 
 		// Get user ID from context
-		userIDStr := r.Context().Value("userId").(string)
-		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		userID, err := currentUserID(r)
 		if err != nil {
-			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
@@ -165,10 +233,9 @@ func ChangePassword(authService *services.AuthService) http.HandlerFunc {
 func GetProfile(authService *services.AuthService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get user ID from context
-		userIDStr := r.Context().Value("userId").(string)
-		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		userID, err := currentUserID(r)
 		if err != nil {
-			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
@@ -187,9 +254,108 @@ func GetProfile(authService *services.AuthService) http.HandlerFunc {
 			Username:       user.Username,
 			Role:           user.Role,
 			IsVerified:     user.IsVerified,
+			VerifiedAt:     user.VerifiedAt,
+			MFAEnabled:     user.MFAEnabled(),
 			ProfilePicture: user.ProfilePicture,
 			Location:       user.Location,
 			CreatedAt:      user.CreatedAt,
 		})
 	}
 }
+
+func VerifyEmail(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifyEmailRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Token == "" {
+			http.Error(w, "Token is required", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.VerifyEmail(r.Context(), req.Token); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func ResendVerification(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := authService.ResendVerification(r.Context(), userID); err != nil {
+			if errors.Is(err, services.ErrTooManyRequests) {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func ForgotPassword(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ForgotPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Email == "" {
+			http.Error(w, "Email is required", http.StatusBadRequest)
+			return
+		}
+
+		// The response is the same whether or not the email is registered
+		authService.ForgotPassword(r.Context(), req.Email)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": "If an account exists for that email, a password reset link has been sent",
+		})
+	}
+}
+
+func ResetPassword(authService *services.AuthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ResetPasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if req.Token == "" || req.NewPassword == "" {
+			http.Error(w, "Token and new password are required", http.StatusBadRequest)
+			return
+		}
+
+		if err := authService.ResetPassword(r.Context(), req.Token, req.NewPassword); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func JWKS(keyService *services.KeyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(keyService.JWKS())
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/arnoldadero/sautii/middleware"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/services"
+)
+
+// Liveness reports that the process is up and serving requests.
+func Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(services.HealthOK))
+	}
+}
+
+// Readiness reports whether the backend's dependencies are available. It
+// answers 503 when a critical check fails. Probes get the status as plain
+// text; users allowed to view system health get every check as JSON.
+func Readiness(healthService *services.HealthService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := healthService.Ready(r.Context())
+		w.Header().Set("Cache-Control", "no-store")
+
+		status := http.StatusOK
+		if report.Status == services.HealthUnavailable {
+			status = http.StatusServiceUnavailable
+		}
+
+		if !models.HasPermission(middleware.GetUserRole(r.Context()), models.PermViewSystemHealth) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(status)
+			w.Write([]byte(report.Status))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -2,40 +2,49 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/arnoldadero/sautii/logging"
 	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
 	"github.com/arnoldadero/sautii/services"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// CreateIssue files a new open issue. The body may set the fields of
+// services.CreateIssueRequest; any other field is rejected.
 func CreateIssue(issueService *services.IssueService, aiService *services.AIService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var issue models.Issue
-		if err := json.NewDecoder(r.Body).Decode(&issue); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		// Issues filed by machine clients are attributed to their API key
+		creatorID, err := currentPrincipalID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		// Get user ID from context (set by auth middleware)
-		userID, ok := r.Context().Value("userID").(primitive.ObjectID)
-		if !ok {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		var req services.CreateIssueRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		issue.CreatedBy = userID
+		issue := req.NewIssue(creatorID)
 
 		// Predict category using AI service if not provided
 		if issue.Category == "" {
-			prediction, err := aiService.PredictCategory(issue.Title, issue.Description)
-			if err == nil && prediction != nil {
+			prediction, err := aiService.PredictCategory(r.Context(), issue.Title, issue.Description)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("failed to classify issue, filing it without a category", "error", err)
+			} else if prediction != nil {
 				issue.Category = prediction.Category
 			}
 		}
 
-		if err := issueService.CreateIssue(r.Context(), &issue); err != nil {
+		if err := issueService.CreateIssue(r.Context(), issue); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -79,8 +88,22 @@ func UpdateIssue(issueService *services.IssueService) http.HandlerFunc {
 			return
 		}
 
-		if err := issueService.UpdateIssue(r.Context(), id, updates); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		actor, err := currentActor(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := issueService.UpdateIssue(r.Context(), actor, id, updates); err != nil {
+			if errors.Is(err, services.ErrForbidden) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if errors.Is(err, repository.ErrNotFound) {
+				http.Error(w, "Issue not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -105,8 +128,8 @@ func VoteOnIssue(issueService *services.IssueService) http.HandlerFunc {
 			return
 		}
 
-		userID, ok := r.Context().Value("userID").(primitive.ObjectID)
-		if !ok {
+		userID, err := currentUserID(r)
+		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -135,8 +158,8 @@ func AddComment(issueService *services.IssueService) http.HandlerFunc {
 			return
 		}
 
-		userID, ok := r.Context().Value("userID").(primitive.ObjectID)
-		if !ok {
+		userID, err := currentUserID(r)
+		if err != nil {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arnoldadero/sautii/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCreateIssueRejectsServerOwnedFields(t *testing.T) {
+	bodies := map[string]string{
+		"status":          `{"title":"Pothole","status":"resolved"}`,
+		"assignedTo":      `{"title":"Pothole","assignedTo":"` + primitive.NewObjectID().Hex() + `"}`,
+		"id":              `{"title":"Pothole","id":"` + primitive.NewObjectID().Hex() + `"}`,
+		"jurisdictionIds": `{"jurisdictionIds":["` + primitive.NewObjectID().Hex() + `"]}`,
+		"votes":           `{"votes":{"up":["` + primitive.NewObjectID().Hex() + `"]}}`,
+		"comments":        `{"comments":[{"content":"hi"}]}`,
+		"createdBy":       `{"createdBy":"` + primitive.NewObjectID().Hex() + `"}`,
+		"createdAt":       `{"createdAt":"2020-01-01T00:00:00Z"}`,
+	}
+
+	// The services are never reached, so none are needed
+	handler := CreateIssue(nil, nil)
+	for field, body := range bodies {
+		t.Run(field, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/issues", strings.NewReader(body))
+			r = r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, primitive.NewObjectID().Hex()))
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(w.Body.String(), field) {
+				t.Errorf("error %q does not name the field %q", w.Body.String(), field)
+			}
+		})
+	}
+}
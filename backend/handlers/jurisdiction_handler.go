@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AssignJurisdictionsRequest struct {
+	JurisdictionIDs []primitive.ObjectID `json:"jurisdictionIds"`
+}
+
+func ListJurisdictions(jurisdictionService *services.JurisdictionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jurisdictions, err := jurisdictionService.List(r.Context(), r.URL.Query().Get("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jurisdictions)
+	}
+}
+
+func GetJurisdiction(jurisdictionService *services.JurisdictionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid jurisdiction ID", http.StatusBadRequest)
+			return
+		}
+
+		jurisdiction, err := jurisdictionService.Get(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jurisdiction)
+	}
+}
+
+// LocateJurisdictions returns the jurisdictions containing ?lat=&lng=.
+func LocateJurisdictions(jurisdictionService *services.JurisdictionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lat, latErr := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+		lng, lngErr := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+		if latErr != nil || lngErr != nil {
+			http.Error(w, "Valid lat and lng are required", http.StatusBadRequest)
+			return
+		}
+
+		jurisdictions, err := jurisdictionService.Locate(r.Context(), lat, lng)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jurisdictions)
+	}
+}
+
+func CreateJurisdiction(jurisdictionService *services.JurisdictionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var jurisdiction models.Jurisdiction
+		if err := json.NewDecoder(r.Body).Decode(&jurisdiction); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := jurisdictionService.Create(r.Context(), &jurisdiction); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(jurisdiction)
+	}
+}
+
+// BackfillJurisdictions recomputes the jurisdictions of existing issues.
+func BackfillJurisdictions(jurisdictionService *services.JurisdictionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		updated, err := jurisdictionService.BackfillIssues(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"updated": updated})
+	}
+}
+
+func AssignJurisdictions(roleService *services.RoleService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var req AssignJurisdictionsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		actor, err := currentActor(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := roleService.AssignJurisdictions(r.Context(), actor, userID, req.JurisdictionIDs); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/arnoldadero/sautii/middleware"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxMessageRequestSize bounds a message request including its attachments.
+const maxMessageRequestSize = 5*services.MaxAttachmentSize + 1<<20
+
+type MessageRequest struct {
+	RepresentativeID primitive.ObjectID `json:"representativeId"`
+	Subject          string             `json:"subject"`
+	Body             string             `json:"body"`
+}
+
+type ConversationStatusRequest struct {
+	Status string `json:"status"`
+}
+
+type StartConversationResponse struct {
+	Conversation *models.Conversation `json:"conversation"`
+	Message      *models.Message      `json:"message"`
+}
+
+// parseMessageRequest reads a message sent either as JSON or as
+// multipart/form-data with the files in "attachments". The caller must call
+// the returned cleanup function once the uploads have been stored.
+func parseMessageRequest(w http.ResponseWriter, r *http.Request) (*MessageRequest, []services.AttachmentUpload, func(), error) {
+	noop := func() {}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		var req MessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, nil, noop, errors.New("Invalid request body")
+		}
+		return &req, nil, noop, nil
+	}
+
+	// Only messages with attachments may exceed the server's body limit
+	middleware.RaiseBodyLimit(w, r, maxMessageRequestSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, nil, noop, errors.New("Invalid request body")
+	}
+
+	req := &MessageRequest{
+		Subject: r.FormValue("subject"),
+		Body:    r.FormValue("body"),
+	}
+	if value := r.FormValue("representativeId"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, nil, noop, errors.New("Invalid representative ID")
+		}
+		req.RepresentativeID = id
+	}
+
+	var files []multipart.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		r.MultipartForm.RemoveAll()
+	}
+
+	var uploads []services.AttachmentUpload
+	for _, header := range r.MultipartForm.File["attachments"] {
+		file, err := header.Open()
+		if err != nil {
+			closeFiles()
+			return nil, nil, noop, errors.New("Invalid attachment")
+		}
+		files = append(files, file)
+
+		// Detect the type from the content rather than trusting the client
+		sniff := make([]byte, 512)
+		n, _ := io.ReadFull(file, sniff)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			closeFiles()
+			return nil, nil, noop, errors.New("Invalid attachment")
+		}
+
+		uploads = append(uploads, services.AttachmentUpload{
+			Filename:    header.Filename,
+			ContentType: http.DetectContentType(sniff[:n]),
+			Size:        header.Size,
+			Content:     file,
+		})
+	}
+	return req, uploads, closeFiles, nil
+}
+
+func StartConversation(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		req, uploads, cleanup, err := parseMessageRequest(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cleanup()
+
+		conversation, message, err := messageService.StartConversation(r.Context(), userID, req.RepresentativeID, req.Subject, req.Body, uploads)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(StartConversationResponse{Conversation: conversation, Message: message})
+	}
+}
+
+func ListConversations(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		conversations, err := messageService.ListConversations(r.Context(), userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversations)
+	}
+}
+
+func GetConversation(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, conversationID, ok := conversationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		conversation, err := messageService.GetConversation(r.Context(), userID, conversationID)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversation)
+	}
+}
+
+// ListMessages returns the thread and records read receipts.
+func ListMessages(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, conversationID, ok := conversationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		messages, err := messageService.Messages(r.Context(), userID, conversationID)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	}
+}
+
+func SendMessage(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, conversationID, ok := conversationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		req, uploads, cleanup, err := parseMessageRequest(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer cleanup()
+
+		message, err := messageService.SendMessage(r.Context(), userID, conversationID, req.Body, uploads)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(message)
+	}
+}
+
+func SetConversationStatus(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, conversationID, ok := conversationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var req ConversationStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := messageService.SetStatus(r.Context(), userID, conversationID, req.Status); err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func DownloadAttachment(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, conversationID, ok := conversationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		attachmentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["attachmentId"])
+		if err != nil {
+			http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+			return
+		}
+
+		attachment, content, err := messageService.OpenAttachment(r.Context(), userID, conversationID, attachmentID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(attachment.Filename))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		io.Copy(w, content)
+	}
+}
+
+// ConvertMessageToIssue publishes a message as a public issue. The body may
+// set the issue's title, description, category and location; any other field
+// is rejected.
+func ConvertMessageToIssue(messageService *services.MessageService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, conversationID, ok := conversationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		messageID, err := primitive.ObjectIDFromHex(mux.Vars(r)["messageId"])
+		if err != nil {
+			http.Error(w, "Invalid message ID", http.StatusBadRequest)
+			return
+		}
+
+		var req services.ConvertToIssueRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		issue, err := messageService.ConvertToIssue(r.Context(), userID, conversationID, messageID, req)
+		if err != nil {
+			writeMessageError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(issue)
+	}
+}
+
+func conversationRequest(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	conversationID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return userID, conversationID, true
+}
+
+func writeMessageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrConversationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrTooManyRequests):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/arnoldadero/sautii/middleware"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConvertMessageToIssueRejectsServerOwnedFields(t *testing.T) {
+	bodies := map[string]string{
+		"status":          `{"title":"Pothole","status":"resolved"}`,
+		"assignedTo":      `{"assignedTo":"` + primitive.NewObjectID().Hex() + `"}`,
+		"jurisdictionIds": `{"jurisdictionIds":["` + primitive.NewObjectID().Hex() + `"]}`,
+		"votes":           `{"votes":{"up":["` + primitive.NewObjectID().Hex() + `"]}}`,
+		"comments":        `{"comments":[{"content":"hi","createdBy":"` + primitive.NewObjectID().Hex() + `"}]}`,
+		"priority":        `{"priority":"high"}`,
+		"tags":            `{"tags":["roads"]}`,
+		"createdBy":       `{"createdBy":"` + primitive.NewObjectID().Hex() + `"}`,
+	}
+
+	// The service is never reached, so none is needed
+	handler := ConvertMessageToIssue(nil)
+	for field, body := range bodies {
+		t.Run(field, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/conversations/x/messages/y/issue", strings.NewReader(body))
+			r = r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, primitive.NewObjectID().Hex()))
+			r = mux.SetURLVars(r, map[string]string{
+				"id":        primitive.NewObjectID().Hex(),
+				"messageId": primitive.NewObjectID().Hex(),
+			})
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if !strings.Contains(w.Body.String(), field) {
+				t.Errorf("error %q does not name the field %q", w.Body.String(), field)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/arnoldadero/sautii/services"
+	"github.com/gorilla/mux"
+)
+
+func ListOIDCProviders(oidcService *services.OIDCService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string][]string{"providers": oidcService.Providers()})
+	}
+}
+
+// OIDCLogin redirects the browser to the identity provider.
+func OIDCLogin(oidcService *services.OIDCService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authURL, err := oidcService.AuthorizationURL(r.Context(), mux.Vars(r)["provider"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallback handles the provider's redirect and sends the browser back to
+// the frontend with the login result.
+func OIDCCallback(oidcService *services.OIDCService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if providerErr := query.Get("error"); providerErr != "" {
+			err := errors.New("identity provider returned an error: " + providerErr)
+			http.Redirect(w, r, oidcService.CompletionURL(nil, err), http.StatusFound)
+			return
+		}
+
+		if query.Get("state") == "" || query.Get("code") == "" {
+			http.Error(w, "State and code are required", http.StatusBadRequest)
+			return
+		}
+
+		result, err := oidcService.HandleCallback(r.Context(), mux.Vars(r)["provider"], query.Get("state"), query.Get("code"), clientInfo(r))
+		http.Redirect(w, r, oidcService.CompletionURL(result, err), http.StatusFound)
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/arnoldadero/sautii/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+	// Token is the emailed confirmation for accounts without a password
+	Token string `json:"token"`
+}
+
+// RequestDataExport starts generating an archive of the user's data.
+func RequestDataExport(privacyService *services.PrivacyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		export, err := privacyService.RequestExport(r.Context(), userID)
+		if err != nil {
+			if errors.Is(err, services.ErrTooManyRequests) {
+				http.Error(w, "An export was already requested in the last 24 hours", http.StatusTooManyRequests)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+func ListDataExports(privacyService *services.PrivacyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		exports, err := privacyService.ListExports(r.Context(), userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(exports)
+	}
+}
+
+func GetDataExport(privacyService *services.PrivacyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, exportID, ok := exportRequest(w, r)
+		if !ok {
+			return
+		}
+
+		export, err := privacyService.GetExport(r.Context(), userID, exportID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+func DownloadDataExport(privacyService *services.PrivacyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, exportID, ok := exportRequest(w, r)
+		if !ok {
+			return
+		}
+
+		export, content, err := privacyService.OpenExport(r.Context(), userID, exportID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Length", strconv.FormatInt(export.Size, 10))
+		w.Header().Set("Content-Disposition", `attachment; filename="sautii-export.zip"`)
+		w.Header().Set("Cache-Control", "no-store")
+		io.Copy(w, content)
+	}
+}
+
+func exportRequest(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, primitive.ObjectID, bool) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+
+	exportID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid export ID", http.StatusBadRequest)
+		return primitive.NilObjectID, primitive.NilObjectID, false
+	}
+	return userID, exportID, true
+}
+
+// DeleteAccount erases the current user's account. Accounts without a
+// password first get 202 Accepted while a confirmation link is emailed.
+func DeleteAccount(privacyService *services.PrivacyService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var req DeleteAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err = privacyService.DeleteAccount(r.Context(), userID, req.Password, req.Code, req.Token, clientIP(r))
+		if errors.Is(err, services.ErrDeletionConfirmationSent) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			writeMFAError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
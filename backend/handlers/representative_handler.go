@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/services"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReviewRepresentativeRequest struct {
+	Status string `json:"status"`
+	Notes  string `json:"notes"`
+}
+
+// ListRepresentatives serves the public directory, filtered by ?q=, ?office=
+// and ?jurisdictionId=.
+func ListRepresentatives(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filters, ok := representativeFilters(w, r)
+		if !ok {
+			return
+		}
+
+		representatives, err := representativeService.List(r.Context(), filters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(representatives)
+	}
+}
+
+// ListRepresentativeProfiles lets reviewers list profiles by ?status=.
+func ListRepresentativeProfiles(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filters, ok := representativeFilters(w, r)
+		if !ok {
+			return
+		}
+		filters.Status = r.URL.Query().Get("status")
+		if filters.Status == "" {
+			filters.Status = models.VerificationPending
+		}
+
+		representatives, err := representativeService.List(r.Context(), filters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(representatives)
+	}
+}
+
+func representativeFilters(w http.ResponseWriter, r *http.Request) (services.RepresentativeFilters, bool) {
+	query := r.URL.Query()
+	filters := services.RepresentativeFilters{
+		Query:  query.Get("q"),
+		Office: query.Get("office"),
+	}
+
+	if value := query.Get("jurisdictionId"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			http.Error(w, "Invalid jurisdiction ID", http.StatusBadRequest)
+			return filters, false
+		}
+		filters.JurisdictionID = id
+	}
+	return filters, true
+}
+
+// LocateRepresentatives returns who represents the point ?lat=&lng=.
+func LocateRepresentatives(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lat, latErr := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+		lng, lngErr := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+		if latErr != nil || lngErr != nil {
+			http.Error(w, "Valid lat and lng are required", http.StatusBadRequest)
+			return
+		}
+
+		representatives, err := representativeService.Locate(r.Context(), lat, lng)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(representatives)
+	}
+}
+
+// GetRepresentative returns a verified profile. Unverified profiles are only
+// visible to their owner and to reviewers.
+func GetRepresentative(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid representative ID", http.StatusBadRequest)
+			return
+		}
+
+		representative, err := representativeService.Get(r.Context(), id)
+		if err != nil || !canViewRepresentative(r, representative) {
+			http.Error(w, "Representative not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(representative)
+	}
+}
+
+func GetRepresentativeStats(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid representative ID", http.StatusBadRequest)
+			return
+		}
+
+		representative, err := representativeService.Get(r.Context(), id)
+		if err != nil || !canViewRepresentative(r, representative) {
+			http.Error(w, "Representative not found", http.StatusNotFound)
+			return
+		}
+
+		stats, err := representativeService.Stats(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+func canViewRepresentative(r *http.Request, representative *models.Representative) bool {
+	if representative.Verification.Status == models.VerificationVerified {
+		return true
+	}
+	actor, err := currentActor(r)
+	if err != nil {
+		return false
+	}
+	return actor.ID == representative.UserID || actor.Can(models.PermVerifyRepresentatives)
+}
+
+// CreateRepresentative submits a representative profile for the current user.
+func CreateRepresentative(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var representative models.Representative
+		if err := json.NewDecoder(r.Body).Decode(&representative); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := representativeService.Create(r.Context(), userID, &representative); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(representative)
+	}
+}
+
+func ReviewRepresentative(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid representative ID", http.StatusBadRequest)
+			return
+		}
+
+		var req ReviewRepresentativeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		actor, err := currentActor(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := representativeService.Review(r.Context(), actor, id, req.Status, req.Notes); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ListStaff(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := currentUserID(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Invalid representative ID", http.StatusBadRequest)
+			return
+		}
+
+		staff, err := representativeService.Staff(r.Context(), userID, id)
+		if err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(staff)
+	}
+}
+
+// AddStaff delegates access to the office's messages to a user.
+func AddStaff(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, id, staffID, ok := staffRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if err := representativeService.AddStaff(r.Context(), userID, id, staffID); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func RemoveStaff(representativeService *services.RepresentativeService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, id, staffID, ok := staffRequest(w, r)
+		if !ok {
+			return
+		}
+
+		if err := representativeService.RemoveStaff(r.Context(), userID, id, staffID); err != nil {
+			writeRoleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func staffRequest(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, primitive.ObjectID, primitive.ObjectID, bool) {
+	var none primitive.ObjectID
+	userID, err := currentUserID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return none, none, none, false
+	}
+
+	vars := mux.Vars(r)
+	id, err := primitive.ObjectIDFromHex(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid representative ID", http.StatusBadRequest)
+		return none, none, none, false
+	}
+	staffID, err := primitive.ObjectIDFromHex(vars["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return none, none, none, false
+	}
+	return userID, id, staffID, true
+}
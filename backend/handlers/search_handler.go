@@ -58,6 +58,11 @@ func SearchIssues(searchService *services.SearchService) http.HandlerFunc {
 			}
 		}
 
+		// Officials only see issues in their jurisdictions
+		if actor, err := currentActor(r); err == nil {
+			filters.ScopeTo(actor)
+		}
+
 		// Execute search
 		result, err := searchService.Search(r.Context(), filters)
 		if err != nil {
@@ -83,6 +88,10 @@ func GetSearchFacets(searchService *services.SearchService) http.HandlerFunc {
 		filters.Page = 1
 		filters.Limit = 1
 
+		if actor, err := currentActor(r); err == nil {
+			filters.ScopeTo(actor)
+		}
+
 		// Execute search to get facets
 		result, err := searchService.Search(r.Context(), filters)
 		if err != nil {
@@ -0,0 +1,124 @@
+// Package logging sets up structured logging with log/slog. Records are
+// scrubbed of secrets before they are written, and the logger for a request
+// travels in its context so that everything logged while serving it carries
+// the same request ID.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/arnoldadero/sautii/config"
+)
+
+const redacted = "[redacted]"
+
+// Attributes whose key contains one of sensitiveFragments or equals one of
+// sensitiveKeys are never logged.
+var (
+	sensitiveFragments = []string{"password", "secret", "token", "authorization", "cookie"}
+	sensitiveKeys      = map[string]bool{"code": true, "api_key": true, "apikey": true, "x-api-key": true}
+)
+
+var (
+	bearerPattern = regexp.MustCompile(`(?i)(bearer|apikey)\s+[A-Za-z0-9._~+/=-]+`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+// New returns a logger writing to w in the configured format and level.
+func New(cfg config.Logging, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(cfg.Level), ReplaceAttr: redact}
+	if cfg.Format == "text" {
+		return slog.New(slog.NewTextHandler(w, opts))
+	}
+	return slog.New(slog.NewJSONHandler(w, opts))
+}
+
+func ParseLevel(level string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// redact hides the values of sensitive attributes and any bearer tokens or
+// JWTs that end up in messages or error strings. Numbers, durations and
+// other scalars are kept, so settings such as ACCESS_TOKEN_TTL stay visible.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		if isSensitive(a.Key) && a.Value.String() != "" {
+			return slog.String(a.Key, redacted)
+		}
+		a.Value = slog.StringValue(scrub(a.Value.String()))
+	case slog.KindDuration:
+		// Readable in JSON too, where durations are otherwise nanoseconds
+		a.Value = slog.StringValue(a.Value.Duration().String())
+	case slog.KindAny:
+		if isSensitive(a.Key) {
+			return slog.String(a.Key, redacted)
+		}
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(scrub(err.Error()))
+		}
+	}
+	return a
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveKeys[key] {
+		return true
+	}
+	for _, fragment := range sensitiveFragments {
+		if strings.Contains(key, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+func scrub(s string) string {
+	if !strings.Contains(s, "eyJ") && !strings.ContainsAny(s, " \t") {
+		return s
+	}
+	s = bearerPattern.ReplaceAllString(s, "$1 "+redacted)
+	return jwtPattern.ReplaceAllString(s, redacted)
+}
+
+type contextKey string
+
+const (
+	loggerKey    contextKey = "logger"
+	requestIDKey contextKey = "requestId"
+)
+
+// WithLogger returns a context carrying the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger of the request being served, or the
+// default logger outside a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the request being served, if any.
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
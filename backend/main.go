@@ -2,17 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/arnoldadero/sautii/config"
 	"github.com/arnoldadero/sautii/handlers"
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/metrics"
 	"github.com/arnoldadero/sautii/middleware"
+	"github.com/arnoldadero/sautii/migrations"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/server"
 	"github.com/arnoldadero/sautii/services"
+	"github.com/arnoldadero/sautii/tracing"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -38,83 +51,369 @@ type CreateIssueResponse struct {
 }
 
 func main() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env file")
+	// Load and validate the configuration
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logging.New(cfg.Logging, os.Stdout))
+	slog.Info("configuration loaded", "config", cfg)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Telemetry)
+	if err != nil {
+		fatal("failed to set up tracing", err)
 	}
 
+	// SIGINT or SIGTERM starts a graceful shutdown
+	shutdown, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Set up MongoDB connection
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Database.ConnectTimeout)
 	defer cancel()
 
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		log.Fatal("MONGODB_URI environment variable is not set")
-	}
-
-	clientOptions := options.Client().ApplyURI(mongoURI)
+	clientOptions := options.Client().ApplyURI(cfg.Database.MongoURI).SetMonitor(tracing.MongoMonitor(metrics.MongoMonitor()))
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to connect to MongoDB", err)
 	}
 
 	// Ping the database
 	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatal(err)
+		fatal("failed to connect to MongoDB", err)
 	}
 
-	fmt.Println("Connected to MongoDB!")
+	slog.Info("connected to MongoDB", "database", cfg.Database.MongoDatabase)
 
-	db := client.Database("sautii")
+	db := client.Database(cfg.Database.MongoDatabase)
+
+	// Create indexes and apply migrations, unless AUTO_MIGRATE=false leaves
+	// that to "sautii migrate"
+	if !cfg.Database.AutoMigrate {
+		pending, err := migrations.Pending(ctx, db)
+		if err != nil {
+			fatal("failed to check migrations", err)
+		}
+		if len(pending) > 0 {
+			slog.Warn("database migrations are pending, run sautii migrate", "pending", len(pending))
+		}
+	} else if _, err := migrations.Run(context.Background(), db); err != nil {
+		fatal("failed to apply migrations", err)
+	}
+
+	// Load the access token signing keys and keep rotating them
+	keyService := services.NewKeyService(db, cfg.Auth)
+	if err := keyService.Init(ctx); err != nil {
+		fatal("failed to load signing keys", err)
+	}
+	background := newWorkers()
+	background.Go("key rotation", keyService.StartRotation)
+
+	// Open storage, migrating PostgreSQL under the same AUTO_MIGRATE rule
+	store, err := repository.Open(ctx, db, cfg.Database)
+	if err != nil {
+		fatal("failed to open storage", err)
+	}
+	if !cfg.Database.AutoMigrate {
+		pending, err := store.PendingMigrations(ctx)
+		if err != nil {
+			fatal("failed to check migrations", err)
+		}
+		if len(pending) > 0 {
+			slog.Warn("postgres migrations are pending, run sautii migrate", "pending", len(pending))
+		}
+	}
 
 	// Set up router
 	r := mux.NewRouter()
-	setupRoutes(r, db)
+	setupRoutes(r, cfg, client, db, store, keyService, background)
+
+	// Every request, including ones rejected by middleware, gets a request
+	// ID and an access log line with the client address
+	srv, err := server.New(cfg.Server, middleware.RealIP(cfg.Server.TrustedProxies)(middleware.RequestLogger(r)))
+	if err != nil {
+		fatal("failed to configure server", err)
+	}
 
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	slog.Info("server is running", "port", cfg.Server.Port, "tls", cfg.Server.TLSCertFile != "")
+	if err := srv.Run(shutdown); err != nil {
+		slog.Error("server stopped", "error", err)
+	}
+
+	// Requests have drained; stop the background workers, then close the
+	// databases they use
+	slog.Info("shutting down")
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancelDrain()
+	if err := background.Stop(drainCtx); err != nil {
+		slog.Error("background workers did not stop", "error", err)
+	}
+	store.Close()
+	if err := client.Disconnect(drainCtx); err != nil {
+		slog.Error("failed to disconnect from MongoDB", "error", err)
+	}
+	if err := shutdownTracing(drainCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+}
+
+// fatal logs an error that prevents the server from starting and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// workers runs background loops until shutdown.
+type workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	stopped []string
+}
+
+func newWorkers() *workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workers{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a goroutine. fn must return soon after its context is
+// cancelled.
+func (w *workers) Go(name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+		if w.ctx.Err() == nil {
+			w.mu.Lock()
+			w.stopped = append(w.stopped, name)
+			w.mu.Unlock()
+		}
+	}()
+}
+
+// Check fails if a worker returned before shutdown.
+func (w *workers) Check(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.stopped) > 0 {
+		return fmt.Errorf("stopped unexpectedly: %s", strings.Join(w.stopped, ", "))
 	}
+	return nil
+}
 
-	fmt.Printf("Server is running on port %s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+// Stop cancels the workers and waits for them to return or ctx to end.
+func (w *workers) Stop(ctx context.Context) error {
+	w.cancel()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func setupRoutes(r *mux.Router, db *mongo.Database) {
+func setupRoutes(r *mux.Router, cfg *config.Config, client *mongo.Client, db *mongo.Database, store *repository.Store, keyService *services.KeyService, background *workers) {
 	// Initialize services
-	authService := services.NewAuthService(db, os.Getenv("JWT_SECRET"), os.Getenv("REFRESH_SECRET"))
-	issueService := services.NewIssueService(db)
-	searchService := services.NewSearchService(db)
-	aiService := services.NewAIService()
+	emailService := services.NewEmailService(cfg.Email, cfg.Server.AppBaseURL)
+	auditService := services.NewAuditService(db)
+	authService := services.NewAuthService(store, emailService, keyService, auditService, cfg.Auth)
+	jurisdictionService := services.NewJurisdictionService(db, store)
+	issueService := services.NewIssueService(store, jurisdictionService)
+	searchService := services.NewSearchService(store)
+	aiService := services.NewAIService(cfg.AI)
+	oidcService := services.NewOIDCService(db, authService, cfg.Auth.OIDCProviders, cfg.Server.AppBaseURL)
+	roleService := services.NewRoleService(db, authService, auditService)
+	representativeService := services.NewRepresentativeService(db, store, jurisdictionService, auditService)
+	// Rate limit buckets for route groups and API keys
+	rateLimitStore, err := services.NewRateLimitStore(db, cfg.Limits)
+	if err != nil {
+		fatal("failed to set up rate limiting", err)
+	}
+	apiKeyService := services.NewAPIKeyService(db, auditService, rateLimitStore)
+	messageService, err := services.NewMessageService(db, representativeService, issueService)
+	if err != nil {
+		fatal("failed to set up messaging", err)
+	}
+
+	privacyService, err := services.NewPrivacyService(db, store, authService, emailService, auditService)
+	if err != nil {
+		fatal("failed to set up data exports", err)
+	}
+	background.Go("export cleanup", privacyService.StartExportCleanup)
+
+	// Readiness checks; the AI backend only degrades the service because
+	// issues can be filed without a category
+	healthChecks := []services.HealthCheck{
+		{Name: "mongodb", Critical: true, Check: func(ctx context.Context) error {
+			return client.Ping(ctx, nil)
+		}},
+		{Name: "migrations", Critical: true, CacheFor: time.Minute, Check: func(ctx context.Context) error {
+			pending, err := migrations.Pending(ctx, db)
+			if err != nil {
+				return err
+			}
+			pendingPostgres, err := store.PendingMigrations(ctx)
+			if err != nil {
+				return err
+			}
+			if count := len(pending) + len(pendingPostgres); count > 0 {
+				return fmt.Errorf("%d migrations pending", count)
+			}
+			return nil
+		}},
+		{Name: "workers", Critical: true, Check: background.Check},
+		{Name: "ai", CacheFor: 5 * time.Minute, Check: aiService.Ping},
+	}
+	if cfg.Database.StorageBackend == "postgres" {
+		healthChecks = append(healthChecks, services.HealthCheck{Name: "postgres", Critical: true, Check: store.Ping})
+	}
+	healthService := services.NewHealthService(healthChecks...)
+
+	// Domain gauges are counted at most once a minute
+	metrics.RegisterIssueGauges(searchService.OpenIssueCounts, time.Minute)
+
+	// Rate limits per route group, keyed by user, API key or IP
+	rateLimits := services.RateLimitPolicies(cfg.Limits)
+	limit := func(group string, h http.Handler) http.Handler {
+		return middleware.RateLimit(rateLimitStore, rateLimits[group])(h)
+	}
 
 	// Middleware
+	r.Use(tracing.Middleware(cfg.Telemetry.ServiceName))
+	r.Use(middleware.TraceLogging)
+	r.Use(middleware.Metrics)
+	r.Use(middleware.MaxBodySize(cfg.Server.MaxBodyBytes))
 	r.Use(middleware.Cors)
-	r.Use(middleware.Auth(authService))
+	r.Use(middleware.Auth(authService, apiKeyService))
+
+	// Liveness and readiness probes
+	r.HandleFunc("/healthz", handlers.Liveness()).Methods("GET")
+	r.HandleFunc("/readyz", handlers.Readiness(healthService)).Methods("GET")
+
+	// Prometheus metrics
+	r.Handle("/metrics", middleware.MetricsAuth(cfg.Telemetry.MetricsToken, metrics.Handler())).Methods("GET")
+
+	// Public keys for verifying access tokens
+	r.HandleFunc("/.well-known/jwks.json", handlers.JWKS(keyService)).Methods("GET")
 
 	// API routes
 	api := r.PathPrefix("/api").Subrouter()
 
 	// Auth routes
 	auth := api.PathPrefix("/auth").Subrouter()
-	auth.HandleFunc("/register", handlers.Register(authService)).Methods("POST")
-	auth.HandleFunc("/login", handlers.Login(authService)).Methods("POST")
-	auth.HandleFunc("/refresh", handlers.RefreshToken(authService)).Methods("POST")
+	auth.Handle("/register", limit("auth", handlers.Register(authService))).Methods("POST")
+	auth.Handle("/login", limit("auth", handlers.Login(authService))).Methods("POST")
+	auth.Handle("/refresh", limit("auth", handlers.RefreshToken(authService))).Methods("POST")
 	auth.HandleFunc("/logout", handlers.Logout(authService)).Methods("POST")
 	auth.HandleFunc("/change-password", handlers.ChangePassword(authService)).Methods("POST")
 	auth.HandleFunc("/profile", handlers.GetProfile(authService)).Methods("GET")
+	auth.Handle("/verify-email", limit("auth", handlers.VerifyEmail(authService))).Methods("POST")
+	auth.Handle("/resend-verification", limit("auth", handlers.ResendVerification(authService))).Methods("POST")
+	auth.Handle("/forgot-password", limit("auth", handlers.ForgotPassword(authService))).Methods("POST")
+	auth.Handle("/reset-password", limit("auth", handlers.ResetPassword(authService))).Methods("POST")
+
+	// Two-factor authentication routes
+	auth.HandleFunc("/mfa/setup", handlers.SetupMFA(authService)).Methods("POST")
+	auth.HandleFunc("/mfa/confirm", handlers.ConfirmMFA(authService)).Methods("POST")
+	auth.Handle("/mfa/verify", limit("auth", handlers.VerifyMFA(authService))).Methods("POST")
+	auth.HandleFunc("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes(authService)).Methods("POST")
+	auth.HandleFunc("/mfa/disable", handlers.DisableMFA(authService)).Methods("POST")
+
+	// External identity provider routes
+	auth.HandleFunc("/oidc/providers", handlers.ListOIDCProviders(oidcService)).Methods("GET")
+	auth.Handle("/oidc/{provider}/login", limit("auth", handlers.OIDCLogin(oidcService))).Methods("GET")
+	auth.Handle("/oidc/{provider}/callback", limit("auth", handlers.OIDCCallback(oidcService))).Methods("GET")
+
+	// Session routes
+	auth.HandleFunc("/sessions", handlers.ListSessions(authService)).Methods("GET")
+	auth.HandleFunc("/sessions", handlers.RevokeOtherSessions(authService)).Methods("DELETE")
+	auth.HandleFunc("/sessions/{id}", handlers.RevokeSession(authService)).Methods("DELETE")
+
+	// Personal data routes
+	account := api.PathPrefix("/account").Subrouter()
+	account.HandleFunc("/exports", handlers.RequestDataExport(privacyService)).Methods("POST")
+	account.HandleFunc("/exports", handlers.ListDataExports(privacyService)).Methods("GET")
+	account.HandleFunc("/exports/{id}", handlers.GetDataExport(privacyService)).Methods("GET")
+	account.HandleFunc("/exports/{id}/download", handlers.DownloadDataExport(privacyService)).Methods("GET")
+	account.HandleFunc("", handlers.DeleteAccount(privacyService)).Methods("DELETE")
 
-	// Issue routes
-	api.HandleFunc("/issues", handlers.CreateIssue(issueService, aiService)).Methods("POST")
-	api.HandleFunc("/issues", handlers.SearchIssues(searchService)).Methods("GET")
+	// Routes guarded by a permission
+	require := func(permission models.Permission, h http.Handler) http.Handler {
+		return middleware.RequirePermission(permission)(h)
+	}
+
+	// Admin routes
+	admin := api.PathPrefix("/admin").Subrouter()
+	admin.Handle("/roles", require(models.PermViewUsers, handlers.ListRoles())).Methods("GET")
+	admin.Handle("/users/{id}/role", require(models.PermManageOfficials, handlers.GrantRole(roleService))).Methods("PUT")
+	admin.Handle("/users/{id}/role", require(models.PermManageOfficials, handlers.RevokeRole(roleService))).Methods("DELETE")
+	admin.Handle("/users/{id}/role-history", require(models.PermViewAuditLog, handlers.GetRoleHistory(roleService))).Methods("GET")
+	admin.Handle("/users/{id}/logout", require(models.PermRevokeSessions, handlers.ForceLogout(authService))).Methods("POST")
+	admin.Handle("/users/{id}/unlock", require(models.PermUnlockAccounts, handlers.UnlockAccount(authService))).Methods("POST")
+	admin.Handle("/security-log", require(models.PermViewAuditLog, handlers.GetSecurityLog(auditService))).Methods("GET")
+	admin.Handle("/users/{id}/jurisdictions", require(models.PermManageOfficials, handlers.AssignJurisdictions(roleService))).Methods("PUT")
+	admin.Handle("/jurisdictions", require(models.PermManageJurisdictions, handlers.CreateJurisdiction(jurisdictionService))).Methods("POST")
+	admin.Handle("/jurisdictions/backfill", require(models.PermManageJurisdictions, handlers.BackfillJurisdictions(jurisdictionService))).Methods("POST")
+	admin.Handle("/api-keys", require(models.PermManageAPIKeys, handlers.ListAPIKeys(apiKeyService))).Methods("GET")
+	admin.Handle("/api-keys", require(models.PermManageAPIKeys, handlers.CreateAPIKey(apiKeyService))).Methods("POST")
+	admin.Handle("/api-keys/{id}", require(models.PermManageAPIKeys, handlers.RevokeAPIKey(apiKeyService))).Methods("DELETE")
+	admin.Handle("/representatives", require(models.PermVerifyRepresentatives, handlers.ListRepresentativeProfiles(representativeService))).Methods("GET")
+	admin.Handle("/representatives/{id}/verification", require(models.PermVerifyRepresentatives, handlers.ReviewRepresentative(representativeService))).Methods("PUT")
+
+	// Jurisdiction routes
+	api.HandleFunc("/jurisdictions", handlers.ListJurisdictions(jurisdictionService)).Methods("GET")
+	api.HandleFunc("/jurisdictions/locate", handlers.LocateJurisdictions(jurisdictionService)).Methods("GET")
+	api.HandleFunc("/jurisdictions/{id}", handlers.GetJurisdiction(jurisdictionService)).Methods("GET")
+
+	// Representative directory
+	api.HandleFunc("/representatives", handlers.ListRepresentatives(representativeService)).Methods("GET")
+	api.HandleFunc("/representatives", handlers.CreateRepresentative(representativeService)).Methods("POST")
+	api.HandleFunc("/representatives/locate", handlers.LocateRepresentatives(representativeService)).Methods("GET")
+	api.HandleFunc("/representatives/{id}", handlers.GetRepresentative(representativeService)).Methods("GET")
+	api.Handle("/representatives/{id}/stats", limit("search", handlers.GetRepresentativeStats(representativeService))).Methods("GET")
+	api.HandleFunc("/representatives/{id}/staff", handlers.ListStaff(representativeService)).Methods("GET")
+	api.HandleFunc("/representatives/{id}/staff/{userId}", handlers.AddStaff(representativeService)).Methods("PUT")
+	api.HandleFunc("/representatives/{id}/staff/{userId}", handlers.RemoveStaff(representativeService)).Methods("DELETE")
+
+	// Actions that unverified accounts may be restricted from
+	requireVerified := func(action string, h http.HandlerFunc) http.Handler {
+		return middleware.RequireVerified(authService, action)(h)
+	}
+
+	// Issue routes. Updates are authorized per field by the issue service.
+	api.Handle("/issues", limit("issues", require(models.PermCreateIssue, requireVerified(services.ActionCreateIssue, handlers.CreateIssue(issueService, aiService))))).Methods("POST")
+	api.Handle("/issues", limit("search", handlers.SearchIssues(searchService))).Methods("GET")
 	api.HandleFunc("/issues/{id}", handlers.GetIssue(issueService)).Methods("GET")
 	api.HandleFunc("/issues/{id}", handlers.UpdateIssue(issueService)).Methods("PUT")
-	api.HandleFunc("/issues/{id}/vote", handlers.VoteOnIssue(issueService)).Methods("POST")
-	api.HandleFunc("/issues/{id}/comments", handlers.AddComment(issueService)).Methods("POST")
+	api.Handle("/issues/{id}/vote", limit("votes", require(models.PermVoteIssue, requireVerified(services.ActionVote, handlers.VoteOnIssue(issueService))))).Methods("POST")
+	api.Handle("/issues/{id}/comments", limit("comments", require(models.PermCommentIssue, requireVerified(services.ActionComment, handlers.AddComment(issueService))))).Methods("POST")
+
+	// Messaging routes
+	api.HandleFunc("/conversations", handlers.ListConversations(messageService)).Methods("GET")
+	api.Handle("/conversations", requireVerified(services.ActionMessage, handlers.StartConversation(messageService))).Methods("POST")
+	api.HandleFunc("/conversations/{id}", handlers.GetConversation(messageService)).Methods("GET")
+	api.HandleFunc("/conversations/{id}/status", handlers.SetConversationStatus(messageService)).Methods("PUT")
+	api.HandleFunc("/conversations/{id}/messages", handlers.ListMessages(messageService)).Methods("GET")
+	api.Handle("/conversations/{id}/messages", requireVerified(services.ActionMessage, handlers.SendMessage(messageService))).Methods("POST")
+	api.HandleFunc("/conversations/{id}/messages/{messageId}/issue", handlers.ConvertMessageToIssue(messageService)).Methods("POST")
+	api.HandleFunc("/conversations/{id}/attachments/{attachmentId}", handlers.DownloadAttachment(messageService)).Methods("GET")
 
 	// Search routes
-	api.HandleFunc("/search/issues", handlers.SearchIssues(searchService)).Methods("POST")
-	api.HandleFunc("/search/facets", handlers.GetSearchFacets(searchService)).Methods("POST")
+	api.Handle("/search/issues", limit("search", handlers.SearchIssues(searchService))).Methods("POST")
+	api.Handle("/search/facets", limit("search", handlers.GetSearchFacets(searchService))).Methods("POST")
 
 	// Static file serving
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./static")))
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aiClassifications = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_classifications_total",
+		Help:      "Issues classified by the AI service by predicted category.",
+	}, []string{"category"})
+
+	aiFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_classification_failures_total",
+		Help:      "Failed AI classifications by reason.",
+	}, []string{"reason"})
+
+	aiDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ai_request_duration_seconds",
+		Help:      "Time OpenAI classification requests took, including failures.",
+		Buckets:   []float64{.1, .25, .5, 1, 2, 4, 8, 15, 30, 60},
+	})
+
+	aiTokens = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_tokens_total",
+		Help:      "OpenAI tokens used by type (prompt or completion).",
+	}, []string{"type"})
+)
+
+// Reasons an AI classification failed.
+const (
+	AIFailureRequest  = "request"
+	AIFailureStatus   = "status"
+	AIFailureResponse = "response"
+)
+
+func ObserveAIRequest(duration time.Duration) {
+	aiDuration.Observe(duration.Seconds())
+}
+
+func ObserveAIClassification(category string) {
+	aiClassifications.WithLabelValues(category).Inc()
+}
+
+func ObserveAIFailure(reason string) {
+	aiFailures.WithLabelValues(reason).Inc()
+}
+
+func AddAITokens(prompt, completion int) {
+	aiTokens.WithLabelValues("prompt").Add(float64(prompt))
+	aiTokens.WithLabelValues("completion").Add(float64(completion))
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const issueCountTimeout = 5 * time.Second
+
+var openIssuesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "open_issues"),
+	"Issues not yet resolved or closed by category and status.",
+	[]string{"category", "status"}, nil,
+)
+
+// IssueCounter returns the number of open issues by status, then category.
+type IssueCounter func(ctx context.Context) (map[string]map[string]int64, error)
+
+// RegisterIssueGauges exports the open issue counts. They are counted at
+// most once per cacheFor, however often Prometheus scrapes; if counting
+// fails the last counts are kept.
+func RegisterIssueGauges(count IssueCounter, cacheFor time.Duration) {
+	Registry.MustRegister(&issueCollector{count: count, cacheFor: cacheFor})
+}
+
+type issueCollector struct {
+	count    IssueCounter
+	cacheFor time.Duration
+
+	mu        sync.Mutex
+	counts    map[string]map[string]int64
+	countedAt time.Time
+}
+
+func (c *issueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openIssuesDesc
+}
+
+func (c *issueCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.countedAt) >= c.cacheFor {
+		ctx, cancel := context.WithTimeout(context.Background(), issueCountTimeout)
+		counts, err := c.count(ctx)
+		cancel()
+		if err != nil {
+			slog.Error("failed to count open issues for metrics", "error", err)
+		} else {
+			c.counts = counts
+			c.countedAt = time.Now()
+		}
+	}
+
+	for status, categories := range c.counts {
+		for category, n := range categories {
+			ch <- prometheus.MustNewConstMetric(openIssuesDesc, prometheus.GaugeValue, float64(n), category, status)
+		}
+	}
+}
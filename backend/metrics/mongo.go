@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var mongoDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "mongodb_command_duration_seconds",
+	Help:      "Time MongoDB commands took by command, collection and outcome.",
+	Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 10},
+}, []string{"command", "collection", "outcome"})
+
+// MongoMonitor returns a command monitor recording the latency of every
+// MongoDB command, for options.Client().SetMonitor.
+func MongoMonitor() *event.CommandMonitor {
+	// The collection is only part of the started event, so it is kept
+	// until the command finishes
+	var collections sync.Map
+
+	finished := func(e event.CommandFinishedEvent, outcome string) {
+		collection, _ := collections.LoadAndDelete(e.RequestID)
+		name, _ := collection.(string)
+		mongoDuration.WithLabelValues(e.CommandName, name, outcome).Observe(e.Duration.Seconds())
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			collections.Store(e.RequestID, commandCollection(e))
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finished(e.CommandFinishedEvent, "success")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent, "failure")
+		},
+	}
+}
+
+// commandCollection returns the collection a command acts on. Most commands
+// name it as their first value; getMore names it in a separate field.
+func commandCollection(e *event.CommandStartedEvent) string {
+	if collection, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+		return collection
+	}
+	if collection, ok := e.Command.Lookup("collection").StringValueOK(); ok {
+		return collection
+	}
+	return ""
+}
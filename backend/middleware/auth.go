@@ -2,59 +2,155 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/arnoldadero/sautii/models"
 	"github.com/arnoldadero/sautii/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type contextKey string
 
 const (
-	UserIDKey contextKey = "userId"
-	RoleKey   contextKey = "role"
+	UserIDKey    contextKey = "userId"
+	RoleKey      contextKey = "role"
+	SessionIDKey contextKey = "sessionId"
+
+	JurisdictionsKey contextKey = "jurisdictions"
+
+	APIKeyIDKey contextKey = "apiKeyId"
+	ScopesKey   contextKey = "scopes"
 )
 
-func Auth(authService *services.AuthService) func(http.Handler) http.Handler {
+// Auth authenticates requests with either a user's bearer token or an API key.
+// API key requests carry no user; what they may do is limited by the key's
+// scopes.
+func Auth(authService *services.AuthService, apiKeyService *services.APIKeyService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Skip authentication for public endpoints
-			if isPublicPath(r.URL.Path) {
-				next.ServeHTTP(w, r)
+			public := isPublicPath(r.Method, r.URL.Path)
+
+			// A presented API key must be valid, even on public endpoints
+			if key := apiKeyFromRequest(r); key != "" {
+				ctx, status, message := authenticateAPIKey(w, r, apiKeyService, key, public)
+				if status != 0 {
+					http.Error(w, message, status)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
 			// Get token from header
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
+				if public {
+					next.ServeHTTP(w, r)
+					return
+				}
 				http.Error(w, "Authorization header required", http.StatusUnauthorized)
 				return
 			}
 
-			// Parse token
-			tokenParts := strings.Split(authHeader, " ")
-			if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
-				return
-			}
-
-			// Verify token
-			claims, err := authService.VerifyAccessToken(tokenParts[1])
-			if err != nil {
-				http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			ctx, status, message := authenticate(r, authService, authHeader)
+			if status != 0 {
+				// Public endpoints are served anonymously instead
+				if public {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, message, status)
 				return
 			}
 
-			// Add user info to context
-			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
-			ctx = context.WithValue(ctx, RoleKey, claims.Role)
-
 			// Call next handler with updated context
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// authenticate verifies the bearer token and returns the request context with
+// the user's details, or an HTTP status and message on failure.
+func authenticate(r *http.Request, authService *services.AuthService, authHeader string) (context.Context, int, string) {
+	// Parse token
+	tokenParts := strings.Split(authHeader, " ")
+	if len(tokenParts) != 2 || strings.ToLower(tokenParts[0]) != "bearer" {
+		return nil, http.StatusUnauthorized, "Invalid authorization header format"
+	}
+
+	// Verify token
+	claims, err := authService.VerifyAccessToken(tokenParts[1])
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid or expired token"
+	}
+
+	// Use the current role rather than the one in the token so role changes
+	// apply immediately
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid or expired token"
+	}
+	sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid or expired token"
+	}
+	state, err := authService.AccessState(r.Context(), userID, sessionID)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "Invalid or expired token"
+	}
+
+	// Accounts that must enroll in MFA can do nothing else first
+	if services.RoleRequiresMFA(state.Role) && !state.MFAEnabled && !isMFASetupPath(r.URL.Path) {
+		return nil, http.StatusForbidden, "Two-factor authentication setup required"
+	}
+
+	// Add user info to context
+	ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+	ctx = context.WithValue(ctx, RoleKey, state.Role)
+	ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+	ctx = context.WithValue(ctx, JurisdictionsKey, state.JurisdictionIDs)
+	return identify(ctx, claims.UserID, ""), 0, ""
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, key, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "apikey") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// authenticateAPIKey verifies an API key and returns the request context with
+// the key's details, or an HTTP status and message on failure. A key over its
+// rate limit gets a Retry-After header.
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, apiKeyService *services.APIKeyService, plaintext string, public bool) (context.Context, int, string) {
+	key, err := apiKeyService.Authenticate(r.Context(), plaintext, ClientIP(r))
+	if err != nil {
+		var limited *services.APIKeyRateLimitedError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(limited.RetryAfter)))
+			return nil, http.StatusTooManyRequests, err.Error()
+		}
+		return nil, http.StatusUnauthorized, services.ErrInvalidAPIKey.Error()
+	}
+
+	// Reading public data needs the read scope; everything else is checked
+	// against the key's scopes by RequirePermission
+	if public && !key.HasScope(models.ScopeSearchRead) {
+		return nil, http.StatusForbidden, "API key lacks the " + models.ScopeSearchRead + " scope"
+	}
+
+	ctx := context.WithValue(r.Context(), APIKeyIDKey, key.ID.Hex())
+	ctx = context.WithValue(ctx, ScopesKey, key.Scopes)
+	return identify(ctx, "", key.ID.Hex()), 0, ""
+}
+
 func RequireRole(roles ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -79,6 +175,56 @@ func RequireRole(roles ...string) func(http.Handler) http.Handler {
 	}
 }
 
+// RequireVerified rejects requests from accounts that may not perform the
+// action until they have verified their email address. API keys are not
+// subject to it.
+func RequireVerified(authService *services.AuthService, action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetAPIKeyID(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, err := primitive.ObjectIDFromHex(GetUserID(r.Context()))
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if err := authService.CanPerform(r.Context(), userID, action); err != nil {
+				if errors.Is(err, services.ErrVerificationNeeded) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission rejects requests from users whose role lacks the
+// permission, and from API keys whose scopes don't grant it.
+func RequirePermission(permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := models.HasPermission(GetUserRole(r.Context()), permission)
+			if GetAPIKeyID(r.Context()) != "" {
+				allowed = models.ScopesAllow(GetScopes(r.Context()), permission)
+			}
+			if !allowed {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func GetUserID(ctx context.Context) string {
 	if userID, ok := ctx.Value(UserIDKey).(string); ok {
 		return userID
@@ -93,12 +239,50 @@ func GetUserRole(ctx context.Context) string {
 	return ""
 }
 
-func isPublicPath(path string) bool {
+func GetSessionID(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(SessionIDKey).(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
+// GetJurisdictionIDs returns the jurisdictions of the authenticated user.
+func GetJurisdictionIDs(ctx context.Context) []primitive.ObjectID {
+	if ids, ok := ctx.Value(JurisdictionsKey).([]primitive.ObjectID); ok {
+		return ids
+	}
+	return nil
+}
+
+// GetAPIKeyID returns the ID of the API key the request was made with.
+func GetAPIKeyID(ctx context.Context) string {
+	if id, ok := ctx.Value(APIKeyIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+func GetScopes(ctx context.Context) []string {
+	if scopes, ok := ctx.Value(ScopesKey).([]string); ok {
+		return scopes
+	}
+	return nil
+}
+
+func isPublicPath(method, path string) bool {
 	publicPaths := []string{
+		"/.well-known/jwks.json",
+		"/healthz",
+		"/readyz",
+		"/metrics",
 		"/api/auth/login",
 		"/api/auth/register",
 		"/api/auth/refresh",
-		"/api/issues",  // GET only
+		"/api/auth/verify-email",
+		"/api/auth/forgot-password",
+		"/api/auth/reset-password",
+		"/api/auth/mfa/verify",
+		"/api/auth/oidc/",
 		"/api/search/issues",
 		"/api/search/facets",
 	}
@@ -109,5 +293,20 @@ func isPublicPath(path string) bool {
 		}
 	}
 
+	// Issues, jurisdictions and representatives can be read without an account
+	if method == http.MethodGet {
+		for _, prefix := range []string{"/api/issues", "/api/jurisdictions", "/api/representatives"} {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+
 	return false
 }
+
+func isMFASetupPath(path string) bool {
+	return strings.HasPrefix(path, "/api/auth/mfa/") ||
+		path == "/api/auth/logout" ||
+		path == "/api/auth/profile"
+}
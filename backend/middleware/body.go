@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+)
+
+// MaxBodySize limits request bodies to limit bytes; reading past it fails
+// and closes the connection. Handlers accepting uploads can allow more with
+// RaiseBodyLimit.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), original: r.Body}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody is a body limited by MaxBodySize, remembering the original so
+// the limit can be replaced.
+type limitedBody struct {
+	io.ReadCloser
+	original io.ReadCloser
+}
+
+// RaiseBodyLimit replaces the limit set by MaxBodySize with a larger one for
+// this request. It must be called before the body is read.
+func RaiseBodyLimit(w http.ResponseWriter, r *http.Request, limit int64) {
+	body := r.Body
+	if limited, ok := body.(*limitedBody); ok {
+		body = limited.original
+	}
+	r.Body = http.MaxBytesReader(w, body, limit)
+}
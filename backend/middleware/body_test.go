@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	const limit = 10
+	body := strings.Repeat("x", 20)
+
+	read := func(contentType string, raise int64) error {
+		var err error
+		handler := MaxBodySize(limit)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if raise > 0 {
+				RaiseBodyLimit(w, r, raise)
+			}
+			_, err = io.ReadAll(r.Body)
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		return err
+	}
+
+	if err := read("application/json", 0); err == nil {
+		t.Error("JSON body over the limit was read")
+	}
+	if err := read("multipart/form-data; boundary=x", 0); err == nil {
+		t.Error("multipart body over the limit was read")
+	}
+	if err := read("multipart/form-data; boundary=x", 2*limit); err != nil {
+		t.Errorf("body within the raised limit: %v", err)
+	}
+	if err := read("multipart/form-data; boundary=x", limit+5); err == nil {
+		t.Error("body over the raised limit was read")
+	}
+}
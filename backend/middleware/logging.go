@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// Request IDs from clients or proxies are kept only if they are safe to log
+// and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestInfoKey struct{}
+
+// requestInfo collects what inner middleware learns about the caller, so it
+// can be included in the access log written by the outer one.
+type requestInfo struct {
+	mu       sync.Mutex
+	userID   string
+	apiKeyID string
+	traceID  string
+}
+
+// RequestLogger assigns each request an ID, taken from the X-Request-ID
+// header when present and otherwise generated, and returns it in the
+// response. The request context carries a logger tagged with the ID, which
+// services get with logging.FromContext. When the request completes, one
+// access log line records the method, path, status, latency and caller.
+//
+// It wraps the whole router so that requests rejected by other middleware
+// are logged too.
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		info := &requestInfo{}
+		ctx := logging.WithRequestID(r.Context(), requestID)
+		ctx = logging.WithLogger(ctx, logger)
+		ctx = context.WithValue(ctx, requestInfoKey{}, info)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("duration", time.Since(start)),
+			slog.Int64("bytes", rec.bytes),
+			slog.String("ip", ClientIP(r)),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		info.mu.Lock()
+		if info.userID != "" {
+			attrs = append(attrs, slog.String("user_id", info.userID))
+		}
+		if info.apiKeyID != "" {
+			attrs = append(attrs, slog.String("api_key_id", info.apiKeyID))
+		}
+		if info.traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", info.traceID))
+		}
+		info.mu.Unlock()
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// identify records the authenticated caller for the access log and adds it
+// to the request's logger.
+func identify(ctx context.Context, userID, apiKeyID string) context.Context {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.mu.Lock()
+		info.userID, info.apiKeyID = userID, apiKeyID
+		info.mu.Unlock()
+	}
+
+	logger := logging.FromContext(ctx)
+	if userID != "" {
+		logger = logger.With("user_id", userID)
+	}
+	if apiKeyID != "" {
+		logger = logger.With("api_key_id", apiKeyID)
+	}
+	return logging.WithLogger(ctx, logger)
+}
+
+// TraceLogging adds the trace and span IDs of the request's span to its
+// logger and access log line, and the request ID to the span, so that logs
+// and traces can be found from each other. It must run after the tracing
+// middleware.
+func TraceLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+		sc := span.SpanContext()
+		if !sc.IsValid() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+			info.mu.Lock()
+			info.traceID = sc.TraceID().String()
+			info.mu.Unlock()
+		}
+		span.SetAttributes(attribute.String("request.id", logging.RequestID(ctx)))
+
+		logger := logging.FromContext(ctx).With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+		next.ServeHTTP(w, r.WithContext(logging.WithLogger(ctx, logger)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder captures the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/services"
+)
+
+// RateLimit limits requests with a token bucket per caller: the user for
+// authenticated requests, the API key for machine clients and otherwise the
+// client IP as determined by RealIP, so anonymous callers cannot reset their
+// bucket by rotating X-Forwarded-For. It must run after Auth. Responses carry
+// the RateLimit-* headers and Retry-After when the limit is reached. If the
+// store fails the request is allowed.
+func RateLimit(store services.RateLimitStore, policy services.RateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := policy.Name + ":" + rateLimitKey(r)
+			result, err := store.Take(r.Context(), key, policy)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("rate limit check failed", "policy", policy.Name, "error", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Period.Seconds())))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				http.Error(w, "Too many requests, please try again later", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func rateLimitKey(r *http.Request) string {
+	if userID := GetUserID(r.Context()); userID != "" {
+		return "user:" + userID
+	}
+	if keyID := GetAPIKeyID(r.Context()); keyID != "" {
+		return "key:" + keyID
+	}
+	return "ip:" + ClientIP(r)
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/services"
+)
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	policy := services.RateLimitPolicy{Name: "auth", Limit: 3, Period: time.Minute}
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := RealIP(trusted)(RateLimit(services.NewMemoryRateLimitStore(), policy)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	))
+
+	send := func(remoteAddr, forwarded string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/login", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// A client talking to the server directly cannot pick its own bucket
+	for i := 0; i < policy.Limit; i++ {
+		if status := send("203.0.113.7:5000", fmt.Sprintf("198.51.100.%d", i)); status != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+	if status := send("203.0.113.7:5000", "198.51.100.99"); status != http.StatusTooManyRequests {
+		t.Fatalf("rotating X-Forwarded-For: status = %d, want %d", status, http.StatusTooManyRequests)
+	}
+
+	// Nor can one behind a trusted proxy by prepending entries
+	for i := 0; i < policy.Limit; i++ {
+		if status := send("10.0.0.5:5000", fmt.Sprintf("1.2.3.%d, 198.51.100.1", i)); status != http.StatusOK {
+			t.Fatalf("proxied request %d: status = %d, want %d", i, status, http.StatusOK)
+		}
+	}
+	if status := send("10.0.0.5:5000", "1.2.3.99, 198.51.100.1"); status != http.StatusTooManyRequests {
+		t.Fatalf("prepending to X-Forwarded-For: status = %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPKey contextKey = "clientIp"
+
+// RealIP determines the client address of each request for ClientIP. The
+// X-Forwarded-For header is only believed when the request comes from one of
+// the trusted proxies, and then only as far as the chain of trusted proxies
+// goes: the right-most entry that is not a trusted proxy is the client, since
+// everything left of it could have been sent by the client itself.
+//
+// It wraps the whole router so that every middleware sees the same address.
+func RealIP(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, prefix := range trusted {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := remoteIP(r)
+			if addr, err := netip.ParseAddr(ip); err == nil && isTrusted(addr.Unmap()) {
+				ip = forwardedClient(r.Header.Values("X-Forwarded-For"), isTrusted, ip)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+		})
+	}
+}
+
+// forwardedClient walks the X-Forwarded-For entries from the right, skipping
+// trusted proxies, and returns the first other address. If the chain holds
+// only trusted proxies the left-most of them is returned, and an entry that
+// is not an address ends the walk.
+func forwardedClient(headers []string, isTrusted func(netip.Addr) bool, peer string) string {
+	var entries []string
+	for _, header := range headers {
+		entries = append(entries, strings.Split(header, ",")...)
+	}
+
+	client := peer
+	for i := len(entries) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(entries[i]))
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		client = addr.String()
+		if !isTrusted(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// ClientIP returns the address of the client as determined by RealIP, or the
+// peer address of the connection outside it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.1/32")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:5000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "forwarded header from an untrusted peer is ignored",
+			remoteAddr: "203.0.113.7:5000",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.5:5000",
+			forwarded:  []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "entries added by the client are skipped",
+			remoteAddr: "10.0.0.5:5000",
+			forwarded:  []string{"1.2.3.4, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.5:5000",
+			forwarded:  []string{"1.2.3.4, 198.51.100.1, 192.0.2.1", "10.1.1.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "only trusted proxies",
+			remoteAddr: "10.0.0.5:5000",
+			forwarded:  []string{"10.2.2.2, 10.1.1.1"},
+			want:       "10.2.2.2",
+		},
+		{
+			name:       "malformed entry ends the walk",
+			remoteAddr: "10.0.0.5:5000",
+			forwarded:  []string{"198.51.100.1, garbage, 10.1.1.1"},
+			want:       "10.1.1.1",
+		},
+		{
+			name:       "trusted proxy without a header",
+			remoteAddr: "10.0.0.5:5000",
+			want:       "10.0.0.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutRealIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	if got := ClientIP(r); got != "203.0.113.7" {
+		t.Errorf("ClientIP = %q, want the peer address", got)
+	}
+}
@@ -0,0 +1,175 @@
+// Package migrations brings a MongoDB database up to date: it creates the
+// indexes every service depends on and applies the versioned migrations in
+// this package that have not been applied yet, recording each in
+// schema_migrations.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/arnoldadero/sautii/services"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	lockID = "migrations"
+	// A lock held longer than this is assumed to belong to an instance that
+	// stopped while migrating
+	lockTimeout   = 30 * time.Minute
+	lockRetryWait = time.Second
+)
+
+// Migration is one versioned change to the database. Up must be safe to run
+// again if it failed part way.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// AppliedMigration records a migration that was applied.
+type AppliedMigration struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Run creates the service indexes and applies the pending migrations in
+// version order, returning the ones it applied. Instances starting together
+// wait for each other.
+func Run(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	if err := lock(ctx, db); err != nil {
+		return nil, err
+	}
+	defer unlock(db)
+
+	if err := ensureIndexes(ctx, db); err != nil {
+		return nil, err
+	}
+
+	pending, err := Pending(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, migration := range pending {
+		start := time.Now()
+		if err := migration.Up(ctx, db); err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d (%s): %v", migration.Version, migration.Description, err)
+		}
+
+		_, err := db.Collection("schema_migrations").InsertOne(ctx, AppliedMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
+		}
+		slog.Info("applied migration", "version", migration.Version, "description", migration.Description, "duration", time.Since(start).Round(time.Millisecond))
+		applied = append(applied, migration)
+	}
+	return applied, nil
+}
+
+// Applied returns the migrations applied to the database in version order.
+func Applied(ctx context.Context, db *mongo.Database) ([]AppliedMigration, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := db.Collection("schema_migrations").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	applied := []AppliedMigration{}
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %v", err)
+	}
+	return applied, nil
+}
+
+// Pending returns the migrations not yet applied to the database in version
+// order.
+func Pending(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	applied, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[int]bool, len(applied))
+	for _, migration := range applied {
+		done[migration.Version] = true
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if !done[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	return pending, nil
+}
+
+// ensureIndexes creates the indexes each service declares. They are created
+// on every run, so services can add indexes without a migration.
+func ensureIndexes(ctx context.Context, db *mongo.Database) error {
+	for _, ensure := range []func(context.Context, *mongo.Database) error{
+		services.EnsureAuthIndexes,
+		services.EnsureJurisdictionIndexes,
+		services.EnsureRepresentativeIndexes,
+		services.EnsureMessageIndexes,
+		services.EnsureAPIKeyIndexes,
+		services.EnsureRateLimitIndexes,
+		services.EnsurePrivacyIndexes,
+	} {
+		if err := ensure(ctx, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lock takes the migration lock, waiting while another instance holds it.
+func lock(ctx context.Context, db *mongo.Database) error {
+	locks := db.Collection("migration_locks")
+	for {
+		now := time.Now()
+		_, err := locks.InsertOne(ctx, bson.M{"_id": lockID, "lockedAt": now, "expiresAt": now.Add(lockTimeout)})
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("failed to lock migrations: %v", err)
+		}
+
+		// Take over a lock left behind by a stopped instance
+		result, err := locks.DeleteOne(ctx, bson.M{"_id": lockID, "expiresAt": bson.M{"$lt": now}})
+		if err != nil {
+			return fmt.Errorf("failed to lock migrations: %v", err)
+		}
+		if result.DeletedCount > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for another instance to finish migrating: %v", ctx.Err())
+		case <-time.After(lockRetryWait):
+		}
+	}
+}
+
+func unlock(db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := db.Collection("migration_locks").DeleteOne(ctx, bson.M{"_id": lockID}); err != nil {
+		slog.Error("failed to unlock migrations", "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations lists every migration. Versions are never reused or changed
+// once released; add a new migration instead.
+var migrations = []Migration{
+	{Version: 1, Description: "create issue text index", Up: createIssueTextIndex},
+	{Version: 2, Description: "make user email and username unique", Up: createUniqueUserIndexes},
+	{Version: 3, Description: "store locations as GeoJSON points", Up: convertLocationsToGeoJSON},
+}
+
+// createIssueTextIndex creates the index issue search uses for $text.
+func createIssueTextIndex(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("issues").Indexes().CreateOne(ctx, repository.IssueTextIndex)
+	return err
+}
+
+// createUniqueUserIndexes makes emails and usernames unique. Existing
+// duplicates have to be resolved by hand first, so they are listed instead of
+// failing on the first one the index build finds.
+func createUniqueUserIndexes(ctx context.Context, db *mongo.Database) error {
+	users := db.Collection("users")
+	for _, field := range []string{"email", "username"} {
+		cursor, err := users.Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$group", Value: bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}}},
+			{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+			{{Key: "$limit", Value: 20}},
+		})
+		if err != nil {
+			return err
+		}
+		var duplicates []struct {
+			Value interface{} `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &duplicates); err != nil {
+			return err
+		}
+		if len(duplicates) > 0 {
+			values := make([]string, len(duplicates))
+			for i, duplicate := range duplicates {
+				values[i] = fmt.Sprint(duplicate.Value)
+			}
+			return fmt.Errorf("users share the %s %s", field, strings.Join(values, ", "))
+		}
+	}
+
+	_, err := users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetName("email_unique").SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetName("username_unique").SetUnique(true)},
+	})
+	return err
+}
+
+// convertLocationsToGeoJSON rewrites lat and lng locations as GeoJSON points
+// and creates the 2dsphere index issue search uses for $geoWithin.
+func convertLocationsToGeoJSON(ctx context.Context, db *mongo.Database) error {
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"location": bson.M{
+		"type":        "Point",
+		"coordinates": bson.A{"$location.lng", "$location.lat"},
+		"address":     "$location.address",
+	}}}}}
+	for _, collection := range []string{"issues", "users"} {
+		result, err := db.Collection(collection).UpdateMany(ctx,
+			bson.M{"location.lat": bson.M{"$exists": true}, "location.type": bson.M{"$exists": false}},
+			update,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to convert %s locations: %v", collection, err)
+		}
+		if result.ModifiedCount > 0 {
+			slog.Info("converted locations to GeoJSON", "collection", collection, "count", result.ModifiedCount)
+		}
+	}
+
+	_, err := db.Collection("issues").Indexes().CreateOne(ctx, repository.IssueLocationIndex)
+	return err
+}
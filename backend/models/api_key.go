@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// API key scopes. A scope grants a machine client the permissions listed in
+// scopePermissions; reading public data needs ScopeSearchRead.
+const (
+	ScopeSearchRead   = "search:read"
+	ScopeIssuesCreate = "issues:create"
+)
+
+var scopePermissions = map[string][]Permission{
+	ScopeSearchRead:   nil,
+	ScopeIssuesCreate: {PermCreateIssue},
+}
+
+// APIKey authenticates a machine client such as a partner dashboard. Only a
+// hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	KeyHash    string             `bson:"keyHash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	RateLimit  int                `bson:"rateLimit" json:"rateLimit"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	ExpiresAt  *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	LastUsedIP string             `bson:"lastUsedIp,omitempty" json:"lastUsedIp,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// NewAPIKey is returned once when a key is created; the plaintext key cannot
+// be retrieved again.
+type NewAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+func ValidScope(scope string) bool {
+	_, ok := scopePermissions[scope]
+	return ok
+}
+
+// ScopePermissions returns the permissions a scope grants.
+func ScopePermissions(scope string) []Permission {
+	return append([]Permission(nil), scopePermissions[scope]...)
+}
+
+// HasScope reports whether the key has the scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopesAllow reports whether any of the scopes grants the permission.
+func ScopesAllow(scopes []string, permission Permission) bool {
+	for _, scope := range scopes {
+		for _, p := range scopePermissions[scope] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
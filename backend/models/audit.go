@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEntry records a security or compliance relevant action.
+type AuditEntry struct {
+	ID           primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Action       string                 `bson:"action" json:"action"`
+	ActorID      primitive.ObjectID     `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	TargetUserID primitive.ObjectID     `bson:"targetUserId,omitempty" json:"targetUserId,omitempty"`
+	Details      map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	IP           string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+	RequestID    string                 `bson:"requestId,omitempty" json:"requestId,omitempty"`
+	CreatedAt    time.Time              `bson:"createdAt" json:"createdAt"`
+}
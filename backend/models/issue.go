@@ -16,6 +16,7 @@ type Issue struct {
 	Location    *Location         `bson:"location,omitempty" json:"location,omitempty"`
 	CreatedBy   primitive.ObjectID `bson:"createdBy" json:"createdBy"`
 	AssignedTo  primitive.ObjectID `bson:"assignedTo,omitempty" json:"assignedTo,omitempty"`
+	JurisdictionIDs []primitive.ObjectID `bson:"jurisdictionIds,omitempty" json:"jurisdictionIds,omitempty"`
 	Tags        []string          `bson:"tags,omitempty" json:"tags,omitempty"`
 	Votes       struct {
 		Up   []primitive.ObjectID `bson:"up,omitempty" json:"up,omitempty"`
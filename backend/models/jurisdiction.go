@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	JurisdictionWard         = "ward"
+	JurisdictionConstituency = "constituency"
+	JurisdictionCounty       = "county"
+)
+
+// Jurisdiction is an administrative area. Areas nest through ParentID, e.g. a
+// ward belongs to a constituency which belongs to a county. Boundary is an
+// optional GeoJSON Polygon or MultiPolygon used to place issues.
+type Jurisdiction struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Name      string              `bson:"name" json:"name"`
+	Level     string              `bson:"level" json:"level"`
+	Code      string              `bson:"code,omitempty" json:"code,omitempty"`
+	ParentID  *primitive.ObjectID `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	Boundary  *Geometry           `bson:"boundary,omitempty" json:"boundary,omitempty"`
+	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Geometry is a GeoJSON geometry.
+type Geometry struct {
+	Type        string      `bson:"type" json:"type"`
+	Coordinates interface{} `bson:"coordinates" json:"coordinates"`
+}
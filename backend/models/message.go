@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ConversationOpen   = "open"
+	ConversationClosed = "closed"
+)
+
+// Conversation is a private thread between a citizen and a representative's
+// office. Anyone in the office (the representative or delegated staff) can
+// read and reply.
+type Conversation struct {
+	ID               primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	CitizenID        primitive.ObjectID  `bson:"citizenId" json:"citizenId"`
+	RepresentativeID primitive.ObjectID  `bson:"representativeId" json:"representativeId"`
+	Subject          string              `bson:"subject" json:"subject"`
+	Status           string              `bson:"status" json:"status"`
+	IssueID          *primitive.ObjectID `bson:"issueId,omitempty" json:"issueId,omitempty"`
+	LastMessageAt    time.Time           `bson:"lastMessageAt" json:"lastMessageAt"`
+	CitizenReadAt    *time.Time          `bson:"citizenReadAt,omitempty" json:"citizenReadAt,omitempty"`
+	OfficeReadAt     *time.Time          `bson:"officeReadAt,omitempty" json:"officeReadAt,omitempty"`
+	CreatedAt        time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt        time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+type Message struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ConversationID primitive.ObjectID `bson:"conversationId" json:"conversationId"`
+	SenderID       primitive.ObjectID `bson:"senderId" json:"senderId"`
+	// FromOffice is set for messages sent by the representative or staff.
+	FromOffice  bool         `bson:"fromOffice" json:"fromOffice"`
+	Body        string       `bson:"body" json:"body"`
+	Attachments []Attachment `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	// ReadAt is when the other side first read the message.
+	ReadAt    *time.Time `bson:"readAt,omitempty" json:"readAt,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+}
+
+// Attachment is a file stored with a message. The content is kept in GridFS
+// and downloaded through the conversation.
+type Attachment struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Filename    string             `bson:"filename" json:"filename"`
+	ContentType string             `bson:"contentType" json:"contentType"`
+	Size        int64              `bson:"size" json:"size"`
+}
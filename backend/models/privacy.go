@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ExportPending = "pending"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport is a user's request for a copy of their personal data. The
+// archive is generated in the background and kept until ExpiresAt.
+type DataExport struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID  `bson:"userId" json:"userId"`
+	Status      string              `bson:"status" json:"status"`
+	FileID      *primitive.ObjectID `bson:"fileId,omitempty" json:"-"`
+	Size        int64               `bson:"size,omitempty" json:"size,omitempty"`
+	Error       string              `bson:"error,omitempty" json:"error,omitempty"`
+	RequestedAt time.Time           `bson:"requestedAt" json:"requestedAt"`
+	CompletedAt *time.Time          `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time          `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+}
+
+// DeletedUserID replaces the author of content kept after its author deleted
+// their account.
+var DeletedUserID = primitive.NilObjectID
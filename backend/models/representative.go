@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	VerificationPending  = "pending"
+	VerificationVerified = "verified"
+	VerificationRejected = "rejected"
+)
+
+// Representative is an elected leader's public profile, linked to the user
+// account that acts for the office.
+type Representative struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID         primitive.ObjectID `bson:"userId" json:"userId"`
+	Name           string             `bson:"name" json:"name"`
+	Office         string             `bson:"office" json:"office"`
+	Party          string             `bson:"party,omitempty" json:"party,omitempty"`
+	JurisdictionID primitive.ObjectID `bson:"jurisdictionId" json:"jurisdictionId"`
+	TermStart      time.Time          `bson:"termStart" json:"termStart"`
+	TermEnd        *time.Time         `bson:"termEnd,omitempty" json:"termEnd,omitempty"`
+	Contact        ContactInfo        `bson:"contact" json:"contact"`
+	Bio            string             `bson:"bio,omitempty" json:"bio,omitempty"`
+	PhotoURL       string             `bson:"photoUrl,omitempty" json:"photoUrl,omitempty"`
+	// StaffIDs are the accounts the representative has delegated access to
+	// the office's messages.
+	StaffIDs     []primitive.ObjectID `bson:"staffIds,omitempty" json:"-"`
+	Verification Verification         `bson:"verification" json:"verification"`
+	CreatedAt    time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+type ContactInfo struct {
+	Email   string `bson:"email,omitempty" json:"email,omitempty"`
+	Phone   string `bson:"phone,omitempty" json:"phone,omitempty"`
+	Address string `bson:"address,omitempty" json:"address,omitempty"`
+	Website string `bson:"website,omitempty" json:"website,omitempty"`
+}
+
+type Verification struct {
+	Status     string             `bson:"status" json:"status"`
+	Notes      string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	ReviewedBy primitive.ObjectID `bson:"reviewedBy,omitempty" json:"-"`
+	ReviewedAt *time.Time         `bson:"reviewedAt,omitempty" json:"reviewedAt,omitempty"`
+}
+
+// IsOffice reports whether the user is the representative or one of their staff.
+func (r *Representative) IsOffice(userID primitive.ObjectID) bool {
+	if r.UserID == userID {
+		return true
+	}
+	for _, id := range r.StaffIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsServing reports whether the representative's term covers t.
+func (r *Representative) IsServing(t time.Time) bool {
+	return !t.Before(r.TermStart) && (r.TermEnd == nil || t.Before(*r.TermEnd))
+}
+
+// RepresentativeStats summarises how a representative's office responds to
+// issues in their jurisdiction.
+type RepresentativeStats struct {
+	RepresentativeID    primitive.ObjectID `json:"representativeId"`
+	IssuesInArea        int64              `json:"issuesInArea"`
+	IssuesResolved      int64              `json:"issuesResolved"`
+	IssuesResponded     int64              `json:"issuesResponded"`
+	ResponseRate        float64            `json:"responseRate"`
+	AverageResponseTime float64            `json:"averageResponseHours"`
+}
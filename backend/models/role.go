@@ -0,0 +1,134 @@
+package models
+
+const (
+	RoleCitizen   = "citizen"
+	RoleModerator = "moderator"
+	RoleOfficial  = "official"
+	// RoleSuperOfficial is an official who is not limited to a jurisdiction.
+	RoleSuperOfficial = "super_official"
+	RoleAgencyAdmin   = "agency_admin"
+	RoleAdmin         = "admin"
+
+	// RoleLegacyUser is the role accounts were created with before roles
+	// were defined. It is treated as citizen.
+	RoleLegacyUser = "user"
+)
+
+type Permission string
+
+const (
+	PermCreateIssue  Permission = "issues:create"
+	PermVoteIssue    Permission = "issues:vote"
+	PermCommentIssue Permission = "issues:comment"
+	PermEditOwnIssue Permission = "issues:edit_own"
+	PermEditAnyIssue Permission = "issues:edit_any"
+	PermTriageIssue  Permission = "issues:triage"
+	PermUpdateStatus Permission = "issues:update_status"
+	PermAssignIssue  Permission = "issues:assign"
+	// PermAllJurisdictions lifts the jurisdiction scoping that applies to
+	// roles that can update status or assign issues.
+	PermAllJurisdictions      Permission = "jurisdictions:all"
+	PermManageJurisdictions   Permission = "jurisdictions:manage"
+	PermModerateContent       Permission = "content:moderate"
+	PermViewUsers             Permission = "users:view"
+	PermManageOfficials       Permission = "roles:manage_officials"
+	PermManageRoles           Permission = "roles:manage_all"
+	PermRevokeSessions        Permission = "sessions:revoke_any"
+	PermViewAuditLog          Permission = "audit:view"
+	PermVerifyRepresentatives Permission = "representatives:verify"
+	PermManageAPIKeys         Permission = "api_keys:manage"
+	PermUnlockAccounts        Permission = "users:unlock"
+	PermViewSystemHealth      Permission = "system:health"
+)
+
+// Roles lists every role from least to most privileged.
+var Roles = []string{RoleCitizen, RoleModerator, RoleOfficial, RoleSuperOfficial, RoleAgencyAdmin, RoleAdmin}
+
+var citizenPermissions = []Permission{
+	PermCreateIssue,
+	PermVoteIssue,
+	PermCommentIssue,
+	PermEditOwnIssue,
+}
+
+var rolePermissions = map[string][]Permission{
+	RoleCitizen: citizenPermissions,
+	RoleModerator: extend(citizenPermissions,
+		PermEditAnyIssue,
+		PermTriageIssue,
+		PermModerateContent,
+	),
+	RoleOfficial: extend(citizenPermissions,
+		PermTriageIssue,
+		PermUpdateStatus,
+		PermAssignIssue,
+	),
+	RoleSuperOfficial: extend(citizenPermissions,
+		PermTriageIssue,
+		PermUpdateStatus,
+		PermAssignIssue,
+		PermAllJurisdictions,
+	),
+	RoleAgencyAdmin: extend(citizenPermissions,
+		PermEditAnyIssue,
+		PermTriageIssue,
+		PermUpdateStatus,
+		PermAssignIssue,
+		PermAllJurisdictions,
+		PermModerateContent,
+		PermViewUsers,
+		PermManageOfficials,
+		PermViewAuditLog,
+		PermVerifyRepresentatives,
+		PermUnlockAccounts,
+	),
+	RoleAdmin: extend(citizenPermissions,
+		PermEditAnyIssue,
+		PermTriageIssue,
+		PermUpdateStatus,
+		PermAssignIssue,
+		PermAllJurisdictions,
+		PermManageJurisdictions,
+		PermModerateContent,
+		PermViewUsers,
+		PermManageOfficials,
+		PermManageRoles,
+		PermRevokeSessions,
+		PermViewAuditLog,
+		PermVerifyRepresentatives,
+		PermManageAPIKeys,
+		PermUnlockAccounts,
+		PermViewSystemHealth,
+	),
+}
+
+func extend(base []Permission, extra ...Permission) []Permission {
+	return append(append([]Permission(nil), base...), extra...)
+}
+
+// NormalizeRole maps legacy and empty roles to citizen.
+func NormalizeRole(role string) string {
+	if role == "" || role == RoleLegacyUser {
+		return RoleCitizen
+	}
+	return role
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[NormalizeRole(role)] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsFor returns the permissions granted to a role.
+func PermissionsFor(role string) []Permission {
+	return append([]Permission(nil), rolePermissions[NormalizeRole(role)]...)
+}
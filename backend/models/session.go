@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a logged in device. Its ID is the refresh token family, which
+// stays the same as the refresh token is rotated.
+type Session struct {
+	ID         primitive.ObjectID `json:"id"`
+	UserAgent  string             `json:"userAgent,omitempty"`
+	IP         string             `json:"ip,omitempty"`
+	LastUsedAt time.Time          `json:"lastUsedAt"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	ExpiresAt  time.Time          `json:"expiresAt"`
+	Current    bool               `json:"current"`
+}
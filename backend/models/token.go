@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is stored by hash only. Tokens rotated from the same login
+// share a FamilyID so that reuse of a rotated token can revoke the family.
+type RefreshToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"userId"`
+	TokenHash  string             `bson:"tokenHash"`
+	FamilyID   primitive.ObjectID `bson:"familyId"`
+	UserAgent  string             `bson:"userAgent,omitempty"`
+	IP         string             `bson:"ip,omitempty"`
+	ExpiresAt  time.Time          `bson:"expiresAt"`
+	LastUsedAt time.Time          `bson:"lastUsedAt"`
+	RotatedAt  *time.Time         `bson:"rotatedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+}
+
+// ActionToken is a single-use token emailed to a user. Only the hash of the
+// token is stored.
+type ActionToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userId"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"tokenHash"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt,omitempty"`
+	Attempts  int                `bson:"attempts,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// LoginAttempts tracks recent failed logins for an account or IP.
+type LoginAttempts struct {
+	Key           string     `bson:"_id"`
+	Failures      int        `bson:"failures"`
+	Lockouts      int        `bson:"lockouts"`
+	LastFailureAt time.Time  `bson:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty"`
+	ExpiresAt     time.Time  `bson:"expiresAt"`
+}
@@ -1,15 +1,50 @@
 package models
 
 import (
+	"errors"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Location is stored as a GeoJSON point so that it can have a 2dsphere
+// index. Locations stored before migration 3 hold lat and lng fields
+// instead and are still read.
 type Location struct {
-	Lat     float64 `bson:"lat" json:"lat"`
-	Lng     float64 `bson:"lng" json:"lng"`
-	Address string  `bson:"address,omitempty" json:"address,omitempty"`
+	Lat     float64 `bson:"-" json:"lat"`
+	Lng     float64 `bson:"-" json:"lng"`
+	Address string  `bson:"-" json:"address,omitempty"`
+}
+
+type geoJSONPoint struct {
+	Type        string    `bson:"type"`
+	Coordinates []float64 `bson:"coordinates"`
+	Address     string    `bson:"address,omitempty"`
+	Lat         *float64  `bson:"lat,omitempty"`
+	Lng         *float64  `bson:"lng,omitempty"`
+}
+
+func (l Location) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(geoJSONPoint{Type: "Point", Coordinates: []float64{l.Lng, l.Lat}, Address: l.Address})
+}
+
+func (l *Location) UnmarshalBSON(data []byte) error {
+	var point geoJSONPoint
+	if err := bson.Unmarshal(data, &point); err != nil {
+		return err
+	}
+
+	switch {
+	case len(point.Coordinates) == 2:
+		l.Lng, l.Lat = point.Coordinates[0], point.Coordinates[1]
+	case point.Lat != nil && point.Lng != nil:
+		l.Lat, l.Lng = *point.Lat, *point.Lng
+	default:
+		return errors.New("location is neither a GeoJSON point nor lat and lng")
+	}
+	l.Address = point.Address
+	return nil
 }
 
 type User struct {
@@ -19,19 +54,48 @@ type User struct {
 	Password       string            `bson:"password" json:"-"`
 	Role           string            `bson:"role" json:"role"`
 	IsVerified     bool              `bson:"isVerified" json:"isVerified"`
+	VerifiedAt     *time.Time        `bson:"verifiedAt,omitempty" json:"verifiedAt,omitempty"`
 	ProfilePicture string            `bson:"profilePicture,omitempty" json:"profilePicture,omitempty"`
 	Location       *Location         `bson:"location,omitempty" json:"location,omitempty"`
+	JurisdictionIDs []primitive.ObjectID `bson:"jurisdictionIds,omitempty" json:"jurisdictionIds,omitempty"`
 	Stats          *UserStats        `bson:"stats,omitempty" json:"stats,omitempty"`
+	MFA            *MFASettings      `bson:"mfa,omitempty" json:"-"`
+	Identities     []ExternalIdentity `bson:"identities,omitempty" json:"-"`
 	CreatedAt      time.Time         `bson:"createdAt" json:"createdAt"`
 	UpdatedAt      time.Time         `bson:"updatedAt" json:"updatedAt"`
 }
 
+// MFASettings holds a user's TOTP enrollment. Recovery codes are stored as
+// hashes and removed once used.
+type MFASettings struct {
+	Enabled       bool       `bson:"enabled"`
+	Secret        string     `bson:"secret,omitempty"`
+	PendingSecret string     `bson:"pendingSecret,omitempty"`
+	RecoveryCodes []string   `bson:"recoveryCodes,omitempty"`
+	LastUsedStep  int64      `bson:"lastUsedStep,omitempty"`
+	EnabledAt     *time.Time `bson:"enabledAt,omitempty"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider.
+type ExternalIdentity struct {
+	Provider string    `bson:"provider"`
+	Subject  string    `bson:"subject"`
+	Email    string    `bson:"email,omitempty"`
+	LinkedAt time.Time `bson:"linkedAt"`
+}
+
+func (u *User) MFAEnabled() bool {
+	return u.MFA != nil && u.MFA.Enabled
+}
+
 type UserProfile struct {
 	ID             primitive.ObjectID `json:"id"`
 	Email          string            `json:"email"`
 	Username       string            `json:"username"`
 	Role           string            `json:"role"`
 	IsVerified     bool              `json:"isVerified"`
+	VerifiedAt     *time.Time        `json:"verifiedAt,omitempty"`
+	MFAEnabled     bool              `json:"mfaEnabled"`
 	ProfilePicture string            `json:"profilePicture,omitempty"`
 	Location       *Location         `json:"location,omitempty"`
 	CreatedAt      time.Time         `json:"createdAt"`
@@ -51,6 +115,21 @@ type AuthTokens struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
+// LoginResult is returned by login. When a second factor is required the
+// tokens are withheld and MFAToken must be exchanged with a TOTP or recovery
+// code. MFASetupRequired means the tokens only allow enrolling in MFA.
+type LoginResult struct {
+	*AuthTokens
+	MFARequired      bool   `json:"mfaRequired,omitempty"`
+	MFAToken         string `json:"mfaToken,omitempty"`
+	MFASetupRequired bool   `json:"mfaSetupRequired,omitempty"`
+}
+
+type MFASetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
 type LoginCredentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
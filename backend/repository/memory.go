@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// NewMemoryStore returns repositories that keep everything in memory. It is
+// meant for tests and local development; nothing is persisted.
+func NewMemoryStore() *Store {
+	return &Store{
+		Users:         &memoryUserRepository{},
+		Tokens:        &memoryTokenRepository{},
+		LoginAttempts: &memoryLoginAttemptRepository{attempts: make(map[string]*models.LoginAttempts)},
+		Issues:        &memoryIssueRepository{},
+	}
+}
+
+// clone returns a deep copy of v as MongoDB would store and return it, so
+// that callers never share memory with the store and empty fields and times
+// (truncated to milliseconds) behave the same as in MongoDB.
+func clone[T any](v *T) (*T, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode document: %v", err)
+	}
+	var copied T
+	if err := bson.Unmarshal(data, &copied); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %v", err)
+	}
+	return &copied, nil
+}
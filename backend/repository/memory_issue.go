@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryIssueRepository struct {
+	mu     sync.Mutex
+	issues []*models.Issue
+}
+
+func (r *memoryIssueRepository) Create(ctx context.Context, issue *models.Issue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if issue.ID.IsZero() {
+		issue.ID = primitive.NewObjectID()
+	}
+	for _, existing := range r.issues {
+		if existing.ID == issue.ID {
+			return ErrDuplicate
+		}
+	}
+
+	stored, err := clone(issue)
+	if err != nil {
+		return err
+	}
+	r.issues = append(r.issues, stored)
+	return nil
+}
+
+func (r *memoryIssueRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Issue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, issue := range r.issues {
+		if issue.ID == id {
+			return clone(issue)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// modify applies fn to the stored issue with the ID.
+func (r *memoryIssueRepository) modify(id primitive.ObjectID, fn func(*models.Issue)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, issue := range r.issues {
+		if issue.ID != id {
+			continue
+		}
+		fn(issue)
+		stored, err := clone(issue)
+		if err != nil {
+			return err
+		}
+		r.issues[i] = stored
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (r *memoryIssueRepository) Update(ctx context.Context, id primitive.ObjectID, update IssueUpdate) error {
+	return r.modify(id, func(issue *models.Issue) {
+		issue.UpdatedAt = time.Now()
+		if update.Title != nil {
+			issue.Title = *update.Title
+		}
+		if update.Description != nil {
+			issue.Description = *update.Description
+		}
+		if update.Category != nil {
+			issue.Category = *update.Category
+		}
+		if update.Priority != nil {
+			issue.Priority = *update.Priority
+		}
+		if update.Status != nil {
+			issue.Status = *update.Status
+		}
+		if update.Tags != nil {
+			issue.Tags = update.Tags
+		}
+		if update.Location != nil {
+			location := *update.Location
+			issue.Location = &location
+		}
+		if update.AssignedTo != nil {
+			issue.AssignedTo = *update.AssignedTo
+		}
+		if update.JurisdictionIDs != nil {
+			issue.JurisdictionIDs = update.JurisdictionIDs
+		}
+	})
+}
+
+func (r *memoryIssueRepository) Vote(ctx context.Context, id, userID primitive.ObjectID, up bool) error {
+	return r.modify(id, func(issue *models.Issue) {
+		add, remove := &issue.Votes.Down, &issue.Votes.Up
+		if up {
+			add, remove = remove, add
+		}
+		*remove = without(*remove, userID)
+		if !contains(*add, userID) {
+			*add = append(*add, userID)
+		}
+	})
+}
+
+func (r *memoryIssueRepository) AddComment(ctx context.Context, id primitive.ObjectID, comment *models.Comment) error {
+	if comment.ID.IsZero() {
+		comment.ID = primitive.NewObjectID()
+	}
+	return r.modify(id, func(issue *models.Issue) {
+		issue.Comments = append(issue.Comments, *comment)
+	})
+}
+
+// Search matches text against whole words of the title, description and
+// tags, ignoring case, and any word of the query is enough for a match. It
+// does not stem words as MongoDB's text search does.
+func (r *memoryIssueRepository) Search(ctx context.Context, query IssueQuery) (*IssueSearchResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var matches []*models.Issue
+	for _, issue := range r.issues {
+		if matchesIssue(issue, query) {
+			matches = append(matches, issue)
+		}
+	}
+
+	result := &IssueSearchResult{
+		Issues:     []models.Issue{},
+		Total:      int64(len(matches)),
+		Categories: make(map[string]int64),
+		Priorities: make(map[string]int64),
+		Statuses:   make(map[string]int64),
+		Tags:       make(map[string]int64),
+	}
+	for _, issue := range matches {
+		result.Categories[issue.Category]++
+		result.Priorities[issue.Priority]++
+		result.Statuses[issue.Status]++
+		for _, tag := range issue.Tags {
+			result.Tags[tag]++
+		}
+	}
+
+	sortIssues(matches, query)
+	start := min(query.Skip, int64(len(matches)))
+	end := int64(len(matches))
+	if query.Limit > 0 {
+		end = min(start+query.Limit, end)
+	}
+
+	for _, issue := range matches[start:end] {
+		copied, err := clone(issue)
+		if err != nil {
+			return nil, err
+		}
+		result.Issues = append(result.Issues, *copied)
+	}
+	return result, nil
+}
+
+func (r *memoryIssueRepository) ResponseStats(ctx context.Context, query IssueQuery, resolvedStatuses []string, responderID primitive.ObjectID) (*ResponseStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := &ResponseStats{}
+	for _, issue := range r.issues {
+		if !matchesIssue(issue, query) {
+			continue
+		}
+		stats.Issues++
+		if containsString(resolvedStatuses, issue.Status) {
+			stats.Resolved++
+		}
+
+		var firstResponse time.Time
+		for _, comment := range issue.Comments {
+			if comment.CreatedBy == responderID && (firstResponse.IsZero() || comment.CreatedAt.Before(firstResponse)) {
+				firstResponse = comment.CreatedAt
+			}
+		}
+		if !firstResponse.IsZero() {
+			stats.Responded++
+			stats.ResponseTime += firstResponse.Sub(issue.CreatedAt)
+		}
+	}
+	return stats, nil
+}
+
+func (r *memoryIssueRepository) RemoveUser(ctx context.Context, userID primitive.ObjectID) (*UserRemoval, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removal := &UserRemoval{}
+	for _, issue := range r.issues {
+		if issue.CreatedBy == userID {
+			issue.CreatedBy = models.DeletedUserID
+			removal.Issues++
+		}
+
+		commented := false
+		for i := range issue.Comments {
+			if issue.Comments[i].CreatedBy == userID {
+				issue.Comments[i].CreatedBy = models.DeletedUserID
+				commented = true
+			}
+		}
+		if commented {
+			removal.Commented++
+		}
+
+		if contains(issue.Votes.Up, userID) || contains(issue.Votes.Down, userID) {
+			issue.Votes.Up = without(issue.Votes.Up, userID)
+			issue.Votes.Down = without(issue.Votes.Down, userID)
+			removal.Voted++
+		}
+
+		if issue.AssignedTo == userID {
+			issue.AssignedTo = primitive.NilObjectID
+			removal.Assigned++
+		}
+	}
+	return removal, nil
+}
+
+// Reindex does nothing; the memory store has no indexes.
+func (r *memoryIssueRepository) Reindex(ctx context.Context) error {
+	return nil
+}
+
+func matchesIssue(issue *models.Issue, query IssueQuery) bool {
+	if query.Text != "" && !matchesText(issue, query.Text) {
+		return false
+	}
+	if len(query.Categories) > 0 && !containsString(query.Categories, issue.Category) {
+		return false
+	}
+	if len(query.Priorities) > 0 && !containsString(query.Priorities, issue.Priority) {
+		return false
+	}
+	if len(query.Statuses) > 0 && !containsString(query.Statuses, issue.Status) {
+		return false
+	}
+	if query.StartDate != nil && issue.CreatedAt.Before(*query.StartDate) {
+		return false
+	}
+	if query.EndDate != nil && issue.CreatedAt.After(*query.EndDate) {
+		return false
+	}
+	for _, tag := range query.Tags {
+		if !containsString(issue.Tags, tag) {
+			return false
+		}
+	}
+	if query.HasLocation && issue.Location == nil {
+		return false
+	}
+	if !query.Participant.IsZero() && !participated(issue, query.Participant) {
+		return false
+	}
+	if query.Near != nil {
+		if issue.Location == nil {
+			return false
+		}
+		if distanceKm(query.Near.Lat, query.Near.Lng, issue.Location.Lat, issue.Location.Lng) > query.Near.RadiusKm {
+			return false
+		}
+	}
+	if query.JurisdictionScoped {
+		found := false
+		for _, id := range issue.JurisdictionIDs {
+			if contains(query.JurisdictionIDs, id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func participated(issue *models.Issue, userID primitive.ObjectID) bool {
+	if issue.CreatedBy == userID || contains(issue.Votes.Up, userID) || contains(issue.Votes.Down, userID) {
+		return true
+	}
+	for _, comment := range issue.Comments {
+		if comment.CreatedBy == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesText(issue *models.Issue, text string) bool {
+	words := make(map[string]bool)
+	for _, field := range append([]string{issue.Title, issue.Description}, issue.Tags...) {
+		for _, word := range splitWords(field) {
+			words[word] = true
+		}
+	}
+	for _, word := range splitWords(text) {
+		if words[word] {
+			return true
+		}
+	}
+	return false
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// distanceKm is the great-circle distance between two points.
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// sortIssues orders issues the same way as the MongoDB sort in issueSort.
+func sortIssues(issues []*models.Issue, query IssueQuery) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+
+		var cmp int
+		switch query.SortBy {
+		case "votes":
+			cmp = voteCount(a) - voteCount(b)
+		case "priority":
+			cmp = strings.Compare(a.Priority, b.Priority)
+		default:
+			cmp = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if query.Descending {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+
+		// Ties are broken by newest first, then by ID
+		if query.SortBy == "votes" || query.SortBy == "priority" {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c < 0
+			}
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	})
+}
+
+func voteCount(issue *models.Issue) int {
+	return len(issue.Votes.Up) - len(issue.Votes.Down)
+}
+
+func contains(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, x := range ids {
+		if x == id {
+			return true
+		}
+	}
+	return false
+}
+
+func without(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	kept := ids[:0:0]
+	for _, x := range ids {
+		if x != id {
+			kept = append(kept, x)
+		}
+	}
+	return kept
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+)
+
+type memoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]*models.LoginAttempts
+}
+
+func (r *memoryLoginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts, ok := r.attempts[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(attempts)
+}
+
+func (r *memoryLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at time.Time, window, retention time.Duration) (*models.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	at = at.Truncate(time.Millisecond)
+	attempts, ok := r.attempts[key]
+	if !ok {
+		attempts = &models.LoginAttempts{Key: key}
+		r.attempts[key] = attempts
+	}
+
+	// Failures outside the window no longer count
+	if attempts.LastFailureAt.Before(at.Add(-window)) {
+		attempts.Failures = 0
+	}
+	attempts.Failures++
+	attempts.LastFailureAt = at
+	attempts.ExpiresAt = at.Add(retention)
+	return clone(attempts)
+}
+
+func (r *memoryLoginAttemptRepository) Lock(ctx context.Context, key string, threshold int, until time.Time, retention time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempts, ok := r.attempts[key]
+	if !ok || attempts.Failures < threshold {
+		return false, nil
+	}
+
+	until = until.Truncate(time.Millisecond)
+	attempts.Failures = 0
+	attempts.LockedUntil = &until
+	attempts.ExpiresAt = until.Add(retention)
+	attempts.Lockouts++
+	return true, nil
+}
+
+func (r *memoryLoginAttemptRepository) Delete(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	for key, attempts := range r.attempts {
+		if attempts.ExpiresAt.Before(before) {
+			delete(r.attempts, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
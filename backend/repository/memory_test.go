@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/repository/repositorytest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) *repository.Store {
+		return repository.NewMemoryStore()
+	})
+}
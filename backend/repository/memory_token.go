@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryTokenRepository struct {
+	mu            sync.Mutex
+	refreshTokens []*models.RefreshToken
+	actionTokens  []*models.ActionToken
+}
+
+func (r *memoryTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.refreshTokens {
+		if existing.TokenHash == token.TokenHash || existing.ID == token.ID {
+			return ErrDuplicate
+		}
+	}
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	stored, err := clone(token)
+	if err != nil {
+		return err
+	}
+	r.refreshTokens = append(r.refreshTokens, stored)
+	return nil
+}
+
+func (r *memoryTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.refreshTokens {
+		if token.TokenHash == tokenHash {
+			return clone(token)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryTokenRepository) RotateRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.refreshTokens {
+		if token.ID != id {
+			continue
+		}
+		if token.RotatedAt != nil || token.RevokedAt != nil {
+			return false, nil
+		}
+		rotatedAt := at.Truncate(time.Millisecond)
+		token.RotatedAt = &rotatedAt
+		token.LastUsedAt = rotatedAt
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *memoryTokenRepository) RevokeRefreshTokens(ctx context.Context, filter RefreshTokenFilter, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	revokedAt := at.Truncate(time.Millisecond)
+	for _, token := range r.refreshTokens {
+		if token.RevokedAt != nil {
+			continue
+		}
+		if !filter.UserID.IsZero() && token.UserID != filter.UserID {
+			continue
+		}
+		if !filter.FamilyID.IsZero() {
+			if token.FamilyID != filter.FamilyID {
+				continue
+			}
+		} else if !filter.ExceptFamilyID.IsZero() && token.FamilyID == filter.ExceptFamilyID {
+			continue
+		}
+		token.RevokedAt = &revokedAt
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) ActiveRefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	tokens := []models.RefreshToken{}
+	for _, token := range r.refreshTokens {
+		if token.UserID != userID || token.RotatedAt != nil || token.RevokedAt != nil || !token.ExpiresAt.After(now) {
+			continue
+		}
+		copied, err := clone(token)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *copied)
+	}
+
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].LastUsedAt.After(tokens[j].LastUsedAt)
+	})
+	return tokens, nil
+}
+
+func (r *memoryTokenRepository) RefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tokens := []models.RefreshToken{}
+	for _, token := range r.refreshTokens {
+		if token.UserID != userID {
+			continue
+		}
+		copied, err := clone(token)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *copied)
+	}
+
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+func (r *memoryTokenRepository) CreateActionToken(ctx context.Context, token *models.ActionToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.actionTokens {
+		if existing.TokenHash == token.TokenHash || existing.ID == token.ID {
+			return ErrDuplicate
+		}
+	}
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	stored, err := clone(token)
+	if err != nil {
+		return err
+	}
+	r.actionTokens = append(r.actionTokens, stored)
+	return nil
+}
+
+func (r *memoryTokenRepository) GetActionToken(ctx context.Context, tokenHash, purpose string) (*models.ActionToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.actionTokens {
+		if token.TokenHash == tokenHash && token.Purpose == purpose {
+			return clone(token)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryTokenRepository) UseActionToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.actionTokens {
+		if token.ID == id && token.UsedAt == nil {
+			usedAt := at.Truncate(time.Millisecond)
+			token.UsedAt = &usedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryTokenRepository) UseActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usedAt := at.Truncate(time.Millisecond)
+	for _, token := range r.actionTokens {
+		if token.UserID == userID && token.Purpose == purpose && token.UsedAt == nil {
+			token.UsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) RecordActionTokenAttempt(ctx context.Context, id primitive.ObjectID, burn bool, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.actionTokens {
+		if token.ID != id {
+			continue
+		}
+		token.Attempts++
+		if burn {
+			usedAt := at.Truncate(time.Millisecond)
+			token.UsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func (r *memoryTokenRepository) CountActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since = since.Truncate(time.Millisecond)
+	var count int64
+	for _, token := range r.actionTokens {
+		if token.UserID == userID && token.Purpose == purpose && !token.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	refreshTokens := r.refreshTokens[:0]
+	for _, token := range r.refreshTokens {
+		if token.ExpiresAt.Before(before) {
+			deleted++
+			continue
+		}
+		refreshTokens = append(refreshTokens, token)
+	}
+	r.refreshTokens = refreshTokens
+
+	actionTokens := r.actionTokens[:0]
+	for _, token := range r.actionTokens {
+		if token.ExpiresAt.Before(before) {
+			deleted++
+			continue
+		}
+		actionTokens = append(actionTokens, token)
+	}
+	r.actionTokens = actionTokens
+	return deleted, nil
+}
+
+func (r *memoryTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refreshTokens := r.refreshTokens[:0]
+	for _, token := range r.refreshTokens {
+		if token.UserID != userID {
+			refreshTokens = append(refreshTokens, token)
+		}
+	}
+	r.refreshTokens = refreshTokens
+
+	actionTokens := r.actionTokens[:0]
+	for _, token := range r.actionTokens {
+		if token.UserID != userID {
+			actionTokens = append(actionTokens, token)
+		}
+	}
+	r.actionTokens = actionTokens
+	return nil
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryUserRepository struct {
+	mu    sync.Mutex
+	users []*models.User
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.users {
+		if existing.Email == user.Email || existing.Username == user.Username || existing.ID == user.ID {
+			return ErrDuplicate
+		}
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	stored, err := clone(user)
+	if err != nil {
+		return err
+	}
+	r.users = append(r.users, stored)
+	return nil
+}
+
+// find returns a copy of the first user matching fn.
+func (r *memoryUserRepository) find(fn func(*models.User) bool) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if fn(user) {
+			return clone(user)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// modify applies fn to the stored user with the ID. fn reports whether it
+// changed the user.
+func (r *memoryUserRepository) modify(id primitive.ObjectID, fn func(*models.User) bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, user := range r.users {
+		if user.ID != id {
+			continue
+		}
+		if !fn(user) {
+			return false, nil
+		}
+		stored, err := clone(user)
+		if err != nil {
+			return false, err
+		}
+		r.users[i] = stored
+		return true, nil
+	}
+	return false, ErrNotFound
+}
+
+func (r *memoryUserRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.ID == id })
+}
+
+func (r *memoryUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.Email == email })
+}
+
+func (r *memoryUserRepository) GetByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.find(func(user *models.User) bool {
+		for _, identity := range user.Identities {
+			if identity.Provider == provider && identity.Subject == subject {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *memoryUserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	_, err := r.find(func(user *models.User) bool { return user.Username == username })
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) error {
+	_, err := r.modify(id, func(user *models.User) bool {
+		user.UpdatedAt = time.Now()
+		if update.Password != nil {
+			user.Password = *update.Password
+		}
+		if update.Role != nil {
+			user.Role = *update.Role
+		}
+		if update.IsVerified != nil {
+			user.IsVerified = *update.IsVerified
+		}
+		if update.VerifiedAt != nil {
+			verifiedAt := *update.VerifiedAt
+			user.VerifiedAt = &verifiedAt
+		}
+		if update.JurisdictionIDs != nil {
+			user.JurisdictionIDs = update.JurisdictionIDs
+		}
+		if update.MFA != nil {
+			mfa := *update.MFA
+			user.MFA = &mfa
+		}
+		if update.RecoveryCodes != nil {
+			if user.MFA == nil {
+				user.MFA = &models.MFASettings{}
+			}
+			user.MFA.RecoveryCodes = update.RecoveryCodes
+		}
+		if update.RemoveMFA {
+			user.MFA = nil
+		}
+		if update.AddIdentity != nil {
+			user.Identities = append(user.Identities, *update.AddIdentity)
+		}
+		return true
+	})
+	return err
+}
+
+func (r *memoryUserRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	used, err := r.modify(id, func(user *models.User) bool {
+		if user.MFA != nil && user.MFA.LastUsedStep >= step {
+			return false
+		}
+		if user.MFA == nil {
+			user.MFA = &models.MFASettings{}
+		}
+		user.MFA.LastUsedStep = step
+		return true
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return used, err
+}
+
+func (r *memoryUserRepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	used, err := r.modify(id, func(user *models.User) bool {
+		if user.MFA == nil {
+			return false
+		}
+		for i, code := range user.MFA.RecoveryCodes {
+			if code == codeHash {
+				user.MFA.RecoveryCodes = append(user.MFA.RecoveryCodes[:i:i], user.MFA.RecoveryCodes[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return used, err
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, user := range r.users {
+		if user.ID == id {
+			r.users = append(r.users[:i:i], r.users[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,138 @@
+-- Users, tokens, login attempts and issues. IDs are the hex form of the
+-- ObjectIDs the rest of the application uses.
+
+CREATE EXTENSION IF NOT EXISTS postgis;
+
+CREATE TABLE users (
+    id text PRIMARY KEY,
+    email text NOT NULL UNIQUE,
+    username text NOT NULL UNIQUE,
+    password text NOT NULL,
+    role text NOT NULL,
+    is_verified boolean NOT NULL DEFAULT false,
+    verified_at timestamptz,
+    profile_picture text NOT NULL DEFAULT '',
+    location geography(Point, 4326),
+    location_address text,
+    jurisdiction_ids text[] NOT NULL DEFAULT '{}',
+    stats jsonb,
+    -- The MFA columns are all null for users who never enrolled
+    mfa_enabled boolean,
+    mfa_secret text,
+    mfa_pending_secret text,
+    mfa_recovery_codes text[],
+    mfa_last_used_step bigint,
+    mfa_enabled_at timestamptz,
+    created_at timestamptz NOT NULL,
+    updated_at timestamptz NOT NULL
+);
+
+CREATE TABLE user_identities (
+    provider text NOT NULL,
+    subject text NOT NULL,
+    user_id text NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email text NOT NULL DEFAULT '',
+    linked_at timestamptz NOT NULL,
+    seq bigserial,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE refresh_tokens (
+    id text PRIMARY KEY,
+    user_id text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    family_id text NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    expires_at timestamptz NOT NULL,
+    last_used_at timestamptz NOT NULL,
+    rotated_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz NOT NULL
+);
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+CREATE TABLE action_tokens (
+    id text PRIMARY KEY,
+    user_id text NOT NULL,
+    purpose text NOT NULL,
+    token_hash text NOT NULL UNIQUE,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    attempts integer NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL
+);
+
+CREATE INDEX action_tokens_user_purpose_idx ON action_tokens (user_id, purpose, created_at DESC);
+CREATE INDEX action_tokens_expires_at_idx ON action_tokens (expires_at);
+
+CREATE TABLE login_attempts (
+    key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    lockouts integer NOT NULL DEFAULT 0,
+    last_failure_at timestamptz NOT NULL,
+    locked_until timestamptz,
+    expires_at timestamptz NOT NULL
+);
+
+CREATE INDEX login_attempts_expires_at_idx ON login_attempts (expires_at);
+
+-- Generated columns need an immutable expression, which array_to_string is
+-- not declared to be
+CREATE FUNCTION issue_search_document(title text, description text, tags text[])
+RETURNS tsvector
+LANGUAGE sql IMMUTABLE
+AS $$
+    SELECT setweight(to_tsvector('english', coalesce(title, '')), 'A')
+        || setweight(to_tsvector('english', coalesce(description, '')), 'B')
+        || setweight(to_tsvector('english', coalesce(array_to_string(tags, ' '), '')), 'C')
+$$;
+
+CREATE TABLE issues (
+    id text PRIMARY KEY,
+    title text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    category text NOT NULL DEFAULT '',
+    priority text NOT NULL DEFAULT '',
+    status text NOT NULL DEFAULT '',
+    location geography(Point, 4326),
+    location_address text,
+    created_by text NOT NULL,
+    assigned_to text,
+    jurisdiction_ids text[] NOT NULL DEFAULT '{}',
+    tags text[] NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL,
+    updated_at timestamptz NOT NULL,
+    search tsvector GENERATED ALWAYS AS (issue_search_document(title, description, tags)) STORED
+);
+
+CREATE INDEX issues_search_idx ON issues USING gin (search);
+CREATE INDEX issues_location_idx ON issues USING gist (location);
+CREATE INDEX issues_tags_idx ON issues USING gin (tags);
+CREATE INDEX issues_jurisdiction_ids_idx ON issues USING gin (jurisdiction_ids);
+CREATE INDEX issues_created_at_idx ON issues (created_at);
+
+CREATE TABLE issue_votes (
+    issue_id text NOT NULL REFERENCES issues (id) ON DELETE CASCADE,
+    user_id text NOT NULL,
+    up boolean NOT NULL,
+    seq bigserial,
+    PRIMARY KEY (issue_id, user_id)
+);
+
+CREATE TABLE issue_comments (
+    id text PRIMARY KEY,
+    issue_id text NOT NULL REFERENCES issues (id) ON DELETE CASCADE,
+    content text NOT NULL,
+    created_by text NOT NULL,
+    created_at timestamptz NOT NULL,
+    updated_at timestamptz NOT NULL,
+    seq bigserial
+);
+
+CREATE INDEX issue_comments_issue_id_idx ON issue_comments (issue_id, seq);
@@ -0,0 +1,7 @@
+-- Used to find and anonymise a user's issues, comments and votes when they
+-- export their data or delete their account.
+
+CREATE INDEX issues_created_by_idx ON issues (created_by);
+CREATE INDEX issues_assigned_to_idx ON issues (assigned_to);
+CREATE INDEX issue_comments_created_by_idx ON issue_comments (created_by);
+CREATE INDEX issue_votes_user_id_idx ON issue_votes (user_id);
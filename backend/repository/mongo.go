@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewMongoStore returns repositories backed by the MongoDB database.
+func NewMongoStore(db *mongo.Database) *Store {
+	return &Store{
+		Users:         &mongoUserRepository{userCollection: db.Collection("users")},
+		Tokens:        &mongoTokenRepository{tokenCollection: db.Collection("refresh_tokens"), actionTokenCollection: db.Collection("action_tokens")},
+		LoginAttempts: &mongoLoginAttemptRepository{loginAttemptCollection: db.Collection("login_attempts")},
+		Issues:        &mongoIssueRepository{issueCollection: db.Collection("issues")},
+	}
+}
+
+// findOne decodes the first document matching filter, mapping a missing
+// document to ErrNotFound.
+func findOne[T any](ctx context.Context, collection *mongo.Collection, filter bson.M) (*T, error) {
+	var doc T
+	err := collection.FindOne(ctx, filter).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
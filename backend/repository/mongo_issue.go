@@ -0,0 +1,399 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoIssueRepository struct {
+	issueCollection *mongo.Collection
+}
+
+// The issue indexes search depends on. Migrations create them and Reindex
+// rebuilds them.
+var (
+	IssueTextIndex = mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "title", Value: "text"},
+			{Key: "description", Value: "text"},
+			{Key: "tags", Value: "text"},
+		},
+		Options: options.Index().
+			SetName("issues_text").
+			SetWeights(bson.D{{Key: "title", Value: 5}, {Key: "tags", Value: 3}, {Key: "description", Value: 1}}),
+	}
+	IssueLocationIndex = mongo.IndexModel{
+		Keys:    bson.D{{Key: "location", Value: "2dsphere"}},
+		Options: options.Index().SetName("location_2dsphere"),
+	}
+)
+
+func (r *mongoIssueRepository) Create(ctx context.Context, issue *models.Issue) error {
+	if issue.ID.IsZero() {
+		issue.ID = primitive.NewObjectID()
+	}
+	_, err := r.issueCollection.InsertOne(ctx, issue)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoIssueRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Issue, error) {
+	return findOne[models.Issue](ctx, r.issueCollection, bson.M{"_id": id})
+}
+
+func (r *mongoIssueRepository) Update(ctx context.Context, id primitive.ObjectID, update IssueUpdate) error {
+	set := bson.M{"updatedAt": time.Now()}
+	if update.Title != nil {
+		set["title"] = *update.Title
+	}
+	if update.Description != nil {
+		set["description"] = *update.Description
+	}
+	if update.Category != nil {
+		set["category"] = *update.Category
+	}
+	if update.Priority != nil {
+		set["priority"] = *update.Priority
+	}
+	if update.Status != nil {
+		set["status"] = *update.Status
+	}
+	if update.Tags != nil {
+		set["tags"] = update.Tags
+	}
+	if update.Location != nil {
+		set["location"] = *update.Location
+	}
+	if update.AssignedTo != nil {
+		set["assignedTo"] = *update.AssignedTo
+	}
+	if update.JurisdictionIDs != nil {
+		set["jurisdictionIds"] = update.JurisdictionIDs
+	}
+
+	return r.updateOne(ctx, id, bson.M{"$set": set})
+}
+
+func (r *mongoIssueRepository) Vote(ctx context.Context, id, userID primitive.ObjectID, up bool) error {
+	add, remove := "votes.down", "votes.up"
+	if up {
+		add, remove = remove, add
+	}
+	return r.updateOne(ctx, id, bson.M{
+		"$addToSet": bson.M{add: userID},
+		"$pull":     bson.M{remove: userID},
+	})
+}
+
+func (r *mongoIssueRepository) AddComment(ctx context.Context, id primitive.ObjectID, comment *models.Comment) error {
+	if comment.ID.IsZero() {
+		comment.ID = primitive.NewObjectID()
+	}
+	return r.updateOne(ctx, id, bson.M{"$push": bson.M{"comments": comment}})
+}
+
+func (r *mongoIssueRepository) RemoveUser(ctx context.Context, userID primitive.ObjectID) (*UserRemoval, error) {
+	removal := &UserRemoval{}
+
+	result, err := r.issueCollection.UpdateMany(ctx,
+		bson.M{"createdBy": userID},
+		bson.M{"$set": bson.M{"createdBy": models.DeletedUserID}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymise issues: %v", err)
+	}
+	removal.Issues = result.ModifiedCount
+
+	result, err = r.issueCollection.UpdateMany(ctx,
+		bson.M{"comments.createdBy": userID},
+		bson.M{"$set": bson.M{"comments.$[c].createdBy": models.DeletedUserID}},
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"c.createdBy": userID}}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymise comments: %v", err)
+	}
+	removal.Commented = result.ModifiedCount
+
+	result, err = r.issueCollection.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"votes.up": userID}, bson.M{"votes.down": userID}}},
+		bson.M{"$pull": bson.M{"votes.up": userID, "votes.down": userID}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove votes: %v", err)
+	}
+	removal.Voted = result.ModifiedCount
+
+	result, err = r.issueCollection.UpdateMany(ctx, bson.M{"assignedTo": userID}, bson.M{"$unset": bson.M{"assignedTo": ""}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unassign issues: %v", err)
+	}
+	removal.Assigned = result.ModifiedCount
+
+	return removal, nil
+}
+
+func (r *mongoIssueRepository) updateOne(ctx context.Context, id primitive.ObjectID, update bson.M) error {
+	result, err := r.issueCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reindex drops and recreates the search indexes. Text and location search
+// fail until each index is rebuilt.
+func (r *mongoIssueRepository) Reindex(ctx context.Context) error {
+	for _, index := range []mongo.IndexModel{IssueTextIndex, IssueLocationIndex} {
+		_, err := r.issueCollection.Indexes().DropOne(ctx, *index.Options.Name)
+		var cmdErr mongo.CommandError
+		if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Name == "IndexNotFound") {
+			return fmt.Errorf("failed to drop index %s: %v", *index.Options.Name, err)
+		}
+		if _, err := r.issueCollection.Indexes().CreateOne(ctx, index); err != nil {
+			return fmt.Errorf("failed to create index %s: %v", *index.Options.Name, err)
+		}
+	}
+	return nil
+}
+
+// Search runs the query as a single aggregation with a $facet stage for the
+// page of issues, the total and the facet counts. Text search needs the text
+// index created by the migrations package.
+func (r *mongoIssueRepository) Search(ctx context.Context, query IssueQuery) (*IssueSearchResult, error) {
+	pipeline := mongo.Pipeline{}
+	if match := issueMatch(query); len(match) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: match}})
+	}
+
+	countBy := func(field string) bson.A {
+		return bson.A{bson.M{"$group": bson.M{"_id": field, "count": bson.M{"$sum": 1}}}}
+	}
+
+	page := bson.A{
+		bson.M{"$addFields": bson.M{"voteCount": bson.M{"$subtract": bson.A{
+			bson.M{"$size": bson.M{"$ifNull": bson.A{"$votes.up", bson.A{}}}},
+			bson.M{"$size": bson.M{"$ifNull": bson.A{"$votes.down", bson.A{}}}},
+		}}}},
+		bson.M{"$sort": issueSort(query)},
+		bson.M{"$skip": query.Skip},
+	}
+	if query.Limit > 0 {
+		page = append(page, bson.M{"$limit": query.Limit})
+	}
+	page = append(page, bson.M{"$project": bson.M{"voteCount": 0}})
+
+	pipeline = append(pipeline, bson.D{{Key: "$facet", Value: bson.M{
+		"categories": countBy("$category"),
+		"priorities": countBy("$priority"),
+		"statuses":   countBy("$status"),
+		"tags":       append(bson.A{bson.M{"$unwind": "$tags"}}, countBy("$tags")...),
+		"total":      bson.A{bson.M{"$count": "count"}},
+		"issues":     page,
+	}}})
+
+	cursor, err := r.issueCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute search: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	type facetCount struct {
+		Value interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	var results []struct {
+		Categories []facetCount   `bson:"categories"`
+		Priorities []facetCount   `bson:"priorities"`
+		Statuses   []facetCount   `bson:"statuses"`
+		Tags       []facetCount   `bson:"tags"`
+		Total      []facetCount   `bson:"total"`
+		Issues     []models.Issue `bson:"issues"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode search results: %v", err)
+	}
+
+	counts := func(facets []facetCount) map[string]int64 {
+		m := make(map[string]int64, len(facets))
+		for _, facet := range facets {
+			// Documents without the field are grouped under null
+			value, _ := facet.Value.(string)
+			m[value] += facet.Count
+		}
+		return m
+	}
+
+	result := &IssueSearchResult{Issues: []models.Issue{}}
+	if len(results) > 0 {
+		facets := results[0]
+		if facets.Issues != nil {
+			result.Issues = facets.Issues
+		}
+		if len(facets.Total) > 0 {
+			result.Total = facets.Total[0].Count
+		}
+		result.Categories = counts(facets.Categories)
+		result.Priorities = counts(facets.Priorities)
+		result.Statuses = counts(facets.Statuses)
+		result.Tags = counts(facets.Tags)
+	}
+	return result, nil
+}
+
+// ResponseStats finds the responder's first comment on each matching issue
+// and totals the counts and response times in a single $group stage.
+func (r *mongoIssueRepository) ResponseStats(ctx context.Context, query IssueQuery, resolvedStatuses []string, responderID primitive.ObjectID) (*ResponseStats, error) {
+	pipeline := mongo.Pipeline{}
+	if match := issueMatch(query); len(match) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: match}})
+	}
+
+	// $min over an empty list of comment times is null
+	firstResponse := bson.M{"$min": bson.M{"$map": bson.M{
+		"input": bson.M{"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$comments", bson.A{}}},
+			"as":    "comment",
+			"cond":  bson.M{"$eq": bson.A{"$$comment.createdBy", responderID}},
+		}},
+		"as": "comment",
+		"in": "$$comment.createdAt",
+	}}}
+	noResponse := bson.M{"$eq": bson.A{"$firstResponse", nil}}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$project", Value: bson.M{
+			"resolved":      bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$status", resolvedStatuses}}, 1, 0}},
+			"createdAt":     1,
+			"firstResponse": firstResponse,
+		}}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":            nil,
+			"issues":         bson.M{"$sum": 1},
+			"resolved":       bson.M{"$sum": "$resolved"},
+			"responded":      bson.M{"$sum": bson.M{"$cond": bson.A{noResponse, 0, 1}}},
+			"responseMillis": bson.M{"$sum": bson.M{"$cond": bson.A{noResponse, 0, bson.M{"$subtract": bson.A{"$firstResponse", "$createdAt"}}}}},
+		}}},
+	)
+
+	cursor, err := r.issueCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute response statistics: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Issues         int64 `bson:"issues"`
+		Resolved       int64 `bson:"resolved"`
+		Responded      int64 `bson:"responded"`
+		ResponseMillis int64 `bson:"responseMillis"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode response statistics: %v", err)
+	}
+
+	// No document comes out of $group when nothing matched
+	stats := &ResponseStats{}
+	if len(results) > 0 {
+		stats.Issues = results[0].Issues
+		stats.Resolved = results[0].Resolved
+		stats.Responded = results[0].Responded
+		stats.ResponseTime = time.Duration(results[0].ResponseMillis) * time.Millisecond
+	}
+	return stats, nil
+}
+
+func issueMatch(query IssueQuery) bson.M {
+	match := bson.M{}
+
+	if query.Text != "" {
+		match["$text"] = bson.M{
+			"$search":             query.Text,
+			"$caseSensitive":      false,
+			"$diacriticSensitive": false,
+		}
+	}
+	if len(query.Categories) > 0 {
+		match["category"] = bson.M{"$in": query.Categories}
+	}
+	if len(query.Priorities) > 0 {
+		match["priority"] = bson.M{"$in": query.Priorities}
+	}
+	if len(query.Statuses) > 0 {
+		match["status"] = bson.M{"$in": query.Statuses}
+	}
+
+	if query.StartDate != nil || query.EndDate != nil {
+		dateFilter := bson.M{}
+		if query.StartDate != nil {
+			dateFilter["$gte"] = query.StartDate
+		}
+		if query.EndDate != nil {
+			dateFilter["$lte"] = query.EndDate
+		}
+		match["createdAt"] = dateFilter
+	}
+
+	if len(query.Tags) > 0 {
+		match["tags"] = bson.M{"$all": query.Tags}
+	}
+
+	if query.HasLocation {
+		match["location"] = bson.M{"$exists": true}
+	}
+	if !query.Participant.IsZero() {
+		match["$or"] = bson.A{
+			bson.M{"createdBy": query.Participant},
+			bson.M{"comments.createdBy": query.Participant},
+			bson.M{"votes.up": query.Participant},
+			bson.M{"votes.down": query.Participant},
+		}
+	}
+	if query.Near != nil {
+		match["location"] = bson.M{
+			"$geoWithin": bson.M{
+				"$centerSphere": bson.A{
+					bson.A{query.Near.Lng, query.Near.Lat},
+					query.Near.RadiusKm / earthRadiusKm, // km to radians
+				},
+			},
+		}
+	}
+
+	if query.JurisdictionScoped {
+		jurisdictionIDs := query.JurisdictionIDs
+		if jurisdictionIDs == nil {
+			jurisdictionIDs = []primitive.ObjectID{}
+		}
+		match["jurisdictionIds"] = bson.M{"$in": jurisdictionIDs}
+	}
+
+	return match
+}
+
+func issueSort(query IssueQuery) bson.D {
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+
+	switch query.SortBy {
+	case "votes":
+		return bson.D{{Key: "voteCount", Value: order}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}
+	case "priority":
+		return bson.D{{Key: "priority", Value: order}, {Key: "createdAt", Value: -1}, {Key: "_id", Value: 1}}
+	default:
+		return bson.D{{Key: "createdAt", Value: order}, {Key: "_id", Value: 1}}
+	}
+}
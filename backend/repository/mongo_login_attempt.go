@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoLoginAttemptRepository struct {
+	loginAttemptCollection *mongo.Collection
+}
+
+func (r *mongoLoginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	return findOne[models.LoginAttempts](ctx, r.loginAttemptCollection, bson.M{"_id": key})
+}
+
+func (r *mongoLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at time.Time, window, retention time.Duration) (*models.LoginAttempts, error) {
+	// Failures outside the window no longer count
+	_, err := r.loginAttemptCollection.UpdateOne(ctx,
+		bson.M{"_id": key, "lastFailureAt": bson.M{"$lt": at.Add(-window)}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var attempts models.LoginAttempts
+	err = r.loginAttemptCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"lastFailureAt": at, "expiresAt": at.Add(retention)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+func (r *mongoLoginAttemptRepository) Lock(ctx context.Context, key string, threshold int, until time.Time, retention time.Duration) (bool, error) {
+	result, err := r.loginAttemptCollection.UpdateOne(ctx,
+		bson.M{"_id": key, "failures": bson.M{"$gte": threshold}},
+		bson.M{
+			"$set": bson.M{"failures": 0, "lockedUntil": until, "expiresAt": until.Add(retention)},
+			"$inc": bson.M{"lockouts": 1},
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *mongoLoginAttemptRepository) Delete(ctx context.Context, key string) error {
+	_, err := r.loginAttemptCollection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+func (r *mongoLoginAttemptRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.loginAttemptCollection.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,43 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/migrations"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/repository/repositorytest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestMongoStore runs against the server in MONGODB_TEST_URI, using a new
+// migrated database for each test.
+func TestMongoStore(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("failed to connect to MongoDB: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	repositorytest.Run(t, func(t *testing.T) *repository.Store {
+		ctx := context.Background()
+		db := client.Database("sautii_test_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() { db.Drop(context.Background()) })
+
+		if _, err := migrations.Run(ctx, db); err != nil {
+			t.Fatal(err)
+		}
+		return repository.NewMongoStore(db)
+	})
+}
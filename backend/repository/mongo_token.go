@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoTokenRepository struct {
+	tokenCollection       *mongo.Collection
+	actionTokenCollection *mongo.Collection
+}
+
+func (r *mongoTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	_, err := r.tokenCollection.InsertOne(ctx, token)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	return findOne[models.RefreshToken](ctx, r.tokenCollection, bson.M{"tokenHash": tokenHash})
+}
+
+func (r *mongoTokenRepository) RotateRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := r.tokenCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "rotatedAt": bson.M{"$exists": false}, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"rotatedAt": at, "lastUsedAt": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *mongoTokenRepository) RevokeRefreshTokens(ctx context.Context, filter RefreshTokenFilter, at time.Time) error {
+	query := bson.M{"revokedAt": bson.M{"$exists": false}}
+	if !filter.UserID.IsZero() {
+		query["userId"] = filter.UserID
+	}
+	if !filter.FamilyID.IsZero() {
+		query["familyId"] = filter.FamilyID
+	} else if !filter.ExceptFamilyID.IsZero() {
+		query["familyId"] = bson.M{"$ne": filter.ExceptFamilyID}
+	}
+
+	_, err := r.tokenCollection.UpdateMany(ctx, query, bson.M{"$set": bson.M{"revokedAt": at}})
+	return err
+}
+
+func (r *mongoTokenRepository) ActiveRefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error) {
+	filter := bson.M{
+		"userId":    userID,
+		"rotatedAt": bson.M{"$exists": false},
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastUsedAt", Value: -1}})
+	cursor, err := r.tokenCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.RefreshToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *mongoTokenRepository) RefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := r.tokenCollection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tokens := []models.RefreshToken{}
+	if err := cursor.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *mongoTokenRepository) CreateActionToken(ctx context.Context, token *models.ActionToken) error {
+	_, err := r.actionTokenCollection.InsertOne(ctx, token)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoTokenRepository) GetActionToken(ctx context.Context, tokenHash, purpose string) (*models.ActionToken, error) {
+	return findOne[models.ActionToken](ctx, r.actionTokenCollection, bson.M{"tokenHash": tokenHash, "purpose": purpose})
+}
+
+func (r *mongoTokenRepository) UseActionToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := r.actionTokenCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *mongoTokenRepository) UseActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error {
+	_, err := r.actionTokenCollection.UpdateMany(ctx,
+		bson.M{"userId": userID, "purpose": purpose, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": at}},
+	)
+	return err
+}
+
+func (r *mongoTokenRepository) RecordActionTokenAttempt(ctx context.Context, id primitive.ObjectID, burn bool, at time.Time) error {
+	update := bson.M{"$inc": bson.M{"attempts": 1}}
+	if burn {
+		update["$set"] = bson.M{"usedAt": at}
+	}
+	_, err := r.actionTokenCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (r *mongoTokenRepository) CountActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) (int64, error) {
+	return r.actionTokenCollection.CountDocuments(ctx, bson.M{
+		"userId":    userID,
+		"purpose":   purpose,
+		"createdAt": bson.M{"$gte": since},
+	})
+}
+
+// DeleteExpired removes what the TTL indexes would, without waiting for
+// MongoDB's next TTL pass.
+func (r *mongoTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for _, collection := range []*mongo.Collection{r.tokenCollection, r.actionTokenCollection} {
+		result, err := collection.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lt": before}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.DeletedCount
+	}
+	return deleted, nil
+}
+
+func (r *mongoTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	for _, collection := range []*mongo.Collection{r.tokenCollection, r.actionTokenCollection} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type mongoUserRepository struct {
+	userCollection *mongo.Collection
+}
+
+func (r *mongoUserRepository) Create(ctx context.Context, user *models.User) error {
+	// Without a unique index two concurrent inserts could both pass this
+	// check; the index turns the loser into a duplicate key error
+	count, err := r.userCollection.CountDocuments(ctx, bson.M{"$or": bson.A{
+		bson.M{"email": user.Email},
+		bson.M{"username": user.Username},
+	}})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrDuplicate
+	}
+
+	_, err = r.userCollection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoUserRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return findOne[models.User](ctx, r.userCollection, bson.M{"_id": id})
+}
+
+func (r *mongoUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return findOne[models.User](ctx, r.userCollection, bson.M{"email": email})
+}
+
+func (r *mongoUserRepository) GetByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return findOne[models.User](ctx, r.userCollection, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	})
+}
+
+func (r *mongoUserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	count, err := r.userCollection.CountDocuments(ctx, bson.M{"username": username})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *mongoUserRepository) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) error {
+	set := bson.M{"updatedAt": time.Now()}
+	if update.Password != nil {
+		set["password"] = *update.Password
+	}
+	if update.Role != nil {
+		set["role"] = *update.Role
+	}
+	if update.IsVerified != nil {
+		set["isVerified"] = *update.IsVerified
+	}
+	if update.VerifiedAt != nil {
+		set["verifiedAt"] = *update.VerifiedAt
+	}
+	if update.JurisdictionIDs != nil {
+		set["jurisdictionIds"] = update.JurisdictionIDs
+	}
+	if update.MFA != nil {
+		set["mfa"] = *update.MFA
+	}
+	if update.RecoveryCodes != nil {
+		set["mfa.recoveryCodes"] = update.RecoveryCodes
+	}
+
+	doc := bson.M{"$set": set}
+	if update.RemoveMFA {
+		doc["$unset"] = bson.M{"mfa": ""}
+	}
+	if update.AddIdentity != nil {
+		doc["$push"] = bson.M{"identities": *update.AddIdentity}
+	}
+
+	result, err := r.userCollection.UpdateOne(ctx, bson.M{"_id": id}, doc)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoUserRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	// A missing lastUsedStep counts as zero
+	result, err := r.userCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"mfa.lastUsedStep": bson.M{"$lt": step}},
+			bson.M{"mfa.lastUsedStep": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"mfa.lastUsedStep": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *mongoUserRepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	result, err := r.userCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "mfa.recoveryCodes": codeHash},
+		bson.M{"$pull": bson.M{"mfa.recoveryCodes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (r *mongoUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.userCollection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+// postgresMigrationLock is the advisory lock held while migrating, so that
+// instances starting together do not apply the same migration twice.
+const postgresMigrationLock = 4279110
+
+const postgresCleanupInterval = time.Hour
+
+// NewPostgresStore returns repositories backed by PostgreSQL with PostGIS.
+// The schema must be up to date, see MigratePostgres.
+func NewPostgresStore(pool *pgxpool.Pool) *Store {
+	return &Store{
+		Users:         &postgresUserRepository{pool: pool},
+		Tokens:        &postgresTokenRepository{pool: pool},
+		LoginAttempts: &postgresLoginAttemptRepository{pool: pool},
+		Issues:        &postgresIssueRepository{pool: pool},
+	}
+}
+
+// PostgresMigration is a schema migration in migrations/postgres. AppliedAt
+// is zero while it is pending.
+type PostgresMigration struct {
+	Version   string
+	File      string
+	AppliedAt time.Time
+}
+
+// MigratePostgres applies the migrations in migrations/postgres that have not
+// been applied yet, in order of their version prefix. Each migration runs in
+// its own transaction and is recorded in schema_migrations.
+func MigratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %v", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLock); err != nil {
+		return fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", postgresMigrationLock)
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version text PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+
+	migrations, err := postgresMigrationStatus(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if !migration.AppliedAt.IsZero() {
+			continue
+		}
+
+		sql, err := postgresMigrations.ReadFile(migration.File)
+		if err != nil {
+			return err
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, string(sql)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", migration.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %s: %v", migration.File, err)
+		}
+		slog.Info("applied postgres migration", "file", migration.File)
+	}
+	return nil
+}
+
+// PostgresMigrations lists every migration in migrations/postgres in order,
+// with the time it was applied.
+func PostgresMigrations(ctx context.Context, pool *pgxpool.Pool) ([]PostgresMigration, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %v", err)
+	}
+	defer conn.Release()
+	return postgresMigrationStatus(ctx, conn)
+}
+
+func postgresMigrationStatus(ctx context.Context, conn *pgxpool.Conn) ([]PostgresMigration, error) {
+	applied := make(map[string]time.Time)
+	rows, err := conn.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err == nil {
+		var version string
+		var appliedAt time.Time
+		_, err = pgx.ForEachRow(rows, []any{&version, &appliedAt}, func() error {
+			applied[version] = appliedAt
+			return nil
+		})
+	}
+	// Nothing has been applied to a new database
+	if err != nil && !isUndefinedTable(err) {
+		return nil, fmt.Errorf("failed to read applied migrations: %v", err)
+	}
+
+	files, err := fs.Glob(postgresMigrations, "migrations/postgres/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	migrations := make([]PostgresMigration, len(files))
+	for i, file := range files {
+		version, _, _ := strings.Cut(strings.TrimPrefix(file, "migrations/postgres/"), "_")
+		migrations[i] = PostgresMigration{Version: version, File: file, AppliedAt: applied[version]}
+	}
+	return migrations, nil
+}
+
+// StartPostgresCleanup periodically deletes expired tokens and login
+// attempts, which MongoDB removes with TTL indexes. It returns when ctx is
+// cancelled.
+func StartPostgresCleanup(ctx context.Context, pool *pgxpool.Pool) {
+	ticker := time.NewTicker(postgresCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, table := range []string{"refresh_tokens", "action_tokens", "login_attempts"} {
+				if _, err := pool.Exec(ctx, "DELETE FROM "+table+" WHERE expires_at < now()"); err != nil {
+					slog.Error("postgres cleanup failed", "table", table, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// sqlArgs collects query arguments, returning the placeholder for each.
+type sqlArgs []any
+
+func (a *sqlArgs) add(value any) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+func isUniqueViolation(err error) bool {
+	return isPgError(err, "23505")
+}
+
+func isForeignKeyViolation(err error) bool {
+	return isPgError(err, "23503")
+}
+
+func isUndefinedTable(err error) bool {
+	return isPgError(err, "42P01")
+}
+
+// notFound maps a missing row to ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func hexIDs(ids []primitive.ObjectID) []string {
+	hexes := make([]string, len(ids))
+	for i, id := range ids {
+		hexes[i] = id.Hex()
+	}
+	return hexes
+}
+
+func parseIDs(hexes []string) ([]primitive.ObjectID, error) {
+	if len(hexes) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, len(hexes))
+	for i, hex := range hexes {
+		id, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// nullableID stores the zero ObjectID as NULL, as MongoDB omits it.
+func nullableID(id primitive.ObjectID) *string {
+	if id.IsZero() {
+		return nil
+	}
+	hex := id.Hex()
+	return &hex
+}
+
+func parseNullableID(hex *string) (primitive.ObjectID, error) {
+	if hex == nil {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.ObjectIDFromHex(*hex)
+}
+
+// locationColumns selects a location column as latitude, longitude and
+// address.
+func locationColumns(column string) string {
+	return fmt.Sprintf("ST_Y(%[1]s::geometry), ST_X(%[1]s::geometry), %[1]s_address", column)
+}
+
+// addPoint adds the location as a PostGIS geography point, NULL for no
+// location.
+func (a *sqlArgs) addPoint(location *models.Location) string {
+	var lat, lng *float64
+	if location != nil {
+		lat, lng = &location.Lat, &location.Lng
+	}
+	return fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s::float8, %s::float8), 4326)::geography", a.add(lng), a.add(lat))
+}
+
+func addressOf(location *models.Location) *string {
+	if location == nil {
+		return nil
+	}
+	return &location.Address
+}
+
+func newLocation(lat, lng *float64, address *string) *models.Location {
+	if lat == nil || lng == nil {
+		return nil
+	}
+	location := &models.Location{Lat: *lat, Lng: *lng}
+	if address != nil {
+		location.Address = *address
+	}
+	return location
+}
@@ -0,0 +1,490 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type postgresIssueRepository struct {
+	pool *pgxpool.Pool
+}
+
+var issueColumns = "id, title, description, category, priority, status, " + locationColumns("location") +
+	", created_by, assigned_to, jurisdiction_ids, tags, created_at, updated_at"
+
+func scanIssue(row pgx.CollectableRow) (models.Issue, error) {
+	var (
+		issue                 models.Issue
+		id, createdBy         string
+		assignedTo            *string
+		lat, lng              *float64
+		address               *string
+		jurisdictionIDs, tags []string
+	)
+	err := row.Scan(&id, &issue.Title, &issue.Description, &issue.Category, &issue.Priority, &issue.Status,
+		&lat, &lng, &address, &createdBy, &assignedTo, &jurisdictionIDs, &tags, &issue.CreatedAt, &issue.UpdatedAt)
+	if err != nil {
+		return issue, err
+	}
+
+	if issue.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return issue, err
+	}
+	if issue.CreatedBy, err = primitive.ObjectIDFromHex(createdBy); err != nil {
+		return issue, err
+	}
+	if issue.AssignedTo, err = parseNullableID(assignedTo); err != nil {
+		return issue, err
+	}
+	if issue.JurisdictionIDs, err = parseIDs(jurisdictionIDs); err != nil {
+		return issue, err
+	}
+	issue.Location = newLocation(lat, lng, address)
+	if len(tags) > 0 {
+		issue.Tags = tags
+	}
+	return issue, nil
+}
+
+func (r *postgresIssueRepository) Create(ctx context.Context, issue *models.Issue) error {
+	if issue.ID.IsZero() {
+		issue.ID = primitive.NewObjectID()
+	}
+
+	args := sqlArgs{}
+	values := []string{
+		args.add(issue.ID.Hex()),
+		args.add(issue.Title),
+		args.add(issue.Description),
+		args.add(issue.Category),
+		args.add(issue.Priority),
+		args.add(issue.Status),
+		args.addPoint(issue.Location),
+		args.add(addressOf(issue.Location)),
+		args.add(issue.CreatedBy.Hex()),
+		args.add(nullableID(issue.AssignedTo)),
+		args.add(hexIDs(issue.JurisdictionIDs)),
+		args.add(nonNilStrings(issue.Tags)),
+		args.add(issue.CreatedAt),
+		args.add(issue.UpdatedAt),
+	}
+
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf(`INSERT INTO issues (id, title, description, category, priority, status,
+			location, location_address, created_by, assigned_to, jurisdiction_ids, tags, created_at, updated_at)
+			VALUES (%s)`, strings.Join(values, ", ")), args...)
+		if err != nil {
+			return err
+		}
+		for _, userID := range issue.Votes.Up {
+			if err := vote(ctx, tx, issue.ID, userID, true); err != nil {
+				return err
+			}
+		}
+		for _, userID := range issue.Votes.Down {
+			if err := vote(ctx, tx, issue.ID, userID, false); err != nil {
+				return err
+			}
+		}
+		for i := range issue.Comments {
+			if err := insertComment(ctx, tx, issue.ID, &issue.Comments[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func (r *postgresIssueRepository) Get(ctx context.Context, id primitive.ObjectID) (*models.Issue, error) {
+	rows, err := r.pool.Query(ctx, "SELECT "+issueColumns+" FROM issues WHERE id = $1", id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	issue, err := pgx.CollectExactlyOneRow(rows, scanIssue)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	issues := []models.Issue{issue}
+	if err := r.loadVotesAndComments(ctx, issues); err != nil {
+		return nil, err
+	}
+	return &issues[0], nil
+}
+
+// loadVotesAndComments fills in the votes and comments of the issues.
+func (r *postgresIssueRepository) loadVotesAndComments(ctx context.Context, issues []models.Issue) error {
+	if len(issues) == 0 {
+		return nil
+	}
+	byID := make(map[string]*models.Issue, len(issues))
+	ids := make([]string, len(issues))
+	for i := range issues {
+		ids[i] = issues[i].ID.Hex()
+		byID[ids[i]] = &issues[i]
+	}
+
+	rows, err := r.pool.Query(ctx, "SELECT issue_id, user_id, up FROM issue_votes WHERE issue_id = ANY ($1) ORDER BY seq", ids)
+	if err != nil {
+		return fmt.Errorf("failed to load votes: %v", err)
+	}
+	var (
+		issueID, userID string
+		up              bool
+	)
+	_, err = pgx.ForEachRow(rows, []any{&issueID, &userID, &up}, func() error {
+		id, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			return err
+		}
+		issue := byID[issueID]
+		if up {
+			issue.Votes.Up = append(issue.Votes.Up, id)
+		} else {
+			issue.Votes.Down = append(issue.Votes.Down, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load votes: %v", err)
+	}
+
+	rows, err = r.pool.Query(ctx, `SELECT issue_id, id, content, created_by, created_at, updated_at
+		FROM issue_comments WHERE issue_id = ANY ($1) ORDER BY seq`, ids)
+	if err != nil {
+		return fmt.Errorf("failed to load comments: %v", err)
+	}
+	var (
+		commentID, createdBy string
+		comment              models.Comment
+	)
+	_, err = pgx.ForEachRow(rows, []any{&issueID, &commentID, &comment.Content, &createdBy, &comment.CreatedAt, &comment.UpdatedAt}, func() error {
+		var err error
+		if comment.ID, err = primitive.ObjectIDFromHex(commentID); err != nil {
+			return err
+		}
+		if comment.CreatedBy, err = primitive.ObjectIDFromHex(createdBy); err != nil {
+			return err
+		}
+		issue := byID[issueID]
+		issue.Comments = append(issue.Comments, comment)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load comments: %v", err)
+	}
+	return nil
+}
+
+func (r *postgresIssueRepository) Update(ctx context.Context, id primitive.ObjectID, update IssueUpdate) error {
+	args := sqlArgs{id.Hex()}
+	set := []string{"updated_at = " + args.add(time.Now())}
+	if update.Title != nil {
+		set = append(set, "title = "+args.add(*update.Title))
+	}
+	if update.Description != nil {
+		set = append(set, "description = "+args.add(*update.Description))
+	}
+	if update.Category != nil {
+		set = append(set, "category = "+args.add(*update.Category))
+	}
+	if update.Priority != nil {
+		set = append(set, "priority = "+args.add(*update.Priority))
+	}
+	if update.Status != nil {
+		set = append(set, "status = "+args.add(*update.Status))
+	}
+	if update.Tags != nil {
+		set = append(set, "tags = "+args.add(update.Tags))
+	}
+	if update.Location != nil {
+		set = append(set,
+			"location = "+args.addPoint(update.Location),
+			"location_address = "+args.add(update.Location.Address),
+		)
+	}
+	if update.AssignedTo != nil {
+		set = append(set, "assigned_to = "+args.add(nullableID(*update.AssignedTo)))
+	}
+	if update.JurisdictionIDs != nil {
+		set = append(set, "jurisdiction_ids = "+args.add(hexIDs(update.JurisdictionIDs)))
+	}
+
+	result, err := r.pool.Exec(ctx, "UPDATE issues SET "+strings.Join(set, ", ")+" WHERE id = $1", args...)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresIssueRepository) Vote(ctx context.Context, id, userID primitive.ObjectID, up bool) error {
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		return vote(ctx, tx, id, userID, up)
+	})
+	if isForeignKeyViolation(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// vote records the vote. A changed vote moves to the end of the other list,
+// as it does in MongoDB.
+func vote(ctx context.Context, tx pgx.Tx, id, userID primitive.ObjectID, up bool) error {
+	_, err := tx.Exec(ctx, `INSERT INTO issue_votes (issue_id, user_id, up) VALUES ($1, $2, $3)
+		ON CONFLICT (issue_id, user_id) DO UPDATE SET up = EXCLUDED.up, seq = EXCLUDED.seq
+		WHERE issue_votes.up <> EXCLUDED.up`, id.Hex(), userID.Hex(), up)
+	return err
+}
+
+func (r *postgresIssueRepository) AddComment(ctx context.Context, id primitive.ObjectID, comment *models.Comment) error {
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		return insertComment(ctx, tx, id, comment)
+	})
+	if isForeignKeyViolation(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func insertComment(ctx context.Context, tx pgx.Tx, id primitive.ObjectID, comment *models.Comment) error {
+	if comment.ID.IsZero() {
+		comment.ID = primitive.NewObjectID()
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO issue_comments (id, issue_id, content, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		comment.ID.Hex(), id.Hex(), comment.Content, comment.CreatedBy.Hex(), comment.CreatedAt, comment.UpdatedAt,
+	)
+	return err
+}
+
+func (r *postgresIssueRepository) RemoveUser(ctx context.Context, userID primitive.ObjectID) (*UserRemoval, error) {
+	removal := &UserRemoval{}
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		id, deleted := userID.Hex(), models.DeletedUserID.Hex()
+
+		result, err := tx.Exec(ctx, "UPDATE issues SET created_by = $2 WHERE created_by = $1", id, deleted)
+		if err != nil {
+			return fmt.Errorf("failed to anonymise issues: %v", err)
+		}
+		removal.Issues = result.RowsAffected()
+
+		// Counted per issue, as MongoDB counts the documents it changes
+		err = tx.QueryRow(ctx, `WITH anonymised AS (
+			UPDATE issue_comments SET created_by = $2 WHERE created_by = $1 RETURNING issue_id
+		) SELECT count(DISTINCT issue_id) FROM anonymised`, id, deleted).Scan(&removal.Commented)
+		if err != nil {
+			return fmt.Errorf("failed to anonymise comments: %v", err)
+		}
+
+		// A user has at most one vote per issue
+		result, err = tx.Exec(ctx, "DELETE FROM issue_votes WHERE user_id = $1", id)
+		if err != nil {
+			return fmt.Errorf("failed to remove votes: %v", err)
+		}
+		removal.Voted = result.RowsAffected()
+
+		result, err = tx.Exec(ctx, "UPDATE issues SET assigned_to = NULL WHERE assigned_to = $1", id)
+		if err != nil {
+			return fmt.Errorf("failed to unassign issues: %v", err)
+		}
+		removal.Assigned = result.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removal, nil
+}
+
+// Search matches text against the generated tsvector of the title,
+// description and tags. As with MongoDB, any word of the query is enough for
+// a match and words are stemmed.
+func (r *postgresIssueRepository) Search(ctx context.Context, query IssueQuery) (*IssueSearchResult, error) {
+	args := sqlArgs{}
+	where := issueWhere(query, &args)
+
+	facetRows, err := r.pool.Query(ctx, fmt.Sprintf(`
+		SELECT 'category', category, count(*) FROM issues WHERE %[1]s GROUP BY category
+		UNION ALL
+		SELECT 'priority', priority, count(*) FROM issues WHERE %[1]s GROUP BY priority
+		UNION ALL
+		SELECT 'status', status, count(*) FROM issues WHERE %[1]s GROUP BY status
+		UNION ALL
+		SELECT 'tag', tag, count(*) FROM issues, unnest(tags) AS tag WHERE %[1]s GROUP BY tag`, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute search: %v", err)
+	}
+
+	result := &IssueSearchResult{
+		Issues:     []models.Issue{},
+		Categories: make(map[string]int64),
+		Priorities: make(map[string]int64),
+		Statuses:   make(map[string]int64),
+		Tags:       make(map[string]int64),
+	}
+	var (
+		facet, value string
+		count        int64
+	)
+	_, err = pgx.ForEachRow(facetRows, []any{&facet, &value, &count}, func() error {
+		switch facet {
+		case "category":
+			result.Categories[value] = count
+			result.Total += count
+		case "priority":
+			result.Priorities[value] = count
+		case "status":
+			result.Statuses[value] = count
+		case "tag":
+			result.Tags[value] = count
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode search results: %v", err)
+	}
+
+	var limit *int64
+	if query.Limit > 0 {
+		limit = &query.Limit
+	}
+	rows, err := r.pool.Query(ctx, fmt.Sprintf("SELECT %s FROM issues WHERE %s ORDER BY %s OFFSET %s LIMIT %s",
+		issueColumns, where, issueOrder(query), args.add(query.Skip), args.add(limit)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute search: %v", err)
+	}
+	issues, err := pgx.CollectRows(rows, scanIssue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode search results: %v", err)
+	}
+	if err := r.loadVotesAndComments(ctx, issues); err != nil {
+		return nil, err
+	}
+	if len(issues) > 0 {
+		result.Issues = issues
+	}
+	return result, nil
+}
+
+// ResponseStats takes each matching issue's first comment by the responder
+// from issue_comments and totals the response times in the database.
+func (r *postgresIssueRepository) ResponseStats(ctx context.Context, query IssueQuery, resolvedStatuses []string, responderID primitive.ObjectID) (*ResponseStats, error) {
+	args := sqlArgs{}
+	where := issueWhere(query, &args)
+
+	stats := &ResponseStats{}
+	var responseSeconds float64
+	err := r.pool.QueryRow(ctx, fmt.Sprintf(`
+		SELECT count(*), count(*) FILTER (WHERE status = ANY (%s)), count(first_response),
+			coalesce(sum(extract(epoch FROM first_response - created_at)), 0)::float8
+		FROM (
+			SELECT status, created_at, (
+				SELECT min(created_at) FROM issue_comments
+				WHERE issue_comments.issue_id = issues.id AND issue_comments.created_by = %s
+			) AS first_response
+			FROM issues WHERE %s
+		) AS matched`, args.add(nonNilStrings(resolvedStatuses)), args.add(responderID.Hex()), where), args...,
+	).Scan(&stats.Issues, &stats.Resolved, &stats.Responded, &responseSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute response statistics: %v", err)
+	}
+	stats.ResponseTime = time.Duration(responseSeconds * float64(time.Second))
+	return stats, nil
+}
+
+func issueWhere(query IssueQuery, args *sqlArgs) string {
+	where := []string{"true"}
+
+	if query.Text != "" {
+		// Words only hold letters and digits, so they are safe in a tsquery
+		words := splitWords(query.Text)
+		if len(words) == 0 {
+			return "false"
+		}
+		where = append(where, "search @@ to_tsquery('english', "+args.add(strings.Join(words, " | "))+")")
+	}
+	if len(query.Categories) > 0 {
+		where = append(where, "category = ANY ("+args.add(query.Categories)+")")
+	}
+	if len(query.Priorities) > 0 {
+		where = append(where, "priority = ANY ("+args.add(query.Priorities)+")")
+	}
+	if len(query.Statuses) > 0 {
+		where = append(where, "status = ANY ("+args.add(query.Statuses)+")")
+	}
+	if query.StartDate != nil {
+		where = append(where, "created_at >= "+args.add(*query.StartDate))
+	}
+	if query.EndDate != nil {
+		where = append(where, "created_at <= "+args.add(*query.EndDate))
+	}
+	if len(query.Tags) > 0 {
+		where = append(where, "tags @> "+args.add(query.Tags)+"::text[]")
+	}
+	if query.HasLocation {
+		where = append(where, "location IS NOT NULL")
+	}
+	if !query.Participant.IsZero() {
+		participant := args.add(query.Participant.Hex())
+		where = append(where, fmt.Sprintf(`(created_by = %[1]s
+			OR EXISTS (SELECT 1 FROM issue_comments WHERE issue_comments.issue_id = issues.id AND issue_comments.created_by = %[1]s)
+			OR EXISTS (SELECT 1 FROM issue_votes WHERE issue_votes.issue_id = issues.id AND issue_votes.user_id = %[1]s))`,
+			participant))
+	}
+	if query.Near != nil {
+		center := args.addPoint(&models.Location{Lat: query.Near.Lat, Lng: query.Near.Lng})
+		where = append(where, fmt.Sprintf("ST_DWithin(location, %s, %s::float8)", center, args.add(query.Near.RadiusKm*1000)))
+	}
+	if query.JurisdictionScoped {
+		where = append(where, "jurisdiction_ids && "+args.add(hexIDs(query.JurisdictionIDs))+"::text[]")
+	}
+
+	return strings.Join(where, " AND ")
+}
+
+// issueOrder sorts the same way as issueSort does in MongoDB, comparing
+// strings byte by byte.
+func issueOrder(query IssueQuery) string {
+	direction := "ASC"
+	if query.Descending {
+		direction = "DESC"
+	}
+
+	switch query.SortBy {
+	case "votes":
+		return fmt.Sprintf(`(SELECT count(*) FILTER (WHERE up) - count(*) FILTER (WHERE NOT up)
+			FROM issue_votes WHERE issue_votes.issue_id = issues.id) %s, created_at DESC, id COLLATE "C"`, direction)
+	case "priority":
+		return fmt.Sprintf(`priority COLLATE "C" %s, created_at DESC, id COLLATE "C"`, direction)
+	default:
+		return fmt.Sprintf(`created_at %s, id COLLATE "C"`, direction)
+	}
+}
+
+// Reindex rebuilds the search, location and tag indexes. The search vector
+// itself is a generated column and always up to date.
+func (r *postgresIssueRepository) Reindex(ctx context.Context) error {
+	_, err := r.pool.Exec(ctx, "REINDEX TABLE issues")
+	return err
+}
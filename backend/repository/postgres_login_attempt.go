@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type postgresLoginAttemptRepository struct {
+	pool *pgxpool.Pool
+}
+
+const loginAttemptColumns = "key, failures, lockouts, last_failure_at, locked_until, expires_at"
+
+func (r *postgresLoginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	rows, err := r.pool.Query(ctx, "SELECT "+loginAttemptColumns+" FROM login_attempts WHERE key = $1", key)
+	if err != nil {
+		return nil, err
+	}
+	attempts, err := pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[models.LoginAttempts])
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return attempts, nil
+}
+
+func (r *postgresLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at time.Time, window, retention time.Duration) (*models.LoginAttempts, error) {
+	// Failures outside the window no longer count
+	rows, err := r.pool.Query(ctx, `INSERT INTO login_attempts (key, failures, last_failure_at, expires_at)
+		VALUES ($1, 1, $2, $3)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < $4 THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at,
+			expires_at = EXCLUDED.expires_at
+		RETURNING `+loginAttemptColumns,
+		key, at, at.Add(retention), at.Add(-window),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByPos[models.LoginAttempts])
+}
+
+func (r *postgresLoginAttemptRepository) Lock(ctx context.Context, key string, threshold int, until time.Time, retention time.Duration) (bool, error) {
+	result, err := r.pool.Exec(ctx, `UPDATE login_attempts
+		SET failures = 0, locked_until = $3, expires_at = $4, lockouts = lockouts + 1
+		WHERE key = $1 AND failures >= $2`,
+		key, threshold, until, until.Add(retention),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *postgresLoginAttemptRepository) Delete(ctx context.Context, key string) error {
+	_, err := r.pool.Exec(ctx, "DELETE FROM login_attempts WHERE key = $1", key)
+	return err
+}
+
+func (r *postgresLoginAttemptRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.pool.Exec(ctx, "DELETE FROM login_attempts WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
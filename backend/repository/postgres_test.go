@@ -0,0 +1,100 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/repository/repositorytest"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestPostgresStore runs against the server in POSTGRES_TEST_URI, migrating
+// a new schema for each test. The server needs the PostGIS extension.
+func TestPostgresStore(t *testing.T) {
+	newPool := postgresTestSchemas(t)
+
+	repositorytest.Run(t, func(t *testing.T) *repository.Store {
+		pool := newPool(t)
+		if err := repository.MigratePostgres(context.Background(), pool); err != nil {
+			t.Fatal(err)
+		}
+		return repository.NewPostgresStore(pool)
+	})
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	ctx := context.Background()
+	pool := postgresTestSchemas(t)(t)
+
+	migrations, err := repository.PostgresMigrations(ctx, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations found")
+	}
+	for _, migration := range migrations {
+		if !migration.AppliedAt.IsZero() {
+			t.Errorf("migration %s is applied to a new schema", migration.Version)
+		}
+	}
+
+	if err := repository.MigratePostgres(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err = repository.PostgresMigrations(ctx, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		if migration.AppliedAt.IsZero() {
+			t.Errorf("migration %s is pending after migrating", migration.Version)
+		}
+	}
+}
+
+// postgresTestSchemas skips the test unless POSTGRES_TEST_URI is set and
+// returns a function connecting to a new, empty schema that is dropped when
+// the test ends.
+func postgresTestSchemas(t *testing.T) func(t *testing.T) *pgxpool.Pool {
+	uri := os.Getenv("POSTGRES_TEST_URI")
+	if uri == "" {
+		t.Skip("POSTGRES_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	admin, err := pgxpool.New(ctx, uri)
+	if err != nil {
+		t.Fatalf("failed to connect to PostgreSQL: %v", err)
+	}
+	t.Cleanup(admin.Close)
+	if _, err := admin.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS postgis"); err != nil {
+		t.Fatalf("failed to create the PostGIS extension: %v", err)
+	}
+
+	return func(t *testing.T) *pgxpool.Pool {
+		ctx := context.Background()
+		schema := "sautii_test_" + primitive.NewObjectID().Hex()
+		if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+			t.Fatalf("failed to create schema: %v", err)
+		}
+		t.Cleanup(func() { admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE") })
+
+		config, err := pgxpool.ParseConfig(uri)
+		if err != nil {
+			t.Fatalf("invalid POSTGRES_TEST_URI: %v", err)
+		}
+		config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+		pool, err := pgxpool.NewWithConfig(ctx, config)
+		if err != nil {
+			t.Fatalf("failed to connect to PostgreSQL: %v", err)
+		}
+		t.Cleanup(pool.Close)
+		return pool
+	}
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type postgresTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+const refreshTokenColumns = "id, user_id, token_hash, family_id, user_agent, ip, expires_at, last_used_at, rotated_at, revoked_at, created_at"
+
+func scanRefreshToken(row pgx.CollectableRow) (models.RefreshToken, error) {
+	var (
+		token                models.RefreshToken
+		id, userID, familyID string
+	)
+	err := row.Scan(&id, &userID, &token.TokenHash, &familyID, &token.UserAgent, &token.IP,
+		&token.ExpiresAt, &token.LastUsedAt, &token.RotatedAt, &token.RevokedAt, &token.CreatedAt)
+	if err != nil {
+		return token, err
+	}
+	if token.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return token, err
+	}
+	if token.UserID, err = primitive.ObjectIDFromHex(userID); err != nil {
+		return token, err
+	}
+	token.FamilyID, err = primitive.ObjectIDFromHex(familyID)
+	return token, err
+}
+
+func (r *postgresTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := r.pool.Exec(ctx, "INSERT INTO refresh_tokens ("+refreshTokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)",
+		token.ID.Hex(), token.UserID.Hex(), token.TokenHash, token.FamilyID.Hex(), token.UserAgent, token.IP,
+		token.ExpiresAt, token.LastUsedAt, token.RotatedAt, token.RevokedAt, token.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresTokenRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	rows, err := r.pool.Query(ctx, "SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE token_hash = $1", tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	token, err := pgx.CollectExactlyOneRow(rows, scanRefreshToken)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &token, nil
+}
+
+func (r *postgresTokenRepository) RotateRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := r.pool.Exec(ctx, `UPDATE refresh_tokens SET rotated_at = $2, last_used_at = $2
+		WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL`, id.Hex(), at)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *postgresTokenRepository) RevokeRefreshTokens(ctx context.Context, filter RefreshTokenFilter, at time.Time) error {
+	args := sqlArgs{at}
+	where := []string{"revoked_at IS NULL"}
+	if !filter.UserID.IsZero() {
+		where = append(where, "user_id = "+args.add(filter.UserID.Hex()))
+	}
+	if !filter.FamilyID.IsZero() {
+		where = append(where, "family_id = "+args.add(filter.FamilyID.Hex()))
+	} else if !filter.ExceptFamilyID.IsZero() {
+		where = append(where, "family_id <> "+args.add(filter.ExceptFamilyID.Hex()))
+	}
+
+	_, err := r.pool.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE "+strings.Join(where, " AND "), args...)
+	return err
+}
+
+func (r *postgresTokenRepository) ActiveRefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error) {
+	rows, err := r.pool.Query(ctx, "SELECT "+refreshTokenColumns+` FROM refresh_tokens
+		WHERE user_id = $1 AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		ORDER BY last_used_at DESC`, userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanRefreshToken)
+}
+
+func (r *postgresTokenRepository) RefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error) {
+	rows, err := r.pool.Query(ctx, "SELECT "+refreshTokenColumns+" FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at DESC",
+		userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, scanRefreshToken)
+}
+
+const actionTokenColumns = "id, user_id, purpose, token_hash, expires_at, used_at, attempts, created_at"
+
+func (r *postgresTokenRepository) CreateActionToken(ctx context.Context, token *models.ActionToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	_, err := r.pool.Exec(ctx, "INSERT INTO action_tokens ("+actionTokenColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		token.ID.Hex(), token.UserID.Hex(), token.Purpose, token.TokenHash, token.ExpiresAt, token.UsedAt,
+		token.Attempts, token.CreatedAt,
+	)
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresTokenRepository) GetActionToken(ctx context.Context, tokenHash, purpose string) (*models.ActionToken, error) {
+	var (
+		token      models.ActionToken
+		id, userID string
+	)
+	err := r.pool.QueryRow(ctx, "SELECT "+actionTokenColumns+" FROM action_tokens WHERE token_hash = $1 AND purpose = $2",
+		tokenHash, purpose,
+	).Scan(&id, &userID, &token.Purpose, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.Attempts, &token.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if token.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = primitive.ObjectIDFromHex(userID); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *postgresTokenRepository) UseActionToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := r.pool.Exec(ctx, "UPDATE action_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL", id.Hex(), at)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *postgresTokenRepository) UseActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error {
+	_, err := r.pool.Exec(ctx, "UPDATE action_tokens SET used_at = $3 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL",
+		userID.Hex(), purpose, at)
+	return err
+}
+
+func (r *postgresTokenRepository) RecordActionTokenAttempt(ctx context.Context, id primitive.ObjectID, burn bool, at time.Time) error {
+	_, err := r.pool.Exec(ctx, `UPDATE action_tokens
+		SET attempts = attempts + 1, used_at = CASE WHEN $2 THEN $3 ELSE used_at END
+		WHERE id = $1`, id.Hex(), burn, at)
+	return err
+}
+
+func (r *postgresTokenRepository) CountActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := r.pool.QueryRow(ctx, "SELECT count(*) FROM action_tokens WHERE user_id = $1 AND purpose = $2 AND created_at >= $3",
+		userID.Hex(), purpose, since,
+	).Scan(&count)
+	return count, err
+}
+
+func (r *postgresTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	var deleted int64
+	for _, table := range []string{"refresh_tokens", "action_tokens"} {
+		result, err := r.pool.Exec(ctx, "DELETE FROM "+table+" WHERE expires_at < $1", before)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += result.RowsAffected()
+	}
+	return deleted, nil
+}
+
+func (r *postgresTokenRepository) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error {
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		for _, table := range []string{"refresh_tokens", "action_tokens"} {
+			if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID.Hex()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type postgresUserRepository struct {
+	pool *pgxpool.Pool
+}
+
+var userColumns = "id, email, username, password, role, is_verified, verified_at, profile_picture, " +
+	locationColumns("location") + ", jurisdiction_ids, stats, mfa_enabled, mfa_secret, mfa_pending_secret, " +
+	"mfa_recovery_codes, mfa_last_used_step, mfa_enabled_at, created_at, updated_at"
+
+func (r *postgresUserRepository) Create(ctx context.Context, user *models.User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	args := sqlArgs{}
+	values := []string{
+		args.add(user.ID.Hex()),
+		args.add(user.Email),
+		args.add(user.Username),
+		args.add(user.Password),
+		args.add(user.Role),
+		args.add(user.IsVerified),
+		args.add(user.VerifiedAt),
+		args.add(user.ProfilePicture),
+		args.addPoint(user.Location),
+		args.add(addressOf(user.Location)),
+		args.add(hexIDs(user.JurisdictionIDs)),
+		args.add(user.Stats),
+	}
+	if mfa := user.MFA; mfa != nil {
+		values = append(values,
+			args.add(mfa.Enabled),
+			args.add(mfa.Secret),
+			args.add(mfa.PendingSecret),
+			args.add(mfa.RecoveryCodes),
+			args.add(mfa.LastUsedStep),
+			args.add(mfa.EnabledAt),
+		)
+	} else {
+		values = append(values, "NULL", "NULL", "NULL", "NULL", "NULL", "NULL")
+	}
+	values = append(values, args.add(user.CreatedAt), args.add(user.UpdatedAt))
+
+	err := pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, fmt.Sprintf(`INSERT INTO users (id, email, username, password, role, is_verified,
+			verified_at, profile_picture, location, location_address, jurisdiction_ids, stats, mfa_enabled,
+			mfa_secret, mfa_pending_secret, mfa_recovery_codes, mfa_last_used_step, mfa_enabled_at,
+			created_at, updated_at) VALUES (%s)`, strings.Join(values, ", ")), args...)
+		if err != nil {
+			return err
+		}
+		for _, identity := range user.Identities {
+			if err := insertIdentity(ctx, tx, user.ID, identity); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isUniqueViolation(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func insertIdentity(ctx context.Context, tx pgx.Tx, userID primitive.ObjectID, identity models.ExternalIdentity) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO user_identities (provider, subject, user_id, email, linked_at) VALUES ($1, $2, $3, $4, $5)",
+		identity.Provider, identity.Subject, userID.Hex(), identity.Email, identity.LinkedAt,
+	)
+	return err
+}
+
+// getUser returns the user matching the condition, with its identities.
+func (r *postgresUserRepository) getUser(ctx context.Context, condition string, args ...any) (*models.User, error) {
+	var (
+		user            models.User
+		id              string
+		lat, lng        *float64
+		address         *string
+		jurisdictionIDs []string
+		mfaEnabled      *bool
+		mfaSecret       *string
+		mfaPending      *string
+		recoveryCodes   []string
+		lastUsedStep    *int64
+		mfaEnabledAt    *time.Time
+	)
+	err := r.pool.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE "+condition, args...).Scan(
+		&id, &user.Email, &user.Username, &user.Password, &user.Role, &user.IsVerified, &user.VerifiedAt,
+		&user.ProfilePicture, &lat, &lng, &address, &jurisdictionIDs, &user.Stats, &mfaEnabled, &mfaSecret,
+		&mfaPending, &recoveryCodes, &lastUsedStep, &mfaEnabledAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	if user.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, err
+	}
+	if user.JurisdictionIDs, err = parseIDs(jurisdictionIDs); err != nil {
+		return nil, err
+	}
+	user.Location = newLocation(lat, lng, address)
+	if mfaEnabled != nil {
+		user.MFA = &models.MFASettings{
+			Enabled:       *mfaEnabled,
+			RecoveryCodes: recoveryCodes,
+			EnabledAt:     mfaEnabledAt,
+		}
+		if mfaSecret != nil {
+			user.MFA.Secret = *mfaSecret
+		}
+		if mfaPending != nil {
+			user.MFA.PendingSecret = *mfaPending
+		}
+		if lastUsedStep != nil {
+			user.MFA.LastUsedStep = *lastUsedStep
+		}
+	}
+
+	rows, err := r.pool.Query(ctx,
+		"SELECT provider, subject, email, linked_at FROM user_identities WHERE user_id = $1 ORDER BY seq", id)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := pgx.CollectRows(rows, pgx.RowToStructByPos[models.ExternalIdentity])
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) > 0 {
+		user.Identities = identities
+	}
+	return &user, nil
+}
+
+func (r *postgresUserRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return r.getUser(ctx, "id = $1", id.Hex())
+}
+
+func (r *postgresUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.getUser(ctx, "email = $1", email)
+}
+
+func (r *postgresUserRepository) GetByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.getUser(ctx, "id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)", provider, subject)
+}
+
+func (r *postgresUserRepository) UsernameExists(ctx context.Context, username string) (bool, error) {
+	var exists bool
+	err := r.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
+	return exists, err
+}
+
+func (r *postgresUserRepository) Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) error {
+	args := sqlArgs{id.Hex()}
+	set := []string{"updated_at = " + args.add(time.Now())}
+	if update.Password != nil {
+		set = append(set, "password = "+args.add(*update.Password))
+	}
+	if update.Role != nil {
+		set = append(set, "role = "+args.add(*update.Role))
+	}
+	if update.IsVerified != nil {
+		set = append(set, "is_verified = "+args.add(*update.IsVerified))
+	}
+	if update.VerifiedAt != nil {
+		set = append(set, "verified_at = "+args.add(*update.VerifiedAt))
+	}
+	if update.JurisdictionIDs != nil {
+		set = append(set, "jurisdiction_ids = "+args.add(hexIDs(update.JurisdictionIDs)))
+	}
+	if mfa := update.MFA; mfa != nil {
+		set = append(set,
+			"mfa_enabled = "+args.add(mfa.Enabled),
+			"mfa_secret = "+args.add(mfa.Secret),
+			"mfa_pending_secret = "+args.add(mfa.PendingSecret),
+			"mfa_recovery_codes = "+args.add(mfa.RecoveryCodes),
+			"mfa_last_used_step = "+args.add(mfa.LastUsedStep),
+			"mfa_enabled_at = "+args.add(mfa.EnabledAt),
+		)
+	}
+	if update.RecoveryCodes != nil {
+		set = append(set,
+			"mfa_recovery_codes = "+args.add(update.RecoveryCodes),
+			"mfa_enabled = coalesce(mfa_enabled, false)",
+		)
+	}
+	if update.RemoveMFA {
+		set = append(set, "mfa_enabled = NULL", "mfa_secret = NULL", "mfa_pending_secret = NULL",
+			"mfa_recovery_codes = NULL", "mfa_last_used_step = NULL", "mfa_enabled_at = NULL")
+	}
+
+	return pgx.BeginFunc(ctx, r.pool, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, "UPDATE users SET "+strings.Join(set, ", ")+" WHERE id = $1", args...)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		if update.AddIdentity != nil {
+			err := insertIdentity(ctx, tx, id, *update.AddIdentity)
+			if isUniqueViolation(err) {
+				return ErrDuplicate
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (r *postgresUserRepository) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	// A missing last used step counts as zero
+	result, err := r.pool.Exec(ctx, `UPDATE users
+		SET mfa_last_used_step = $2, mfa_enabled = coalesce(mfa_enabled, false)
+		WHERE id = $1 AND coalesce(mfa_last_used_step, 0) < $2`, id.Hex(), step)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *postgresUserRepository) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error) {
+	result, err := r.pool.Exec(ctx, `UPDATE users
+		SET mfa_recovery_codes = array_remove(mfa_recovery_codes, $2)
+		WHERE id = $1 AND $2 = ANY (mfa_recovery_codes)`, id.Hex(), codeHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+func (r *postgresUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	// Identities are deleted with the user by their foreign key
+	result, err := r.pool.Exec(ctx, "DELETE FROM users WHERE id = $1", id.Hex())
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+// Package repository stores users, tokens and issues behind interfaces so
+// services do not depend on a particular database. Every backend must pass
+// the conformance suite in repository/repositorytest.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("already exists")
+)
+
+// Store groups the repositories of one storage backend.
+type Store struct {
+	Users         UserRepository
+	Tokens        TokenRepository
+	LoginAttempts LoginAttemptRepository
+	Issues        IssueRepository
+
+	ping       func(ctx context.Context) error
+	close      func()
+	migrate    func(ctx context.Context) error
+	migrations func(ctx context.Context) ([]PostgresMigration, error)
+}
+
+// Ping checks the connection to a database the store opened itself.
+func (s *Store) Ping(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	return s.ping(ctx)
+}
+
+// Migrate applies the pending schema migrations of a store with its own
+// schema. MongoDB stores are migrated by package migrations instead.
+func (s *Store) Migrate(ctx context.Context) error {
+	if s.migrate == nil {
+		return nil
+	}
+	return s.migrate(ctx)
+}
+
+// Migrations lists the schema migrations of a store with its own schema,
+// applied or pending.
+func (s *Store) Migrations(ctx context.Context) ([]PostgresMigration, error) {
+	if s.migrations == nil {
+		return nil, nil
+	}
+	return s.migrations(ctx)
+}
+
+// PendingMigrations lists the schema migrations Migrate would apply.
+func (s *Store) PendingMigrations(ctx context.Context) ([]PostgresMigration, error) {
+	migrations, err := s.Migrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []PostgresMigration
+	for _, migration := range migrations {
+		if migration.AppliedAt.IsZero() {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Close stops the store's background work and closes connections it opened.
+// Stores over a caller's MongoDB client leave closing it to the caller.
+func (s *Store) Close() {
+	if s.close != nil {
+		s.close()
+	}
+}
+
+// Open returns the backend selected by cfg.StorageBackend: "mongo" or
+// "postgres", which connects to cfg.PostgresURL, migrates its schema if
+// cfg.AutoMigrate is set and starts deleting expired rows in the background.
+func Open(ctx context.Context, db *mongo.Database, cfg config.Database) (*Store, error) {
+	switch backend := cfg.StorageBackend; backend {
+	case "", "mongo":
+		return NewMongoStore(db), nil
+	case "postgres":
+		pool, err := pgxpool.New(ctx, cfg.PostgresURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_CONNECTION: %v", err)
+		}
+		if err := pool.Ping(ctx); err != nil {
+			return nil, fmt.Errorf("failed to connect to PostgreSQL: %v", err)
+		}
+		if cfg.AutoMigrate {
+			if err := MigratePostgres(ctx, pool); err != nil {
+				pool.Close()
+				return nil, err
+			}
+		}
+
+		cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+		cleanupDone := make(chan struct{})
+		go func() {
+			StartPostgresCleanup(cleanupCtx, pool)
+			close(cleanupDone)
+		}()
+
+		store := NewPostgresStore(pool)
+		store.ping = pool.Ping
+		store.migrate = func(ctx context.Context) error { return MigratePostgres(ctx, pool) }
+		store.migrations = func(ctx context.Context) ([]PostgresMigration, error) { return PostgresMigrations(ctx, pool) }
+		store.close = func() {
+			stopCleanup()
+			<-cleanupDone
+			pool.Close()
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// UserUpdate lists the user fields to change. Nil fields are left as they
+// are; UpdatedAt is always set.
+type UserUpdate struct {
+	Password        *string
+	Role            *string
+	IsVerified      *bool
+	VerifiedAt      *time.Time
+	JurisdictionIDs []primitive.ObjectID
+	MFA             *models.MFASettings
+	RemoveMFA       bool
+	RecoveryCodes   []string
+	AddIdentity     *models.ExternalIdentity
+}
+
+type UserRepository interface {
+	// Create inserts a new user. It returns ErrDuplicate if the email or
+	// username is taken.
+	Create(ctx context.Context, user *models.User) error
+	GetByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	// GetByIdentity finds the user linked to an account at an identity
+	// provider.
+	GetByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	UsernameExists(ctx context.Context, username string) (bool, error)
+	Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) error
+	// UseTOTPStep records step as the user's last used TOTP step. It reports
+	// false if the same or a later step was already used.
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
+	// UseRecoveryCode removes the recovery code hash from the user. It
+	// reports false if the user did not have it.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, codeHash string) (bool, error)
+	// Delete removes the user and their linked identities.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// RefreshTokenFilter selects refresh tokens to revoke. Zero fields match
+// every token.
+type RefreshTokenFilter struct {
+	UserID         primitive.ObjectID
+	FamilyID       primitive.ObjectID
+	ExceptFamilyID primitive.ObjectID
+}
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// RotateRefreshToken marks the token as rotated. It reports false if the
+	// token was already rotated or revoked.
+	RotateRefreshToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error)
+	// RevokeRefreshTokens revokes the unrevoked tokens matching filter.
+	RevokeRefreshTokens(ctx context.Context, filter RefreshTokenFilter, at time.Time) error
+	// ActiveRefreshTokens returns the unrotated, unrevoked and unexpired
+	// tokens of the user, most recently used first.
+	ActiveRefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error)
+	// RefreshTokens returns every token of the user, including rotated,
+	// revoked and expired ones, newest first.
+	RefreshTokens(ctx context.Context, userID primitive.ObjectID) ([]models.RefreshToken, error)
+
+	CreateActionToken(ctx context.Context, token *models.ActionToken) error
+	GetActionToken(ctx context.Context, tokenHash, purpose string) (*models.ActionToken, error)
+	// UseActionToken marks the token as used. It reports false if it was
+	// already used.
+	UseActionToken(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error)
+	// UseActionTokens marks every unused token of the user with the purpose
+	// as used.
+	UseActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, at time.Time) error
+	// RecordActionTokenAttempt counts a failed attempt against the token and
+	// marks it as used when burn is set.
+	RecordActionTokenAttempt(ctx context.Context, id primitive.ObjectID, burn bool, at time.Time) error
+	// CountActionTokens counts the tokens issued to the user for the purpose
+	// since the given time.
+	CountActionTokens(ctx context.Context, userID primitive.ObjectID, purpose string, since time.Time) (int64, error)
+
+	// DeleteExpired deletes refresh and action tokens that expired before
+	// the given time and returns how many it deleted.
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+	// DeleteUserTokens deletes every refresh and action token of the user.
+	DeleteUserTokens(ctx context.Context, userID primitive.ObjectID) error
+}
+
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, key string) (*models.LoginAttempts, error)
+	// RecordFailure counts a failure for key, starting the count again if the
+	// previous failure is older than window, and returns the result.
+	RecordFailure(ctx context.Context, key string, at time.Time, window, retention time.Duration) (*models.LoginAttempts, error)
+	// Lock locks key until the given time if it has at least threshold
+	// failures, resetting its failures and counting the lockout. It reports
+	// whether this call applied the lock.
+	Lock(ctx context.Context, key string, threshold int, until time.Time, retention time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// DeleteExpired deletes the records that expired before the given time
+	// and returns how many it deleted.
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// IssueUpdate lists the issue fields to change. Nil fields are left as they
+// are; UpdatedAt is always set.
+type IssueUpdate struct {
+	Title       *string
+	Description *string
+	Category    *string
+	Priority    *string
+	Status      *string
+	Tags        []string
+	Location    *models.Location
+	AssignedTo  *primitive.ObjectID
+	// A non-nil empty slice removes the issue from every jurisdiction
+	JurisdictionIDs []primitive.ObjectID
+}
+
+// IssueQuery filters, sorts and pages issues. Empty filters match
+// everything.
+type IssueQuery struct {
+	Text       string
+	Categories []string
+	Priorities []string
+	Statuses   []string
+	StartDate  *time.Time
+	EndDate    *time.Time
+	// Issues must have every tag
+	Tags []string
+	Near *GeoRadius
+	// HasLocation matches only issues with a location
+	HasLocation bool
+	// Participant matches only issues the user created, commented on or
+	// voted on
+	Participant primitive.ObjectID
+	// When JurisdictionScoped is set only issues in one of JurisdictionIDs
+	// match
+	JurisdictionScoped bool
+	JurisdictionIDs    []primitive.ObjectID
+	// SortBy is "date" (the default), "votes" or "priority"
+	SortBy     string
+	Descending bool
+	Skip       int64
+	Limit      int64
+}
+
+const earthRadiusKm = 6371
+
+// GeoRadius matches locations within RadiusKm kilometres of a point.
+type GeoRadius struct {
+	Lat      float64
+	Lng      float64
+	RadiusKm float64
+}
+
+// IssueSearchResult holds a page of matching issues, the total number of
+// matches and the number of matches per facet value.
+type IssueSearchResult struct {
+	Issues     []models.Issue
+	Total      int64
+	Categories map[string]int64
+	Priorities map[string]int64
+	Statuses   map[string]int64
+	Tags       map[string]int64
+}
+
+// UserRemoval counts the issues changed when a user was removed from them.
+type UserRemoval struct {
+	Issues    int64
+	Commented int64
+	Voted     int64
+	Assigned  int64
+}
+
+// ResponseStats summarises how one user responded to a set of issues.
+type ResponseStats struct {
+	Issues    int64
+	Resolved  int64
+	Responded int64
+	// ResponseTime is the total time from the creation of each issue the
+	// user responded to until their first comment on it
+	ResponseTime time.Duration
+}
+
+type IssueRepository interface {
+	Create(ctx context.Context, issue *models.Issue) error
+	Get(ctx context.Context, id primitive.ObjectID) (*models.Issue, error)
+	Update(ctx context.Context, id primitive.ObjectID, update IssueUpdate) error
+	// Vote records the user's vote, replacing any earlier vote on the issue.
+	Vote(ctx context.Context, id, userID primitive.ObjectID, up bool) error
+	AddComment(ctx context.Context, id primitive.ObjectID, comment *models.Comment) error
+	Search(ctx context.Context, query IssueQuery) (*IssueSearchResult, error)
+	// ResponseStats counts the issues matching the query, those with one of
+	// the resolved statuses and those the responder commented on, without
+	// loading them. The query's sorting and paging are ignored.
+	ResponseStats(ctx context.Context, query IssueQuery, resolvedStatuses []string, responderID primitive.ObjectID) (*ResponseStats, error)
+	// RemoveUser attributes the issues and comments the user wrote to
+	// models.DeletedUserID, removes their votes and unassigns them.
+	RemoveUser(ctx context.Context, userID primitive.ObjectID) (*UserRemoval, error)
+	// Reindex rebuilds the indexes used by Search.
+	Reindex(ctx context.Context) error
+}
@@ -0,0 +1,1055 @@
+// Package repositorytest is the conformance suite every storage backend must
+// pass. Backends run it from their own tests:
+//
+//	repositorytest.Run(t, func(t *testing.T) *repository.Store {
+//		return newEmptyStore(t)
+//	})
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Run runs the suite. newStore must return an empty store for each test.
+func Run(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, newStore) })
+	t.Run("RefreshTokens", func(t *testing.T) { testRefreshTokens(t, newStore) })
+	t.Run("ActionTokens", func(t *testing.T) { testActionTokens(t, newStore) })
+	t.Run("DeleteExpiredTokens", func(t *testing.T) { testDeleteExpiredTokens(t, newStore) })
+	t.Run("DeleteUserTokens", func(t *testing.T) { testDeleteUserTokens(t, newStore) })
+	t.Run("LoginAttempts", func(t *testing.T) { testLoginAttempts(t, newStore) })
+	t.Run("Issues", func(t *testing.T) { testIssues(t, newStore) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newStore) })
+}
+
+// now is rounded to milliseconds, the precision backends must keep.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func mustNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func mustBe(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("got error %v, want %v", err, want)
+	}
+}
+
+func mustReport(t *testing.T, got bool, err error, want bool) {
+	t.Helper()
+	mustNoError(t, err)
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func newUser(email, username string) *models.User {
+	return &models.User{
+		ID:        primitive.NewObjectID(),
+		Email:     email,
+		Username:  username,
+		Password:  "hash",
+		Role:      models.RoleCitizen,
+		CreatedAt: now(),
+		UpdatedAt: now(),
+	}
+}
+
+func testUsers(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("amina@example.com", "amina")
+		mustNoError(t, users.Create(ctx, user))
+
+		byID, err := users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		if byID.Email != user.Email || byID.Username != user.Username || !byID.CreatedAt.Equal(user.CreatedAt) {
+			t.Fatalf("GetByID returned %+v, want %+v", byID, user)
+		}
+
+		byEmail, err := users.GetByEmail(ctx, "amina@example.com")
+		mustNoError(t, err)
+		if byEmail.ID != user.ID {
+			t.Fatalf("GetByEmail returned user %s, want %s", byEmail.ID.Hex(), user.ID.Hex())
+		}
+
+		_, err = users.GetByID(ctx, primitive.NewObjectID())
+		mustBe(t, err, repository.ErrNotFound)
+		_, err = users.GetByEmail(ctx, "nobody@example.com")
+		mustBe(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		users := newStore(t).Users
+		mustNoError(t, users.Create(ctx, newUser("amina@example.com", "amina")))
+		mustBe(t, users.Create(ctx, newUser("amina@example.com", "other")), repository.ErrDuplicate)
+		mustBe(t, users.Create(ctx, newUser("other@example.com", "amina")), repository.ErrDuplicate)
+
+		exists, err := users.UsernameExists(ctx, "amina")
+		mustReport(t, exists, err, true)
+		exists, err = users.UsernameExists(ctx, "other")
+		mustReport(t, exists, err, false)
+	})
+
+	t.Run("ReturnedUsersAreCopies", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("amina@example.com", "amina")
+		mustNoError(t, users.Create(ctx, user))
+		user.Role = models.RoleAdmin
+
+		stored, err := users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		stored.Email = "changed@example.com"
+
+		again, err := users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		if again.Role != models.RoleCitizen || again.Email != "amina@example.com" {
+			t.Fatalf("stored user was changed through a returned value: %+v", again)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("amina@example.com", "amina")
+		mustNoError(t, users.Create(ctx, user))
+
+		password, role, verified, verifiedAt := "new-hash", models.RoleOfficial, true, now()
+		jurisdictionID := primitive.NewObjectID()
+		err := users.Update(ctx, user.ID, repository.UserUpdate{
+			Password:        &password,
+			Role:            &role,
+			IsVerified:      &verified,
+			VerifiedAt:      &verifiedAt,
+			JurisdictionIDs: []primitive.ObjectID{jurisdictionID},
+		})
+		mustNoError(t, err)
+
+		updated, err := users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		if updated.Password != password || updated.Role != role || !updated.IsVerified {
+			t.Fatalf("update was not applied: %+v", updated)
+		}
+		if updated.VerifiedAt == nil || !updated.VerifiedAt.Equal(verifiedAt) {
+			t.Fatalf("verifiedAt = %v, want %v", updated.VerifiedAt, verifiedAt)
+		}
+		if len(updated.JurisdictionIDs) != 1 || updated.JurisdictionIDs[0] != jurisdictionID {
+			t.Fatalf("jurisdictionIds = %v", updated.JurisdictionIDs)
+		}
+		if updated.UpdatedAt.Before(user.UpdatedAt) {
+			t.Fatalf("updatedAt was not set")
+		}
+		if updated.Email != user.Email || updated.Username != user.Username {
+			t.Fatalf("fields without an update changed: %+v", updated)
+		}
+
+		mustBe(t, users.Update(ctx, primitive.NewObjectID(), repository.UserUpdate{Role: &role}), repository.ErrNotFound)
+	})
+
+	t.Run("Identities", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("amina@example.com", "amina")
+		mustNoError(t, users.Create(ctx, user))
+
+		_, err := users.GetByIdentity(ctx, "google", "123")
+		mustBe(t, err, repository.ErrNotFound)
+
+		identity := models.ExternalIdentity{Provider: "google", Subject: "123", Email: user.Email, LinkedAt: now()}
+		mustNoError(t, users.Update(ctx, user.ID, repository.UserUpdate{AddIdentity: &identity}))
+
+		linked, err := users.GetByIdentity(ctx, "google", "123")
+		mustNoError(t, err)
+		if linked.ID != user.ID || len(linked.Identities) != 1 {
+			t.Fatalf("GetByIdentity returned %+v", linked)
+		}
+
+		_, err = users.GetByIdentity(ctx, "github", "123")
+		mustBe(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("MFA", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("amina@example.com", "amina")
+		mustNoError(t, users.Create(ctx, user))
+
+		mustNoError(t, users.Update(ctx, user.ID, repository.UserUpdate{MFA: &models.MFASettings{
+			Enabled:       true,
+			Secret:        "secret",
+			RecoveryCodes: []string{"a", "b"},
+			LastUsedStep:  10,
+		}}))
+
+		used, err := users.UseTOTPStep(ctx, user.ID, 10)
+		mustReport(t, used, err, false)
+		used, err = users.UseTOTPStep(ctx, user.ID, 11)
+		mustReport(t, used, err, true)
+		used, err = users.UseTOTPStep(ctx, user.ID, 11)
+		mustReport(t, used, err, false)
+
+		used, err = users.UseRecoveryCode(ctx, user.ID, "a")
+		mustReport(t, used, err, true)
+		used, err = users.UseRecoveryCode(ctx, user.ID, "a")
+		mustReport(t, used, err, false)
+
+		stored, err := users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		if !stored.MFAEnabled() || stored.MFA.LastUsedStep != 11 || len(stored.MFA.RecoveryCodes) != 1 {
+			t.Fatalf("mfa = %+v", stored.MFA)
+		}
+
+		mustNoError(t, users.Update(ctx, user.ID, repository.UserUpdate{RecoveryCodes: []string{"c", "d", "e"}}))
+		stored, err = users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		if len(stored.MFA.RecoveryCodes) != 3 || stored.MFA.Secret != "secret" {
+			t.Fatalf("replacing recovery codes gave %+v", stored.MFA)
+		}
+
+		mustNoError(t, users.Update(ctx, user.ID, repository.UserUpdate{RemoveMFA: true}))
+		stored, err = users.GetByID(ctx, user.ID)
+		mustNoError(t, err)
+		if stored.MFA != nil {
+			t.Fatalf("mfa was not removed: %+v", stored.MFA)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		users := newStore(t).Users
+		user := newUser("amina@example.com", "amina")
+		other := newUser("other@example.com", "other")
+		mustNoError(t, users.Create(ctx, user))
+		mustNoError(t, users.Create(ctx, other))
+		identity := models.ExternalIdentity{Provider: "google", Subject: "123", LinkedAt: now()}
+		mustNoError(t, users.Update(ctx, user.ID, repository.UserUpdate{AddIdentity: &identity}))
+
+		mustNoError(t, users.Delete(ctx, user.ID))
+		_, err := users.GetByID(ctx, user.ID)
+		mustBe(t, err, repository.ErrNotFound)
+		_, err = users.GetByIdentity(ctx, "google", "123")
+		mustBe(t, err, repository.ErrNotFound)
+		mustBe(t, users.Delete(ctx, user.ID), repository.ErrNotFound)
+
+		// The email and username are free again
+		mustNoError(t, users.Create(ctx, newUser("amina@example.com", "amina")))
+		_, err = users.GetByID(ctx, other.ID)
+		mustNoError(t, err)
+	})
+}
+
+func newRefreshToken(userID, familyID primitive.ObjectID, hash string, lastUsed time.Time) *models.RefreshToken {
+	return &models.RefreshToken{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		TokenHash:  hash,
+		FamilyID:   familyID,
+		ExpiresAt:  now().Add(time.Hour),
+		LastUsedAt: lastUsed,
+		CreatedAt:  now(),
+	}
+}
+
+func familyIDs(tokens []models.RefreshToken) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(tokens))
+	for i, token := range tokens {
+		ids[i] = token.FamilyID
+	}
+	return ids
+}
+
+func testRefreshTokens(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		token := newRefreshToken(primitive.NewObjectID(), primitive.NewObjectID(), "hash", now())
+		mustNoError(t, tokens.CreateRefreshToken(ctx, token))
+
+		stored, err := tokens.GetRefreshToken(ctx, "hash")
+		mustNoError(t, err)
+		if stored.ID != token.ID || stored.FamilyID != token.FamilyID || !stored.ExpiresAt.Equal(token.ExpiresAt) {
+			t.Fatalf("GetRefreshToken returned %+v, want %+v", stored, token)
+		}
+
+		_, err = tokens.GetRefreshToken(ctx, "other")
+		mustBe(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("RotateOnce", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		token := newRefreshToken(primitive.NewObjectID(), primitive.NewObjectID(), "hash", now())
+		mustNoError(t, tokens.CreateRefreshToken(ctx, token))
+
+		rotated, err := tokens.RotateRefreshToken(ctx, token.ID, now())
+		mustReport(t, rotated, err, true)
+		rotated, err = tokens.RotateRefreshToken(ctx, token.ID, now())
+		mustReport(t, rotated, err, false)
+
+		stored, err := tokens.GetRefreshToken(ctx, "hash")
+		mustNoError(t, err)
+		if stored.RotatedAt == nil {
+			t.Fatalf("rotatedAt was not set")
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		user, other := primitive.NewObjectID(), primitive.NewObjectID()
+		familyA, familyB, familyC := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		mustNoError(t, tokens.CreateRefreshToken(ctx, newRefreshToken(user, familyA, "a", now())))
+		mustNoError(t, tokens.CreateRefreshToken(ctx, newRefreshToken(user, familyB, "b", now())))
+		mustNoError(t, tokens.CreateRefreshToken(ctx, newRefreshToken(other, familyC, "c", now())))
+
+		// A revoked token cannot be rotated
+		mustNoError(t, tokens.RevokeRefreshTokens(ctx, repository.RefreshTokenFilter{FamilyID: familyA}, now()))
+		stored, err := tokens.GetRefreshToken(ctx, "a")
+		mustNoError(t, err)
+		rotated, err := tokens.RotateRefreshToken(ctx, stored.ID, now())
+		mustReport(t, rotated, err, false)
+
+		active, err := tokens.ActiveRefreshTokens(ctx, user)
+		mustNoError(t, err)
+		if ids := familyIDs(active); len(ids) != 1 || ids[0] != familyB {
+			t.Fatalf("active families = %v, want [%s]", ids, familyB.Hex())
+		}
+
+		// Revoking everything except family B leaves it active
+		mustNoError(t, tokens.RevokeRefreshTokens(ctx, repository.RefreshTokenFilter{UserID: user, ExceptFamilyID: familyB}, now()))
+		active, err = tokens.ActiveRefreshTokens(ctx, user)
+		mustNoError(t, err)
+		if len(active) != 1 {
+			t.Fatalf("got %d active tokens, want 1", len(active))
+		}
+
+		mustNoError(t, tokens.RevokeRefreshTokens(ctx, repository.RefreshTokenFilter{UserID: user}, now()))
+		active, err = tokens.ActiveRefreshTokens(ctx, user)
+		mustNoError(t, err)
+		if len(active) != 0 {
+			t.Fatalf("got %d active tokens after revoking all, want 0", len(active))
+		}
+
+		// Other users are unaffected
+		active, err = tokens.ActiveRefreshTokens(ctx, other)
+		mustNoError(t, err)
+		if len(active) != 1 {
+			t.Fatalf("another user's tokens were revoked")
+		}
+	})
+
+	t.Run("Active", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		user := primitive.NewObjectID()
+		older := newRefreshToken(user, primitive.NewObjectID(), "older", now().Add(-time.Hour))
+		newer := newRefreshToken(user, primitive.NewObjectID(), "newer", now())
+		rotated := newRefreshToken(user, primitive.NewObjectID(), "rotated", now())
+		expired := newRefreshToken(user, primitive.NewObjectID(), "expired", now())
+		expired.ExpiresAt = now().Add(-time.Minute)
+		for _, token := range []*models.RefreshToken{older, newer, rotated, expired} {
+			mustNoError(t, tokens.CreateRefreshToken(ctx, token))
+		}
+		_, err := tokens.RotateRefreshToken(ctx, rotated.ID, now())
+		mustNoError(t, err)
+
+		active, err := tokens.ActiveRefreshTokens(ctx, user)
+		mustNoError(t, err)
+		ids := familyIDs(active)
+		if len(ids) != 2 || ids[0] != newer.FamilyID || ids[1] != older.FamilyID {
+			t.Fatalf("active families = %v, want newest first [%s %s]", ids, newer.FamilyID.Hex(), older.FamilyID.Hex())
+		}
+	})
+
+	t.Run("AllOfUser", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		user := primitive.NewObjectID()
+		first := newRefreshToken(user, primitive.NewObjectID(), "first", now())
+		first.CreatedAt = now().Add(-2 * time.Hour)
+		revoked := newRefreshToken(user, primitive.NewObjectID(), "revoked", now())
+		revoked.CreatedAt = now().Add(-time.Hour)
+		expired := newRefreshToken(user, primitive.NewObjectID(), "expired", now())
+		expired.ExpiresAt = now().Add(-time.Minute)
+		other := newRefreshToken(primitive.NewObjectID(), primitive.NewObjectID(), "other", now())
+		for _, token := range []*models.RefreshToken{first, revoked, expired, other} {
+			mustNoError(t, tokens.CreateRefreshToken(ctx, token))
+		}
+		mustNoError(t, tokens.RevokeRefreshTokens(ctx, repository.RefreshTokenFilter{FamilyID: revoked.FamilyID}, now()))
+
+		all, err := tokens.RefreshTokens(ctx, user)
+		mustNoError(t, err)
+		ids := familyIDs(all)
+		if len(ids) != 3 || ids[0] != expired.FamilyID || ids[1] != revoked.FamilyID || ids[2] != first.FamilyID {
+			t.Fatalf("families = %v, want newest first [%s %s %s]", ids, expired.FamilyID.Hex(), revoked.FamilyID.Hex(), first.FamilyID.Hex())
+		}
+		if all[1].RevokedAt == nil {
+			t.Fatalf("revoked token returned without revokedAt")
+		}
+	})
+}
+
+func testDeleteExpiredTokens(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+	tokens := newStore(t).Tokens
+	user := primitive.NewObjectID()
+
+	expiredRefresh := newRefreshToken(user, primitive.NewObjectID(), "expired", now())
+	expiredRefresh.ExpiresAt = now().Add(-time.Minute)
+	expiredAction := newActionToken(user, "password_reset", "expired", now().Add(-2*time.Hour))
+	mustNoError(t, tokens.CreateRefreshToken(ctx, expiredRefresh))
+	mustNoError(t, tokens.CreateRefreshToken(ctx, newRefreshToken(user, primitive.NewObjectID(), "current", now())))
+	mustNoError(t, tokens.CreateActionToken(ctx, expiredAction))
+	mustNoError(t, tokens.CreateActionToken(ctx, newActionToken(user, "password_reset", "current", now())))
+
+	deleted, err := tokens.DeleteExpired(ctx, now())
+	mustNoError(t, err)
+	if deleted != 2 {
+		t.Fatalf("deleted %d tokens, want 2", deleted)
+	}
+
+	_, err = tokens.GetRefreshToken(ctx, "expired")
+	mustBe(t, err, repository.ErrNotFound)
+	_, err = tokens.GetActionToken(ctx, "expired", "password_reset")
+	mustBe(t, err, repository.ErrNotFound)
+	_, err = tokens.GetRefreshToken(ctx, "current")
+	mustNoError(t, err)
+	_, err = tokens.GetActionToken(ctx, "current", "password_reset")
+	mustNoError(t, err)
+}
+
+func testDeleteUserTokens(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+	tokens := newStore(t).Tokens
+	user, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+	mustNoError(t, tokens.CreateRefreshToken(ctx, newRefreshToken(user, primitive.NewObjectID(), "refresh", now())))
+	mustNoError(t, tokens.CreateActionToken(ctx, newActionToken(user, "password_reset", "action", now())))
+	mustNoError(t, tokens.CreateRefreshToken(ctx, newRefreshToken(other, primitive.NewObjectID(), "other refresh", now())))
+	mustNoError(t, tokens.CreateActionToken(ctx, newActionToken(other, "password_reset", "other action", now())))
+
+	mustNoError(t, tokens.DeleteUserTokens(ctx, user))
+
+	_, err := tokens.GetRefreshToken(ctx, "refresh")
+	mustBe(t, err, repository.ErrNotFound)
+	_, err = tokens.GetActionToken(ctx, "action", "password_reset")
+	mustBe(t, err, repository.ErrNotFound)
+	_, err = tokens.GetRefreshToken(ctx, "other refresh")
+	mustNoError(t, err)
+	_, err = tokens.GetActionToken(ctx, "other action", "password_reset")
+	mustNoError(t, err)
+}
+
+func newActionToken(userID primitive.ObjectID, purpose, hash string, createdAt time.Time) *models.ActionToken {
+	return &models.ActionToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: createdAt.Add(time.Hour),
+		CreatedAt: createdAt,
+	}
+}
+
+func testActionTokens(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+
+	t.Run("GetByPurpose", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		token := newActionToken(primitive.NewObjectID(), "email_verification", "hash", now())
+		mustNoError(t, tokens.CreateActionToken(ctx, token))
+
+		stored, err := tokens.GetActionToken(ctx, "hash", "email_verification")
+		mustNoError(t, err)
+		if stored.ID != token.ID || stored.UsedAt != nil {
+			t.Fatalf("GetActionToken returned %+v", stored)
+		}
+
+		_, err = tokens.GetActionToken(ctx, "hash", "password_reset")
+		mustBe(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("UseOnce", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		token := newActionToken(primitive.NewObjectID(), "password_reset", "hash", now())
+		mustNoError(t, tokens.CreateActionToken(ctx, token))
+
+		used, err := tokens.UseActionToken(ctx, token.ID, now())
+		mustReport(t, used, err, true)
+		used, err = tokens.UseActionToken(ctx, token.ID, now())
+		mustReport(t, used, err, false)
+	})
+
+	t.Run("UseAllForPurpose", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		user := primitive.NewObjectID()
+		first := newActionToken(user, "password_reset", "first", now())
+		second := newActionToken(user, "password_reset", "second", now())
+		other := newActionToken(user, "email_verification", "other", now())
+		for _, token := range []*models.ActionToken{first, second, other} {
+			mustNoError(t, tokens.CreateActionToken(ctx, token))
+		}
+
+		mustNoError(t, tokens.UseActionTokens(ctx, user, "password_reset", now()))
+
+		used, err := tokens.UseActionToken(ctx, second.ID, now())
+		mustReport(t, used, err, false)
+		used, err = tokens.UseActionToken(ctx, other.ID, now())
+		mustReport(t, used, err, true)
+	})
+
+	t.Run("Attempts", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		token := newActionToken(primitive.NewObjectID(), "mfa_challenge", "hash", now())
+		mustNoError(t, tokens.CreateActionToken(ctx, token))
+
+		mustNoError(t, tokens.RecordActionTokenAttempt(ctx, token.ID, false, now()))
+		stored, err := tokens.GetActionToken(ctx, "hash", "mfa_challenge")
+		mustNoError(t, err)
+		if stored.Attempts != 1 || stored.UsedAt != nil {
+			t.Fatalf("after one attempt got %+v", stored)
+		}
+
+		mustNoError(t, tokens.RecordActionTokenAttempt(ctx, token.ID, true, now()))
+		stored, err = tokens.GetActionToken(ctx, "hash", "mfa_challenge")
+		mustNoError(t, err)
+		if stored.Attempts != 2 || stored.UsedAt == nil {
+			t.Fatalf("burning the token gave %+v", stored)
+		}
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		tokens := newStore(t).Tokens
+		user := primitive.NewObjectID()
+		mustNoError(t, tokens.CreateActionToken(ctx, newActionToken(user, "email_verification", "old", now().Add(-2*time.Hour))))
+		mustNoError(t, tokens.CreateActionToken(ctx, newActionToken(user, "email_verification", "new", now())))
+		mustNoError(t, tokens.CreateActionToken(ctx, newActionToken(user, "password_reset", "reset", now())))
+
+		count, err := tokens.CountActionTokens(ctx, user, "email_verification", now().Add(-time.Hour))
+		mustNoError(t, err)
+		if count != 1 {
+			t.Fatalf("got %d recent tokens, want 1", count)
+		}
+		count, err = tokens.CountActionTokens(ctx, user, "email_verification", now().Add(-24*time.Hour))
+		mustNoError(t, err)
+		if count != 2 {
+			t.Fatalf("got %d tokens today, want 2", count)
+		}
+	})
+}
+
+func testLoginAttempts(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+	const window, retention = 15 * time.Minute, 24 * time.Hour
+
+	t.Run("CountFailures", func(t *testing.T) {
+		attempts := newStore(t).LoginAttempts
+		_, err := attempts.Get(ctx, "account:amina@example.com")
+		mustBe(t, err, repository.ErrNotFound)
+
+		start := now().Add(-time.Hour)
+		for i := 1; i <= 3; i++ {
+			result, err := attempts.RecordFailure(ctx, "account:amina@example.com", start, window, retention)
+			mustNoError(t, err)
+			if result.Failures != i {
+				t.Fatalf("failure %d counted as %d", i, result.Failures)
+			}
+		}
+
+		// A failure after the window starts counting again
+		result, err := attempts.RecordFailure(ctx, "account:amina@example.com", now(), window, retention)
+		mustNoError(t, err)
+		if result.Failures != 1 || !result.LastFailureAt.After(start) {
+			t.Fatalf("failure after the window gave %+v", result)
+		}
+
+		stored, err := attempts.Get(ctx, "account:amina@example.com")
+		mustNoError(t, err)
+		if stored.Failures != 1 {
+			t.Fatalf("stored failures = %d, want 1", stored.Failures)
+		}
+
+		mustNoError(t, attempts.Delete(ctx, "account:amina@example.com"))
+		_, err = attempts.Get(ctx, "account:amina@example.com")
+		mustBe(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		attempts := newStore(t).LoginAttempts
+		until := now().Add(15 * time.Minute)
+
+		locked, err := attempts.Lock(ctx, "ip:192.0.2.1", 2, until, retention)
+		mustReport(t, locked, err, false)
+
+		_, err = attempts.RecordFailure(ctx, "ip:192.0.2.1", now(), window, retention)
+		mustNoError(t, err)
+		locked, err = attempts.Lock(ctx, "ip:192.0.2.1", 2, until, retention)
+		mustReport(t, locked, err, false)
+
+		_, err = attempts.RecordFailure(ctx, "ip:192.0.2.1", now(), window, retention)
+		mustNoError(t, err)
+		locked, err = attempts.Lock(ctx, "ip:192.0.2.1", 2, until, retention)
+		mustReport(t, locked, err, true)
+
+		// Only one caller applies the lock
+		locked, err = attempts.Lock(ctx, "ip:192.0.2.1", 2, until, retention)
+		mustReport(t, locked, err, false)
+
+		stored, err := attempts.Get(ctx, "ip:192.0.2.1")
+		mustNoError(t, err)
+		if stored.Failures != 0 || stored.Lockouts != 1 || stored.LockedUntil == nil || !stored.LockedUntil.Equal(until) {
+			t.Fatalf("after locking got %+v", stored)
+		}
+	})
+
+	t.Run("DeleteExpired", func(t *testing.T) {
+		attempts := newStore(t).LoginAttempts
+		_, err := attempts.RecordFailure(ctx, "ip:192.0.2.1", now().Add(-2*time.Hour), window, time.Hour)
+		mustNoError(t, err)
+		_, err = attempts.RecordFailure(ctx, "ip:192.0.2.2", now(), window, time.Hour)
+		mustNoError(t, err)
+
+		deleted, err := attempts.DeleteExpired(ctx, now())
+		mustNoError(t, err)
+		if deleted != 1 {
+			t.Fatalf("deleted %d records, want 1", deleted)
+		}
+		_, err = attempts.Get(ctx, "ip:192.0.2.1")
+		mustBe(t, err, repository.ErrNotFound)
+		_, err = attempts.Get(ctx, "ip:192.0.2.2")
+		mustNoError(t, err)
+	})
+}
+
+func newIssue(title string, createdAt time.Time) *models.Issue {
+	return &models.Issue{
+		ID:        primitive.NewObjectID(),
+		Title:     title,
+		Category:  "infrastructure",
+		Priority:  "medium",
+		Status:    "open",
+		CreatedBy: primitive.NewObjectID(),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+}
+
+func testIssues(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		issues := newStore(t).Issues
+		issue := newIssue("Broken streetlight", now())
+		issue.Tags = []string{"lighting"}
+		issue.Location = &models.Location{Lat: -1.2921, Lng: 36.8219, Address: "Moi Avenue"}
+		mustNoError(t, issues.Create(ctx, issue))
+
+		stored, err := issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if stored.Title != issue.Title || len(stored.Tags) != 1 || stored.Location == nil || stored.Location.Address != "Moi Avenue" {
+			t.Fatalf("Get returned %+v, want %+v", stored, issue)
+		}
+
+		_, err = issues.Get(ctx, primitive.NewObjectID())
+		mustBe(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		issues := newStore(t).Issues
+		issue := newIssue("Broken streetlight", now())
+		mustNoError(t, issues.Create(ctx, issue))
+
+		status, assignee := "in_progress", primitive.NewObjectID()
+		err := issues.Update(ctx, issue.ID, repository.IssueUpdate{
+			Status:     &status,
+			Tags:       []string{"lighting", "safety"},
+			Location:   &models.Location{Lat: 1, Lng: 2},
+			AssignedTo: &assignee,
+		})
+		mustNoError(t, err)
+
+		stored, err := issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if stored.Status != status || stored.AssignedTo != assignee || len(stored.Tags) != 2 || stored.Location == nil {
+			t.Fatalf("update was not applied: %+v", stored)
+		}
+		if stored.Title != issue.Title || stored.Category != issue.Category {
+			t.Fatalf("fields without an update changed: %+v", stored)
+		}
+
+		mustBe(t, issues.Update(ctx, primitive.NewObjectID(), repository.IssueUpdate{Status: &status}), repository.ErrNotFound)
+	})
+
+	t.Run("Jurisdictions", func(t *testing.T) {
+		issues := newStore(t).Issues
+		issue := newIssue("Broken streetlight", now())
+		issue.JurisdictionIDs = []primitive.ObjectID{primitive.NewObjectID()}
+		mustNoError(t, issues.Create(ctx, issue))
+
+		moved := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+		mustNoError(t, issues.Update(ctx, issue.ID, repository.IssueUpdate{JurisdictionIDs: moved}))
+		stored, err := issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if len(stored.JurisdictionIDs) != 2 || stored.JurisdictionIDs[0] != moved[0] || stored.JurisdictionIDs[1] != moved[1] {
+			t.Fatalf("jurisdictions = %v, want %v", stored.JurisdictionIDs, moved)
+		}
+
+		title := "Streetlight"
+		mustNoError(t, issues.Update(ctx, issue.ID, repository.IssueUpdate{Title: &title}))
+		stored, err = issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if len(stored.JurisdictionIDs) != 2 {
+			t.Fatalf("jurisdictions changed without an update: %v", stored.JurisdictionIDs)
+		}
+
+		mustNoError(t, issues.Update(ctx, issue.ID, repository.IssueUpdate{JurisdictionIDs: []primitive.ObjectID{}}))
+		stored, err = issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if len(stored.JurisdictionIDs) != 0 {
+			t.Fatalf("jurisdictions = %v, want none", stored.JurisdictionIDs)
+		}
+	})
+
+	t.Run("HasLocation", func(t *testing.T) {
+		issues := newStore(t).Issues
+		located := newIssue("Broken streetlight", now())
+		located.Location = &models.Location{Lat: -1.2921, Lng: 36.8219}
+		mustNoError(t, issues.Create(ctx, located))
+		mustNoError(t, issues.Create(ctx, newIssue("Rude officer", now())))
+
+		result, err := issues.Search(ctx, repository.IssueQuery{HasLocation: true})
+		mustNoError(t, err)
+		if result.Total != 1 || !sameTitles(result.Issues, located.Title) {
+			t.Fatalf("got %d issues %v", result.Total, titles(result.Issues))
+		}
+	})
+
+	t.Run("Votes", func(t *testing.T) {
+		issues := newStore(t).Issues
+		issue := newIssue("Broken streetlight", now())
+		mustNoError(t, issues.Create(ctx, issue))
+		alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+		mustNoError(t, issues.Vote(ctx, issue.ID, alice, true))
+		mustNoError(t, issues.Vote(ctx, issue.ID, alice, true))
+		mustNoError(t, issues.Vote(ctx, issue.ID, bob, true))
+		mustNoError(t, issues.Vote(ctx, issue.ID, bob, false))
+
+		stored, err := issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if len(stored.Votes.Up) != 1 || stored.Votes.Up[0] != alice || len(stored.Votes.Down) != 1 || stored.Votes.Down[0] != bob {
+			t.Fatalf("votes = %+v", stored.Votes)
+		}
+
+		mustBe(t, issues.Vote(ctx, primitive.NewObjectID(), alice, true), repository.ErrNotFound)
+	})
+
+	t.Run("Comments", func(t *testing.T) {
+		issues := newStore(t).Issues
+		issue := newIssue("Broken streetlight", now())
+		mustNoError(t, issues.Create(ctx, issue))
+
+		for _, content := range []string{"first", "second"} {
+			comment := &models.Comment{Content: content, CreatedBy: primitive.NewObjectID(), CreatedAt: now(), UpdatedAt: now()}
+			mustNoError(t, issues.AddComment(ctx, issue.ID, comment))
+			if comment.ID.IsZero() {
+				t.Fatalf("comment was not given an ID")
+			}
+		}
+
+		stored, err := issues.Get(ctx, issue.ID)
+		mustNoError(t, err)
+		if len(stored.Comments) != 2 || stored.Comments[0].Content != "first" || stored.Comments[1].Content != "second" {
+			t.Fatalf("comments = %+v", stored.Comments)
+		}
+
+		mustBe(t, issues.AddComment(ctx, primitive.NewObjectID(), &models.Comment{Content: "lost"}), repository.ErrNotFound)
+	})
+
+	t.Run("Participant", func(t *testing.T) {
+		issues := newStore(t).Issues
+		user := primitive.NewObjectID()
+
+		created := newIssue("Created", now())
+		created.CreatedBy = user
+		commented, voted, untouched := newIssue("Commented", now()), newIssue("Voted", now()), newIssue("Untouched", now())
+		for _, issue := range []*models.Issue{created, commented, voted, untouched} {
+			mustNoError(t, issues.Create(ctx, issue))
+		}
+		mustNoError(t, issues.AddComment(ctx, commented.ID, &models.Comment{Content: "me too", CreatedBy: user, CreatedAt: now(), UpdatedAt: now()}))
+		mustNoError(t, issues.AddComment(ctx, untouched.ID, &models.Comment{Content: "not me", CreatedBy: primitive.NewObjectID(), CreatedAt: now(), UpdatedAt: now()}))
+		mustNoError(t, issues.Vote(ctx, voted.ID, user, false))
+		mustNoError(t, issues.Vote(ctx, untouched.ID, primitive.NewObjectID(), true))
+
+		result, err := issues.Search(ctx, repository.IssueQuery{Participant: user})
+		mustNoError(t, err)
+		if result.Total != 3 || !sameTitles(result.Issues, "Created", "Commented", "Voted") {
+			t.Fatalf("got %d issues %v", result.Total, titles(result.Issues))
+		}
+	})
+
+	t.Run("ResponseStats", func(t *testing.T) {
+		issues := newStore(t).Issues
+		ward, responder := primitive.NewObjectID(), primitive.NewObjectID()
+		base := now().Add(-24 * time.Hour)
+
+		answered, resolved, ignored, elsewhere := newIssue("Answered", base), newIssue("Resolved", base), newIssue("Ignored", base), newIssue("Elsewhere", base)
+		resolved.Status = "closed"
+		for _, issue := range []*models.Issue{answered, resolved, ignored} {
+			issue.JurisdictionIDs = []primitive.ObjectID{ward}
+		}
+		for _, issue := range []*models.Issue{answered, resolved, ignored, elsewhere} {
+			mustNoError(t, issues.Create(ctx, issue))
+		}
+
+		comment := func(issue *models.Issue, by primitive.ObjectID, after time.Duration) {
+			at := base.Add(after)
+			mustNoError(t, issues.AddComment(ctx, issue.ID, &models.Comment{Content: "on it", CreatedBy: by, CreatedAt: at, UpdatedAt: at}))
+		}
+		comment(answered, primitive.NewObjectID(), time.Minute)
+		comment(answered, responder, 3*time.Hour)
+		comment(answered, responder, 2*time.Hour)
+		comment(resolved, responder, 4*time.Hour)
+		comment(ignored, primitive.NewObjectID(), time.Hour)
+		comment(elsewhere, responder, time.Hour)
+
+		query := repository.IssueQuery{JurisdictionScoped: true, JurisdictionIDs: []primitive.ObjectID{ward}}
+		stats, err := issues.ResponseStats(ctx, query, []string{"resolved", "closed"}, responder)
+		mustNoError(t, err)
+		want := repository.ResponseStats{Issues: 3, Resolved: 1, Responded: 2, ResponseTime: 6 * time.Hour}
+		if *stats != want {
+			t.Fatalf("stats = %+v, want %+v", *stats, want)
+		}
+
+		query.JurisdictionIDs = []primitive.ObjectID{primitive.NewObjectID()}
+		stats, err = issues.ResponseStats(ctx, query, []string{"resolved", "closed"}, responder)
+		mustNoError(t, err)
+		if *stats != (repository.ResponseStats{}) {
+			t.Fatalf("stats without matches = %+v", *stats)
+		}
+	})
+
+	t.Run("RemoveUser", func(t *testing.T) {
+		issues := newStore(t).Issues
+		user, other := primitive.NewObjectID(), primitive.NewObjectID()
+
+		created := newIssue("Created", now())
+		created.CreatedBy = user
+		created.AssignedTo = other
+		involved := newIssue("Involved", now())
+		involved.AssignedTo = user
+		for _, issue := range []*models.Issue{created, involved} {
+			mustNoError(t, issues.Create(ctx, issue))
+		}
+		for _, author := range []primitive.ObjectID{user, other, user} {
+			comment := &models.Comment{Content: "comment", CreatedBy: author, CreatedAt: now(), UpdatedAt: now()}
+			mustNoError(t, issues.AddComment(ctx, involved.ID, comment))
+		}
+		mustNoError(t, issues.Vote(ctx, created.ID, user, true))
+		mustNoError(t, issues.Vote(ctx, involved.ID, user, false))
+		mustNoError(t, issues.Vote(ctx, involved.ID, other, false))
+
+		removal, err := issues.RemoveUser(ctx, user)
+		mustNoError(t, err)
+		want := repository.UserRemoval{Issues: 1, Commented: 1, Voted: 2, Assigned: 1}
+		if *removal != want {
+			t.Fatalf("RemoveUser = %+v, want %+v", *removal, want)
+		}
+
+		stored, err := issues.Get(ctx, created.ID)
+		mustNoError(t, err)
+		if stored.CreatedBy != models.DeletedUserID || stored.AssignedTo != other || len(stored.Votes.Up) != 0 {
+			t.Fatalf("created issue after removal = %+v", stored)
+		}
+
+		stored, err = issues.Get(ctx, involved.ID)
+		mustNoError(t, err)
+		if len(stored.Comments) != 3 || stored.Comments[0].CreatedBy != models.DeletedUserID ||
+			stored.Comments[1].CreatedBy != other || stored.Comments[2].CreatedBy != models.DeletedUserID {
+			t.Fatalf("comments after removal = %+v", stored.Comments)
+		}
+		if !stored.AssignedTo.IsZero() || len(stored.Votes.Down) != 1 || stored.Votes.Down[0] != other {
+			t.Fatalf("involved issue after removal = %+v", stored)
+		}
+
+		result, err := issues.Search(ctx, repository.IssueQuery{Participant: user})
+		mustNoError(t, err)
+		if result.Total != 0 {
+			t.Fatalf("removed user still participates in %v", titles(result.Issues))
+		}
+	})
+}
+
+func titles(issues []models.Issue) []string {
+	titles := make([]string, len(issues))
+	for i, issue := range issues {
+		titles[i] = issue.Title
+	}
+	return titles
+}
+
+func sameTitles(got []models.Issue, want ...string) bool {
+	gotTitles := titles(got)
+	sort.Strings(gotTitles)
+	sort.Strings(want)
+	if len(gotTitles) != len(want) {
+		return false
+	}
+	for i := range want {
+		if gotTitles[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testSearch(t *testing.T, newStore func(t *testing.T) *repository.Store) {
+	ctx := context.Background()
+	issues := newStore(t).Issues
+
+	base := now().Add(-24 * time.Hour)
+	westlands, kibera := primitive.NewObjectID(), primitive.NewObjectID()
+
+	streetlight := newIssue("Broken streetlight", base)
+	streetlight.Description = "The streetlight outside the market has been out for a week"
+	streetlight.Tags = []string{"lighting", "safety"}
+	streetlight.Priority = "high"
+	streetlight.Location = &models.Location{Lat: -1.2921, Lng: 36.8219}
+	streetlight.JurisdictionIDs = []primitive.ObjectID{westlands}
+
+	pothole := newIssue("Pothole on Ngong Road", base.Add(time.Hour))
+	pothole.Description = "A deep pothole is damaging cars"
+	pothole.Tags = []string{"roads"}
+	pothole.Location = &models.Location{Lat: -1.2995, Lng: 36.7820}
+	pothole.JurisdictionIDs = []primitive.ObjectID{kibera}
+
+	water := newIssue("No water supply", base.Add(2*time.Hour))
+	water.Description = "Taps have been dry since Monday"
+	water.Category = "utilities"
+	water.Status = "resolved"
+	water.Tags = []string{"water", "safety"}
+	water.Location = &models.Location{Lat: -4.0435, Lng: 39.6682}
+
+	for _, issue := range []*models.Issue{streetlight, pothole, water} {
+		mustNoError(t, issues.Create(ctx, issue))
+	}
+	mustNoError(t, issues.Vote(ctx, pothole.ID, primitive.NewObjectID(), true))
+	mustNoError(t, issues.Vote(ctx, pothole.ID, primitive.NewObjectID(), true))
+	mustNoError(t, issues.Vote(ctx, water.ID, primitive.NewObjectID(), false))
+
+	search := func(t *testing.T, query repository.IssueQuery) *repository.IssueSearchResult {
+		t.Helper()
+		result, err := issues.Search(ctx, query)
+		mustNoError(t, err)
+		return result
+	}
+
+	t.Run("All", func(t *testing.T) {
+		result := search(t, repository.IssueQuery{})
+		if result.Total != 3 || !sameTitles(result.Issues, streetlight.Title, pothole.Title, water.Title) {
+			t.Fatalf("got %d issues %v", result.Total, titles(result.Issues))
+		}
+	})
+
+	t.Run("Text", func(t *testing.T) {
+		result := search(t, repository.IssueQuery{Text: "pothole"})
+		if !sameTitles(result.Issues, pothole.Title) {
+			t.Fatalf("got %v", titles(result.Issues))
+		}
+	})
+
+	t.Run("Filters", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			query repository.IssueQuery
+			want  []string
+		}{
+			{"Category", repository.IssueQuery{Categories: []string{"utilities"}}, []string{water.Title}},
+			{"Priority", repository.IssueQuery{Priorities: []string{"high"}}, []string{streetlight.Title}},
+			{"Status", repository.IssueQuery{Statuses: []string{"open"}}, []string{streetlight.Title, pothole.Title}},
+			{"EveryTag", repository.IssueQuery{Tags: []string{"safety", "lighting"}}, []string{streetlight.Title}},
+			{"StartDate", repository.IssueQuery{StartDate: timePtr(base.Add(30 * time.Minute))}, []string{pothole.Title, water.Title}},
+			{"EndDate", repository.IssueQuery{EndDate: timePtr(base.Add(time.Hour))}, []string{streetlight.Title, pothole.Title}},
+			{"Near", repository.IssueQuery{Near: &repository.GeoRadius{Lat: -1.2921, Lng: 36.8219, RadiusKm: 10}}, []string{streetlight.Title, pothole.Title}},
+			{"Jurisdiction", repository.IssueQuery{JurisdictionScoped: true, JurisdictionIDs: []primitive.ObjectID{kibera}}, []string{pothole.Title}},
+			{"NoJurisdictions", repository.IssueQuery{JurisdictionScoped: true}, nil},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				result := search(t, tc.query)
+				if !sameTitles(result.Issues, tc.want...) || result.Total != int64(len(tc.want)) {
+					t.Fatalf("got %d issues %v, want %v", result.Total, titles(result.Issues), tc.want)
+				}
+			})
+		}
+	})
+
+	t.Run("Facets", func(t *testing.T) {
+		result := search(t, repository.IssueQuery{Tags: []string{"safety"}})
+		if result.Categories["infrastructure"] != 1 || result.Categories["utilities"] != 1 {
+			t.Fatalf("categories = %v", result.Categories)
+		}
+		if result.Statuses["open"] != 1 || result.Statuses["resolved"] != 1 {
+			t.Fatalf("statuses = %v", result.Statuses)
+		}
+		if result.Tags["safety"] != 2 || result.Tags["lighting"] != 1 || result.Tags["water"] != 1 || result.Tags["roads"] != 0 {
+			t.Fatalf("tags = %v", result.Tags)
+		}
+	})
+
+	t.Run("Sort", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			query repository.IssueQuery
+			want  []string
+		}{
+			{"DateAscending", repository.IssueQuery{}, []string{streetlight.Title, pothole.Title, water.Title}},
+			{"DateDescending", repository.IssueQuery{Descending: true}, []string{water.Title, pothole.Title, streetlight.Title}},
+			{"Votes", repository.IssueQuery{SortBy: "votes", Descending: true}, []string{pothole.Title, streetlight.Title, water.Title}},
+			{"Priority", repository.IssueQuery{SortBy: "priority"}, []string{streetlight.Title, water.Title, pothole.Title}},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				got := titles(search(t, tc.query).Issues)
+				for i := range tc.want {
+					if i >= len(got) || got[i] != tc.want[i] {
+						t.Fatalf("got %v, want %v", got, tc.want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("Reindex", func(t *testing.T) {
+		mustNoError(t, issues.Reindex(ctx))
+		result := search(t, repository.IssueQuery{Text: "water", Near: &repository.GeoRadius{Lat: -4.0435, Lng: 39.6682, RadiusKm: 1}})
+		if !sameTitles(result.Issues, water.Title) {
+			t.Fatalf("after reindexing got %v", titles(result.Issues))
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		result := search(t, repository.IssueQuery{Skip: 1, Limit: 1})
+		if result.Total != 3 || len(result.Issues) != 1 || result.Issues[0].Title != pothole.Title {
+			t.Fatalf("second page = %d issues %v", result.Total, titles(result.Issues))
+		}
+
+		result = search(t, repository.IssueQuery{Skip: 5, Limit: 2})
+		if result.Total != 3 || len(result.Issues) != 0 {
+			t.Fatalf("page past the end = %d issues %v", result.Total, titles(result.Issues))
+		}
+	})
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,78 @@
+// Package server runs the API's HTTP server with timeouts, size limits,
+// optional TLS and graceful shutdown.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+)
+
+type Server struct {
+	http            *http.Server
+	tls             bool
+	shutdownTimeout time.Duration
+}
+
+func New(cfg config.Server, handler http.Handler) (*Server, error) {
+	s := &Server{
+		http: &http.Server{
+			Addr:              ":" + cfg.Port,
+			Handler:           handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		},
+		shutdownTimeout: cfg.ShutdownTimeout,
+	}
+
+	if cfg.TLSCertFile != "" {
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.http.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+		s.tls = true
+	}
+	return s, nil
+}
+
+// Run serves requests until ctx is cancelled, then stops accepting
+// connections and waits up to the shutdown timeout for in-flight requests.
+func (s *Server) Run(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		if s.tls {
+			// The certificate comes from TLSConfig.GetCertificate
+			errc <- s.http.ListenAndServeTLS("", "")
+		} else {
+			errc <- s.http.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+	if err := s.http.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to drain requests: %v", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
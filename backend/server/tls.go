@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes, such as a renewal by certbot or cert-manager.
+const certCheckInterval = 30 * time.Second
+
+// certReloader serves a certificate and reloads it when its files change.
+// A certificate that fails to load is logged and the previous one kept.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := c.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checkedAt) >= certCheckInterval {
+		c.checkedAt = time.Now()
+		modTime, err := c.filesModTime()
+		if err != nil {
+			slog.Error("failed to check TLS certificate", "error", err)
+		} else if !modTime.Equal(c.modTime) {
+			if err := c.load(modTime); err != nil {
+				slog.Error("failed to reload TLS certificate, keeping the previous one", "error", err)
+			} else {
+				slog.Info("reloaded TLS certificate", "file", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	c.cert = &cert
+	c.modTime = modTime
+	c.checkedAt = time.Now()
+	return nil
+}
+
+// filesModTime returns the later modification time of the two files.
+func (c *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
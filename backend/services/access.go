@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// accessCacheTTL bounds how long a role change or an ended session can take
+// to reach requests served by another instance. Changes made by this instance
+// apply at once.
+const accessCacheTTL = 10 * time.Second
+
+// ErrSessionEnded is returned for access tokens whose session has been
+// logged out or revoked.
+var ErrSessionEnded = errors.New("session has ended")
+
+// AccessState is the live authorization state of a user. It is looked up per
+// request so that role changes take effect before access tokens expire.
+type AccessState struct {
+	Role            string
+	MFAEnabled      bool
+	JurisdictionIDs []primitive.ObjectID
+}
+
+type accessCacheEntry struct {
+	state AccessState
+	// sessions holds the IDs of the user's active sessions
+	sessions  map[primitive.ObjectID]bool
+	expiresAt time.Time
+}
+
+type accessCache struct {
+	entries sync.Map
+}
+
+// AccessState returns the user's state for a request made in the given
+// session. It fails with ErrSessionEnded once the session no longer has an
+// active refresh token, so revoked sessions lose access before their access
+// tokens expire.
+func (s *AuthService) AccessState(ctx context.Context, userID, sessionID primitive.ObjectID) (*AccessState, error) {
+	if value, ok := s.accessCache.entries.Load(userID); ok {
+		entry := value.(accessCacheEntry)
+		// A session started after the entry was cached is looked up afresh
+		if time.Now().Before(entry.expiresAt) && entry.sessions[sessionID] {
+			state := entry.state
+			return &state, nil
+		}
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := s.tokens.ActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %v", err)
+	}
+
+	entry := accessCacheEntry{
+		state: AccessState{
+			Role:            models.NormalizeRole(user.Role),
+			MFAEnabled:      user.MFAEnabled(),
+			JurisdictionIDs: user.JurisdictionIDs,
+		},
+		sessions:  make(map[primitive.ObjectID]bool, len(tokens)),
+		expiresAt: time.Now().Add(accessCacheTTL),
+	}
+	for _, token := range tokens {
+		entry.sessions[token.FamilyID] = true
+	}
+	s.accessCache.entries.Store(userID, entry)
+
+	if !entry.sessions[sessionID] {
+		return nil, ErrSessionEnded
+	}
+	state := entry.state
+	return &state, nil
+}
+
+// InvalidateAccess drops the cached state after a change to the user's role,
+// MFA enrollment or sessions.
+func (s *AuthService) InvalidateAccess(userID primitive.ObjectID) {
+	s.accessCache.entries.Delete(userID)
+}
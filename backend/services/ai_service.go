@@ -2,14 +2,42 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/metrics"
+	"github.com/arnoldadero/sautii/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// IssueCategories are the categories the AI service classifies issues into.
+var IssueCategories = []string{
+	"INFRASTRUCTURE", "SAFETY", "ENVIRONMENT", "COMMUNITY", "HEALTHCARE",
+	"EDUCATION", "SECURITY", "GOVERNANCE", "ECONOMY", "SOCIAL",
+}
+
+// knownCategory returns category if it is one of IssueCategories and
+// "OTHER" otherwise, to keep metric labels bounded.
+func knownCategory(category string) string {
+	for _, c := range IssueCategories {
+		if c == category {
+			return c
+		}
+	}
+	return "OTHER"
+}
+
 type AIService struct {
-	apiKey string
+	apiKey     string
+	model      string
+	httpClient *http.Client
 }
 
 type CategoryPrediction struct {
@@ -18,16 +46,45 @@ type CategoryPrediction struct {
 	Explanation string  `json:"explanation"`
 }
 
-func NewAIService() *AIService {
+func NewAIService(cfg config.AI) *AIService {
 	return &AIService{
-		apiKey: os.Getenv("OPENAI_API_KEY"),
+		apiKey:     cfg.OpenAIAPIKey,
+		model:      cfg.Model,
+		httpClient: &http.Client{Timeout: cfg.Timeout, Transport: tracing.Transport(http.DefaultTransport)},
 	}
 }
 
-func (s *AIService) PredictCategory(title, description string) (*CategoryPrediction, error) {
+// Ping checks that the OpenAI API is reachable and accepts the API key.
+func (s *AIService) Ping(ctx context.Context) error {
+	if s.apiKey == "" {
+		return errors.New("OPENAI_API_KEY is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.openai.com/v1/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OpenAI API returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *AIService) PredictCategory(ctx context.Context, title, description string) (_ *CategoryPrediction, err error) {
+	ctx, span := tracing.Start(ctx, "AIService.PredictCategory", trace.WithAttributes(attribute.String("ai.model", s.model)))
+	defer tracing.End(span, &err)
+
 	// Prepare the prompt for GPT
 	prompt := fmt.Sprintf(`Analyze the following community issue and categorize it into one of these categories:
-Categories: INFRASTRUCTURE, SAFETY, ENVIRONMENT, COMMUNITY, HEALTHCARE, EDUCATION, SECURITY, GOVERNANCE, ECONOMY, SOCIAL
+Categories: %s
 
 Title: %s
 Description: %s
@@ -37,11 +94,11 @@ Provide the response in JSON format with the following fields:
 - confidence: A number between 0 and 1 indicating confidence in the categorization
 - explanation: A brief explanation of why this category was chosen
 
-Response should be valid JSON.`, title, description)
+Response should be valid JSON.`, strings.Join(IssueCategories, ", "), title, description)
 
 	// Prepare the request to OpenAI API
 	requestBody := map[string]interface{}{
-		"model": "gpt-3.5-turbo",
+		"model": s.model,
 		"messages": []map[string]string{
 			{
 				"role":    "system",
@@ -61,7 +118,7 @@ Response should be valid JSON.`, title, description)
 	}
 
 	// Make request to OpenAI API
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestJSON))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestJSON))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -69,34 +126,55 @@ Response should be valid JSON.`, title, description)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.apiKey))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	start := time.Now()
+	resp, err := s.httpClient.Do(req)
+	metrics.ObserveAIRequest(time.Since(start))
 	if err != nil {
+		metrics.ObserveAIFailure(metrics.AIFailureRequest)
 		return nil, fmt.Errorf("failed to make request: %v", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		metrics.ObserveAIFailure(metrics.AIFailureStatus)
+		return nil, fmt.Errorf("OpenAI API returned %s", resp.Status)
+	}
+
 	var response struct {
 		Choices []struct {
 			Message struct {
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		metrics.ObserveAIFailure(metrics.AIFailureResponse)
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
+	metrics.AddAITokens(response.Usage.PromptTokens, response.Usage.CompletionTokens)
+	span.SetAttributes(
+		attribute.Int("ai.prompt_tokens", response.Usage.PromptTokens),
+		attribute.Int("ai.completion_tokens", response.Usage.CompletionTokens),
+	)
 
 	if len(response.Choices) == 0 {
+		metrics.ObserveAIFailure(metrics.AIFailureResponse)
 		return nil, fmt.Errorf("no response from AI service")
 	}
 
 	// Parse the AI response
 	var prediction CategoryPrediction
 	if err := json.Unmarshal([]byte(response.Choices[0].Message.Content), &prediction); err != nil {
+		metrics.ObserveAIFailure(metrics.AIFailureResponse)
 		return nil, fmt.Errorf("failed to parse AI response: %v", err)
 	}
 
+	metrics.ObserveAIClassification(knownCategory(prediction.Category))
+	span.SetAttributes(attribute.String("ai.category", prediction.Category))
 	return &prediction, nil
 }
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// apiKeyPrefix marks API keys so they are recognisable in logs and by
+	// secret scanners.
+	apiKeyPrefix = "sau_"
+
+	defaultAPIKeyRateLimit = 60
+	maxAPIKeyRateLimit     = 6000
+	// apiKeyUsageInterval limits how often last-used tracking writes to the
+	// database for a busy key.
+	apiKeyUsageInterval = time.Minute
+)
+
+var ErrInvalidAPIKey = errors.New("invalid or expired API key")
+
+// APIKeyRateLimitedError is returned when a key has used up its requests.
+type APIKeyRateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *APIKeyRateLimitedError) Error() string {
+	return "API key rate limit exceeded"
+}
+
+type APIKeyService struct {
+	keys         apiKeyStore
+	auditService *AuditService
+	rateLimits   RateLimitStore
+}
+
+// apiKeyStore holds API keys.
+type apiKeyStore interface {
+	insert(ctx context.Context, key *models.APIKey) error
+	// list returns every key, newest first.
+	list(ctx context.Context) ([]models.APIKey, error)
+	// byHash returns the key with the hash, or nil if there is none.
+	byHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	// revoke marks the key revoked. It reports false if the key does not
+	// exist or was already revoked.
+	revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error)
+	recordUse(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	RateLimit int        `json:"rateLimit"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func NewAPIKeyService(db *mongo.Database, auditService *AuditService, rateLimits RateLimitStore) *APIKeyService {
+	return &APIKeyService{
+		keys:         &mongoAPIKeyStore{apiKeyCollection: db.Collection("api_keys")},
+		auditService: auditService,
+		rateLimits:   rateLimits,
+	}
+}
+
+func EnsureAPIKeyIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("api_keys").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "keyHash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create API key indexes: %v", err)
+	}
+	return nil
+}
+
+// Create issues a new API key. The actor can only grant scopes whose
+// permissions they hold themselves.
+func (s *APIKeyService) Create(ctx context.Context, actor Actor, req CreateAPIKeyRequest) (*models.NewAPIKey, error) {
+	if !actor.Can(models.PermManageAPIKeys) {
+		return nil, ErrForbidden
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, errors.New("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !models.ValidScope(scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		for _, permission := range models.ScopePermissions(scope) {
+			if !actor.Can(permission) {
+				return nil, ErrForbidden
+			}
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	rateLimit := req.RateLimit
+	if rateLimit == 0 {
+		rateLimit = defaultAPIKeyRateLimit
+	}
+	if rateLimit < 1 || rateLimit > maxAPIKeyRateLimit {
+		return nil, fmt.Errorf("rate limit must be between 1 and %d requests per minute", maxAPIKeyRateLimit)
+	}
+
+	secret, err := generateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+	plaintext := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		ID:        primitive.NewObjectID(),
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    plaintext[:len(apiKeyPrefix)+6],
+		KeyHash:   hashToken(plaintext),
+		Scopes:    req.Scopes,
+		RateLimit: rateLimit,
+		CreatedBy: actor.ID,
+		ExpiresAt: req.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.keys.insert(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to create API key: %v", err)
+	}
+
+	err = s.auditService.Record(ctx, &models.AuditEntry{
+		Action:  AuditAPIKeyCreated,
+		ActorID: actor.ID,
+		IP:      actor.IP,
+		Details: map[string]interface{}{
+			"apiKeyId":  key.ID,
+			"name":      key.Name,
+			"scopes":    key.Scopes,
+			"rateLimit": key.RateLimit,
+			"expiresAt": key.ExpiresAt,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.NewAPIKey{APIKey: key, Key: plaintext}, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]models.APIKey, error) {
+	return s.keys.list(ctx)
+}
+
+// Revoke disables a key immediately.
+func (s *APIKeyService) Revoke(ctx context.Context, actor Actor, id primitive.ObjectID) error {
+	if !actor.Can(models.PermManageAPIKeys) {
+		return ErrForbidden
+	}
+
+	revoked, err := s.keys.revoke(ctx, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %v", err)
+	}
+	if !revoked {
+		return errors.New("API key not found or already revoked")
+	}
+
+	return s.auditService.Record(ctx, &models.AuditEntry{
+		Action:  AuditAPIKeyRevoked,
+		ActorID: actor.ID,
+		IP:      actor.IP,
+		Details: map[string]interface{}{"apiKeyId": id},
+	})
+}
+
+// Authenticate returns the active key matching the plaintext key, enforcing
+// its rate limit and recording its use.
+func (s *APIKeyService) Authenticate(ctx context.Context, plaintext, ip string) (*models.APIKey, error) {
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.keys.byHash(ctx, hashToken(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if err := s.allow(ctx, key); err != nil {
+		return nil, err
+	}
+
+	s.recordUse(ctx, key, ip, now)
+	return key, nil
+}
+
+// allow counts the request against the key's per-minute limit. If the
+// rate limit store fails the request is allowed, as for route limits.
+func (s *APIKeyService) allow(ctx context.Context, key *models.APIKey) error {
+	policy := RateLimitPolicy{Name: "apikey", Limit: key.RateLimit, Period: time.Minute}
+	result, err := s.rateLimits.Take(ctx, "apikey:"+key.ID.Hex(), policy)
+	if err != nil {
+		logging.FromContext(ctx).Error("API key rate limit check failed", "api_key_id", key.ID.Hex(), "error", err)
+		return nil
+	}
+	if !result.Allowed {
+		return &APIKeyRateLimitedError{RetryAfter: result.RetryAfter}
+	}
+	return nil
+}
+
+func (s *APIKeyService) recordUse(ctx context.Context, key *models.APIKey, ip string, now time.Time) {
+	if key.LastUsedAt != nil && now.Sub(*key.LastUsedAt) < apiKeyUsageInterval && key.LastUsedIP == ip {
+		return
+	}
+
+	if err := s.keys.recordUse(ctx, key.ID, ip, now); err == nil {
+		key.LastUsedAt = &now
+		key.LastUsedIP = ip
+	}
+}
+
+type mongoAPIKeyStore struct {
+	apiKeyCollection *mongo.Collection
+}
+
+func (m *mongoAPIKeyStore) insert(ctx context.Context, key *models.APIKey) error {
+	_, err := m.apiKeyCollection.InsertOne(ctx, key)
+	return err
+}
+
+func (m *mongoAPIKeyStore) list(ctx context.Context) ([]models.APIKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := m.apiKeyCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode API keys: %v", err)
+	}
+	return keys, nil
+}
+
+func (m *mongoAPIKeyStore) byHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := m.apiKeyCollection.FindOne(ctx, bson.M{"keyHash": keyHash}).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (m *mongoAPIKeyStore) revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	result, err := m.apiKeyCollection.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": at}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (m *mongoAPIKeyStore) recordUse(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error {
+	_, err := m.apiKeyCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"lastUsedAt": at,
+		"lastUsedIp": ip,
+	}})
+	return err
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryAPIKeys keeps API keys in memory for tests.
+type memoryAPIKeys struct {
+	mu   sync.Mutex
+	keys []models.APIKey
+}
+
+func (m *memoryAPIKeys) insert(ctx context.Context, key *models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, *key)
+	return nil
+}
+
+func (m *memoryAPIKeys) list(ctx context.Context) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []models.APIKey{}
+	for i := len(m.keys) - 1; i >= 0; i-- {
+		keys = append(keys, m.keys[i])
+	}
+	return keys, nil
+}
+
+func (m *memoryAPIKeys) byHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range m.keys {
+		if key.KeyHash == keyHash {
+			return &key, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryAPIKeys) revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.keys {
+		if m.keys[i].ID == id && m.keys[i].RevokedAt == nil {
+			m.keys[i].RevokedAt = &at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryAPIKeys) recordUse(ctx context.Context, id primitive.ObjectID, ip string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.keys {
+		if m.keys[i].ID == id {
+			m.keys[i].LastUsedAt = &at
+			m.keys[i].LastUsedIP = ip
+		}
+	}
+	return nil
+}
+
+func newTestAPIKeyService(keys *memoryAPIKeys) *APIKeyService {
+	return &APIKeyService{
+		keys:         keys,
+		auditService: &AuditService{log: &memoryAuditLog{}},
+		rateLimits:   NewMemoryRateLimitStore(),
+	}
+}
+
+// failingRateLimits is a rate limit store that is down.
+type failingRateLimits struct{}
+
+func (failingRateLimits) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("store unavailable")
+}
+
+var testAdmin = Actor{ID: primitive.NewObjectID(), Role: models.RoleAdmin}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+	apiKeyService := newTestAPIKeyService(&memoryAPIKeys{})
+
+	for _, scopes := range [][]string{{"webhooks:admin"}, {models.ScopeSearchRead, "issues:delete"}, {}} {
+		if _, err := apiKeyService.Create(ctx, testAdmin, CreateAPIKeyRequest{Name: "dashboard", Scopes: scopes}); err == nil {
+			t.Errorf("key created with scopes %q", scopes)
+		}
+	}
+	citizen := Actor{ID: primitive.NewObjectID(), Role: models.RoleCitizen}
+	if _, err := apiKeyService.Create(ctx, citizen, CreateAPIKeyRequest{Name: "script", Scopes: []string{models.ScopeSearchRead}}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Create by a citizen = %v, want ErrForbidden", err)
+	}
+
+	// Scopes only grant their own permissions; unknown ones grant nothing
+	tests := []struct {
+		scopes     []string
+		permission models.Permission
+		want       bool
+	}{
+		{[]string{models.ScopeIssuesCreate}, models.PermCreateIssue, true},
+		{[]string{models.ScopeSearchRead}, models.PermCreateIssue, false},
+		{[]string{models.ScopeIssuesCreate}, models.PermManageAPIKeys, false},
+		{[]string{"webhooks:admin"}, models.PermManageAPIKeys, false},
+		{[]string{string(models.PermCreateIssue) + " "}, models.PermCreateIssue, false},
+	}
+	for _, tt := range tests {
+		if got := models.ScopesAllow(tt.scopes, tt.permission); got != tt.want {
+			t.Errorf("ScopesAllow(%q, %s) = %v, want %v", tt.scopes, tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	keys := &memoryAPIKeys{}
+	apiKeyService := newTestAPIKeyService(keys)
+
+	create := func(expiresAt *time.Time) *models.NewAPIKey {
+		t.Helper()
+		key, err := apiKeyService.Create(ctx, testAdmin, CreateAPIKeyRequest{Name: "dashboard", Scopes: []string{models.ScopeSearchRead}, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	active := create(nil)
+	if !strings.HasPrefix(active.Key, apiKeyPrefix) || keys.keys[0].KeyHash == active.Key {
+		t.Errorf("key %q stored as %q, want a hash of a %s key", active.Key, keys.keys[0].KeyHash, apiKeyPrefix)
+	}
+	key, err := apiKeyService.Authenticate(ctx, active.Key, "192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != active.ID || key.LastUsedIP != "192.0.2.1" {
+		t.Errorf("authenticated key = %+v", key)
+	}
+
+	soon := time.Now().Add(time.Hour)
+	expiring := create(&soon)
+	expired := time.Now().Add(-time.Second)
+	keys.keys[1].ExpiresAt = &expired
+
+	revoked := create(nil)
+	if err := apiKeyService.Revoke(ctx, testAdmin, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiKeyService.Revoke(ctx, testAdmin, revoked.ID); err == nil {
+		t.Error("key revoked twice")
+	}
+
+	for name, plaintext := range map[string]string{
+		"expired":   expiring.Key,
+		"revoked":   revoked.Key,
+		"unknown":   apiKeyPrefix + "unknown",
+		"no prefix": strings.TrimPrefix(active.Key, apiKeyPrefix),
+	} {
+		if _, err := apiKeyService.Authenticate(ctx, plaintext, ""); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("Authenticate with the %s key = %v, want ErrInvalidAPIKey", name, err)
+		}
+	}
+
+	past := time.Now().Add(-time.Minute)
+	if _, err := apiKeyService.Create(ctx, testAdmin, CreateAPIKeyRequest{Name: "old", Scopes: []string{models.ScopeSearchRead}, ExpiresAt: &past}); err == nil {
+		t.Error("key created already expired")
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	ctx := context.Background()
+	keys := &memoryAPIKeys{}
+	apiKeyService := newTestAPIKeyService(keys)
+	// A second instance sharing the rate limit store
+	other := &APIKeyService{keys: keys, auditService: apiKeyService.auditService, rateLimits: apiKeyService.rateLimits}
+
+	create := func(rateLimit int) string {
+		t.Helper()
+		key, err := apiKeyService.Create(ctx, testAdmin, CreateAPIKeyRequest{Name: "dashboard", Scopes: []string{models.ScopeSearchRead}, RateLimit: rateLimit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key.Key
+	}
+	limited, busy := create(2), create(100)
+
+	for _, s := range []*APIKeyService{apiKeyService, other} {
+		if _, err := s.Authenticate(ctx, limited, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := apiKeyService.Authenticate(ctx, limited, "")
+	var rateLimited *APIKeyRateLimitedError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("third request = %v, want APIKeyRateLimitedError", err)
+	}
+	// Two requests a minute refill one every 30 seconds
+	if rateLimited.RetryAfter <= 29*time.Second || rateLimited.RetryAfter > 30*time.Second {
+		t.Errorf("RetryAfter = %s, want about 30s", rateLimited.RetryAfter)
+	}
+
+	// Each key has its own limit
+	for i := 0; i < 10; i++ {
+		if _, err := apiKeyService.Authenticate(ctx, busy, ""); err != nil {
+			t.Fatalf("request %d with another key = %v", i+1, err)
+		}
+	}
+
+	// Requests are let through while the store is down
+	apiKeyService.rateLimits = failingRateLimits{}
+	if _, err := apiKeyService.Authenticate(ctx, limited, ""); err != nil {
+		t.Errorf("Authenticate without a rate limit store = %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditRoleGranted = "role.granted"
+	AuditRoleRevoked = "role.revoked"
+
+	AuditJurisdictionsAssigned = "jurisdictions.assigned"
+
+	AuditRepresentativeReviewed = "representative.reviewed"
+
+	AuditAPIKeyCreated = "api_key.created"
+	AuditAPIKeyRevoked = "api_key.revoked"
+
+	AuditLoginFailed     = "login.failed"
+	AuditAccountLocked   = "account.locked"
+	AuditAccountUnlocked = "account.unlocked"
+	AuditIPBlocked       = "ip.blocked"
+
+	AuditAccountDeleted = "account.deleted"
+)
+
+// SecurityActions are the audit actions shown in the security log.
+var SecurityActions = []string{AuditLoginFailed, AuditAccountLocked, AuditAccountUnlocked, AuditIPBlocked}
+
+type AuditService struct {
+	log auditLog
+}
+
+// auditLog stores audit entries.
+type auditLog interface {
+	insert(ctx context.Context, entry *models.AuditEntry) error
+	// list returns entries matching filter, newest first, up to limit.
+	list(ctx context.Context, filter AuditFilter, limit int64) ([]models.AuditEntry, error)
+}
+
+type AuditFilter struct {
+	Actions      []string
+	ActorID      primitive.ObjectID
+	TargetUserID primitive.ObjectID
+	Limit        int64
+}
+
+func NewAuditService(db *mongo.Database) *AuditService {
+	return &AuditService{
+		log: &mongoAuditLog{auditCollection: db.Collection("audit_log")},
+	}
+}
+
+func (s *AuditService) Record(ctx context.Context, entry *models.AuditEntry) error {
+	entry.ID = primitive.NewObjectID()
+	entry.CreatedAt = time.Now()
+	if entry.RequestID == "" {
+		entry.RequestID = logging.RequestID(ctx)
+	}
+
+	if err := s.log.insert(ctx, entry); err != nil {
+		return fmt.Errorf("failed to record audit entry: %v", err)
+	}
+	return nil
+}
+
+// List returns matching audit entries, newest first.
+func (s *AuditService) List(ctx context.Context, filter AuditFilter) ([]models.AuditEntry, error) {
+	limit := filter.Limit
+	if limit < 1 || limit > 500 {
+		limit = 100
+	}
+
+	entries, err := s.log.list(ctx, filter, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %v", err)
+	}
+	return entries, nil
+}
+
+type mongoAuditLog struct {
+	auditCollection *mongo.Collection
+}
+
+func (l *mongoAuditLog) insert(ctx context.Context, entry *models.AuditEntry) error {
+	_, err := l.auditCollection.InsertOne(ctx, entry)
+	return err
+}
+
+func (l *mongoAuditLog) list(ctx context.Context, filter AuditFilter, limit int64) ([]models.AuditEntry, error) {
+	query := bson.M{}
+	if len(filter.Actions) > 0 {
+		query["action"] = bson.M{"$in": filter.Actions}
+	}
+	if !filter.ActorID.IsZero() {
+		query["actorId"] = filter.ActorID
+	}
+	if !filter.TargetUserID.IsZero() {
+		query["targetUserId"] = filter.TargetUserID
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit)
+	cursor, err := l.auditCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []models.AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode audit entries: %v", err)
+	}
+	return entries, nil
+}
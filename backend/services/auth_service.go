@@ -6,52 +6,77 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/arnoldadero/sautii/config"
 	"github.com/arnoldadero/sautii/models"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/tracing"
+	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthService struct {
-	userCollection  *mongo.Collection
-	tokenCollection *mongo.Collection
-	jwtSecret      string
-	refreshSecret  string
+	users           repository.UserRepository
+	tokens          repository.TokenRepository
+	loginAttempts   repository.LoginAttemptRepository
+	emailService    *EmailService
+	keyService      *KeyService
+	auditService    *AuditService
+	issuer          string
+	audience        string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+
+	unverifiedRestrictions map[string]bool
+	accessCache            accessCache
 }
 
 type TokenClaims struct {
-	UserID string `json:"userId"`
-	Role   string `json:"role"`
-	jwt.StandardClaims
+	UserID    string `json:"userId"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
+	// MFASetup marks a token for a role that requires MFA but has not
+	// enrolled yet; it is only accepted by the MFA enrollment endpoints.
+	MFASetup bool `json:"mfaSetup,omitempty"`
+	jwt.RegisteredClaims
 }
 
-type RefreshToken struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	UserID    primitive.ObjectID `bson:"userId"`
-	Token     string            `bson:"token"`
-	ExpiresAt time.Time         `bson:"expiresAt"`
-	CreatedAt time.Time         `bson:"createdAt"`
+// ClientInfo describes the device a refresh token was issued to.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
 }
 
-func NewAuthService(db *mongo.Database, jwtSecret, refreshSecret string) *AuthService {
+// NewAuthService creates the auth service, taking the access token issuer,
+// audience and token lifetimes from cfg.
+func NewAuthService(store *repository.Store, emailService *EmailService, keyService *KeyService, auditService *AuditService, cfg config.Auth) *AuthService {
 	return &AuthService{
-		userCollection:  db.Collection("users"),
-		tokenCollection: db.Collection("refresh_tokens"),
-		jwtSecret:      jwtSecret,
-		refreshSecret:  refreshSecret,
+		users:                  store.Users,
+		tokens:                 store.Tokens,
+		loginAttempts:          store.LoginAttempts,
+		emailService:           emailService,
+		keyService:             keyService,
+		auditService:           auditService,
+		issuer:                 cfg.Issuer,
+		audience:               cfg.Audience,
+		accessTokenTTL:         cfg.AccessTokenTTL,
+		refreshTokenTTL:        cfg.RefreshTokenTTL,
+		unverifiedRestrictions: restrictionSet(cfg.UnverifiedRestrictions),
 	}
 }
 
-func (s *AuthService) Register(ctx context.Context, email, username, password string) (*models.User, error) {
+func (s *AuthService) Register(ctx context.Context, email, username, password string) (_ *models.User, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Register")
+	defer tracing.End(span, &err)
+
 	// Check if user already exists
-	exists, err := s.userExists(ctx, email)
-	if err != nil {
-		return nil, err
-	}
-	if exists {
+	if _, err := s.users.GetByEmail(ctx, email); err == nil {
 		return nil, errors.New("user with this email already exists")
+	} else if err != repository.ErrNotFound {
+		return nil, err
 	}
 
 	// Hash password
@@ -66,27 +91,39 @@ func (s *AuthService) Register(ctx context.Context, email, username, password st
 		Email:      email,
 		Username:   username,
 		Password:   string(hashedPassword),
-		Role:       "user",
+		Role:       models.RoleCitizen,
 		IsVerified: false,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
 
 	// Insert user into database
-	_, err = s.userCollection.InsertOne(ctx, user)
-	if err != nil {
+	if err := s.users.Create(ctx, user); err != nil {
+		if err == repository.ErrDuplicate {
+			return nil, errors.New("email or username is already taken")
+		}
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
 
+	// Send the email verification link
+	s.registerVerification(ctx, user)
+
 	return user, nil
 }
 
-func (s *AuthService) Login(ctx context.Context, email, password string) (*models.AuthTokens, error) {
+func (s *AuthService) Login(ctx context.Context, email, password string, client ClientInfo) (_ *models.LoginResult, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.Login")
+	defer tracing.End(span, &err)
+
+	if err := s.checkLoginAllowed(ctx, email, client.IP); err != nil {
+		return nil, err
+	}
+
 	// Find user
-	var user models.User
-	err := s.userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	user, err := s.users.GetByEmail(ctx, email)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == repository.ErrNotFound {
+			s.recordLoginFailure(ctx, email, nil, client.IP)
 			return nil, errors.New("invalid email or password")
 		}
 		return nil, err
@@ -95,16 +132,52 @@ func (s *AuthService) Login(ctx context.Context, email, password string) (*model
 	// Verify password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
+		s.recordLoginFailure(ctx, email, user, client.IP)
 		return nil, errors.New("invalid email or password")
 	}
 
-	// Generate tokens
-	accessToken, err := s.generateAccessToken(user.ID.Hex(), user.Role)
+	// With MFA enabled the failures are only cleared once the code is
+	// verified, so wrong codes keep counting towards a lockout
+	if !user.MFAEnabled() {
+		if err := s.clearLoginFailures(ctx, email); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.completeLogin(ctx, user, client)
+}
+
+// completeLogin finishes a login once the user's first factor has been
+// checked.
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User, client ClientInfo) (*models.LoginResult, error) {
+	// With MFA enabled the first factor only earns a challenge token
+	if user.MFAEnabled() {
+		challenge, err := s.issueActionToken(ctx, user.ID, TokenPurposeMFAChallenge, mfaChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &models.LoginResult{MFARequired: true, MFAToken: challenge}, nil
+	}
+
+	// Each login starts a new session (refresh token family)
+	tokens, err := s.issueTokens(ctx, user, primitive.NewObjectID(), client)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := s.generateRefreshToken(user.ID)
+	return &models.LoginResult{
+		AuthTokens:       tokens,
+		MFASetupRequired: s.mfaSetupRequired(user),
+	}, nil
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, user *models.User, sessionID primitive.ObjectID, client ClientInfo) (*models.AuthTokens, error) {
+	accessToken, err := s.generateAccessToken(user.ID.Hex(), user.Role, sessionID.Hex(), s.mfaSetupRequired(user))
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := s.generateRefreshToken(ctx, user.ID, sessionID, client)
 	if err != nil {
 		return nil, err
 	}
@@ -115,61 +188,90 @@ func (s *AuthService) Login(ctx context.Context, email, password string) (*model
 	}, nil
 }
 
-func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*models.AuthTokens, error) {
+// RefreshToken rotates a refresh token. Presenting a token that has already
+// been rotated is treated as theft and revokes every token in its family.
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (_ *models.AuthTokens, err error) {
+	ctx, span := tracing.Start(ctx, "AuthService.RefreshToken")
+	defer tracing.End(span, &err)
+
 	// Verify refresh token
-	var storedToken RefreshToken
-	err := s.tokenCollection.FindOne(ctx, bson.M{"token": refreshToken}).Decode(&storedToken)
+	storedToken, err := s.tokens.GetRefreshToken(ctx, hashToken(refreshToken))
 	if err != nil {
 		return nil, errors.New("invalid refresh token")
 	}
 
+	if storedToken.RevokedAt != nil {
+		return nil, errors.New("refresh token revoked")
+	}
+
+	if storedToken.RotatedAt != nil {
+		if err := s.revokeFamily(ctx, storedToken.UserID, storedToken.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("refresh token reuse detected, please log in again")
+	}
+
 	// Check if token is expired
 	if time.Now().After(storedToken.ExpiresAt) {
 		return nil, errors.New("refresh token expired")
 	}
 
-	// Find user
-	var user models.User
-	err = s.userCollection.FindOne(ctx, bson.M{"_id": storedToken.UserID}).Decode(&user)
+	// Mark the token as rotated. Rotation is conditional so that two
+	// concurrent refreshes cannot both succeed with the same token.
+	rotated, err := s.tokens.RotateRefreshToken(ctx, storedToken.ID, time.Now())
 	if err != nil {
 		return nil, err
 	}
-
-	// Generate new tokens
-	accessToken, err := s.generateAccessToken(user.ID.Hex(), user.Role)
-	if err != nil {
-		return nil, err
+	if !rotated {
+		if err := s.revokeFamily(ctx, storedToken.UserID, storedToken.FamilyID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("refresh token reuse detected, please log in again")
 	}
 
-	newRefreshToken, err := s.generateRefreshToken(user.ID)
+	// Find user
+	user, err := s.GetUserByID(ctx, storedToken.UserID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Delete old refresh token
-	_, err = s.tokenCollection.DeleteOne(ctx, bson.M{"_id": storedToken.ID})
+	// Generate new tokens
+	return s.issueTokens(ctx, user, storedToken.FamilyID, client)
+}
+
+// Logout revokes the session the refresh token belongs to.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	storedToken, err := s.tokens.GetRefreshToken(ctx, hashToken(refreshToken))
 	if err != nil {
-		return nil, err
+		if err == repository.ErrNotFound {
+			return nil
+		}
+		return err
 	}
+	return s.revokeFamily(ctx, storedToken.UserID, storedToken.FamilyID)
+}
 
-	return &models.AuthTokens{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-	}, nil
+func (s *AuthService) revokeFamily(ctx context.Context, userID, familyID primitive.ObjectID) error {
+	return s.revokeTokens(ctx, repository.RefreshTokenFilter{UserID: userID, FamilyID: familyID})
 }
 
-func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
-	_, err := s.tokenCollection.DeleteOne(ctx, bson.M{"token": refreshToken})
+// revokeTokens ends the sessions of the matching refresh tokens. Their access
+// tokens stop working as soon as the cached access state is dropped.
+func (s *AuthService) revokeTokens(ctx context.Context, filter repository.RefreshTokenFilter) error {
+	err := s.tokens.RevokeRefreshTokens(ctx, filter, time.Now())
+	if !filter.UserID.IsZero() {
+		s.InvalidateAccess(filter.UserID)
+	}
 	return err
 }
 
 func (s *AuthService) VerifyAccessToken(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.jwtSecret), nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, s.keyService.Keyfunc,
+		jwt.WithValidMethods(s.keyService.Algorithms()),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(s.audience),
+		jwt.WithExpirationRequired(),
+	)
 
 	if err != nil {
 		return nil, err
@@ -182,67 +284,144 @@ func (s *AuthService) VerifyAccessToken(tokenString string) (*TokenClaims, error
 	return nil, errors.New("invalid token")
 }
 
-func (s *AuthService) generateAccessToken(userID, role string) (string, error) {
+func (s *AuthService) generateAccessToken(userID, role, sessionID string, mfaSetup bool) (string, error) {
 	claims := TokenClaims{
-		UserID: userID,
-		Role:   role,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(15 * time.Minute).Unix(),
-			IssuedAt:  time.Now().Unix(),
+		UserID:    userID,
+		Role:      role,
+		SessionID: sessionID,
+		MFASetup:  mfaSetup,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{s.audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.jwtSecret))
+	return s.keyService.Sign(claims)
 }
 
-func (s *AuthService) generateRefreshToken(userID primitive.ObjectID) (string, error) {
+func (s *AuthService) generateRefreshToken(ctx context.Context, userID, familyID primitive.ObjectID, client ClientInfo) (string, error) {
 	// Generate random token
-	token := primitive.NewObjectID().Hex()
+	token, err := generateSecureToken()
+	if err != nil {
+		return "", err
+	}
 
-	// Store refresh token
-	refreshToken := RefreshToken{
-		ID:        primitive.NewObjectID(),
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
-		CreatedAt: time.Now(),
+	// Store only the hash of the refresh token
+	now := time.Now()
+	refreshToken := &models.RefreshToken{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		TokenHash:  hashToken(token),
+		FamilyID:   familyID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		ExpiresAt:  now.Add(s.refreshTokenTTL),
+		LastUsedAt: now,
+		CreatedAt:  now,
 	}
 
-	_, err := s.tokenCollection.InsertOne(context.Background(), refreshToken)
-	if err != nil {
+	if err := s.tokens.CreateRefreshToken(ctx, refreshToken); err != nil {
 		return "", err
 	}
 
 	return token, nil
 }
 
-func (s *AuthService) userExists(ctx context.Context, email string) (bool, error) {
-	count, err := s.userCollection.CountDocuments(ctx, bson.M{"email": email})
+// EnsureAuthIndexes creates the indexes the auth collections depend on,
+// including TTL indexes that remove expired tokens.
+func EnsureAuthIndexes(ctx context.Context, db *mongo.Database) error {
+	// Tokens issued before hashing have no tokenHash and are left to expire
+	_, err := db.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"tokenHash": bson.M{"$exists": true}}),
+		},
+		{Keys: bson.D{{Key: "familyId", Value: 1}}},
+		{Keys: bson.D{{Key: "userId", Value: 1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create refresh token indexes: %v", err)
+	}
+
+	_, err = db.Collection("action_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}, {Key: "createdAt", Value: -1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create action token indexes: %v", err)
+	}
+
+	_, err = db.Collection("oidc_states").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "stateHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create OIDC state indexes: %v", err)
+	}
+
+	_, err = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create user identity index: %v", err)
+	}
+
+	_, err = db.Collection("login_attempts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
 	if err != nil {
-		return false, err
+		return fmt.Errorf("failed to create login attempt indexes: %v", err)
 	}
-	return count > 0, nil
+	return nil
 }
 
 func (s *AuthService) GetUserByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
-	var user models.User
-	err := s.userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	user, err := s.users.GetByID(ctx, id)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == repository.ErrNotFound {
 			return nil, errors.New("user not found")
 		}
 		return nil, err
 	}
-	return &user, nil
+	return user, nil
 }
 
-func (s *AuthService) UpdateUser(ctx context.Context, userID primitive.ObjectID, updates bson.M) error {
-	updates["updatedAt"] = time.Now()
-	_, err := s.userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": updates})
+func (s *AuthService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, errors.New("user not found")
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *AuthService) UpdateUser(ctx context.Context, userID primitive.ObjectID, update repository.UserUpdate) error {
+	err := s.users.Update(ctx, userID, update)
+	s.InvalidateAccess(userID)
 	return err
 }
 
+// PurgeExpiredTokens deletes expired refresh tokens, action tokens and login
+// attempt records. Backends without expiry indexes keep them until purged.
+func (s *AuthService) PurgeExpiredTokens(ctx context.Context) (tokens, attempts int64, err error) {
+	now := time.Now()
+	if tokens, err = s.tokens.DeleteExpired(ctx, now); err != nil {
+		return tokens, 0, fmt.Errorf("failed to delete expired tokens: %v", err)
+	}
+	if attempts, err = s.loginAttempts.DeleteExpired(ctx, now); err != nil {
+		return tokens, attempts, fmt.Errorf("failed to delete expired login attempts: %v", err)
+	}
+	return tokens, attempts, nil
+}
+
 func (s *AuthService) ChangePassword(ctx context.Context, userID primitive.ObjectID, currentPassword, newPassword string) error {
 	// Get user
 	user, err := s.GetUserByID(ctx, userID)
@@ -263,5 +442,6 @@ func (s *AuthService) ChangePassword(ctx context.Context, userID primitive.Objec
 	}
 
 	// Update password
-	return s.UpdateUser(ctx, userID, bson.M{"password": string(hashedPassword)})
+	password := string(hashedPassword)
+	return s.UpdateUser(ctx, userID, repository.UserUpdate{Password: &password})
 }
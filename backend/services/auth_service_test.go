@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryAuditLog keeps audit entries in memory for tests.
+type memoryAuditLog struct {
+	mu      sync.Mutex
+	entries []models.AuditEntry
+}
+
+func (l *memoryAuditLog) insert(ctx context.Context, entry *models.AuditEntry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, *entry)
+	return nil
+}
+
+func (l *memoryAuditLog) list(ctx context.Context, filter AuditFilter, limit int64) ([]models.AuditEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []models.AuditEntry{}
+	for i := len(l.entries) - 1; i >= 0 && int64(len(entries)) < limit; i-- {
+		entry := l.entries[i]
+		if len(filter.Actions) > 0 && !contains(filter.Actions, entry.Action) {
+			continue
+		}
+		if !filter.ActorID.IsZero() && entry.ActorID != filter.ActorID {
+			continue
+		}
+		if !filter.TargetUserID.IsZero() && entry.TargetUserID != filter.TargetUserID {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// shiftedLoginAttempts lets tests move time forward for login throttling:
+// after advance(d), earlier failures and locks look d older.
+type shiftedLoginAttempts struct {
+	repository.LoginAttemptRepository
+
+	mu      sync.Mutex
+	elapsed time.Duration
+}
+
+func (r *shiftedLoginAttempts) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.elapsed += d
+}
+
+func (r *shiftedLoginAttempts) shift() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.elapsed
+}
+
+// since converts stored times back to the caller's clock.
+func (r *shiftedLoginAttempts) since(attempts *models.LoginAttempts) *models.LoginAttempts {
+	shift := r.shift()
+	attempts.LastFailureAt = attempts.LastFailureAt.Add(-shift)
+	attempts.ExpiresAt = attempts.ExpiresAt.Add(-shift)
+	if attempts.LockedUntil != nil {
+		lockedUntil := attempts.LockedUntil.Add(-shift)
+		attempts.LockedUntil = &lockedUntil
+	}
+	return attempts
+}
+
+func (r *shiftedLoginAttempts) Get(ctx context.Context, key string) (*models.LoginAttempts, error) {
+	attempts, err := r.LoginAttemptRepository.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return r.since(attempts), nil
+}
+
+func (r *shiftedLoginAttempts) RecordFailure(ctx context.Context, key string, at time.Time, window, retention time.Duration) (*models.LoginAttempts, error) {
+	attempts, err := r.LoginAttemptRepository.RecordFailure(ctx, key, at.Add(r.shift()), window, retention)
+	if err != nil {
+		return nil, err
+	}
+	return r.since(attempts), nil
+}
+
+func (r *shiftedLoginAttempts) Lock(ctx context.Context, key string, threshold int, until time.Time, retention time.Duration) (bool, error) {
+	return r.LoginAttemptRepository.Lock(ctx, key, threshold, until.Add(r.shift()), retention)
+}
+
+// newThrottlingTestAuthService returns an auth service whose login attempts
+// can be aged with advance.
+func newThrottlingTestAuthService(t *testing.T, store *repository.Store) (*AuthService, *shiftedLoginAttempts, *memoryAuditLog) {
+	t.Helper()
+	attempts := &shiftedLoginAttempts{LoginAttemptRepository: store.LoginAttempts}
+	store.LoginAttempts = attempts
+	authService, auditLog := newTestAuthService(t, store)
+	return authService, attempts, auditLog
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestKeyService returns a key service holding a single EdDSA key, without
+// a database.
+func newTestKeyService(t *testing.T) *KeyService {
+	t.Helper()
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	return &KeyService{
+		algorithm:        SigningAlgEdDSA,
+		rotationInterval: 24 * time.Hour,
+		accessTokenTTL:   15 * time.Minute,
+		keys: []*signingKey{{
+			ID:         "test",
+			Algorithm:  SigningAlgEdDSA,
+			PrivateKey: privateKey,
+			CreatedAt:  now,
+			ExpiresAt:  now.Add(time.Hour),
+		}},
+	}
+}
+
+// newTestAuthService returns an auth service over an in-memory store.
+func newTestAuthService(t *testing.T, store *repository.Store) (*AuthService, *memoryAuditLog) {
+	t.Helper()
+	auditLog := &memoryAuditLog{}
+	authService := NewAuthService(
+		store,
+		NewEmailService(config.Email{}, "https://sautii.example"),
+		newTestKeyService(t),
+		&AuditService{log: auditLog},
+		config.Auth{
+			Issuer:          "https://sautii.example",
+			Audience:        "sautii",
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 24 * time.Hour,
+		},
+	)
+	return authService, auditLog
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	authService, auditLog := newTestAuthService(t, repository.NewMemoryStore())
+
+	user, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password == "correct horse" {
+		t.Error("password stored in plain text")
+	}
+	if user.Role != models.RoleCitizen || user.IsVerified {
+		t.Errorf("new user has role %q and verified %v", user.Role, user.IsVerified)
+	}
+	if _, err := authService.Register(ctx, "amina@example.com", "amina2", "correct horse"); err == nil {
+		t.Error("registered a second user with the same email")
+	}
+
+	if _, err := authService.Login(ctx, "amina@example.com", "wrong", ClientInfo{IP: "192.0.2.1"}); err == nil {
+		t.Error("logged in with the wrong password")
+	}
+	failures, err := (&AuditService{log: auditLog}).List(ctx, AuditFilter{Actions: []string{AuditLoginFailed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].TargetUserID != user.ID {
+		t.Errorf("login failures = %+v, want one for the user", failures)
+	}
+
+	result, err := authService.Login(ctx, "amina@example.com", "correct horse", ClientInfo{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AuthTokens == nil || result.MFARequired {
+		t.Fatalf("login result = %+v, want tokens", result)
+	}
+
+	claims, err := authService.VerifyAccessToken(result.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID.Hex() || claims.Role != models.RoleCitizen || claims.SessionID == "" {
+		t.Errorf("claims = %+v", claims)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	authService, _ := newTestAuthService(t, repository.NewMemoryStore())
+
+	if _, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	login, err := authService.Login(ctx, "amina@example.com", "correct horse", ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := authService.RefreshToken(ctx, login.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.RefreshToken == login.RefreshToken {
+		t.Error("refresh token was not rotated")
+	}
+
+	// Replaying the rotated token revokes the whole session
+	if _, err := authService.RefreshToken(ctx, login.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("rotated refresh token accepted")
+	}
+	if _, err := authService.RefreshToken(ctx, refreshed.RefreshToken, ClientInfo{}); err == nil {
+		t.Error("refresh token accepted after its session was revoked for reuse")
+	}
+
+	if _, err := authService.RefreshToken(ctx, "not-a-token", ClientInfo{}); err == nil {
+		t.Error("unknown refresh token accepted")
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	authService, _ := newTestAuthService(t, repository.NewMemoryStore())
+
+	user, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logins []*models.LoginResult
+	for _, userAgent := range []string{"phone", "laptop"} {
+		login, err := authService.Login(ctx, "amina@example.com", "correct horse", ClientInfo{UserAgent: userAgent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		logins = append(logins, login)
+	}
+	claims, err := authService.VerifyAccessToken(logins[0].AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens without a session ID must not log the caller out too
+	if err := authService.RevokeOtherSessions(ctx, user.ID, ""); !errors.Is(err, ErrUnknownSession) {
+		t.Fatalf("RevokeOtherSessions without a session = %v, want ErrUnknownSession", err)
+	}
+	sessions, err := authService.ListSessions(ctx, user.ID, claims.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("%d sessions after a refused revocation, want 2", len(sessions))
+	}
+
+	if err := authService.RevokeOtherSessions(ctx, user.ID, claims.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err = authService.ListSessions(ctx, user.ID, claims.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current || sessions[0].UserAgent != "phone" {
+		t.Errorf("sessions = %+v, want only the current one", sessions)
+	}
+	if _, err := authService.RefreshToken(ctx, logins[1].RefreshToken, ClientInfo{}); err == nil {
+		t.Error("revoked session can still refresh")
+	}
+}
+
+func TestAccessEndsWithSession(t *testing.T) {
+	ctx := context.Background()
+	authService, _ := newTestAuthService(t, repository.NewMemoryStore())
+
+	user, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := func() primitive.ObjectID {
+		t.Helper()
+		result, err := authService.Login(ctx, "amina@example.com", "correct horse", ClientInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := authService.VerifyAccessToken(result.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sessionID
+	}
+	phone, laptop := login(), login()
+	for _, session := range []primitive.ObjectID{phone, laptop} {
+		if _, err := authService.AccessState(ctx, user.ID, session); err != nil {
+			t.Fatalf("AccessState = %v for an active session", err)
+		}
+	}
+
+	if err := authService.RevokeSession(ctx, user.ID, laptop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authService.AccessState(ctx, user.ID, laptop); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("AccessState for a revoked session = %v, want ErrSessionEnded", err)
+	}
+	if _, err := authService.AccessState(ctx, user.ID, phone); err != nil {
+		t.Errorf("AccessState for the remaining session = %v", err)
+	}
+
+	// A login after the state was cached is recognised
+	tablet := login()
+	if _, err := authService.AccessState(ctx, user.ID, tablet); err != nil {
+		t.Errorf("AccessState for a new session = %v", err)
+	}
+
+	if err := authService.RevokeAllSessions(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range []primitive.ObjectID{phone, tablet} {
+		if _, err := authService.AccessState(ctx, user.ID, session); !errors.Is(err, ErrSessionEnded) {
+			t.Errorf("AccessState after logging out everywhere = %v, want ErrSessionEnded", err)
+		}
+	}
+}
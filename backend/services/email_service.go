@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/logging"
+)
+
+// linkToken matches the token in verification and reset links.
+var linkToken = regexp.MustCompile(`token=[^\s&]+`)
+
+type EmailService struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+	baseURL  string
+}
+
+// NewEmailService creates the email service. Links in emails point to
+// appBaseURL.
+func NewEmailService(cfg config.Email, appBaseURL string) *EmailService {
+	return &EmailService{
+		host:     cfg.SMTPHost,
+		port:     cfg.SMTPPort,
+		username: cfg.SMTPUsername,
+		password: cfg.SMTPPassword,
+		from:     cfg.From,
+		baseURL:  strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// Link builds an absolute link into the frontend application.
+func (s *EmailService) Link(path string, query string) string {
+	if query == "" {
+		return s.baseURL + path
+	}
+	return s.baseURL + path + "?" + query
+}
+
+func (s *EmailService) Send(ctx context.Context, to, subject, body string) error {
+	// Without SMTP configured (local development) the message is only
+	// logged. Its links can sign the recipient in, so their tokens are left
+	// out.
+	if s.host == "" {
+		logger := logging.FromContext(ctx)
+		logger.Info("SMTP is not configured, logging email instead of sending it", "to", to, "subject", subject)
+		logger.Debug("unsent email body", "to", to, "body", linkToken.ReplaceAllString(body, "token=[redacted]"))
+		return nil
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	if err := smtp.SendMail(s.host+":"+s.port, auth, s.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return nil
+}
+
+func (s *EmailService) SendVerificationEmail(ctx context.Context, to, username, token string) error {
+	link := s.Link("/verify-email", "token="+token)
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours. If you did not create a Sautii account you can ignore this email.\n", username, link)
+	return s.Send(ctx, to, "Verify your Sautii account", body)
+}
+
+func (s *EmailService) SendLockoutEmail(ctx context.Context, to, username string, until time.Time) error {
+	link := s.Link("/forgot-password", "")
+	body := fmt.Sprintf("Hi %s,\n\nWe locked sign-in to your Sautii account until %s after too many failed password attempts.\n\nIf this was you, you can wait or reset your password now, which also unlocks the account:\n\n%s\n\nIf it wasn't you, someone may be trying to guess your password. We recommend resetting it and enabling two-factor authentication.\n", username, until.UTC().Format("2 Jan 2006 15:04 MST"), link)
+	return s.Send(ctx, to, "Your Sautii account was locked", body)
+}
+
+func (s *EmailService) SendExportReadyEmail(ctx context.Context, to, username string) error {
+	link := s.Link("/account/privacy", "")
+	body := fmt.Sprintf("Hi %s,\n\nThe copy of your Sautii data you requested is ready. Sign in and download it from:\n\n%s\n\nThe download is available for 7 days.\n", username, link)
+	return s.Send(ctx, to, "Your Sautii data export is ready", body)
+}
+
+func (s *EmailService) SendPasswordResetEmail(ctx context.Context, to, username, token string) error {
+	link := s.Link("/reset-password", "token="+token)
+	body := fmt.Sprintf("Hi %s,\n\nWe received a request to reset your Sautii password. Open the link below to choose a new one:\n\n%s\n\nThe link expires in 30 minutes and can only be used once. If you did not request a reset you can ignore this email.\n", username, link)
+	return s.Send(ctx, to, "Reset your Sautii password", body)
+}
+
+func (s *EmailService) SendAccountDeletionEmail(ctx context.Context, to, username, token string) error {
+	link := s.Link("/account/privacy", "token="+token)
+	body := fmt.Sprintf("Hi %s,\n\nWe received a request to delete your Sautii account. Open the link below to confirm it:\n\n%s\n\nThe link expires in 30 minutes and can only be used once. Deleting your account cannot be undone. If you did not ask for this, someone may have access to your account: sign out of all sessions and contact support.\n", username, link)
+	return s.Send(ctx, to, "Confirm deleting your Sautii account", body)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/logging"
+)
+
+func TestUnsentEmailLogOmitsLinkTokens(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	emailService := NewEmailService(config.Email{}, "https://sautii.example")
+	if err := emailService.SendPasswordResetEmail(ctx, "amina@example.com", "amina", "s3cr3t-reset-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := buf.String()
+	if strings.Contains(logged, "s3cr3t-reset-token") {
+		t.Errorf("log contains the reset token:\n%s", logged)
+	}
+	if !strings.Contains(logged, "amina@example.com") || !strings.Contains(logged, "Reset your Sautii password") {
+		t.Errorf("log lacks the recipient or subject:\n%s", logged)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logged), "\n") {
+		if strings.Contains(line, "level=INFO") && strings.Contains(line, "body=") {
+			t.Errorf("body logged at INFO: %s", line)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	HealthOK          = "ok"
+	HealthDegraded    = "degraded"
+	HealthUnavailable = "unavailable"
+
+	healthCheckTimeout = 3 * time.Second
+)
+
+// HealthCheck checks one dependency. A failing critical check makes the
+// backend unavailable; any other failing check only degrades it. Results
+// are reused for CacheFor, so slow or rate-limited dependencies are not
+// checked on every probe.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	CacheFor time.Duration
+	Check    func(ctx context.Context) error
+}
+
+type HealthCheckResult struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	Latency   string    `json:"latency"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+type HealthService struct {
+	checks []HealthCheck
+
+	mu     sync.Mutex
+	cached map[string]HealthCheckResult
+}
+
+func NewHealthService(checks ...HealthCheck) *HealthService {
+	return &HealthService{checks: checks, cached: make(map[string]HealthCheckResult)}
+}
+
+// Ready runs the checks concurrently and reports whether the backend can
+// serve requests.
+func (s *HealthService) Ready(ctx context.Context) *HealthReport {
+	report := &HealthReport{Status: HealthOK, Checks: make(map[string]HealthCheckResult, len(s.checks))}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, check := range s.checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			result := s.run(ctx, check)
+			mu.Lock()
+			report.Checks[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status == HealthOK {
+			continue
+		}
+		if result.Critical {
+			report.Status = HealthUnavailable
+		} else if report.Status == HealthOK {
+			report.Status = HealthDegraded
+		}
+	}
+	return report
+}
+
+func (s *HealthService) run(ctx context.Context, check HealthCheck) HealthCheckResult {
+	if check.CacheFor > 0 {
+		s.mu.Lock()
+		result, ok := s.cached[check.Name]
+		s.mu.Unlock()
+		if ok && time.Since(result.CheckedAt) < check.CacheFor {
+			return result
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := HealthCheckResult{
+		Status:    HealthOK,
+		Critical:  check.Critical,
+		Latency:   time.Since(start).Round(time.Millisecond).String(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = HealthDegraded
+		if check.Critical {
+			result.Status = HealthUnavailable
+		}
+		result.Error = err.Error()
+	}
+
+	if check.CacheFor > 0 {
+		s.mu.Lock()
+		s.cached[check.Name] = result
+		s.mu.Unlock()
+	}
+	return result
+}
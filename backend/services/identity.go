@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ExternalIdentity is the verified result of a login at an identity provider.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+var usernameCleaner = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// LoginWithExternalIdentity signs in the user linked to the identity. An
+// identity seen for the first time is linked to the account with the same
+// email if the provider has verified that email, otherwise a new account is
+// created.
+func (s *AuthService) LoginWithExternalIdentity(ctx context.Context, identity ExternalIdentity, client ClientInfo) (*models.LoginResult, error) {
+	user, err := s.findOrLinkIdentity(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, user, client)
+}
+
+func (s *AuthService) findOrLinkIdentity(ctx context.Context, identity ExternalIdentity) (*models.User, error) {
+	user, err := s.users.GetByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errors.New("identity provider did not return a verified email address")
+	}
+
+	link := models.ExternalIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}
+
+	user, err = s.users.GetByEmail(ctx, identity.Email)
+	if err == nil {
+		return s.linkIdentity(ctx, user, link)
+	}
+	if err != repository.ErrNotFound {
+		return nil, err
+	}
+
+	username, err := s.availableUsername(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	newUser := &models.User{
+		ID:         primitive.NewObjectID(),
+		Email:      identity.Email,
+		Username:   username,
+		Role:       models.RoleCitizen,
+		IsVerified: true,
+		VerifiedAt: &now,
+		Identities: []models.ExternalIdentity{link},
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.users.Create(ctx, newUser); err != nil {
+		return nil, fmt.Errorf("failed to create user: %v", err)
+	}
+	return newUser, nil
+}
+
+// linkIdentity attaches the identity to an existing account. If the account
+// never verified its email, whoever registered it may not own the address, so
+// its password and sessions are discarded.
+func (s *AuthService) linkIdentity(ctx context.Context, user *models.User, link models.ExternalIdentity) (*models.User, error) {
+	update := repository.UserUpdate{AddIdentity: &link}
+	if !user.IsVerified {
+		now := time.Now()
+		verified, password := true, ""
+		update.IsVerified = &verified
+		update.VerifiedAt = &now
+		update.Password = &password
+		if err := s.revokeTokens(ctx, repository.RefreshTokenFilter{UserID: user.ID}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.users.Update(ctx, user.ID, update); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %v", err)
+	}
+
+	return s.GetUserByID(ctx, user.ID)
+}
+
+func (s *AuthService) availableUsername(ctx context.Context, identity ExternalIdentity) (string, error) {
+	base := identity.Name
+	if base == "" {
+		base = strings.Split(identity.Email, "@")[0]
+	}
+	base = strings.Trim(usernameCleaner.ReplaceAllString(strings.ToLower(base), "_"), "_")
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		exists, err := s.users.UsernameExists(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%s", base, primitive.NewObjectID().Hex()[18:])
+	}
+	return "", errors.New("could not find an available username")
+}
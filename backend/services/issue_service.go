@@ -2,72 +2,270 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/tracing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type IssueService struct {
-	issueCollection *mongo.Collection
+	issues              repository.IssueRepository
+	users               repository.UserRepository
+	jurisdictionService *JurisdictionService
 }
 
-func NewIssueService(db *mongo.Database) *IssueService {
+func NewIssueService(store *repository.Store, jurisdictionService *JurisdictionService) *IssueService {
 	return &IssueService{
-		issueCollection: db.Collection("issues"),
+		issues:              store.Issues,
+		users:               store.Users,
+		jurisdictionService: jurisdictionService,
 	}
 }
 
-func (s *IssueService) CreateIssue(ctx context.Context, issue *models.Issue) error {
+// CreateIssueRequest holds the issue fields a reporter may choose. Status,
+// assignment, jurisdictions, votes and comments are set by the server.
+// IsAnonymous is accepted from the issue form but not stored yet; the
+// reporter is always recorded as the creator.
+type CreateIssueRequest struct {
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Category    string           `json:"category"`
+	Location    *models.Location `json:"location,omitempty"`
+	Tags        []string         `json:"tags,omitempty"`
+	IsAnonymous bool             `json:"isAnonymous"`
+}
+
+// NewIssue returns an open issue filed by createdBy.
+func (req CreateIssueRequest) NewIssue(createdBy primitive.ObjectID) *models.Issue {
+	return &models.Issue{
+		ID:          primitive.NewObjectID(),
+		Title:       req.Title,
+		Description: req.Description,
+		Category:    req.Category,
+		Status:      "open",
+		Location:    req.Location,
+		CreatedBy:   createdBy,
+		Tags:        req.Tags,
+	}
+}
+
+func (s *IssueService) CreateIssue(ctx context.Context, issue *models.Issue) (err error) {
+	ctx, span := tracing.Start(ctx, "IssueService.CreateIssue")
+	defer tracing.End(span, &err)
+
 	issue.CreatedAt = time.Now()
 	issue.UpdatedAt = time.Now()
+
+	// Place the issue in the jurisdictions containing its location
+	jurisdictionIDs, err := s.jurisdictionService.Resolve(ctx, issue.Location)
+	if err != nil {
+		return err
+	}
+	issue.JurisdictionIDs = jurisdictionIDs
 	
-	_, err := s.issueCollection.InsertOne(ctx, issue)
-	return err
+	return s.issues.Create(ctx, issue)
+}
+
+// ImportIssue stores an issue exported from another installation, keeping its
+// ID and timestamps. Issues without jurisdictions are placed like new ones.
+// It returns repository.ErrDuplicate if the issue already exists.
+func (s *IssueService) ImportIssue(ctx context.Context, issue *models.Issue) error {
+	if issue.CreatedAt.IsZero() {
+		issue.CreatedAt = time.Now()
+	}
+	if issue.UpdatedAt.IsZero() {
+		issue.UpdatedAt = issue.CreatedAt
+	}
+
+	if len(issue.JurisdictionIDs) == 0 {
+		jurisdictionIDs, err := s.jurisdictionService.Resolve(ctx, issue.Location)
+		if err != nil {
+			return err
+		}
+		issue.JurisdictionIDs = jurisdictionIDs
+	}
+
+	return s.issues.Create(ctx, issue)
 }
 
 func (s *IssueService) GetIssue(ctx context.Context, id primitive.ObjectID) (*models.Issue, error) {
-	var issue models.Issue
-	err := s.issueCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&issue)
+	return s.issues.Get(ctx, id)
+}
+
+// issueContentFields can be edited by the issue's author or by anyone with
+// PermEditAnyIssue. The remaining editable fields each need a permission.
+var issueContentFields = map[string]bool{
+	"title":       true,
+	"description": true,
+	"tags":        true,
+	"location":    true,
+}
+
+var issueFieldPermissions = map[string]models.Permission{
+	"category":   models.PermTriageIssue,
+	"priority":   models.PermTriageIssue,
+	"status":     models.PermUpdateStatus,
+	"assignedTo": models.PermAssignIssue,
+}
+
+// authorizeIssueUpdate checks that the actor may change every field in
+// updates.
+func authorizeIssueUpdate(issue *models.Issue, actor Actor, updates bson.M) error {
+	for field := range updates {
+		if issueContentFields[field] {
+			isAuthor := issue.CreatedBy == actor.ID && actor.Can(models.PermEditOwnIssue)
+			if !isAuthor && !actor.Can(models.PermEditAnyIssue) {
+				return ErrForbidden
+			}
+			continue
+		}
+
+		permission, ok := issueFieldPermissions[field]
+		if !ok {
+			return fmt.Errorf("field %q cannot be updated", field)
+		}
+		if !actor.Can(permission) {
+			return ErrForbidden
+		}
+
+		// Officials only manage issues in their own jurisdictions
+		if (field == "status" || field == "assignedTo") && !actor.InJurisdiction(issue.JurisdictionIDs) {
+			return ErrForbidden
+		}
+	}
+	return nil
+}
+
+// checkAssignee ensures issues are only assigned to officials who can manage
+// them.
+func (s *IssueService) checkAssignee(ctx context.Context, issue *models.Issue, assigneeID primitive.ObjectID) error {
+	assignee, err := s.users.GetByID(ctx, assigneeID)
 	if err != nil {
-		return nil, err
+		if err == repository.ErrNotFound {
+			return errors.New("assignee not found")
+		}
+		return err
+	}
+
+	candidate := Actor{ID: assignee.ID, Role: assignee.Role, JurisdictionIDs: assignee.JurisdictionIDs}
+	if !candidate.Can(models.PermUpdateStatus) {
+		return errors.New("issues can only be assigned to officials")
 	}
-	return &issue, nil
+	if !candidate.InJurisdiction(issue.JurisdictionIDs) {
+		return errors.New("assignee does not cover the issue's jurisdiction")
+	}
+	return nil
 }
 
-func (s *IssueService) UpdateIssue(ctx context.Context, id primitive.ObjectID, updates bson.M) error {
-	updates["updatedAt"] = time.Now()
-	_, err := s.issueCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": updates})
-	return err
+func (s *IssueService) UpdateIssue(ctx context.Context, actor Actor, id primitive.ObjectID, updates bson.M) (err error) {
+	ctx, span := tracing.Start(ctx, "IssueService.UpdateIssue", trace.WithAttributes(attribute.String("issue.id", id.Hex())))
+	defer tracing.End(span, &err)
+
+	issue, err := s.GetIssue(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := authorizeIssueUpdate(issue, actor, updates); err != nil {
+		return err
+	}
+
+	update, err := issueUpdate(updates)
+	if err != nil {
+		return err
+	}
+
+	if update.AssignedTo != nil {
+		if err := s.checkAssignee(ctx, issue, *update.AssignedTo); err != nil {
+			return err
+		}
+	}
+
+	return s.issues.Update(ctx, id, update)
 }
 
-func (s *IssueService) VoteOnIssue(ctx context.Context, issueID primitive.ObjectID, userID primitive.ObjectID, voteType string) error {
-	var update bson.M
-	if voteType == "up" {
-		update = bson.M{
-			"$addToSet": bson.M{"votes.up": userID},
-			"$pull":     bson.M{"votes.down": userID},
+// issueUpdate converts the fields of an update request to an IssueUpdate.
+func issueUpdate(updates bson.M) (repository.IssueUpdate, error) {
+	var update repository.IssueUpdate
+	for field, value := range updates {
+		var err error
+		switch field {
+		case "title":
+			update.Title, err = stringField(field, value)
+		case "description":
+			update.Description, err = stringField(field, value)
+		case "category":
+			update.Category, err = stringField(field, value)
+		case "priority":
+			update.Priority, err = stringField(field, value)
+		case "status":
+			update.Status, err = stringField(field, value)
+		case "tags":
+			update.Tags = []string{}
+			err = decodeField(field, value, &update.Tags)
+		case "location":
+			update.Location = &models.Location{}
+			err = decodeField(field, value, update.Location)
+		case "assignedTo":
+			var assignee *string
+			if assignee, err = stringField(field, value); err == nil {
+				assigneeID, idErr := primitive.ObjectIDFromHex(*assignee)
+				if idErr != nil {
+					return update, errors.New("invalid assignee ID")
+				}
+				update.AssignedTo = &assigneeID
+			}
+		default:
+			err = fmt.Errorf("field %q cannot be updated", field)
 		}
-	} else {
-		update = bson.M{
-			"$addToSet": bson.M{"votes.down": userID},
-			"$pull":     bson.M{"votes.up": userID},
+		if err != nil {
+			return update, err
 		}
 	}
-	
-	_, err := s.issueCollection.UpdateOne(ctx, bson.M{"_id": issueID}, update)
-	return err
+	return update, nil
+}
+
+func stringField(field string, value interface{}) (*string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("field %q must be a string", field)
+	}
+	return &s, nil
 }
 
-func (s *IssueService) AddComment(ctx context.Context, issueID primitive.ObjectID, comment *models.Comment) error {
+// decodeField converts a decoded JSON value to the field's type.
+func decodeField(field string, value, target interface{}) error {
+	data, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(data, target)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", field, err)
+	}
+	return nil
+}
+
+func (s *IssueService) VoteOnIssue(ctx context.Context, issueID primitive.ObjectID, userID primitive.ObjectID, voteType string) (err error) {
+	ctx, span := tracing.Start(ctx, "IssueService.VoteOnIssue", trace.WithAttributes(attribute.String("issue.id", issueID.Hex())))
+	defer tracing.End(span, &err)
+
+	return s.issues.Vote(ctx, issueID, userID, voteType == "up")
+}
+
+func (s *IssueService) AddComment(ctx context.Context, issueID primitive.ObjectID, comment *models.Comment) (err error) {
+	ctx, span := tracing.Start(ctx, "IssueService.AddComment", trace.WithAttributes(attribute.String("issue.id", issueID.Hex())))
+	defer tracing.End(span, &err)
+
 	comment.CreatedAt = time.Now()
 	comment.UpdatedAt = time.Now()
 	
-	_, err := s.issueCollection.UpdateOne(ctx, 
-		bson.M{"_id": issueID},
-		bson.M{"$push": bson.M{"comments": comment}},
-	)
-	return err
+	return s.issues.AddComment(ctx, issueID, comment)
 }
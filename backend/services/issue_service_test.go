@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestIssueService returns an issue service over store. Jurisdictions
+// are only resolved for issues with a location, so issues created without
+// one never reach the database.
+func newTestIssueService(store *repository.Store) *IssueService {
+	return NewIssueService(store, &JurisdictionService{issues: store.Issues})
+}
+
+func createTestUser(t *testing.T, store *repository.Store, role string, jurisdictionIDs ...primitive.ObjectID) *models.User {
+	t.Helper()
+	id := primitive.NewObjectID()
+	user := &models.User{
+		ID:              id,
+		Email:           id.Hex() + "@example.com",
+		Username:        id.Hex(),
+		Role:            role,
+		JurisdictionIDs: jurisdictionIDs,
+	}
+	if err := store.Users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func actorFor(user *models.User) Actor {
+	return Actor{ID: user.ID, Role: user.Role, JurisdictionIDs: user.JurisdictionIDs}
+}
+
+// errAny matches any error in table tests.
+var errAny = errors.New("any error")
+
+func TestUpdateIssuePermissions(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	issueService := newTestIssueService(store)
+
+	ward, otherWard := primitive.NewObjectID(), primitive.NewObjectID()
+	author := createTestUser(t, store, models.RoleCitizen)
+	neighbour := createTestUser(t, store, models.RoleCitizen)
+	moderator := createTestUser(t, store, models.RoleModerator)
+	official := createTestUser(t, store, models.RoleOfficial, ward)
+	outsider := createTestUser(t, store, models.RoleOfficial, otherWard)
+
+	issue := &models.Issue{ID: primitive.NewObjectID(), Title: "Broken streetlight", Status: "open", CreatedBy: author.ID}
+	if err := issueService.CreateIssue(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Issues.Update(ctx, issue.ID, repository.IssueUpdate{JurisdictionIDs: []primitive.ObjectID{ward}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		actor   *models.User
+		updates bson.M
+		wantErr error
+	}{
+		{"author edits title", author, bson.M{"title": "Streetlight out"}, nil},
+		{"neighbour edits title", neighbour, bson.M{"title": "Spam"}, ErrForbidden},
+		{"author changes status", author, bson.M{"status": "resolved"}, ErrForbidden},
+		{"moderator triages", moderator, bson.M{"category": "INFRASTRUCTURE", "priority": "high"}, nil},
+		{"moderator changes status", moderator, bson.M{"status": "resolved"}, ErrForbidden},
+		{"official changes status", official, bson.M{"status": "in_progress"}, nil},
+		{"official outside jurisdiction", outsider, bson.M{"status": "closed"}, ErrForbidden},
+		{"assign to citizen", official, bson.M{"assignedTo": neighbour.ID.Hex()}, errAny},
+		{"assign outside jurisdiction", official, bson.M{"assignedTo": outsider.ID.Hex()}, errAny},
+		{"assign to official", official, bson.M{"assignedTo": official.ID.Hex()}, nil},
+		{"server-owned field", author, bson.M{"createdBy": neighbour.ID.Hex()}, errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := issueService.UpdateIssue(ctx, actorFor(tt.actor), issue.ID, tt.updates)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("UpdateIssue = %v, want success", err)
+			case tt.wantErr == errAny && err == nil:
+				t.Error("UpdateIssue succeeded, want an error")
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Errorf("UpdateIssue = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	got, err := issueService.GetIssue(ctx, issue.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Streetlight out" || got.Status != "in_progress" || got.Category != "INFRASTRUCTURE" ||
+		got.AssignedTo != official.ID || got.CreatedBy != author.ID {
+		t.Errorf("issue after updates = %+v", got)
+	}
+}
+
+func TestCreateIssueCannotPreassign(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	issueService := newTestIssueService(store)
+
+	ward, otherWard := primitive.NewObjectID(), primitive.NewObjectID()
+	author := createTestUser(t, store, models.RoleCitizen)
+	official := createTestUser(t, store, models.RoleOfficial, ward)
+	outsider := createTestUser(t, store, models.RoleOfficial, otherWard)
+
+	// Fields the request doesn't hold are dropped even by a lenient decoder
+	var req CreateIssueRequest
+	body := `{"title":"Blocked drain","status":"resolved","assignedTo":"` + outsider.ID.Hex() + `","jurisdictionIds":["` + otherWard.Hex() + `"]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	issue := req.NewIssue(author.ID)
+	if err := issueService.CreateIssue(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Issues.Update(ctx, issue.ID, repository.IssueUpdate{JurisdictionIDs: []primitive.ObjectID{ward}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := issueService.GetIssue(ctx, issue.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "open" || !got.AssignedTo.IsZero() || got.CreatedBy != author.ID || got.CreatedAt.IsZero() {
+		t.Errorf("created issue = %+v, want an open, unassigned issue by the author", got)
+	}
+
+	// Assignment goes through the jurisdiction check
+	if err := issueService.UpdateIssue(ctx, actorFor(official), issue.ID, bson.M{"assignedTo": outsider.ID.Hex()}); err == nil {
+		t.Error("assigned to an official outside the issue's jurisdiction")
+	}
+	if err := issueService.UpdateIssue(ctx, actorFor(official), issue.ID, bson.M{"assignedTo": official.ID.Hex()}); err != nil {
+		t.Errorf("assigning to an official in the jurisdiction = %v", err)
+	}
+}
+
+func TestVoteAndComment(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	issueService := newTestIssueService(store)
+
+	voter := primitive.NewObjectID()
+	issue := &models.Issue{ID: primitive.NewObjectID(), Title: "Pothole", Status: "open", CreatedBy: primitive.NewObjectID()}
+	if err := issueService.CreateIssue(ctx, issue); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, voteType := range []string{"up", "up", "down"} {
+		if err := issueService.VoteOnIssue(ctx, issue.ID, voter, voteType); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comment := &models.Comment{Content: "Still there", CreatedBy: voter}
+	if err := issueService.AddComment(ctx, issue.ID, comment); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := issueService.GetIssue(ctx, issue.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Votes.Up) != 0 || len(got.Votes.Down) != 1 || got.Votes.Down[0] != voter {
+		t.Errorf("votes = %+v, want a single down vote", got.Votes)
+	}
+	if len(got.Comments) != 1 || got.Comments[0].Content != "Still there" || got.Comments[0].ID.IsZero() || got.Comments[0].CreatedAt.IsZero() {
+		t.Errorf("comments = %+v", got.Comments)
+	}
+
+	missing := primitive.NewObjectID()
+	if err := issueService.VoteOnIssue(ctx, missing, voter, "up"); err != repository.ErrNotFound {
+		t.Errorf("voting on a missing issue = %v, want ErrNotFound", err)
+	}
+	if err := issueService.AddComment(ctx, missing, &models.Comment{Content: "?"}); err != repository.ErrNotFound {
+		t.Errorf("commenting on a missing issue = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxJurisdictionDepth guards against cycles in the parent chain.
+const maxJurisdictionDepth = 8
+
+// backfillPageSize is how many issues BackfillIssues reads at a time.
+const backfillPageSize = 500
+
+type JurisdictionService struct {
+	jurisdictionCollection *mongo.Collection
+	issues                 repository.IssueRepository
+}
+
+func NewJurisdictionService(db *mongo.Database, store *repository.Store) *JurisdictionService {
+	return &JurisdictionService{
+		jurisdictionCollection: db.Collection("jurisdictions"),
+		issues:                 store.Issues,
+	}
+}
+
+// EnsureJurisdictionIndexes creates the geospatial index used to place
+// issues and the index used to scope issue queries.
+func EnsureJurisdictionIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("jurisdictions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "boundary", Value: "2dsphere"}}},
+		{Keys: bson.D{{Key: "parentId", Value: 1}}},
+		{
+			Keys: bson.D{{Key: "level", Value: 1}, {Key: "code", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"code": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create jurisdiction indexes: %v", err)
+	}
+
+	_, err = db.Collection("issues").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "jurisdictionIds", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create issue jurisdiction index: %v", err)
+	}
+	return nil
+}
+
+func (s *JurisdictionService) Create(ctx context.Context, jurisdiction *models.Jurisdiction) error {
+	if jurisdiction.Name == "" || jurisdiction.Level == "" {
+		return errors.New("name and level are required")
+	}
+	if jurisdiction.Boundary != nil && jurisdiction.Boundary.Type != "Polygon" && jurisdiction.Boundary.Type != "MultiPolygon" {
+		return errors.New("boundary must be a GeoJSON Polygon or MultiPolygon")
+	}
+	if jurisdiction.ParentID != nil {
+		if _, err := s.Get(ctx, *jurisdiction.ParentID); err != nil {
+			return errors.New("parent jurisdiction not found")
+		}
+	}
+
+	jurisdiction.ID = primitive.NewObjectID()
+	jurisdiction.CreatedAt = time.Now()
+	jurisdiction.UpdatedAt = time.Now()
+
+	if _, err := s.jurisdictionCollection.InsertOne(ctx, jurisdiction); err != nil {
+		return fmt.Errorf("failed to create jurisdiction: %v", err)
+	}
+	return nil
+}
+
+func (s *JurisdictionService) Get(ctx context.Context, id primitive.ObjectID) (*models.Jurisdiction, error) {
+	var jurisdiction models.Jurisdiction
+	err := s.jurisdictionCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&jurisdiction)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("jurisdiction not found")
+		}
+		return nil, err
+	}
+	return &jurisdiction, nil
+}
+
+// List returns jurisdictions, optionally of one level, without boundaries.
+func (s *JurisdictionService) List(ctx context.Context, level string) ([]models.Jurisdiction, error) {
+	filter := bson.M{}
+	if level != "" {
+		filter["level"] = level
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "level", Value: 1}, {Key: "name", Value: 1}}).
+		SetProjection(bson.M{"boundary": 0})
+	cursor, err := s.jurisdictionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jurisdictions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	jurisdictions := []models.Jurisdiction{}
+	if err := cursor.All(ctx, &jurisdictions); err != nil {
+		return nil, fmt.Errorf("failed to decode jurisdictions: %v", err)
+	}
+	return jurisdictions, nil
+}
+
+// Locate returns the jurisdictions whose boundary contains the point.
+func (s *JurisdictionService) Locate(ctx context.Context, lat, lng float64) ([]models.Jurisdiction, error) {
+	filter := bson.M{
+		"boundary": bson.M{
+			"$geoIntersects": bson.M{
+				"$geometry": bson.M{"type": "Point", "coordinates": []float64{lng, lat}},
+			},
+		},
+	}
+
+	cursor, err := s.jurisdictionCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"boundary": 0}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate jurisdictions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	jurisdictions := []models.Jurisdiction{}
+	if err := cursor.All(ctx, &jurisdictions); err != nil {
+		return nil, fmt.Errorf("failed to decode jurisdictions: %v", err)
+	}
+	return jurisdictions, nil
+}
+
+// Resolve returns the IDs of every jurisdiction containing the location,
+// including the ancestors of jurisdictions that have no boundary of their own.
+func (s *JurisdictionService) Resolve(ctx context.Context, location *models.Location) (_ []primitive.ObjectID, err error) {
+	ctx, span := tracing.Start(ctx, "JurisdictionService.Resolve")
+	defer tracing.End(span, &err)
+
+	if location == nil {
+		return nil, nil
+	}
+
+	matches, err := s.Locate(ctx, location.Lat, location.Lng)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	var ids []primitive.ObjectID
+	for _, match := range matches {
+		current := match
+		for depth := 0; depth < maxJurisdictionDepth; depth++ {
+			if !seen[current.ID] {
+				seen[current.ID] = true
+				ids = append(ids, current.ID)
+			}
+			if current.ParentID == nil || seen[*current.ParentID] {
+				break
+			}
+			parent, err := s.Get(ctx, *current.ParentID)
+			if err != nil {
+				break
+			}
+			current = *parent
+		}
+	}
+	return ids, nil
+}
+
+// BackfillIssues recomputes the jurisdictions of every issue with a
+// location, e.g. after boundaries are added or changed.
+func (s *JurisdictionService) BackfillIssues(ctx context.Context) (int, error) {
+	updated := 0
+	// Pages are in creation order, so issues filed meanwhile come last
+	query := repository.IssueQuery{HasLocation: true, Limit: backfillPageSize}
+	for {
+		page, err := s.issues.Search(ctx, query)
+		if err != nil {
+			return updated, fmt.Errorf("failed to list issues: %v", err)
+		}
+
+		for _, issue := range page.Issues {
+			ids, err := s.Resolve(ctx, issue.Location)
+			if err != nil {
+				return updated, err
+			}
+			if ids == nil {
+				ids = []primitive.ObjectID{}
+			}
+
+			if err := s.issues.Update(ctx, issue.ID, repository.IssueUpdate{JurisdictionIDs: ids}); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+
+		if len(page.Issues) < backfillPageSize {
+			return updated, nil
+		}
+		query.Skip += backfillPageSize
+	}
+}
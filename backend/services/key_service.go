@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	SigningAlgRS256 = "RS256"
+	SigningAlgEdDSA = "EdDSA"
+
+	keyRefreshInterval = time.Minute
+	// keyPublishLead is how long a new key is published before it signs, so
+	// every instance and JWKS cache has loaded it by then.
+	keyPublishLead = keyRefreshInterval
+)
+
+// KeyService manages the asymmetric keys access tokens are signed with. Keys
+// are shared between instances through the signing_keys collection, with the
+// private key encrypted using the configured secret. A new key is published
+// in the JWKS for keyPublishLead before it starts signing; older keys stay
+// published until tokens signed with them expire.
+type KeyService struct {
+	store            signingKeyStore
+	encryptionKey    []byte
+	algorithm        string
+	rotationInterval time.Duration
+	accessTokenTTL   time.Duration
+
+	mu   sync.RWMutex
+	keys []*signingKey
+}
+
+type signingKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	CreatedAt  time.Time
+	ActiveAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// storedSigningKey is the database form of a signing key. Keys stored before
+// activeAt existed have a zero ActiveAt and sign from creation.
+type storedSigningKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	KeyID      string             `bson:"kid"`
+	Algorithm  string             `bson:"alg"`
+	PrivateKey string             `bson:"privateKey"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	ActiveAt   time.Time          `bson:"activeAt,omitempty"`
+	ExpiresAt  time.Time          `bson:"expiresAt"`
+}
+
+// signingKeyStore shares signing keys between instances.
+type signingKeyStore interface {
+	createIndexes(ctx context.Context) error
+	insert(ctx context.Context, key *storedSigningKey) error
+	// unexpired returns the keys expiring after now, newest first.
+	unexpired(ctx context.Context, now time.Time) ([]storedSigningKey, error)
+}
+
+// JSONWebKey is a public key in JWKS form.
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewKeyService creates a key service. The JWT secret encrypts private keys
+// at rest.
+func NewKeyService(db *mongo.Database, cfg config.Auth) *KeyService {
+	encryptionKey := sha256.Sum256([]byte(cfg.JWTSecret))
+	return &KeyService{
+		store:            &mongoSigningKeyStore{keyCollection: db.Collection("signing_keys")},
+		encryptionKey:    encryptionKey[:],
+		algorithm:        cfg.SigningAlg,
+		rotationInterval: cfg.KeyRotation,
+		accessTokenTTL:   cfg.AccessTokenTTL,
+	}
+}
+
+// Init loads the keys and creates the first one if there are none.
+func (s *KeyService) Init(ctx context.Context) error {
+	if s.algorithm != SigningAlgRS256 && s.algorithm != SigningAlgEdDSA {
+		return fmt.Errorf("unsupported signing algorithm %q", s.algorithm)
+	}
+
+	if err := s.store.createIndexes(ctx); err != nil {
+		return fmt.Errorf("failed to create signing key indexes: %v", err)
+	}
+
+	return s.rotateIfDue(ctx)
+}
+
+// StartRotation periodically reloads keys written by other instances and
+// creates a new signing key when the current one is older than the rotation
+// interval. It returns when ctx is cancelled.
+func (s *KeyService) StartRotation(ctx context.Context) {
+	ticker := time.NewTicker(keyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.rotateIfDue(ctx); err != nil {
+				logging.FromContext(ctx).Error("signing key rotation failed", "error", err)
+			}
+		}
+	}
+}
+
+// Rotate creates a new signing key. It is published at once and signs after
+// keyPublishLead, or at once if there is no key yet.
+func (s *KeyService) Rotate(ctx context.Context) error {
+	activeAt := time.Now()
+	if s.current() != nil {
+		activeAt = activeAt.Add(keyPublishLead)
+	}
+	if err := s.createKey(ctx, activeAt); err != nil {
+		return err
+	}
+	return s.load(ctx)
+}
+
+func (s *KeyService) rotateIfDue(ctx context.Context) error {
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+
+	// A key waiting to sign counts, so rotation isn't repeated meanwhile
+	newest := s.newest()
+	if newest != nil && newest.Algorithm == s.algorithm && time.Since(newest.CreatedAt) < s.rotationInterval {
+		return nil
+	}
+	return s.Rotate(ctx)
+}
+
+func (s *KeyService) load(ctx context.Context) error {
+	stored, err := s.store.unexpired(ctx, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	keys := make([]*signingKey, 0, len(stored))
+	for _, k := range stored {
+		privateKey, err := s.decryptKey(k.PrivateKey)
+		if err != nil {
+			logging.FromContext(ctx).Warn("skipping signing key", "kid", k.KeyID, "error", err)
+			continue
+		}
+		keys = append(keys, &signingKey{
+			ID:         k.KeyID,
+			Algorithm:  k.Algorithm,
+			PrivateKey: privateKey,
+			CreatedAt:  k.CreatedAt,
+			ActiveAt:   k.ActiveAt,
+			ExpiresAt:  k.ExpiresAt,
+		})
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *KeyService) createKey(ctx context.Context, activeAt time.Time) error {
+	var privateKey crypto.Signer
+	var err error
+	switch s.algorithm {
+	case SigningAlgEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %v", err)
+	}
+
+	encrypted, err := s.encryptKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	kid, err := generateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	// A key signs for one rotation interval, give or take a refresh for the
+	// rotation check and the next key's lead, and must then remain published
+	// for as long as the tokens it signed can be used. Instances that have not
+	// reloaded yet may sign with it for one more refresh.
+	err = s.store.insert(ctx, &storedSigningKey{
+		ID:         primitive.NewObjectID(),
+		KeyID:      kid[:16],
+		Algorithm:  s.algorithm,
+		PrivateKey: encrypted,
+		CreatedAt:  time.Now(),
+		ActiveAt:   activeAt,
+		ExpiresAt:  activeAt.Add(s.rotationInterval + keyPublishLead + s.accessTokenTTL + 2*keyRefreshInterval),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store signing key: %v", err)
+	}
+	return nil
+}
+
+// current returns the newest key that has started signing, or the oldest
+// key if none has.
+func (s *KeyService) current() *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, key := range s.keys {
+		if !key.ActiveAt.After(now) {
+			return key
+		}
+	}
+	return s.keys[len(s.keys)-1]
+}
+
+// newest returns the newest key, which may not sign yet.
+func (s *KeyService) newest() *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return nil
+	}
+	return s.keys[0]
+}
+
+// Sign signs the claims with the current key.
+func (s *KeyService) Sign(claims jwt.Claims) (string, error) {
+	key := s.current()
+	if key == nil {
+		return "", errors.New("no signing key available")
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// Keyfunc resolves the verification key for a token by its kid header.
+func (s *KeyService) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.ID == kid {
+			if token.Method.Alg() != key.Algorithm {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return key.PrivateKey.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// Algorithms lists the algorithms tokens may be signed with.
+func (s *KeyService) Algorithms() []string {
+	return []string{SigningAlgRS256, SigningAlgEdDSA}
+}
+
+// JWKS returns the public keys partners can verify tokens with.
+func (s *KeyService) JWKS() JSONWebKeySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwk := JSONWebKey{Kid: key.ID, Alg: key.Algorithm, Use: "sig"}
+		switch public := key.PrivateKey.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func signingMethod(algorithm string) jwt.SigningMethod {
+	if algorithm == SigningAlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+func (s *KeyService) encryptKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signing key: %v", err)
+	}
+
+	gcm, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to encrypt signing key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, der, nil)), nil
+}
+
+func (s *KeyService) decryptKey(encoded string) (crypto.Signer, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted key is too short")
+	}
+	der, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt signing key, was the secret changed?")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return signer, nil
+}
+
+func (s *KeyService) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type mongoSigningKeyStore struct {
+	keyCollection *mongo.Collection
+}
+
+func (m *mongoSigningKeyStore) createIndexes(ctx context.Context) error {
+	_, err := m.keyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "kid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+func (m *mongoSigningKeyStore) insert(ctx context.Context, key *storedSigningKey) error {
+	_, err := m.keyCollection.InsertOne(ctx, key)
+	return err
+}
+
+func (m *mongoSigningKeyStore) unexpired(ctx context.Context, now time.Time) ([]storedSigningKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := m.keyCollection.Find(ctx, bson.M{"expiresAt": bson.M{"$gt": now}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stored []storedSigningKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode signing keys: %v", err)
+	}
+	return stored, nil
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memorySigningKeys keeps signing keys in memory for tests.
+type memorySigningKeys struct {
+	mu   sync.Mutex
+	keys []storedSigningKey
+}
+
+func (m *memorySigningKeys) createIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (m *memorySigningKeys) insert(ctx context.Context, key *storedSigningKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, *key)
+	return nil
+}
+
+func (m *memorySigningKeys) unexpired(ctx context.Context, now time.Time) ([]storedSigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := []storedSigningKey{}
+	for _, key := range m.keys {
+		if key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// activateAll lets the publish lead of every stored key pass.
+func (m *memorySigningKeys) activateAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.keys {
+		m.keys[i].ActiveAt = time.Now().Add(-time.Second)
+	}
+}
+
+// newStoredKeyService returns an initialised key service over store. Key
+// services sharing a store act as instances of one deployment.
+func newStoredKeyService(t *testing.T, store *memorySigningKeys, algorithm, secret string) *KeyService {
+	t.Helper()
+	encryptionKey := sha256.Sum256([]byte(secret))
+	s := &KeyService{
+		store:            store,
+		encryptionKey:    encryptionKey[:],
+		algorithm:        algorithm,
+		rotationInterval: 24 * time.Hour,
+		accessTokenTTL:   15 * time.Minute,
+	}
+	if err := s.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   primitive.NewObjectID().Hex(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+}
+
+// verify parses the token with the key service and returns its kid.
+func verify(s *KeyService, token string) (string, error) {
+	parsed, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, s.Keyfunc, jwt.WithValidMethods(s.Algorithms()))
+	if err != nil {
+		return "", err
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid, nil
+}
+
+func jwksKeyIDs(s *KeyService) []string {
+	var kids []string
+	for _, key := range s.JWKS().Keys {
+		kids = append(kids, key.Kid)
+	}
+	return kids
+}
+
+func TestKeyServiceSignAndVerify(t *testing.T) {
+	for _, tt := range []struct {
+		algorithm string
+		kty       string
+	}{
+		{SigningAlgRS256, "RSA"},
+		{SigningAlgEdDSA, "OKP"},
+	} {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			store := &memorySigningKeys{}
+			keyService := newStoredKeyService(t, store, tt.algorithm, "secret")
+
+			token, err := keyService.Sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			kid, err := verify(keyService, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			jwks := keyService.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != kid || jwks.Keys[0].Kty != tt.kty || jwks.Keys[0].Alg != tt.algorithm {
+				t.Errorf("JWKS = %+v, want the %s key %s", jwks, tt.kty, kid)
+			}
+
+			// Another instance loads the same key instead of creating one
+			other := newStoredKeyService(t, store, tt.algorithm, "secret")
+			if _, err := verify(other, token); err != nil {
+				t.Errorf("other instance rejected the token: %v", err)
+			}
+			if len(store.keys) != 1 {
+				t.Errorf("%d keys stored, want 1", len(store.keys))
+			}
+		})
+	}
+}
+
+func TestKeyRotationPublishesBeforeSigning(t *testing.T) {
+	ctx := context.Background()
+	store := &memorySigningKeys{}
+	rotating := newStoredKeyService(t, store, SigningAlgRS256, "secret")
+	other := newStoredKeyService(t, store, SigningAlgRS256, "secret")
+
+	before, err := rotating.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousKid, _ := verify(rotating, before)
+
+	if err := rotating.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if kids := jwksKeyIDs(rotating); len(kids) != 2 {
+		t.Fatalf("JWKS after rotation = %v, want the new and previous keys", kids)
+	}
+
+	// The new key is only published until every instance has loaded it
+	during, err := rotating.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := verify(rotating, during); kid != previousKid {
+		t.Errorf("signed with %s during the publish lead, want the previous key %s", kid, previousKid)
+	}
+	if err := rotating.rotateIfDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.keys) != 2 {
+		t.Errorf("%d keys stored, want no rotation while the new key waits", len(store.keys))
+	}
+
+	// Other instances refresh within the lead, then the new key signs
+	if err := other.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store.activateAll()
+	if err := rotating.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after, err := rotating.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKid, err := verify(other, after)
+	if err != nil {
+		t.Fatalf("other instance rejected a token from the new key: %v", err)
+	}
+	if newKid == previousKid {
+		t.Error("still signing with the previous key")
+	}
+
+	// The previous key stays published for the tokens it signed
+	for _, s := range []*KeyService{rotating, other} {
+		if kids := jwksKeyIDs(s); len(kids) != 2 || kids[0] != newKid || kids[1] != previousKid {
+			t.Errorf("JWKS = %v, want [%s %s]", kids, newKid, previousKid)
+		}
+		if _, err := verify(s, before); err != nil {
+			t.Errorf("token from the previous key rejected: %v", err)
+		}
+	}
+}
+
+func TestKeyfuncRejectsForeignKeys(t *testing.T) {
+	keyService := newStoredKeyService(t, &memorySigningKeys{}, SigningAlgRS256, "secret")
+	ownKid := keyService.current().ID
+
+	for _, tt := range []struct {
+		name      string
+		algorithm string
+		kid       string
+		wantErr   string
+	}{
+		{"unknown kid", SigningAlgRS256, "0123456789abcdef", "unknown signing key"},
+		{"missing kid", SigningAlgRS256, "", "unknown signing key"},
+		{"own kid, foreign key", SigningAlgRS256, ownKid, "signature is invalid"},
+		{"own kid, other algorithm", SigningAlgEdDSA, ownKid, "unexpected signing method"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			foreign := newStoredKeyService(t, &memorySigningKeys{}, tt.algorithm, "secret").current()
+			token := jwt.NewWithClaims(signingMethod(foreign.Algorithm), testClaims())
+			if tt.kid != "" {
+				token.Header["kid"] = tt.kid
+			}
+			signed, err := token.SignedString(foreign.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := verify(keyService, signed); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verify = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSigningKeyEncryption(t *testing.T) {
+	ctx := context.Background()
+	store := &memorySigningKeys{}
+	keyService := newStoredKeyService(t, store, SigningAlgEdDSA, "secret")
+	encrypted := store.keys[0].PrivateKey
+
+	decrypted, err := keyService.decryptKey(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !keyService.current().PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(decrypted.Public()) {
+		t.Error("decrypted key differs from the stored one")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flip := func(i int) string {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 1
+		return base64.StdEncoding.EncodeToString(tampered)
+	}
+	for name, ciphertext := range map[string]string{
+		"tampered ciphertext": flip(len(data) - 1),
+		"tampered nonce":      flip(0),
+		"truncated":           base64.StdEncoding.EncodeToString(data[:8]),
+		"not base64":          "%%%",
+	} {
+		if _, err := keyService.decryptKey(ciphertext); err == nil {
+			t.Errorf("%s decrypted", name)
+		}
+	}
+
+	// A different secret can't decrypt the key, and skips it when loading
+	otherSecret := newStoredKeyService(t, &memorySigningKeys{}, SigningAlgEdDSA, "another secret")
+	if _, err := otherSecret.decryptKey(encrypted); err == nil {
+		t.Error("key decrypted with another secret")
+	}
+	otherSecret.store = store
+	if err := otherSecret.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if key := otherSecret.current(); key != nil {
+		t.Errorf("loaded key %s it cannot decrypt", key.ID)
+	}
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Brute-force protection for logins. Wrong passwords and wrong second-factor
+// codes both count as failures. Failures are counted per account (by email,
+// whether or not it exists, so responses don't reveal which accounts exist)
+// and per IP. After a few account failures each attempt must wait
+// progressively longer; enough failures lock the account, for longer each
+// time it is locked again within a day.
+const (
+	loginDelayThreshold   = 3
+	loginDelayMax         = time.Minute
+	accountLockThreshold  = 10
+	ipLockThreshold       = 50
+	loginFailureWindow    = 15 * time.Minute
+	baseLockoutDuration   = 15 * time.Minute
+	maxLockoutDuration    = 24 * time.Hour
+	loginAttemptRetention = 24 * time.Hour
+)
+
+// LoginThrottledError is returned when a login is refused because of earlier
+// failures.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed login attempts, try again in %s", e.RetryAfter.Round(time.Minute))
+	}
+	return "too many failed login attempts, please wait before trying again"
+}
+
+func accountAttemptKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginDelay is how long an account must wait after its latest failure.
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayThreshold {
+		return 0
+	}
+	delay := time.Second << (failures - loginDelayThreshold)
+	if delay > loginDelayMax || delay <= 0 {
+		return loginDelayMax
+	}
+	return delay
+}
+
+func lockoutDuration(lockouts int) time.Duration {
+	if lockouts < 1 {
+		lockouts = 1
+	}
+	duration := baseLockoutDuration << (lockouts - 1)
+	if duration > maxLockoutDuration || duration <= 0 {
+		return maxLockoutDuration
+	}
+	return duration
+}
+
+// checkLoginAllowed refuses the attempt while the account or IP is locked or
+// the account's progressive delay has not passed.
+func (s *AuthService) checkLoginAllowed(ctx context.Context, email, ip string) error {
+	now := time.Now()
+
+	for _, key := range []string{ipAttemptKey(ip), accountAttemptKey(email)} {
+		attempts, err := s.loginAttempts.Get(ctx, key)
+		if err == repository.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+			return &LoginThrottledError{RetryAfter: attempts.LockedUntil.Sub(now), Locked: true}
+		}
+
+		if strings.HasPrefix(key, "account:") && now.Sub(attempts.LastFailureAt) < loginFailureWindow {
+			if wait := attempts.LastFailureAt.Add(loginDelay(attempts.Failures)).Sub(now); wait > 0 {
+				return &LoginThrottledError{RetryAfter: wait}
+			}
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a wrong password or code, locking the account or
+// IP when it crosses the threshold. user is nil when no account matched.
+func (s *AuthService) recordLoginFailure(ctx context.Context, email string, user *models.User, ip string) {
+	details := map[string]interface{}{"email": email}
+	entry := &models.AuditEntry{Action: AuditLoginFailed, IP: ip, Details: details}
+	if user != nil {
+		entry.TargetUserID = user.ID
+	}
+	s.recordSecurityEvent(ctx, entry)
+
+	if locked, until := s.countFailure(ctx, accountAttemptKey(email), accountLockThreshold, true); locked {
+		s.onAccountLocked(ctx, email, user, ip, until)
+	}
+
+	if ip == "" {
+		return
+	}
+	if locked, until := s.countFailure(ctx, ipAttemptKey(ip), ipLockThreshold, false); locked {
+		s.recordSecurityEvent(ctx, &models.AuditEntry{
+			Action:  AuditIPBlocked,
+			IP:      ip,
+			Details: map[string]interface{}{"lockedUntil": until},
+		})
+	}
+}
+
+// countFailure increments the failure count for key and locks it when it
+// reaches threshold. It reports whether this failure caused a lock.
+func (s *AuthService) countFailure(ctx context.Context, key string, threshold int, progressive bool) (bool, time.Time) {
+	now := time.Now()
+
+	attempts, err := s.loginAttempts.RecordFailure(ctx, key, now, loginFailureWindow, loginAttemptRetention)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to track login failure", "error", err)
+		return false, time.Time{}
+	}
+
+	if attempts.Failures < threshold {
+		return false, time.Time{}
+	}
+
+	duration := baseLockoutDuration
+	if progressive {
+		duration = lockoutDuration(attempts.Lockouts + 1)
+	}
+	until := now.Add(duration)
+
+	// Only the request that crosses the threshold applies the lock
+	locked, err := s.loginAttempts.Lock(ctx, key, threshold, until, loginAttemptRetention)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to lock after login failures", "error", err)
+		return false, time.Time{}
+	}
+	return locked, until
+}
+
+func (s *AuthService) onAccountLocked(ctx context.Context, email string, user *models.User, ip string, until time.Time) {
+	entry := &models.AuditEntry{
+		Action:  AuditAccountLocked,
+		IP:      ip,
+		Details: map[string]interface{}{"email": email, "lockedUntil": until},
+	}
+	if user == nil {
+		s.recordSecurityEvent(ctx, entry)
+		return
+	}
+
+	entry.TargetUserID = user.ID
+	s.recordSecurityEvent(ctx, entry)
+
+	if err := s.emailService.SendLockoutEmail(ctx, user.Email, user.Username, until); err != nil {
+		logging.FromContext(ctx).Error("failed to send lockout email", "target_user_id", user.ID.Hex(), "error", err)
+	}
+}
+
+// clearLoginFailures resets an account's failures after a successful login
+// or password reset.
+func (s *AuthService) clearLoginFailures(ctx context.Context, email string) error {
+	return s.loginAttempts.Delete(ctx, accountAttemptKey(email))
+}
+
+// UnlockAccount lifts a lockout and clears the account's failures.
+func (s *AuthService) UnlockAccount(ctx context.Context, actor Actor, userID primitive.ObjectID) error {
+	if !actor.Can(models.PermUnlockAccounts) {
+		return ErrForbidden
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.clearLoginFailures(ctx, user.Email); err != nil {
+		return fmt.Errorf("failed to unlock account: %v", err)
+	}
+
+	return s.auditService.Record(ctx, &models.AuditEntry{
+		Action:       AuditAccountUnlocked,
+		ActorID:      actor.ID,
+		TargetUserID: userID,
+		IP:           actor.IP,
+	})
+}
+
+// recordSecurityEvent writes to the audit log. Failures are only logged so
+// that they don't affect the login response.
+func (s *AuthService) recordSecurityEvent(ctx context.Context, entry *models.AuditEntry) {
+	if err := s.auditService.Record(ctx, entry); err != nil {
+		logging.FromContext(ctx).Error("failed to record security event", "action", entry.Action, "error", err)
+	}
+}
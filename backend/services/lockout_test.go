@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginDelayThreshold - 1, 0},
+		{loginDelayThreshold, time.Second},
+		{loginDelayThreshold + 1, 2 * time.Second},
+		{loginDelayThreshold + 5, 32 * time.Second},
+		{loginDelayThreshold + 6, loginDelayMax},
+		{100, loginDelayMax},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		lockouts int
+		want     time.Duration
+	}{
+		{0, baseLockoutDuration},
+		{1, baseLockoutDuration},
+		{2, 2 * baseLockoutDuration},
+		{3, 4 * baseLockoutDuration},
+		{7, 64 * baseLockoutDuration},
+		{8, maxLockoutDuration},
+		{100, maxLockoutDuration},
+	}
+	for _, tt := range tests {
+		if got := lockoutDuration(tt.lockouts); got != tt.want {
+			t.Errorf("lockoutDuration(%d) = %s, want %s", tt.lockouts, got, tt.want)
+		}
+	}
+}
+
+// login signs in with the password and returns only the error.
+func login(authService *AuthService, email, password, ip string) error {
+	_, err := authService.Login(context.Background(), email, password, ClientInfo{IP: ip})
+	return err
+}
+
+// wantThrottled fails the test unless err refuses the login for about
+// retryAfter.
+func wantThrottled(t *testing.T, err error, retryAfter time.Duration, locked bool) {
+	t.Helper()
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("login = %v, want LoginThrottledError", err)
+	}
+	if throttled.Locked != locked || throttled.RetryAfter > retryAfter || throttled.RetryAfter < retryAfter-time.Second {
+		t.Fatalf("login refused for %s (locked %v), want %s (locked %v)", throttled.RetryAfter, throttled.Locked, retryAfter, locked)
+	}
+}
+
+func TestProgressiveLoginDelay(t *testing.T) {
+	ctx := context.Background()
+	authService, attempts, _ := newThrottlingTestAuthService(t, repository.NewMemoryStore())
+	if _, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < loginDelayThreshold; i++ {
+		if err := login(authService, "amina@example.com", "wrong", "192.0.2.1"); err == nil || errors.As(err, new(*LoginThrottledError)) {
+			t.Fatalf("failure %d = %v, want a wrong password error", i+1, err)
+		}
+	}
+
+	// Each further failure doubles the wait, whatever the address
+	wantThrottled(t, login(authService, "amina@example.com", "correct horse", "198.51.100.1"), time.Second, false)
+	attempts.advance(time.Second)
+	if err := login(authService, "amina@example.com", "wrong", "192.0.2.1"); errors.As(err, new(*LoginThrottledError)) {
+		t.Fatalf("login after the delay = %v", err)
+	}
+	wantThrottled(t, login(authService, "amina@example.com", "correct horse", "192.0.2.1"), 2*time.Second, false)
+
+	// Other accounts are not slowed down
+	if err := login(authService, "other@example.com", "wrong", "192.0.2.1"); errors.As(err, new(*LoginThrottledError)) {
+		t.Errorf("login to another account = %v", err)
+	}
+
+	attempts.advance(2 * time.Second)
+	if err := login(authService, "amina@example.com", "correct horse", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := login(authService, "amina@example.com", "wrong", "192.0.2.1"); errors.As(err, new(*LoginThrottledError)) {
+		t.Errorf("failures not cleared by a successful login: %v", err)
+	}
+
+	// Failures older than the window are forgotten
+	for i := 0; i < loginDelayThreshold; i++ {
+		attempts.advance(loginDelayMax)
+		login(authService, "amina@example.com", "wrong", "192.0.2.1")
+	}
+	attempts.advance(loginFailureWindow)
+	if err := login(authService, "amina@example.com", "wrong", "192.0.2.1"); errors.As(err, new(*LoginThrottledError)) {
+		t.Errorf("login after the failure window = %v", err)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	authService, attempts, auditLog := newThrottlingTestAuthService(t, store)
+	user, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Failures a minute apart stay clear of the progressive delay
+	failLogins := func() {
+		t.Helper()
+		for i := 0; i < accountLockThreshold; i++ {
+			attempts.advance(loginDelayMax)
+			if err := login(authService, "amina@example.com", "wrong", "192.0.2.1"); errors.As(err, new(*LoginThrottledError)) {
+				t.Fatalf("failure %d = %v, want a wrong password error", i+1, err)
+			}
+		}
+	}
+	lockOut := func(want time.Duration) {
+		t.Helper()
+		failLogins()
+		wantThrottled(t, login(authService, "amina@example.com", "correct horse", "198.51.100.1"), want, true)
+		attempts.advance(want - time.Second)
+		wantThrottled(t, login(authService, "amina@example.com", "correct horse", "198.51.100.1"), time.Second, true)
+		attempts.advance(time.Second)
+	}
+
+	// Locking again within a day locks for longer
+	lockOut(baseLockoutDuration)
+	lockOut(2 * baseLockoutDuration)
+	lockOut(4 * baseLockoutDuration)
+
+	locks, err := (&AuditService{log: auditLog}).List(ctx, AuditFilter{Actions: []string{AuditAccountLocked}, TargetUserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 3 {
+		t.Errorf("%d lockouts audited, want 3", len(locks))
+	}
+
+	// An admin can lift a lockout early
+	failLogins()
+	if err := login(authService, "amina@example.com", "correct horse", "192.0.2.1"); !errors.As(err, new(*LoginThrottledError)) {
+		t.Fatalf("login while locked = %v", err)
+	}
+	citizen := Actor{ID: primitive.NewObjectID(), Role: models.RoleCitizen}
+	if err := authService.UnlockAccount(ctx, citizen, user.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("UnlockAccount by a citizen = %v, want ErrForbidden", err)
+	}
+	admin := Actor{ID: primitive.NewObjectID(), Role: models.RoleAdmin, IP: "203.0.113.1"}
+	if err := authService.UnlockAccount(ctx, admin, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := login(authService, "amina@example.com", "correct horse", "192.0.2.1"); err != nil {
+		t.Errorf("login after unlocking = %v", err)
+	}
+	unlocks, err := (&AuditService{log: auditLog}).List(ctx, AuditFilter{Actions: []string{AuditAccountUnlocked}, TargetUserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unlocks) != 1 || unlocks[0].ActorID != admin.ID {
+		t.Errorf("unlocks audited = %+v, want one by the admin", unlocks)
+	}
+}
+
+func TestIPLockout(t *testing.T) {
+	ctx := context.Background()
+	authService, attempts, auditLog := newThrottlingTestAuthService(t, repository.NewMemoryStore())
+	if _, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spreading guesses over many accounts avoids their delays but not the
+	// address's limit, which does not grow with repeated locks
+	for round := 0; round < 2; round++ {
+		for i := 0; i < ipLockThreshold; i++ {
+			email := fmt.Sprintf("guess%d-%d@example.com", round, i)
+			if err := login(authService, email, "wrong", "192.0.2.1"); errors.As(err, new(*LoginThrottledError)) {
+				t.Fatalf("failure %d = %v, want a wrong password error", i+1, err)
+			}
+		}
+		wantThrottled(t, login(authService, "amina@example.com", "correct horse", "192.0.2.1"), baseLockoutDuration, true)
+		if err := login(authService, "amina@example.com", "correct horse", "198.51.100.1"); err != nil {
+			t.Fatalf("login from another address = %v", err)
+		}
+		attempts.advance(baseLockoutDuration)
+	}
+
+	if err := login(authService, "amina@example.com", "correct horse", "192.0.2.1"); err != nil {
+		t.Errorf("login after the lock = %v", err)
+	}
+	blocks, err := (&AuditService{log: auditLog}).List(ctx, AuditFilter{Actions: []string{AuditIPBlocked}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].IP != "192.0.2.1" {
+		t.Errorf("IP blocks audited = %+v, want two for 192.0.2.1", blocks)
+	}
+}
+
+func TestMFAChallengeKeepsLoginFailures(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	authService, _, _ := newThrottlingTestAuthService(t, store)
+	user, secret, _ := enrollTestMFA(t, authService)
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	challenge := mfaChallenge(t, authService)
+	for i := 0; i < loginDelayThreshold-1; i++ {
+		if _, err := authService.VerifyMFA(ctx, challenge, wrongTOTPCode(t, secret), client); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("guess %d = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+
+	// The right password alone doesn't wipe out the wrong codes
+	challenge = mfaChallenge(t, authService)
+	attempts, err := store.LoginAttempts.Get(ctx, accountAttemptKey(user.Email))
+	if err != nil {
+		t.Fatalf("failures after a password login = %v, want them kept", err)
+	}
+	if attempts.Failures != loginDelayThreshold-1 {
+		t.Errorf("%d failures after a password login, want %d", attempts.Failures, loginDelayThreshold-1)
+	}
+
+	if _, err := authService.VerifyMFA(ctx, challenge, wrongTOTPCode(t, secret), client); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatal(err)
+	}
+	wantThrottled(t, login(authService, "amina@example.com", "correct horse", "192.0.2.1"), time.Second, false)
+}
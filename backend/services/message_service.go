@@ -0,0 +1,537 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits that keep citizens from flooding an office with messages.
+const (
+	conversationDailyLimit       = 5
+	messageHourlyLimit           = 20
+	maxUnansweredMessages        = 5
+	maxMessageLength             = 5000
+	maxAttachments               = 5
+	MaxAttachmentSize      int64 = 10 << 20
+)
+
+var allowedAttachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+// AttachmentUpload is a file to store with a message.
+type AttachmentUpload struct {
+	Filename    string
+	ContentType string
+	Size        int64
+	Content     io.Reader
+}
+
+type MessageService struct {
+	conversationCollection *mongo.Collection
+	messageCollection      *mongo.Collection
+	attachmentBucket       *gridfs.Bucket
+	representativeService  *RepresentativeService
+	issueService           *IssueService
+}
+
+func NewMessageService(db *mongo.Database, representativeService *RepresentativeService, issueService *IssueService) (*MessageService, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("attachments"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open attachment bucket: %v", err)
+	}
+
+	return &MessageService{
+		conversationCollection: db.Collection("conversations"),
+		messageCollection:      db.Collection("messages"),
+		attachmentBucket:       bucket,
+		representativeService:  representativeService,
+		issueService:           issueService,
+	}, nil
+}
+
+// EnsureMessageIndexes creates the indexes used to list conversations and
+// messages and to enforce the sending limits.
+func EnsureMessageIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("conversations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "citizenId", Value: 1}, {Key: "lastMessageAt", Value: -1}}},
+		{Keys: bson.D{{Key: "representativeId", Value: 1}, {Key: "lastMessageAt", Value: -1}}},
+		{Keys: bson.D{{Key: "citizenId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create conversation indexes: %v", err)
+	}
+
+	_, err = db.Collection("messages").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "conversationId", Value: 1}, {Key: "createdAt", Value: 1}}},
+		{Keys: bson.D{{Key: "senderId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create message indexes: %v", err)
+	}
+	return nil
+}
+
+// StartConversation opens a thread from a citizen to a verified
+// representative's office.
+func (s *MessageService) StartConversation(ctx context.Context, citizenID, representativeID primitive.ObjectID, subject, body string, uploads []AttachmentUpload) (*models.Conversation, *models.Message, error) {
+	subject = strings.TrimSpace(subject)
+	if subject == "" {
+		return nil, nil, errors.New("subject is required")
+	}
+
+	rep, err := s.representativeService.Get(ctx, representativeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rep.Verification.Status != models.VerificationVerified {
+		return nil, nil, errors.New("representative not found")
+	}
+	if rep.IsOffice(citizenID) {
+		return nil, nil, errors.New("you cannot message your own office")
+	}
+
+	started, err := s.conversationCollection.CountDocuments(ctx, bson.M{
+		"citizenId": citizenID,
+		"createdAt": bson.M{"$gte": time.Now().Add(-24 * time.Hour)},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if started >= conversationDailyLimit {
+		return nil, nil, ErrTooManyRequests
+	}
+	if err := s.checkSendRate(ctx, citizenID); err != nil {
+		return nil, nil, err
+	}
+	if err := validateMessage(body, uploads); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	conversation := &models.Conversation{
+		ID:               primitive.NewObjectID(),
+		CitizenID:        citizenID,
+		RepresentativeID: representativeID,
+		Subject:          subject,
+		Status:           models.ConversationOpen,
+		LastMessageAt:    now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if _, err := s.conversationCollection.InsertOne(ctx, conversation); err != nil {
+		return nil, nil, fmt.Errorf("failed to create conversation: %v", err)
+	}
+
+	message, err := s.insertMessage(ctx, conversation, citizenID, false, body, uploads)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conversation, message, nil
+}
+
+// ListConversations returns the threads the user takes part in, either as a
+// citizen or as a member of a representative's office.
+func (s *MessageService) ListConversations(ctx context.Context, userID primitive.ObjectID) ([]models.Conversation, error) {
+	officeIDs, err := s.representativeService.OfficesOf(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"$or": []bson.M{
+		{"citizenId": userID},
+		{"representativeId": bson.M{"$in": officeIDs}},
+	}}
+	opts := options.Find().SetSort(bson.D{{Key: "lastMessageAt", Value: -1}}).SetLimit(100)
+	cursor, err := s.conversationCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	conversations := []models.Conversation{}
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, fmt.Errorf("failed to decode conversations: %v", err)
+	}
+	return conversations, nil
+}
+
+// access loads a conversation the user takes part in and reports whether
+// they act for the representative's office.
+func (s *MessageService) access(ctx context.Context, userID, conversationID primitive.ObjectID) (*models.Conversation, bool, error) {
+	var conversation models.Conversation
+	err := s.conversationCollection.FindOne(ctx, bson.M{"_id": conversationID}).Decode(&conversation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, false, ErrConversationNotFound
+		}
+		return nil, false, err
+	}
+
+	if conversation.CitizenID == userID {
+		return &conversation, false, nil
+	}
+
+	rep, err := s.representativeService.Get(ctx, conversation.RepresentativeID)
+	if err != nil || !rep.IsOffice(userID) {
+		// Don't reveal that the conversation exists
+		return nil, false, ErrConversationNotFound
+	}
+	return &conversation, true, nil
+}
+
+func (s *MessageService) GetConversation(ctx context.Context, userID, conversationID primitive.ObjectID) (*models.Conversation, error) {
+	conversation, _, err := s.access(ctx, userID, conversationID)
+	return conversation, err
+}
+
+// Messages returns the thread and marks the other side's messages as read.
+func (s *MessageService) Messages(ctx context.Context, userID, conversationID primitive.ObjectID) ([]models.Message, error) {
+	conversation, office, err := s.access(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := s.messageCollection.Find(ctx, bson.M{"conversationId": conversation.ID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	messages := []models.Message{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, fmt.Errorf("failed to decode messages: %v", err)
+	}
+
+	if err := s.markRead(ctx, conversation, office); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// markRead records read receipts for the messages sent by the other side.
+func (s *MessageService) markRead(ctx context.Context, conversation *models.Conversation, office bool) error {
+	now := time.Now()
+	_, err := s.messageCollection.UpdateMany(ctx, bson.M{
+		"conversationId": conversation.ID,
+		"fromOffice":     !office,
+		"readAt":         bson.M{"$exists": false},
+	}, bson.M{"$set": bson.M{"readAt": now}})
+	if err != nil {
+		return fmt.Errorf("failed to mark messages read: %v", err)
+	}
+
+	field := "citizenReadAt"
+	if office {
+		field = "officeReadAt"
+	}
+	_, err = s.conversationCollection.UpdateOne(ctx, bson.M{"_id": conversation.ID}, bson.M{"$set": bson.M{field: now}})
+	return err
+}
+
+// SendMessage adds a message to an open conversation.
+func (s *MessageService) SendMessage(ctx context.Context, userID, conversationID primitive.ObjectID, body string, uploads []AttachmentUpload) (*models.Message, error) {
+	conversation, office, err := s.access(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Status != models.ConversationOpen {
+		return nil, errors.New("conversation is closed")
+	}
+
+	if !office {
+		if err := s.checkSendRate(ctx, userID); err != nil {
+			return nil, err
+		}
+		if err := s.checkUnanswered(ctx, conversation.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := validateMessage(body, uploads); err != nil {
+		return nil, err
+	}
+
+	return s.insertMessage(ctx, conversation, userID, office, body, uploads)
+}
+
+func (s *MessageService) checkSendRate(ctx context.Context, userID primitive.ObjectID) error {
+	sent, err := s.messageCollection.CountDocuments(ctx, bson.M{
+		"senderId":  userID,
+		"createdAt": bson.M{"$gte": time.Now().Add(-time.Hour)},
+	})
+	if err != nil {
+		return err
+	}
+	if sent >= messageHourlyLimit {
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+// checkUnanswered stops a citizen from sending more than a few messages in a
+// row before the office replies.
+func (s *MessageService) checkUnanswered(ctx context.Context, conversationID primitive.ObjectID) error {
+	filter := bson.M{"conversationId": conversationID}
+
+	var lastReply models.Message
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	err := s.messageCollection.FindOne(ctx, bson.M{"conversationId": conversationID, "fromOffice": true}, opts).Decode(&lastReply)
+	if err == nil {
+		filter["createdAt"] = bson.M{"$gt": lastReply.CreatedAt}
+	} else if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	filter["fromOffice"] = false
+	unanswered, err := s.messageCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if unanswered >= maxUnansweredMessages {
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+func validateMessage(body string, uploads []AttachmentUpload) error {
+	if strings.TrimSpace(body) == "" && len(uploads) == 0 {
+		return errors.New("message is empty")
+	}
+	if len(body) > maxMessageLength {
+		return fmt.Errorf("message is longer than %d characters", maxMessageLength)
+	}
+	if len(uploads) > maxAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", maxAttachments)
+	}
+	for _, upload := range uploads {
+		if upload.Size > MaxAttachmentSize {
+			return fmt.Errorf("%s is larger than %d MB", upload.Filename, MaxAttachmentSize>>20)
+		}
+		if !allowedAttachmentTypes[upload.ContentType] {
+			return fmt.Errorf("%s is not an allowed file type", upload.Filename)
+		}
+	}
+	return nil
+}
+
+func (s *MessageService) insertMessage(ctx context.Context, conversation *models.Conversation, senderID primitive.ObjectID, office bool, body string, uploads []AttachmentUpload) (*models.Message, error) {
+	message := &models.Message{
+		ID:             primitive.NewObjectID(),
+		ConversationID: conversation.ID,
+		SenderID:       senderID,
+		FromOffice:     office,
+		Body:           strings.TrimSpace(body),
+		CreatedAt:      time.Now(),
+	}
+
+	for _, upload := range uploads {
+		attachment, err := s.storeAttachment(message, upload)
+		if err != nil {
+			s.deleteAttachments(ctx, message.Attachments)
+			return nil, err
+		}
+		message.Attachments = append(message.Attachments, *attachment)
+	}
+
+	if _, err := s.messageCollection.InsertOne(ctx, message); err != nil {
+		s.deleteAttachments(ctx, message.Attachments)
+		return nil, fmt.Errorf("failed to send message: %v", err)
+	}
+
+	_, err := s.conversationCollection.UpdateOne(ctx, bson.M{"_id": conversation.ID}, bson.M{"$set": bson.M{
+		"lastMessageAt": message.CreatedAt,
+		"updatedAt":     message.CreatedAt,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (s *MessageService) storeAttachment(message *models.Message, upload AttachmentUpload) (*models.Attachment, error) {
+	attachment := &models.Attachment{
+		ID:          primitive.NewObjectID(),
+		Filename:    upload.Filename,
+		ContentType: upload.ContentType,
+	}
+
+	opts := options.GridFSUpload().SetMetadata(bson.M{
+		"conversationId": message.ConversationID,
+		"messageId":      message.ID,
+		"contentType":    upload.ContentType,
+	})
+	stream, err := s.attachmentBucket.OpenUploadStreamWithID(attachment.ID, upload.Filename, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %v", err)
+	}
+
+	// Enforce the size limit on the content rather than trusting the
+	// declared size
+	size, err := io.Copy(stream, io.LimitReader(upload.Content, MaxAttachmentSize+1))
+	if err != nil {
+		stream.Abort()
+		return nil, fmt.Errorf("failed to store attachment: %v", err)
+	}
+	if size > MaxAttachmentSize {
+		stream.Abort()
+		return nil, fmt.Errorf("%s is larger than %d MB", upload.Filename, MaxAttachmentSize>>20)
+	}
+	if err := stream.Close(); err != nil {
+		return nil, fmt.Errorf("failed to store attachment: %v", err)
+	}
+
+	attachment.Size = size
+	return attachment, nil
+}
+
+func (s *MessageService) deleteAttachments(ctx context.Context, attachments []models.Attachment) {
+	for _, attachment := range attachments {
+		s.attachmentBucket.DeleteContext(ctx, attachment.ID)
+	}
+}
+
+// OpenAttachment returns an attachment of a message in the conversation and
+// a reader for its content. The caller must close the reader.
+func (s *MessageService) OpenAttachment(ctx context.Context, userID, conversationID, attachmentID primitive.ObjectID) (*models.Attachment, io.ReadCloser, error) {
+	conversation, _, err := s.access(ctx, userID, conversationID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var message models.Message
+	err = s.messageCollection.FindOne(ctx, bson.M{
+		"conversationId":  conversation.ID,
+		"attachments._id": attachmentID,
+	}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errors.New("attachment not found")
+		}
+		return nil, nil, err
+	}
+
+	var attachment models.Attachment
+	for _, a := range message.Attachments {
+		if a.ID == attachmentID {
+			attachment = a
+		}
+	}
+
+	stream, err := s.attachmentBucket.OpenDownloadStream(attachmentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open attachment: %v", err)
+	}
+	return &attachment, stream, nil
+}
+
+// SetStatus closes or reopens a conversation. Either side may close it; only
+// the office may reopen it.
+func (s *MessageService) SetStatus(ctx context.Context, userID, conversationID primitive.ObjectID, status string) error {
+	_, office, err := s.access(ctx, userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	switch status {
+	case models.ConversationClosed:
+	case models.ConversationOpen:
+		if !office {
+			return ErrForbidden
+		}
+	default:
+		return fmt.Errorf("unknown conversation status %q", status)
+	}
+
+	_, err = s.conversationCollection.UpdateOne(ctx, bson.M{"_id": conversationID}, bson.M{"$set": bson.M{
+		"status":    status,
+		"updatedAt": time.Now(),
+	}})
+	return err
+}
+
+// ConvertToIssueRequest holds the issue fields the office may choose when
+// publishing a message. Everything else about the issue is set here.
+type ConvertToIssueRequest struct {
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Category    string           `json:"category"`
+	Location    *models.Location `json:"location,omitempty"`
+}
+
+// ConvertToIssue lets the office publish a message as a public issue. The
+// issue is created by the office member; the citizen's identity and any
+// attachments stay private. Title and description default to the
+// conversation subject and the message body.
+func (s *MessageService) ConvertToIssue(ctx context.Context, userID, conversationID, messageID primitive.ObjectID, req ConvertToIssueRequest) (*models.Issue, error) {
+	conversation, office, err := s.access(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if !office {
+		return nil, ErrForbidden
+	}
+	if conversation.IssueID != nil {
+		return nil, errors.New("conversation has already been converted to an issue")
+	}
+
+	var message models.Message
+	err = s.messageCollection.FindOne(ctx, bson.M{"_id": messageID, "conversationId": conversation.ID}).Decode(&message)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("message not found")
+		}
+		return nil, err
+	}
+
+	issue := &models.Issue{
+		ID:          primitive.NewObjectID(),
+		Title:       req.Title,
+		Description: req.Description,
+		Category:    req.Category,
+		Status:      "open",
+		Location:    req.Location,
+		CreatedBy:   userID,
+	}
+	if issue.Title == "" {
+		issue.Title = conversation.Subject
+	}
+	if issue.Description == "" {
+		issue.Description = message.Body
+	}
+
+	// Claim the conversation first so it can only be converted once
+	result, err := s.conversationCollection.UpdateOne(ctx,
+		bson.M{"_id": conversation.ID, "issueId": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"issueId": issue.ID, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, errors.New("conversation has already been converted to an issue")
+	}
+
+	if err := s.issueService.CreateIssue(ctx, issue); err != nil {
+		s.conversationCollection.UpdateOne(ctx, bson.M{"_id": conversation.ID}, bson.M{"$unset": bson.M{"issueId": ""}})
+		return nil, err
+	}
+	return issue, nil
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TokenPurposeMFAChallenge = "mfa_challenge"
+
+	mfaIssuer         = "Sautii"
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+var ErrInvalidMFACode = errors.New("invalid authentication code")
+
+// RoleRequiresMFA reports whether accounts with the role must use a second
+// factor. Every role above citizen does.
+func RoleRequiresMFA(role string) bool {
+	return models.NormalizeRole(role) != models.RoleCitizen
+}
+
+func (s *AuthService) mfaSetupRequired(user *models.User) bool {
+	return RoleRequiresMFA(user.Role) && !user.MFAEnabled()
+}
+
+// SetupMFA starts TOTP enrollment. The secret only takes effect once a code
+// generated from it is confirmed with ConfirmMFA.
+func (s *AuthService) SetupMFA(ctx context.Context, userID primitive.ObjectID) (*models.MFASetup, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled() {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.UpdateUser(ctx, userID, repository.UserUpdate{MFA: &models.MFASettings{PendingSecret: secret}}); err != nil {
+		return nil, err
+	}
+
+	return &models.MFASetup{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA once the user proves their authenticator works and
+// returns the recovery codes. The codes are only shown this once.
+func (s *AuthService) ConfirmMFA(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled() {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	if user.MFA == nil || user.MFA.PendingSecret == "" {
+		return nil, errors.New("two-factor setup has not been started")
+	}
+
+	step, ok := validateTOTP(user.MFA.PendingSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.UpdateUser(ctx, userID, repository.UserUpdate{MFA: &models.MFASettings{
+		Enabled:       true,
+		Secret:        user.MFA.PendingSecret,
+		RecoveryCodes: hashes,
+		LastUsedStep:  step,
+		EnabledAt:     &now,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyMFA exchanges the challenge token returned by Login and a TOTP or
+// recovery code for a new session.
+func (s *AuthService) VerifyMFA(ctx context.Context, challenge, code string, client ClientInfo) (*models.AuthTokens, error) {
+	stored, err := s.tokens.GetActionToken(ctx, hashToken(challenge), TokenPurposeMFAChallenge)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, errors.New("invalid or expired challenge")
+		}
+		return nil, err
+	}
+	if stored.UsedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, errors.New("invalid or expired challenge")
+	}
+
+	user, err := s.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords, so a
+	// known password doesn't buy unlimited guesses through new challenges
+	if err := s.checkLoginAllowed(ctx, user.Email, client.IP); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.recordLoginFailure(ctx, user.Email, user, client.IP)
+
+			// Too many wrong codes burn the challenge and force a new login
+			burn := stored.Attempts+1 >= mfaMaxAttempts
+			if updateErr := s.tokens.RecordActionTokenAttempt(ctx, stored.ID, burn, time.Now()); updateErr != nil {
+				return nil, updateErr
+			}
+		}
+		return nil, err
+	}
+
+	used, err := s.tokens.UseActionToken(ctx, stored.ID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, errors.New("invalid or expired challenge")
+	}
+
+	if err := s.clearLoginFailures(ctx, user.Email); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(ctx, user, primitive.NewObjectID(), client)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes.
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID primitive.ObjectID, code string) ([]string, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled() {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.UpdateUser(ctx, userID, repository.UserUpdate{RecoveryCodes: hashes}); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA turns off MFA for roles that do not require it.
+func (s *AuthService) DisableMFA(ctx context.Context, userID primitive.ObjectID, password, code string) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled() {
+		return errors.New("two-factor authentication is not enabled")
+	}
+	if RoleRequiresMFA(user.Role) {
+		return errors.New("two-factor authentication is required for your role")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return errors.New("password is incorrect")
+	}
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+
+	return s.UpdateUser(ctx, userID, repository.UserUpdate{RemoveMFA: true})
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Both are consumed atomically so they cannot be replayed.
+func (s *AuthService) verifySecondFactor(ctx context.Context, user *models.User, code string) error {
+	if !user.MFAEnabled() {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	if step, ok := validateTOTP(user.MFA.Secret, code, time.Now(), user.MFA.LastUsedStep); ok {
+		used, err := s.users.UseTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := s.users.UseRecoveryCode(ctx, user.ID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to generate recovery code: %v", err)
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))
+		codes = append(codes, raw[:4]+"-"+raw[4:])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// enrollTestMFA registers amina@example.com with MFA enabled and returns the
+// user, the TOTP secret and the recovery codes. The step of the code used to
+// enroll is in user.MFA.LastUsedStep.
+func enrollTestMFA(t *testing.T, authService *AuthService) (*models.User, string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	user, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup, err := authService.SetupMFA(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authService.ConfirmMFA(ctx, user.ID, wrongTOTPCode(t, setup.Secret)); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("ConfirmMFA with a wrong code = %v, want ErrInvalidMFACode", err)
+	}
+	recoveryCodes, err := authService.ConfirmMFA(ctx, user.ID, stepCode(t, setup.Secret, totpStep(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err = authService.GetUserByID(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	return user, setup.Secret, recoveryCodes
+}
+
+func stepCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totpCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// wrongTOTPCode returns a code that is not valid for secret now.
+func wrongTOTPCode(t *testing.T, secret string) string {
+	t.Helper()
+	for digit := '0'; digit <= '9'; digit++ {
+		code := strings.Repeat(string(digit), totpDigits)
+		if _, ok := validateTOTP(secret, code, time.Now(), 0); !ok {
+			return code
+		}
+	}
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+// mfaChallenge logs in with the password and returns the MFA challenge.
+func mfaChallenge(t *testing.T, authService *AuthService) string {
+	t.Helper()
+	result, err := authService.Login(context.Background(), "amina@example.com", "correct horse", ClientInfo{IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.MFARequired || result.MFAToken == "" || result.AuthTokens != nil {
+		t.Fatalf("login result = %+v, want only an MFA challenge", result)
+	}
+	return result.MFAToken
+}
+
+func TestVerifyMFA(t *testing.T) {
+	ctx := context.Background()
+	authService, _ := newTestAuthService(t, repository.NewMemoryStore())
+	user, secret, recoveryCodes := enrollTestMFA(t, authService)
+	enrolled, next := stepCode(t, secret, user.MFA.LastUsedStep), stepCode(t, secret, user.MFA.LastUsedStep+1)
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	// The code used to confirm enrollment can't be used again, the next one can
+	challenge := mfaChallenge(t, authService)
+	if _, err := authService.VerifyMFA(ctx, challenge, enrolled, client); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("VerifyMFA with the enrollment code = %v, want ErrInvalidMFACode", err)
+	}
+	tokens, err := authService.VerifyMFA(ctx, challenge, next, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("tokens = %+v", tokens)
+	}
+	if _, err := authService.VerifyMFA(ctx, challenge, next, client); err == nil {
+		t.Error("challenge used twice")
+	}
+
+	// Codes can't be replayed on a new challenge
+	challenge = mfaChallenge(t, authService)
+	if _, err := authService.VerifyMFA(ctx, challenge, next, client); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replayed code = %v, want ErrInvalidMFACode", err)
+	}
+
+	// Recovery codes work once, with or without the dash
+	if _, err := authService.VerifyMFA(ctx, challenge, strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", "")), client); err != nil {
+		t.Fatalf("VerifyMFA with a recovery code = %v", err)
+	}
+	challenge = mfaChallenge(t, authService)
+	if _, err := authService.VerifyMFA(ctx, challenge, recoveryCodes[0], client); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("reused recovery code = %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestMFAChallengeExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	authService, _ := newTestAuthService(t, store)
+	user, secret, _ := enrollTestMFA(t, authService)
+	next := stepCode(t, secret, user.MFA.LastUsedStep+1)
+
+	err := store.Tokens.CreateActionToken(ctx, &models.ActionToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Purpose:   TokenPurposeMFAChallenge,
+		TokenHash: hashToken("expired-challenge"),
+		ExpiresAt: time.Now().Add(-time.Second),
+		CreatedAt: time.Now().Add(-mfaChallengeTTL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, challenge := range []string{"expired-challenge", "unknown-challenge"} {
+		_, err := authService.VerifyMFA(ctx, challenge, next, ClientInfo{})
+		if err == nil || !strings.Contains(err.Error(), "invalid or expired challenge") {
+			t.Errorf("VerifyMFA(%s) = %v, want an invalid challenge error", challenge, err)
+		}
+	}
+}
+
+func TestMFAChallengeAttemptLimit(t *testing.T) {
+	ctx := context.Background()
+	authService, attempts, _ := newThrottlingTestAuthService(t, repository.NewMemoryStore())
+	user, secret, _ := enrollTestMFA(t, authService)
+	next := stepCode(t, secret, user.MFA.LastUsedStep+1)
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	challenge := mfaChallenge(t, authService)
+	for i := 0; i < mfaMaxAttempts; i++ {
+		// Wait out the progressive delay between guesses
+		attempts.advance(loginDelayMax)
+		if _, err := authService.VerifyMFA(ctx, challenge, wrongTOTPCode(t, secret), client); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("guess %d = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+
+	attempts.advance(loginDelayMax)
+	_, err := authService.VerifyMFA(ctx, challenge, next, client)
+	if err == nil || !strings.Contains(err.Error(), "invalid or expired challenge") {
+		t.Errorf("VerifyMFA after %d wrong codes = %v, want the challenge burnt", mfaMaxAttempts, err)
+	}
+}
+
+func TestMFAFailuresThrottleLogin(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	authService, attempts, auditLog := newThrottlingTestAuthService(t, store)
+	user, secret, _ := enrollTestMFA(t, authService)
+	next := stepCode(t, secret, user.MFA.LastUsedStep+1)
+	client := ClientInfo{IP: "192.0.2.1"}
+
+	challenge := mfaChallenge(t, authService)
+	for i := 0; i < loginDelayThreshold; i++ {
+		if _, err := authService.VerifyMFA(ctx, challenge, wrongTOTPCode(t, secret), client); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("guess %d = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+
+	// Even the right code has to wait out the delay
+	var throttled *LoginThrottledError
+	if _, err := authService.VerifyMFA(ctx, challenge, next, client); !errors.As(err, &throttled) {
+		t.Fatalf("VerifyMFA during the delay = %v, want LoginThrottledError", err)
+	}
+	failures, err := (&AuditService{log: auditLog}).List(ctx, AuditFilter{Actions: []string{AuditLoginFailed}, TargetUserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != loginDelayThreshold {
+		t.Errorf("%d login failures audited, want %d", len(failures), loginDelayThreshold)
+	}
+
+	attempts.advance(loginDelayMax)
+	if _, err := authService.VerifyMFA(ctx, challenge, next, client); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoginAttempts.Get(ctx, accountAttemptKey(user.Email)); err != repository.ErrNotFound {
+		t.Errorf("failures after a completed login = %v, want them cleared", err)
+	}
+}
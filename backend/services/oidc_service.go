@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// OIDCService implements the OpenID Connect authorization code flow with
+// PKCE for any number of configured identity providers.
+type OIDCService struct {
+	states      oidcStates
+	authService *AuthService
+	httpClient  *http.Client
+	providers   map[string]*oidcProvider
+	completeURL string
+}
+
+// oidcStates stores login state between the redirect and the callback.
+type oidcStates interface {
+	insert(ctx context.Context, state *oidcState) error
+	// take removes and returns the state, or returns nil if there is none.
+	take(ctx context.Context, stateHash, provider string) (*oidcState, error)
+}
+
+type oidcProvider struct {
+	config config.OIDCProvider
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+}
+
+type oidcDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// oidcState is stored between redirecting to the provider and the callback.
+type oidcState struct {
+	StateHash    string    `bson:"stateHash"`
+	Provider     string    `bson:"provider"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"codeVerifier"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewOIDCService creates the service for the given providers. Completed
+// logins are sent back to the frontend at appBaseURL.
+func NewOIDCService(db *mongo.Database, authService *AuthService, providers []config.OIDCProvider, appBaseURL string) *OIDCService {
+	s := &OIDCService{
+		states:      &mongoOIDCStates{stateCollection: db.Collection("oidc_states")},
+		authService: authService,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		providers:   make(map[string]*oidcProvider),
+		completeURL: strings.TrimRight(appBaseURL, "/") + "/auth/callback",
+	}
+	for _, provider := range providers {
+		s.providers[provider.Name] = &oidcProvider{config: provider}
+	}
+	return s
+}
+
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// AuthorizationURL starts a login and returns the provider URL to redirect
+// the browser to.
+func (s *OIDCService) AuthorizationURL(ctx context.Context, providerName string) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", errors.New("unknown identity provider")
+	}
+
+	discovery, err := s.discover(ctx, provider)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := generateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := generateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := generateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.states.insert(ctx, &oidcState{
+		StateHash:    hashToken(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store login state: %v", err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.config.ClientID)
+	params.Set("redirect_uri", provider.config.RedirectURL)
+	params.Set("scope", strings.Join(provider.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// HandleCallback completes a login from the provider's redirect.
+func (s *OIDCService) HandleCallback(ctx context.Context, providerName, state, code string, client ClientInfo) (*models.LoginResult, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, errors.New("unknown identity provider")
+	}
+
+	// The state is single use
+	stored, err := s.states.take(ctx, hashToken(state), providerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load login state: %v", err)
+	}
+	if stored == nil || time.Now().After(stored.ExpiresAt) {
+		return nil, errors.New("invalid or expired login state")
+	}
+
+	idToken, err := s.exchangeCode(ctx, provider, code, stored.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.validateIDToken(ctx, provider, idToken, stored.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := ExternalIdentity{Provider: providerName}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["preferred_username"].(string)
+	if identity.Name == "" {
+		identity.Name, _ = claims["name"].(string)
+	}
+	// Some providers encode email_verified as a string
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	identity.Email = strings.ToLower(identity.Email)
+
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return s.authService.LoginWithExternalIdentity(ctx, identity, client)
+}
+
+// CompletionURL is the frontend page the browser is sent back to after the
+// callback. Tokens travel in the URL fragment so they never reach server logs.
+func (s *OIDCService) CompletionURL(result *models.LoginResult, loginErr error) string {
+	values := url.Values{}
+	switch {
+	case loginErr != nil:
+		values.Set("error", loginErr.Error())
+	case result.MFARequired:
+		values.Set("mfaRequired", "true")
+		values.Set("mfaToken", result.MFAToken)
+	default:
+		values.Set("accessToken", result.AccessToken)
+		values.Set("refreshToken", result.RefreshToken)
+		if result.MFASetupRequired {
+			values.Set("mfaSetupRequired", "true")
+		}
+	}
+	return s.completeURL + "#" + values.Encode()
+}
+
+func (s *OIDCService) discover(ctx context.Context, provider *oidcProvider) (*oidcDiscovery, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.discovery != nil {
+		return provider.discovery, nil
+	}
+
+	issuer := strings.TrimRight(provider.config.Issuer, "/")
+	var discovery oidcDiscovery
+	if err := s.getJSON(ctx, issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover identity provider: %v", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("identity provider issuer mismatch: %s", discovery.Issuer)
+	}
+
+	provider.discovery = &discovery
+	return provider.discovery, nil
+}
+
+func (s *OIDCService) exchangeCode(ctx context.Context, provider *oidcProvider, code, verifier string) (string, error) {
+	discovery, err := s.discover(ctx, provider)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", provider.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// client_secret_basic is the default unless the provider only supports post
+	useBasic := len(discovery.TokenAuthMethods) == 0
+	for _, method := range discovery.TokenAuthMethods {
+		if method == "client_secret_basic" {
+			useBasic = true
+		}
+	}
+	if !useBasic || provider.config.ClientSecret == "" {
+		form.Set("client_id", provider.config.ClientID)
+		if provider.config.ClientSecret != "" {
+			form.Set("client_secret", provider.config.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic && provider.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(provider.config.ClientID), url.QueryEscape(provider.config.ClientSecret))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("identity provider rejected code: %s %s", response.Error, response.ErrorDescription)
+	}
+	if response.IDToken == "" {
+		return "", errors.New("identity provider did not return an ID token")
+	}
+	return response.IDToken, nil
+}
+
+func (s *OIDCService) validateIDToken(ctx context.Context, provider *oidcProvider, idToken, nonce string) (jwt.MapClaims, error) {
+	discovery, err := s.discover(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.signingKey(ctx, provider, discovery.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(discovery.Issuer),
+		jwt.WithAudience(provider.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider key with the given ID, refetching the key
+// set once if the key is unknown so provider key rotation is picked up.
+func (s *OIDCService) signingKey(ctx context.Context, provider *oidcProvider, jwksURI, kid string) (interface{}, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if key, ok := provider.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := s.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	provider.keys = keys
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func (s *OIDCService) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type mongoOIDCStates struct {
+	stateCollection *mongo.Collection
+}
+
+func (m *mongoOIDCStates) insert(ctx context.Context, state *oidcState) error {
+	_, err := m.stateCollection.InsertOne(ctx, state)
+	return err
+}
+
+func (m *mongoOIDCStates) take(ctx context.Context, stateHash, provider string) (*oidcState, error) {
+	var state oidcState
+	err := m.stateCollection.FindOneAndDelete(ctx, bson.M{
+		"stateHash": stateHash,
+		"provider":  provider,
+	}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
@@ -0,0 +1,491 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// memoryOIDCStates keeps login state in memory for tests.
+type memoryOIDCStates struct {
+	mu     sync.Mutex
+	states map[string]oidcState
+}
+
+func (m *memoryOIDCStates) insert(ctx context.Context, state *oidcState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states == nil {
+		m.states = make(map[string]oidcState)
+	}
+	m.states[state.Provider+"/"+state.StateHash] = *state
+	return nil
+}
+
+func (m *memoryOIDCStates) take(ctx context.Context, stateHash, provider string) (*oidcState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[provider+"/"+stateHash]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.states, provider+"/"+stateHash)
+	return &state, nil
+}
+
+// expireAll backdates every stored state past its expiry.
+func (m *memoryOIDCStates) expireAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, state := range m.states {
+		state.ExpiresAt = time.Now().Add(-time.Minute)
+		m.states[key] = state
+	}
+}
+
+const (
+	mockClientID     = "sautii-web"
+	mockClientSecret = "s3cret"
+	mockRedirectURL  = "https://sautii.example/api/auth/oidc/mock/callback"
+)
+
+// mockProvider is an OpenID provider serving discovery, a key set and a token
+// endpoint that enforces PKCE. Authorizations are granted directly by the
+// test instead of through a login page.
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu     sync.Mutex
+	key    *rsa.PrivateKey
+	kid    string
+	issuer string
+	codes  map[string]mockGrant
+	// tamper edits the claims, and may replace the signing key, of the
+	// next ID token.
+	tamper func(claims jwt.MapClaims) (key *rsa.PrivateKey, kid string)
+	// discoveryStatus, if set, is returned instead of the discovery document.
+	discoveryStatus int
+}
+
+type mockGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+	email     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	p := &mockProvider{t: t, kid: "mock-1", codes: make(map[string]mockGrant)}
+	p.key = newRSAKey(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	mux.HandleFunc("/token", p.handleToken)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	p.issuer = p.server.URL
+	return p
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (p *mockProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discoveryStatus != 0 {
+		w.WriteHeader(p.discoveryStatus)
+		return
+	}
+	json.NewEncoder(w).Encode(oidcDiscovery{
+		Issuer:                p.issuer,
+		AuthorizationEndpoint: p.server.URL + "/authorize",
+		TokenEndpoint:         p.server.URL + "/token",
+		JWKSURI:               p.server.URL + "/jwks",
+		TokenAuthMethods:      []string{"client_secret_basic"},
+	})
+}
+
+func (p *mockProvider) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+		Kid: p.kid,
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+func (p *mockProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != mockClientID || secret != mockClientSecret {
+		fail("invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != mockRedirectURL {
+		fail("invalid_request")
+		return
+	}
+
+	// Codes are single use
+	grant, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	if !ok {
+		fail("invalid_grant")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.issuer,
+		"aud":            mockClientID,
+		"sub":            grant.subject,
+		"email":          grant.email,
+		"email_verified": true,
+		"nonce":          grant.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+	key, kid := p.key, p.kid
+	if p.tamper != nil {
+		if tamperedKey, tamperedKid := p.tamper(claims); tamperedKey != nil {
+			key, kid = tamperedKey, tamperedKid
+		}
+		p.tamper = nil
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		p.t.Error(err)
+		fail("server_error")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// authorize plays the user logging in at the provider for the given
+// authorization URL and returns the state and code the browser would bring
+// back to the callback.
+func (p *mockProvider) authorize(authURL, subject, email string) (state, code string) {
+	p.t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("client_id") != mockClientID || query.Get("redirect_uri") != mockRedirectURL {
+		p.t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		p.t.Fatalf("authorization URL without a PKCE challenge: %s", authURL)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	code = "code-" + query.Get("state")
+	p.codes[code] = mockGrant{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		subject:   subject,
+		email:     email,
+	}
+	return query.Get("state"), code
+}
+
+// newTestOIDCService returns a service logging in through the mock provider
+// under the name "mock", with login state kept in memory.
+func newTestOIDCService(t *testing.T, provider *mockProvider) (*OIDCService, *memoryOIDCStates, *repository.Store) {
+	t.Helper()
+	store := repository.NewMemoryStore()
+	authService, _ := newTestAuthService(t, store)
+	states := &memoryOIDCStates{}
+	return &OIDCService{
+		states:      states,
+		authService: authService,
+		httpClient:  provider.server.Client(),
+		providers: map[string]*oidcProvider{"mock": {config: config.OIDCProvider{
+			Name:         "mock",
+			Issuer:       provider.server.URL,
+			ClientID:     mockClientID,
+			ClientSecret: mockClientSecret,
+			RedirectURL:  mockRedirectURL,
+			Scopes:       []string{"openid", "email"},
+		}}},
+		completeURL: "https://sautii.example/auth/callback",
+	}, states, store
+}
+
+func TestOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	provider := newMockProvider(t)
+	oidcService, _, store := newTestOIDCService(t, provider)
+
+	authURL, err := oidcService.AuthorizationURL(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := provider.authorize(authURL, "user-1", "Amina@Example.com")
+	result, err := oidcService.HandleCallback(ctx, "mock", state, code, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AuthTokens == nil {
+		t.Fatalf("login result = %+v, want tokens", result)
+	}
+
+	user, err := store.Users.GetByIdentity(ctx, "mock", "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "amina@example.com" || !user.IsVerified {
+		t.Errorf("created user = %+v", user)
+	}
+	claims, err := oidcService.authService.VerifyAccessToken(result.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID.Hex() {
+		t.Errorf("access token for %s, want %s", claims.UserID, user.ID.Hex())
+	}
+
+	// Logging in again signs in the same account, even after the provider
+	// rotates its signing key
+	provider.mu.Lock()
+	provider.key, provider.kid = newRSAKey(t), "mock-2"
+	provider.mu.Unlock()
+	authURL, err = oidcService.AuthorizationURL(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code = provider.authorize(authURL, "user-1", "amina@example.com")
+	result, err = oidcService.HandleCallback(ctx, "mock", state, code, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err = oidcService.authService.VerifyAccessToken(result.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID.Hex() {
+		t.Errorf("second login signed in %s, want %s", claims.UserID, user.ID.Hex())
+	}
+}
+
+func TestOIDCDiscoveryRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(p *mockProvider)
+		wantErr string
+	}{
+		{"provider unavailable", func(p *mockProvider) { p.discoveryStatus = http.StatusInternalServerError }, "failed to discover"},
+		{"issuer mismatch", func(p *mockProvider) { p.issuer = "https://evil.example" }, "issuer mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := newMockProvider(t)
+			tt.setup(provider)
+			oidcService, _, _ := newTestOIDCService(t, provider)
+
+			_, err := oidcService.AuthorizationURL(context.Background(), "mock")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("AuthorizationURL = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	oidcService, _, _ := newTestOIDCService(t, newMockProvider(t))
+	if _, err := oidcService.AuthorizationURL(context.Background(), "other"); err == nil {
+		t.Error("started a login at an unknown provider")
+	}
+}
+
+func TestOIDCStateRejected(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unknown state", func(t *testing.T) {
+		provider := newMockProvider(t)
+		oidcService, _, _ := newTestOIDCService(t, provider)
+		authURL, err := oidcService.AuthorizationURL(ctx, "mock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, code := provider.authorize(authURL, "user-1", "amina@example.com")
+		if _, err := oidcService.HandleCallback(ctx, "mock", "forged", code, ClientInfo{}); err == nil || !strings.Contains(err.Error(), "login state") {
+			t.Errorf("HandleCallback = %v, want a login state error", err)
+		}
+	})
+
+	t.Run("reused state", func(t *testing.T) {
+		provider := newMockProvider(t)
+		oidcService, _, _ := newTestOIDCService(t, provider)
+		authURL, err := oidcService.AuthorizationURL(ctx, "mock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, code := provider.authorize(authURL, "user-1", "amina@example.com")
+		if _, err := oidcService.HandleCallback(ctx, "mock", state, code, ClientInfo{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := oidcService.HandleCallback(ctx, "mock", state, code, ClientInfo{}); err == nil || !strings.Contains(err.Error(), "login state") {
+			t.Errorf("replayed callback = %v, want a login state error", err)
+		}
+	})
+
+	t.Run("expired state", func(t *testing.T) {
+		provider := newMockProvider(t)
+		oidcService, states, _ := newTestOIDCService(t, provider)
+		authURL, err := oidcService.AuthorizationURL(ctx, "mock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, code := provider.authorize(authURL, "user-1", "amina@example.com")
+		states.expireAll()
+		if _, err := oidcService.HandleCallback(ctx, "mock", state, code, ClientInfo{}); err == nil || !strings.Contains(err.Error(), "login state") {
+			t.Errorf("HandleCallback = %v, want a login state error", err)
+		}
+	})
+
+	t.Run("state from another provider", func(t *testing.T) {
+		provider := newMockProvider(t)
+		oidcService, _, _ := newTestOIDCService(t, provider)
+		oidcService.providers["other"] = &oidcProvider{config: oidcService.providers["mock"].config}
+		authURL, err := oidcService.AuthorizationURL(ctx, "mock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, code := provider.authorize(authURL, "user-1", "amina@example.com")
+		if _, err := oidcService.HandleCallback(ctx, "other", state, code, ClientInfo{}); err == nil || !strings.Contains(err.Error(), "login state") {
+			t.Errorf("HandleCallback = %v, want a login state error", err)
+		}
+	})
+}
+
+func TestOIDCPKCERejected(t *testing.T) {
+	ctx := context.Background()
+	provider := newMockProvider(t)
+	oidcService, _, store := newTestOIDCService(t, provider)
+
+	// An attacker's code injected into the victim's callback was issued for
+	// the attacker's challenge, so the victim's verifier does not match it
+	attackerURL, err := oidcService.AuthorizationURL(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, attackerCode := provider.authorize(attackerURL, "attacker", "attacker@example.com")
+	victimURL, err := oidcService.AuthorizationURL(ctx, "mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	victimState, _ := provider.authorize(victimURL, "victim", "victim@example.com")
+
+	_, err = oidcService.HandleCallback(ctx, "mock", victimState, attackerCode, ClientInfo{})
+	if err == nil || !strings.Contains(err.Error(), "rejected code") {
+		t.Errorf("HandleCallback = %v, want the code rejected", err)
+	}
+	if _, err := store.Users.GetByIdentity(ctx, "mock", "attacker"); err != repository.ErrNotFound {
+		t.Errorf("attacker identity looked up with %v, want ErrNotFound", err)
+	}
+}
+
+func TestOIDCIDTokenRejected(t *testing.T) {
+	otherKey := newRSAKey(t)
+	tests := []struct {
+		name    string
+		tamper  func(claims jwt.MapClaims) (*rsa.PrivateKey, string)
+		wantErr string
+	}{
+		{"wrong issuer", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			claims["iss"] = "https://evil.example"
+			return nil, ""
+		}, "invalid issuer"},
+		{"wrong audience", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			claims["aud"] = "another-client"
+			return nil, ""
+		}, "invalid audience"},
+		{"wrong nonce", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			claims["nonce"] = "replayed"
+			return nil, ""
+		}, "nonce mismatch"},
+		{"missing nonce", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			delete(claims, "nonce")
+			return nil, ""
+		}, "nonce mismatch"},
+		{"expired", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return nil, ""
+		}, "expired"},
+		{"no expiry", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			delete(claims, "exp")
+			return nil, ""
+		}, "exp claim is required"},
+		{"forged signature", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			return otherKey, "mock-1"
+		}, "signature is invalid"},
+		{"unknown key", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			return otherKey, "unknown"
+		}, "unknown signing key"},
+		{"no subject", func(claims jwt.MapClaims) (*rsa.PrivateKey, string) {
+			delete(claims, "sub")
+			return nil, ""
+		}, "no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			provider := newMockProvider(t)
+			oidcService, _, _ := newTestOIDCService(t, provider)
+
+			authURL, err := oidcService.AuthorizationURL(ctx, "mock")
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, code := provider.authorize(authURL, "user-1", "amina@example.com")
+			provider.tamper = tt.tamper
+			_, err = oidcService.HandleCallback(ctx, "mock", state, code, ClientInfo{})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("HandleCallback = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
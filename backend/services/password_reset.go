@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	TokenPurposePasswordReset = "password_reset"
+
+	passwordResetTokenTTL = 30 * time.Minute
+	passwordResetWait     = time.Minute
+	minPasswordLength     = 8
+)
+
+// ForgotPassword emails a password reset link if the email belongs to an
+// account. It returns nil whether or not the account exists so callers cannot
+// use it to discover registered addresses; failures are only logged.
+func (s *AuthService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		if err != repository.ErrNotFound {
+			logging.FromContext(ctx).Error("failed to look up user for password reset", "error", err)
+		}
+		return nil
+	}
+
+	// Silently drop repeated requests for the same account
+	recent, err := s.tokens.CountActionTokens(ctx, user.ID, TokenPurposePasswordReset, time.Now().Add(-passwordResetWait))
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to check password reset throttle", "target_user_id", user.ID.Hex(), "error", err)
+		return nil
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	token, err := s.issueActionToken(ctx, user.ID, TokenPurposePasswordReset, passwordResetTokenTTL)
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to issue password reset link", "target_user_id", user.ID.Hex(), "error", err)
+		return nil
+	}
+
+	if err := s.emailService.SendPasswordResetEmail(ctx, user.Email, user.Username, token); err != nil {
+		logging.FromContext(ctx).Error("failed to send password reset email", "target_user_id", user.ID.Hex(), "error", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. All of
+// the user's refresh tokens and outstanding reset tokens are invalidated.
+func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if len(newPassword) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	stored, err := s.consumeActionToken(ctx, token, TokenPurposePasswordReset)
+	if err != nil {
+		return errors.New("invalid or expired reset token")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+
+	password := string(hashedPassword)
+	if err := s.UpdateUser(ctx, stored.UserID, repository.UserUpdate{Password: &password}); err != nil {
+		return err
+	}
+
+	// Log out every session
+	if err := s.revokeTokens(ctx, repository.RefreshTokenFilter{UserID: stored.UserID}); err != nil {
+		return err
+	}
+
+	// Proving control of the mailbox lifts any lockout
+	user, err := s.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return err
+	}
+	if err := s.clearLoginFailures(ctx, user.Email); err != nil {
+		return err
+	}
+
+	return s.tokens.UseActionTokens(ctx, stored.UserID, TokenPurposePasswordReset, time.Now())
+}
@@ -0,0 +1,689 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/metrics"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	exportRetention     = 7 * 24 * time.Hour
+	exportRequestWait   = 24 * time.Hour
+	exportTimeout       = 10 * time.Minute
+	exportSweepInterval = time.Hour
+
+	// exportPageSize is how many issues an export reads at a time
+	exportPageSize = 500
+
+	TokenPurposeAccountDeletion = "account_deletion"
+
+	accountDeletionTokenTTL = 30 * time.Minute
+	accountDeletionWait     = time.Minute
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	// ErrDeletionConfirmationSent is returned when an account without a
+	// password asks to be deleted; the link in the email confirms it.
+	ErrDeletionConfirmationSent = errors.New("a link to confirm the deletion has been sent to your email address")
+)
+
+// PrivacyService implements data subject requests: exporting a user's
+// personal data and erasing their account. Users, tokens and issues are read
+// through the store; messaging, representatives and the exports themselves
+// live in MongoDB.
+type PrivacyService struct {
+	users                    repository.UserRepository
+	tokens                   repository.TokenRepository
+	loginAttempts            repository.LoginAttemptRepository
+	issues                   repository.IssueRepository
+	conversationCollection   *mongo.Collection
+	messageCollection        *mongo.Collection
+	representativeCollection *mongo.Collection
+	exportCollection         *mongo.Collection
+	exportBucket             *gridfs.Bucket
+	authService              *AuthService
+	emailService             *EmailService
+	auditService             *AuditService
+
+	// exports counts archives being generated
+	exports sync.WaitGroup
+}
+
+func NewPrivacyService(db *mongo.Database, store *repository.Store, authService *AuthService, emailService *EmailService, auditService *AuditService) (*PrivacyService, error) {
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("exports"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export bucket: %v", err)
+	}
+
+	return &PrivacyService{
+		users:                    store.Users,
+		tokens:                   store.Tokens,
+		loginAttempts:            store.LoginAttempts,
+		issues:                   store.Issues,
+		conversationCollection:   db.Collection("conversations"),
+		messageCollection:        db.Collection("messages"),
+		representativeCollection: db.Collection("representatives"),
+		exportCollection:         db.Collection("data_exports"),
+		exportBucket:             bucket,
+		authService:              authService,
+		emailService:             emailService,
+		auditService:             auditService,
+	}, nil
+}
+
+func EnsurePrivacyIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("data_exports").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "requestedAt", Value: -1}}},
+		{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create data export indexes: %v", err)
+	}
+
+	// Used to find a user's comments and votes
+	_, err = db.Collection("issues").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "createdBy", Value: 1}}},
+		{Keys: bson.D{{Key: "comments.createdBy", Value: 1}}},
+		{Keys: bson.D{{Key: "votes.up", Value: 1}}},
+		{Keys: bson.D{{Key: "votes.down", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create issue author indexes: %v", err)
+	}
+	return nil
+}
+
+// RequestExport queues an export of the user's data. The archive is built in
+// the background; the user is emailed when it is ready.
+func (s *PrivacyService) RequestExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error) {
+	recent, err := s.exportCollection.CountDocuments(ctx, bson.M{
+		"userId":      userID,
+		"status":      bson.M{"$ne": models.ExportFailed},
+		"requestedAt": bson.M{"$gte": time.Now().Add(-exportRequestWait)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if recent > 0 {
+		return nil, ErrTooManyRequests
+	}
+
+	export := &models.DataExport{
+		ID:          primitive.NewObjectID(),
+		UserID:      userID,
+		Status:      models.ExportPending,
+		RequestedAt: time.Now(),
+	}
+	if _, err := s.exportCollection.InsertOne(ctx, export); err != nil {
+		return nil, fmt.Errorf("failed to create export: %v", err)
+	}
+
+	s.exports.Add(1)
+	finished := metrics.JobQueued("data_export")
+	go func() {
+		defer s.exports.Done()
+		defer finished()
+		s.generateExport(ctx, export.ID, userID)
+	}()
+	return export, nil
+}
+
+func (s *PrivacyService) ListExports(ctx context.Context, userID primitive.ObjectID) ([]models.DataExport, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "requestedAt", Value: -1}})
+	cursor, err := s.exportCollection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	exports := []models.DataExport{}
+	if err := cursor.All(ctx, &exports); err != nil {
+		return nil, fmt.Errorf("failed to decode exports: %v", err)
+	}
+	return exports, nil
+}
+
+func (s *PrivacyService) GetExport(ctx context.Context, userID, id primitive.ObjectID) (*models.DataExport, error) {
+	var export models.DataExport
+	err := s.exportCollection.FindOne(ctx, bson.M{"_id": id, "userId": userID}).Decode(&export)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	return &export, nil
+}
+
+// OpenExport returns a reader for a ready export archive. The caller must
+// close it.
+func (s *PrivacyService) OpenExport(ctx context.Context, userID, id primitive.ObjectID) (*models.DataExport, io.ReadCloser, error) {
+	export, err := s.GetExport(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != models.ExportReady || export.FileID == nil {
+		return nil, nil, errors.New("export is not ready")
+	}
+
+	stream, err := s.exportBucket.OpenDownloadStream(*export.FileID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open export: %v", err)
+	}
+	return export, stream, nil
+}
+
+// generateExport runs after the request has been answered; ctx only carries
+// the request's logger.
+func (s *PrivacyService) generateExport(ctx context.Context, exportID, userID primitive.ObjectID) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), exportTimeout)
+	defer cancel()
+
+	update := bson.M{}
+	archive, err := s.buildArchive(ctx, userID)
+	if err == nil {
+		fileID := primitive.NewObjectID()
+		err = s.exportBucket.UploadFromStreamWithID(fileID, fmt.Sprintf("sautii-export-%s.zip", exportID.Hex()), bytes.NewReader(archive))
+		now := time.Now()
+		expiresAt := now.Add(exportRetention)
+		update = bson.M{
+			"status":      models.ExportReady,
+			"fileId":      fileID,
+			"size":        len(archive),
+			"completedAt": now,
+			"expiresAt":   expiresAt,
+		}
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("data export failed", "export_id", exportID.Hex(), "error", err)
+		update = bson.M{"status": models.ExportFailed, "error": "export could not be generated", "completedAt": time.Now()}
+	}
+
+	if _, err := s.exportCollection.UpdateOne(ctx, bson.M{"_id": exportID}, bson.M{"$set": update}); err != nil {
+		logging.FromContext(ctx).Error("failed to update data export", "export_id", exportID.Hex(), "error", err)
+		return
+	}
+
+	if update["status"] == models.ExportReady {
+		if user, err := s.authService.GetUserByID(ctx, userID); err == nil {
+			if err := s.emailService.SendExportReadyEmail(ctx, user.Email, user.Username); err != nil {
+				logging.FromContext(ctx).Error("failed to send export email", "export_id", exportID.Hex(), "error", err)
+			}
+		}
+	}
+}
+
+// Records included in an export.
+type exportProfile struct {
+	ID              primitive.ObjectID   `json:"id"`
+	Email           string               `json:"email"`
+	Username        string               `json:"username"`
+	Role            string               `json:"role"`
+	IsVerified      bool                 `json:"isVerified"`
+	VerifiedAt      *time.Time           `json:"verifiedAt,omitempty"`
+	ProfilePicture  string               `json:"profilePicture,omitempty"`
+	Location        *models.Location     `json:"location,omitempty"`
+	JurisdictionIDs []primitive.ObjectID `json:"jurisdictionIds,omitempty"`
+	MFAEnabled      bool                 `json:"mfaEnabled"`
+	Identities      []exportIdentity     `json:"linkedIdentities,omitempty"`
+	CreatedAt       time.Time            `json:"createdAt"`
+	UpdatedAt       time.Time            `json:"updatedAt"`
+}
+
+type exportIdentity struct {
+	Provider string    `json:"provider"`
+	Email    string    `json:"email,omitempty"`
+	LinkedAt time.Time `json:"linkedAt"`
+}
+
+type exportComment struct {
+	IssueID    primitive.ObjectID `json:"issueId"`
+	IssueTitle string             `json:"issueTitle"`
+	Content    string             `json:"content"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+}
+
+type exportVote struct {
+	IssueID    primitive.ObjectID `json:"issueId"`
+	IssueTitle string             `json:"issueTitle"`
+	Vote       string             `json:"vote"`
+}
+
+type exportSession struct {
+	ID         primitive.ObjectID `json:"id"`
+	UserAgent  string             `json:"userAgent,omitempty"`
+	IP         string             `json:"ip,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	LastUsedAt time.Time          `json:"lastUsedAt"`
+	ExpiresAt  time.Time          `json:"expiresAt"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty"`
+}
+
+type exportConversation struct {
+	models.Conversation
+	Messages []models.Message `json:"messages"`
+}
+
+func (s *PrivacyService) buildArchive(ctx context.Context, userID primitive.ObjectID) ([]byte, error) {
+	user, err := s.authService.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := exportProfile{
+		ID:              user.ID,
+		Email:           user.Email,
+		Username:        user.Username,
+		Role:            user.Role,
+		IsVerified:      user.IsVerified,
+		VerifiedAt:      user.VerifiedAt,
+		ProfilePicture:  user.ProfilePicture,
+		Location:        user.Location,
+		JurisdictionIDs: user.JurisdictionIDs,
+		MFAEnabled:      user.MFAEnabled(),
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+	for _, identity := range user.Identities {
+		profile.Identities = append(profile.Identities, exportIdentity{
+			Provider: identity.Provider,
+			Email:    identity.Email,
+			LinkedAt: identity.LinkedAt,
+		})
+	}
+
+	issues, comments, votes, err := s.issueActivity(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.sessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	conversations, err := s.conversations(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	archive := zip.NewWriter(buf)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"issues.json", issues},
+		{"comments.json", comments},
+		{"votes.json", votes},
+		{"sessions.json", sessions},
+		{"conversations.json", conversations},
+	}
+	for _, file := range files {
+		w, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// issueActivity returns the issues the user created, without other people's
+// votes and comments, which are not the user's personal data, and the user's
+// own comments and votes on any issue.
+func (s *PrivacyService) issueActivity(ctx context.Context, userID primitive.ObjectID) ([]models.Issue, []exportComment, []exportVote, error) {
+	issues := []models.Issue{}
+	comments := []exportComment{}
+	votes := []exportVote{}
+
+	query := repository.IssueQuery{Participant: userID, Limit: exportPageSize}
+	for {
+		page, err := s.issues.Search(ctx, query)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to list issues: %v", err)
+		}
+
+		for _, issue := range page.Issues {
+			for _, comment := range issue.Comments {
+				if comment.CreatedBy == userID {
+					comments = append(comments, exportComment{
+						IssueID:    issue.ID,
+						IssueTitle: issue.Title,
+						Content:    comment.Content,
+						CreatedAt:  comment.CreatedAt,
+						UpdatedAt:  comment.UpdatedAt,
+					})
+				}
+			}
+
+			for _, id := range issue.Votes.Up {
+				if id == userID {
+					votes = append(votes, exportVote{IssueID: issue.ID, IssueTitle: issue.Title, Vote: "up"})
+				}
+			}
+			for _, id := range issue.Votes.Down {
+				if id == userID {
+					votes = append(votes, exportVote{IssueID: issue.ID, IssueTitle: issue.Title, Vote: "down"})
+				}
+			}
+
+			if issue.CreatedBy == userID {
+				issue.Comments = nil
+				issue.Votes.Up = nil
+				issue.Votes.Down = nil
+				issues = append(issues, issue)
+			}
+		}
+
+		if len(page.Issues) < exportPageSize {
+			return issues, comments, votes, nil
+		}
+		query.Skip += exportPageSize
+	}
+}
+
+func (s *PrivacyService) sessions(ctx context.Context, userID primitive.ObjectID) ([]exportSession, error) {
+	// Each session is the newest token of its family
+	tokens, err := s.tokens.RefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	sessions := []exportSession{}
+	for _, token := range tokens {
+		if seen[token.FamilyID] {
+			continue
+		}
+		seen[token.FamilyID] = true
+		sessions = append(sessions, exportSession{
+			ID:         token.FamilyID,
+			UserAgent:  token.UserAgent,
+			IP:         token.IP,
+			CreatedAt:  token.FamilyID.Timestamp(),
+			LastUsedAt: token.LastUsedAt,
+			ExpiresAt:  token.ExpiresAt,
+			RevokedAt:  token.RevokedAt,
+		})
+	}
+	return sessions, nil
+}
+
+// conversations returns the threads the user started as a citizen.
+func (s *PrivacyService) conversations(ctx context.Context, userID primitive.ObjectID) ([]exportConversation, error) {
+	cursor, err := s.conversationCollection.Find(ctx, bson.M{"citizenId": userID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var conversations []models.Conversation
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return nil, err
+	}
+
+	result := []exportConversation{}
+	for _, conversation := range conversations {
+		opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+		cursor, err := s.messageCollection.Find(ctx, bson.M{"conversationId": conversation.ID}, opts)
+		if err != nil {
+			return nil, err
+		}
+		messages := []models.Message{}
+		if err := cursor.All(ctx, &messages); err != nil {
+			return nil, err
+		}
+		result = append(result, exportConversation{Conversation: conversation, Messages: messages})
+	}
+	return result, nil
+}
+
+// StartExportCleanup periodically deletes expired export archives and fails
+// exports abandoned by an instance that stopped. When ctx is cancelled it
+// waits for exports in progress and returns.
+func (s *PrivacyService) StartExportCleanup(ctx context.Context) {
+	ticker := time.NewTicker(exportSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.exports.Wait()
+			return
+		case <-ticker.C:
+			if err := s.deleteExports(ctx, bson.M{"expiresAt": bson.M{"$lt": time.Now()}}); err != nil {
+				logging.FromContext(ctx).Error("export cleanup failed", "error", err)
+			}
+			_, err := s.exportCollection.UpdateMany(ctx,
+				bson.M{"status": models.ExportPending, "requestedAt": bson.M{"$lt": time.Now().Add(-exportTimeout)}},
+				bson.M{"$set": bson.M{"status": models.ExportFailed, "error": "export could not be generated", "completedAt": time.Now()}},
+			)
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to expire stuck exports", "error", err)
+			}
+		}
+	}
+}
+
+func (s *PrivacyService) deleteExports(ctx context.Context, filter bson.M) error {
+	cursor, err := s.exportCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var exports []models.DataExport
+	if err := cursor.All(ctx, &exports); err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if export.FileID != nil {
+			if err := s.exportBucket.DeleteContext(ctx, *export.FileID); err != nil && err != gridfs.ErrFileNotFound {
+				return err
+			}
+		}
+		if _, err := s.exportCollection.DeleteOne(ctx, bson.M{"_id": export.ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAccount erases the user's account. Issues and comments they wrote
+// stay public but are no longer attributed to them; votes, sessions, exports
+// and messages they sent as a citizen are removed. The user must confirm with
+// their password, or with a token emailed to them if they have none, and a
+// second-factor code when MFA is enabled.
+func (s *PrivacyService) DeleteAccount(ctx context.Context, userID primitive.ObjectID, password, code, token, ip string) error {
+	user, err := s.authService.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.confirmDeletion(ctx, user, password, code, token); err != nil {
+		return err
+	}
+
+	// Log out everywhere before anything else so a failure part-way leaves
+	// no usable sessions
+	if err := s.tokens.DeleteUserTokens(ctx, userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	s.authService.InvalidateAccess(userID)
+
+	removal, err := s.issues.RemoveUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	counts := map[string]interface{}{
+		"issuesAnonymised":             removal.Issues,
+		"issuesWithCommentsAnonymised": removal.Commented,
+		"votesRemoved":                 removal.Voted,
+		"issuesUnassigned":             removal.Assigned,
+	}
+
+	if err := s.deleteConversations(ctx, userID); err != nil {
+		return err
+	}
+
+	// Messages sent on behalf of an office belong to the office's record
+	if _, err := s.messageCollection.UpdateMany(ctx, bson.M{"senderId": userID, "fromOffice": true}, bson.M{"$set": bson.M{"senderId": models.DeletedUserID}}); err != nil {
+		return fmt.Errorf("failed to anonymise office messages: %v", err)
+	}
+	if _, err := s.representativeCollection.UpdateMany(ctx, bson.M{"staffIds": userID}, bson.M{"$pull": bson.M{"staffIds": userID}}); err != nil {
+		return fmt.Errorf("failed to remove staff access: %v", err)
+	}
+	if _, err := s.representativeCollection.DeleteMany(ctx, bson.M{"userId": userID}); err != nil {
+		return fmt.Errorf("failed to delete representative profile: %v", err)
+	}
+
+	if err := s.deleteExports(ctx, bson.M{"userId": userID}); err != nil {
+		return fmt.Errorf("failed to delete exports: %v", err)
+	}
+	if err := s.loginAttempts.Delete(ctx, accountAttemptKey(user.Email)); err != nil {
+		return fmt.Errorf("failed to delete login attempts: %v", err)
+	}
+
+	if err := s.users.Delete(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete account: %v", err)
+	}
+	s.authService.InvalidateAccess(userID)
+
+	// The audit entry keeps only the account ID, not the personal data
+	return s.auditService.Record(ctx, &models.AuditEntry{
+		Action:       AuditAccountDeleted,
+		ActorID:      userID,
+		TargetUserID: userID,
+		IP:           ip,
+		Details:      counts,
+	})
+}
+
+// confirmDeletion checks that the user really wants their account deleted.
+// Accounts created through an identity provider have no password, and a
+// stolen session alone must not be enough to delete them, so they confirm
+// through a link emailed to them. It returns ErrDeletionConfirmationSent
+// after sending the link.
+func (s *PrivacyService) confirmDeletion(ctx context.Context, user *models.User, password, code, token string) error {
+	if user.Password == "" && token == "" {
+		return s.sendDeletionConfirmation(ctx, user)
+	}
+
+	if user.Password != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+			return errors.New("password is incorrect")
+		}
+	}
+	if user.MFAEnabled() {
+		if err := s.authService.verifySecondFactor(ctx, user, code); err != nil {
+			return err
+		}
+	}
+
+	if user.Password == "" {
+		stored, err := s.authService.consumeActionToken(ctx, token, TokenPurposeAccountDeletion)
+		if err != nil || stored.UserID != user.ID {
+			return errors.New("invalid or expired confirmation token")
+		}
+	}
+	return nil
+}
+
+func (s *PrivacyService) sendDeletionConfirmation(ctx context.Context, user *models.User) error {
+	// A link sent moments ago is still on its way
+	recent, err := s.tokens.CountActionTokens(ctx, user.ID, TokenPurposeAccountDeletion, time.Now().Add(-accountDeletionWait))
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrDeletionConfirmationSent
+	}
+
+	token, err := s.authService.issueActionToken(ctx, user.ID, TokenPurposeAccountDeletion, accountDeletionTokenTTL)
+	if err != nil {
+		return err
+	}
+	if err := s.emailService.SendAccountDeletionEmail(ctx, user.Email, user.Username, token); err != nil {
+		return err
+	}
+	return ErrDeletionConfirmationSent
+}
+
+// deleteConversations removes the threads the user started as a citizen,
+// including their attachments.
+func (s *PrivacyService) deleteConversations(ctx context.Context, userID primitive.ObjectID) error {
+	cursor, err := s.conversationCollection.Find(ctx, bson.M{"citizenId": userID}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var conversations []models.Conversation
+	if err := cursor.All(ctx, &conversations); err != nil {
+		return err
+	}
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID
+	}
+
+	// Attachments are stored in the "attachments" GridFS bucket
+	bucket, err := gridfs.NewBucket(s.messageCollection.Database(), options.GridFSBucket().SetName("attachments"))
+	if err != nil {
+		return err
+	}
+	files, err := bucket.FindContext(ctx, bson.M{"metadata.conversationId": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var stored []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := files.All(ctx, &stored); err != nil {
+		return err
+	}
+	for _, file := range stored {
+		if err := bucket.DeleteContext(ctx, file.ID); err != nil && err != gridfs.ErrFileNotFound {
+			return err
+		}
+	}
+
+	if _, err := s.messageCollection.DeleteMany(ctx, bson.M{"conversationId": bson.M{"$in": ids}}); err != nil {
+		return fmt.Errorf("failed to delete messages: %v", err)
+	}
+	if _, err := s.conversationCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return fmt.Errorf("failed to delete conversations: %v", err)
+	}
+	return nil
+}
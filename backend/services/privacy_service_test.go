@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportReadsIssuesAndSessionsFromStore(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	privacyService := &PrivacyService{issues: store.Issues, tokens: store.Tokens}
+
+	user, neighbour := primitive.NewObjectID(), primitive.NewObjectID()
+	own := &models.Issue{ID: primitive.NewObjectID(), Title: "Broken streetlight", CreatedBy: user}
+	other := &models.Issue{ID: primitive.NewObjectID(), Title: "Pothole", CreatedBy: neighbour}
+	unrelated := &models.Issue{ID: primitive.NewObjectID(), Title: "Stray dogs", CreatedBy: neighbour}
+	for _, issue := range []*models.Issue{own, other, unrelated} {
+		if err := store.Issues.Create(ctx, issue); err != nil {
+			t.Fatal(err)
+		}
+	}
+	comments := []struct {
+		issue  primitive.ObjectID
+		author primitive.ObjectID
+		text   string
+	}{
+		{own.ID, neighbour, "Same on my street"},
+		{other.ID, user, "Still there"},
+		{unrelated.ID, neighbour, "Seen them too"},
+	}
+	for _, c := range comments {
+		if err := store.Issues.AddComment(ctx, c.issue, &models.Comment{Content: c.text, CreatedBy: c.author}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, vote := range []struct {
+		issue, voter primitive.ObjectID
+		up           bool
+	}{{own.ID, neighbour, true}, {other.ID, user, false}, {unrelated.ID, neighbour, true}} {
+		if err := store.Issues.Vote(ctx, vote.issue, vote.voter, vote.up); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	issues, exportedComments, votes, err := privacyService.issueActivity(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].ID != own.ID {
+		t.Fatalf("issues = %+v, want only the user's own", issues)
+	}
+	if len(issues[0].Comments) != 0 || len(issues[0].Votes.Up) != 0 {
+		t.Errorf("exported issue keeps other people's activity: %+v", issues[0])
+	}
+	if len(exportedComments) != 1 || exportedComments[0].Content != "Still there" || exportedComments[0].IssueID != other.ID {
+		t.Errorf("comments = %+v", exportedComments)
+	}
+	if len(votes) != 1 || votes[0].IssueID != other.ID || votes[0].Vote != "down" {
+		t.Errorf("votes = %+v", votes)
+	}
+
+	// A session is listed once, as its newest token
+	session := primitive.NewObjectID()
+	for i, hash := range []string{"first", "rotated"} {
+		createdAt := time.Now().Add(time.Duration(i-2) * time.Minute)
+		err := store.Tokens.CreateRefreshToken(ctx, &models.RefreshToken{
+			UserID:     user,
+			TokenHash:  hash,
+			FamilyID:   session,
+			UserAgent:  hash,
+			ExpiresAt:  time.Now().Add(time.Hour),
+			LastUsedAt: createdAt,
+			CreatedAt:  createdAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions, err := privacyService.sessions(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != session || sessions[0].UserAgent != "rotated" {
+		t.Errorf("sessions = %+v", sessions)
+	}
+}
+
+func TestDeleteAccountConfirmation(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	authService, _ := newTestAuthService(t, store)
+	privacyService := &PrivacyService{users: store.Users, tokens: store.Tokens, authService: authService, emailService: authService.emailService}
+
+	withPassword, err := authService.Register(ctx, "amina@example.com", "amina", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidcOnly := &models.User{ID: primitive.NewObjectID(), Email: "baraka@example.com", Username: "baraka"}
+	if err := store.Users.Create(ctx, oidcOnly); err != nil {
+		t.Fatal(err)
+	}
+
+	// Accounts with a password confirm with it; tokens don't replace it
+	passwordToken, err := authService.issueActionToken(ctx, withPassword.ID, TokenPurposeAccountDeletion, accountDeletionTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := privacyService.confirmDeletion(ctx, withPassword, "", "", passwordToken); err == nil {
+		t.Error("deletion confirmed with a token instead of the password")
+	}
+	if err := privacyService.confirmDeletion(ctx, withPassword, "correct horse", "", ""); err != nil {
+		t.Errorf("confirmDeletion with the password = %v", err)
+	}
+
+	// Accounts without one are emailed a link, at most once a minute
+	for i := 0; i < 2; i++ {
+		if err := privacyService.confirmDeletion(ctx, oidcOnly, "", "", ""); !errors.Is(err, ErrDeletionConfirmationSent) {
+			t.Fatalf("confirmDeletion without a token = %v, want ErrDeletionConfirmationSent", err)
+		}
+	}
+	sent, err := store.Tokens.CountActionTokens(ctx, oidcOnly.ID, TokenPurposeAccountDeletion, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 1 {
+		t.Errorf("%d confirmation links sent, want 1", sent)
+	}
+
+	for name, token := range map[string]string{
+		"unknown token":         "not-a-token",
+		"another user's token":  passwordToken,
+		"token for another use": mustIssueActionToken(t, authService, oidcOnly.ID, TokenPurposePasswordReset),
+		"password instead":      "",
+	} {
+		if err := privacyService.confirmDeletion(ctx, oidcOnly, "anything", "", token); err == nil {
+			t.Errorf("confirmDeletion with the %s = %v, want it refused", name, err)
+		}
+	}
+
+	token := mustIssueActionToken(t, authService, oidcOnly.ID, TokenPurposeAccountDeletion)
+	if err := privacyService.confirmDeletion(ctx, oidcOnly, "", "", token); err != nil {
+		t.Fatalf("confirmDeletion with the emailed token = %v", err)
+	}
+	if err := privacyService.confirmDeletion(ctx, oidcOnly, "", "", token); err == nil {
+		t.Error("confirmation token used twice")
+	}
+}
+
+func mustIssueActionToken(t *testing.T, authService *AuthService, userID primitive.ObjectID, purpose string) string {
+	t.Helper()
+	token, err := authService.issueActionToken(context.Background(), userID, purpose, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/arnoldadero/sautii/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimitPolicy is a token bucket that holds Limit tokens and refills
+// completely over Period.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// RateLimitResult describes the bucket after a request was counted.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// RateLimitStore keeps token buckets. The in-memory store suits a single
+// instance; the Mongo store shares buckets between instances.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+}
+
+func (p RateLimitPolicy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+func (p RateLimitPolicy) result(tokens float64, allowed bool) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(p.Limit) - tokens) / p.rate() * float64(time.Second)),
+	}
+	if tokens < 1 {
+		result.RetryAfter = time.Duration((1 - tokens) / p.rate() * float64(time.Second))
+	}
+	return result
+}
+
+// RateLimitPolicies returns the policy for each route group in cfg.
+func RateLimitPolicies(cfg config.Limits) map[string]RateLimitPolicy {
+	policies := make(map[string]RateLimitPolicy)
+	for name, limit := range cfg.RateLimits() {
+		policies[name] = RateLimitPolicy{Name: name, Limit: limit.Limit, Period: limit.Period}
+	}
+	return policies
+}
+
+// NewRateLimitStore creates the store selected by cfg.RateLimitStore:
+// "memory" or "mongo".
+func NewRateLimitStore(db *mongo.Database, cfg config.Limits) (RateLimitStore, error) {
+	switch store := cfg.RateLimitStore; store {
+	case "", "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "mongo":
+		return NewMongoRateLimitStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q", store)
+	}
+}
+
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(policy.Limit), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(policy.Limit), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*policy.rate())
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+
+	result := policy.result(bucket.tokens, allowed)
+	bucket.fullAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops buckets that have refilled, at most once a minute.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.After(bucket.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+type MongoRateLimitStore struct {
+	bucketCollection *mongo.Collection
+}
+
+func NewMongoRateLimitStore(db *mongo.Database) *MongoRateLimitStore {
+	return &MongoRateLimitStore{bucketCollection: db.Collection("rate_limits")}
+}
+
+// EnsureRateLimitIndexes expires buckets once they have refilled.
+func EnsureRateLimitIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("rate_limits").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create rate limit indexes: %v", err)
+	}
+	return nil
+}
+
+// Take refills and takes from the bucket in a single atomic update.
+func (s *MongoRateLimitStore) Take(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	now := time.Now()
+	limit := float64(policy.Limit)
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updatedAt", now}}}}, 1000}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{limit, bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$tokens", limit}},
+				bson.M{"$multiply": bson.A{elapsed, policy.rate()}},
+			}}}},
+			"updatedAt": now,
+		}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{
+			"tokens":    bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+			"expiresAt": now.Add(policy.Period),
+		}}},
+	}
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	err := s.bucketCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		pipeline,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&bucket)
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("failed to update rate limit: %v", err)
+	}
+
+	return policy.result(bucket.Tokens, bucket.Allowed), nil
+}
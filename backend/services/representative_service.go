@@ -0,0 +1,336 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// resolvedStatuses are the issue statuses counted as resolved.
+var resolvedStatuses = []string{"resolved", "closed"}
+
+type RepresentativeService struct {
+	representativeCollection *mongo.Collection
+	issues                   repository.IssueRepository
+	users                    repository.UserRepository
+	jurisdictionService      *JurisdictionService
+	auditService             *AuditService
+}
+
+type RepresentativeFilters struct {
+	Query          string
+	Office         string
+	JurisdictionID primitive.ObjectID
+	// Status selects profiles by verification status. The public directory
+	// always lists verified profiles only; other statuses are for reviewers.
+	Status string
+}
+
+func NewRepresentativeService(db *mongo.Database, store *repository.Store, jurisdictionService *JurisdictionService, auditService *AuditService) *RepresentativeService {
+	return &RepresentativeService{
+		representativeCollection: db.Collection("representatives"),
+		issues:                   store.Issues,
+		users:                    store.Users,
+		jurisdictionService:      jurisdictionService,
+		auditService:             auditService,
+	}
+}
+
+// EnsureRepresentativeIndexes creates the indexes used by the directory. A
+// user account can hold at most one representative profile.
+func EnsureRepresentativeIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("representatives").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "jurisdictionId", Value: 1}, {Key: "verification.status", Value: 1}}},
+		{Keys: bson.D{{Key: "staffIds", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create representative indexes: %v", err)
+	}
+	return nil
+}
+
+// Create registers a representative profile for the user. Profiles start
+// pending and are not listed until verified.
+func (s *RepresentativeService) Create(ctx context.Context, userID primitive.ObjectID, rep *models.Representative) error {
+	if rep.Name == "" || rep.Office == "" || rep.JurisdictionID.IsZero() {
+		return errors.New("name, office and jurisdiction are required")
+	}
+	if rep.TermStart.IsZero() {
+		return errors.New("term start is required")
+	}
+	if rep.TermEnd != nil && !rep.TermEnd.After(rep.TermStart) {
+		return errors.New("term end must be after term start")
+	}
+	if _, err := s.jurisdictionService.Get(ctx, rep.JurisdictionID); err != nil {
+		return err
+	}
+
+	rep.ID = primitive.NewObjectID()
+	rep.UserID = userID
+	rep.Verification = models.Verification{Status: models.VerificationPending}
+	rep.CreatedAt = time.Now()
+	rep.UpdatedAt = time.Now()
+
+	if _, err := s.representativeCollection.InsertOne(ctx, rep); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("this account already has a representative profile")
+		}
+		return fmt.Errorf("failed to create representative: %v", err)
+	}
+	return nil
+}
+
+func (s *RepresentativeService) Get(ctx context.Context, id primitive.ObjectID) (*models.Representative, error) {
+	var rep models.Representative
+	err := s.representativeCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&rep)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("representative not found")
+		}
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// GetByUser returns the representative profile linked to a user account.
+func (s *RepresentativeService) GetByUser(ctx context.Context, userID primitive.ObjectID) (*models.Representative, error) {
+	var rep models.Representative
+	err := s.representativeCollection.FindOne(ctx, bson.M{"userId": userID}).Decode(&rep)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("representative not found")
+		}
+		return nil, err
+	}
+	return &rep, nil
+}
+
+// OfficesOf returns the representatives the user acts for, as the
+// representative or as delegated staff.
+func (s *RepresentativeService) OfficesOf(ctx context.Context, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	filter := bson.M{"$or": []bson.M{{"userId": userID}, {"staffIds": userID}}}
+	cursor, err := s.representativeCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var reps []models.Representative
+	if err := cursor.All(ctx, &reps); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(reps))
+	for i, rep := range reps {
+		ids[i] = rep.ID
+	}
+	return ids, nil
+}
+
+// AddStaff delegates access to the office's messages to another account.
+// Only the representative can manage their staff.
+func (s *RepresentativeService) AddStaff(ctx context.Context, userID, id, staffID primitive.ObjectID) error {
+	rep, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rep.UserID != userID {
+		return ErrForbidden
+	}
+	if staffID == rep.UserID {
+		return errors.New("the representative is already part of the office")
+	}
+
+	if _, err := s.users.GetByID(ctx, staffID); err != nil {
+		if err == repository.ErrNotFound {
+			return errors.New("user not found")
+		}
+		return err
+	}
+
+	_, err = s.representativeCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$addToSet": bson.M{"staffIds": staffID},
+		"$set":      bson.M{"updatedAt": time.Now()},
+	})
+	return err
+}
+
+func (s *RepresentativeService) RemoveStaff(ctx context.Context, userID, id, staffID primitive.ObjectID) error {
+	rep, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rep.UserID != userID {
+		return ErrForbidden
+	}
+
+	_, err = s.representativeCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$pull": bson.M{"staffIds": staffID},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+	return err
+}
+
+// Staff lists the accounts with delegated access to the office.
+func (s *RepresentativeService) Staff(ctx context.Context, userID, id primitive.ObjectID) ([]primitive.ObjectID, error) {
+	rep, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if rep.UserID != userID {
+		return nil, ErrForbidden
+	}
+	if rep.StaffIDs == nil {
+		return []primitive.ObjectID{}, nil
+	}
+	return rep.StaffIDs, nil
+}
+
+// List searches the public directory.
+func (s *RepresentativeService) List(ctx context.Context, filters RepresentativeFilters) ([]models.Representative, error) {
+	status := filters.Status
+	if status == "" {
+		status = models.VerificationVerified
+	}
+
+	query := bson.M{"verification.status": status}
+	if filters.Query != "" {
+		query["name"] = bson.M{"$regex": regexp.QuoteMeta(filters.Query), "$options": "i"}
+	}
+	if filters.Office != "" {
+		query["office"] = bson.M{"$regex": regexp.QuoteMeta(filters.Office), "$options": "i"}
+	}
+	if !filters.JurisdictionID.IsZero() {
+		query["jurisdictionId"] = filters.JurisdictionID
+	}
+
+	return s.find(ctx, query)
+}
+
+// Locate answers "who represents this point?" with the verified, serving
+// representatives of every jurisdiction containing it.
+func (s *RepresentativeService) Locate(ctx context.Context, lat, lng float64) ([]models.Representative, error) {
+	jurisdictionIDs, err := s.jurisdictionService.Resolve(ctx, &models.Location{Lat: lat, Lng: lng})
+	if err != nil {
+		return nil, err
+	}
+	if len(jurisdictionIDs) == 0 {
+		return []models.Representative{}, nil
+	}
+
+	now := time.Now()
+	return s.find(ctx, bson.M{
+		"jurisdictionId":      bson.M{"$in": jurisdictionIDs},
+		"verification.status": models.VerificationVerified,
+		"termStart":           bson.M{"$lte": now},
+		"$or": []bson.M{
+			{"termEnd": bson.M{"$exists": false}},
+			{"termEnd": bson.M{"$gt": now}},
+		},
+	})
+}
+
+func (s *RepresentativeService) find(ctx context.Context, query bson.M) ([]models.Representative, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetLimit(200)
+	cursor, err := s.representativeCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list representatives: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	reps := []models.Representative{}
+	if err := cursor.All(ctx, &reps); err != nil {
+		return nil, fmt.Errorf("failed to decode representatives: %v", err)
+	}
+	return reps, nil
+}
+
+// Review sets the verification status of a profile.
+func (s *RepresentativeService) Review(ctx context.Context, actor Actor, id primitive.ObjectID, status, notes string) error {
+	if !actor.Can(models.PermVerifyRepresentatives) {
+		return ErrForbidden
+	}
+	if status != models.VerificationVerified && status != models.VerificationRejected && status != models.VerificationPending {
+		return fmt.Errorf("unknown verification status %q", status)
+	}
+
+	rep, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rep.UserID == actor.ID {
+		return errors.New("you cannot review your own profile")
+	}
+
+	now := time.Now()
+	_, err = s.representativeCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+		"verification": models.Verification{
+			Status:     status,
+			Notes:      notes,
+			ReviewedBy: actor.ID,
+			ReviewedAt: &now,
+		},
+		"updatedAt": now,
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to update verification: %v", err)
+	}
+
+	return s.auditService.Record(ctx, &models.AuditEntry{
+		Action:       AuditRepresentativeReviewed,
+		ActorID:      actor.ID,
+		TargetUserID: rep.UserID,
+		IP:           actor.IP,
+		Details: map[string]interface{}{
+			"representativeId": id,
+			"previousStatus":   rep.Verification.Status,
+			"status":           status,
+			"notes":            notes,
+		},
+	})
+}
+
+// Stats computes the representative's response statistics: how many issues in
+// their jurisdiction were resolved, how many the representative commented
+// on, and how long the first comment took on average. The counting is done
+// by the database.
+func (s *RepresentativeService) Stats(ctx context.Context, id primitive.ObjectID) (*models.RepresentativeStats, error) {
+	rep, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := repository.IssueQuery{
+		JurisdictionScoped: true,
+		JurisdictionIDs:    []primitive.ObjectID{rep.JurisdictionID},
+		StartDate:          &rep.TermStart,
+	}
+	counts, err := s.issues.ResponseStats(ctx, query, resolvedStatuses, rep.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute statistics: %v", err)
+	}
+
+	stats := &models.RepresentativeStats{
+		RepresentativeID: rep.ID,
+		IssuesInArea:     counts.Issues,
+		IssuesResolved:   counts.Resolved,
+		IssuesResponded:  counts.Responded,
+	}
+	if counts.Issues > 0 {
+		stats.ResponseRate = float64(counts.Responded) / float64(counts.Issues)
+	}
+	if counts.Responded > 0 {
+		stats.AverageResponseTime = counts.ResponseTime.Hours() / float64(counts.Responded)
+	}
+	return stats, nil
+}
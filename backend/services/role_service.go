@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrForbidden = errors.New("insufficient permissions")
+
+// Actor is the authenticated user performing an action.
+type Actor struct {
+	ID              primitive.ObjectID
+	Role            string
+	IP              string
+	JurisdictionIDs []primitive.ObjectID
+}
+
+func (a Actor) Can(permission models.Permission) bool {
+	return models.HasPermission(a.Role, permission)
+}
+
+// JurisdictionScoped reports whether the actor's issue management is limited
+// to their jurisdictions, as it is for officials.
+func (a Actor) JurisdictionScoped() bool {
+	return a.Can(models.PermUpdateStatus) && !a.Can(models.PermAllJurisdictions)
+}
+
+// InJurisdiction reports whether the actor may manage something located in
+// any of the given jurisdictions.
+func (a Actor) InJurisdiction(ids []primitive.ObjectID) bool {
+	if !a.JurisdictionScoped() {
+		return true
+	}
+	return intersects(a.JurisdictionIDs, ids)
+}
+
+func intersects(a, b []primitive.ObjectID) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type RoleService struct {
+	jurisdictionCollection *mongo.Collection
+	authService            *AuthService
+	auditService           *AuditService
+}
+
+func NewRoleService(db *mongo.Database, authService *AuthService, auditService *AuditService) *RoleService {
+	return &RoleService{
+		jurisdictionCollection: db.Collection("jurisdictions"),
+		authService:            authService,
+		auditService:           auditService,
+	}
+}
+
+// officialRoles can be managed by agency admins; every other role change
+// needs PermManageRoles.
+var officialRoles = map[string]bool{
+	models.RoleCitizen:   true,
+	models.RoleModerator: true,
+	models.RoleOfficial:  true,
+}
+
+func (s *RoleService) canChange(actor Actor, from, to string) bool {
+	if actor.Can(models.PermManageRoles) {
+		return true
+	}
+	return actor.Can(models.PermManageOfficials) && officialRoles[from] && officialRoles[to]
+}
+
+// GrantRole gives the user a role. The change applies to the user's next
+// request and is recorded in the audit log.
+func (s *RoleService) GrantRole(ctx context.Context, actor Actor, userID primitive.ObjectID, role, reason string) error {
+	return s.setRole(ctx, actor, userID, role, reason, AuditRoleGranted)
+}
+
+// RevokeRole returns the user to the citizen role.
+func (s *RoleService) RevokeRole(ctx context.Context, actor Actor, userID primitive.ObjectID, reason string) error {
+	return s.setRole(ctx, actor, userID, models.RoleCitizen, reason, AuditRoleRevoked)
+}
+
+func (s *RoleService) setRole(ctx context.Context, actor Actor, userID primitive.ObjectID, role, reason, action string) error {
+	if !models.ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if actor.ID == userID {
+		return errors.New("you cannot change your own role")
+	}
+
+	user, err := s.authService.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	previous := models.NormalizeRole(user.Role)
+	if !s.canChange(actor, previous, role) {
+		return ErrForbidden
+	}
+	if previous == role {
+		return nil
+	}
+
+	if err := s.authService.UpdateUser(ctx, userID, repository.UserUpdate{Role: &role}); err != nil {
+		return fmt.Errorf("failed to update role: %v", err)
+	}
+
+	return s.auditService.Record(ctx, &models.AuditEntry{
+		Action:       action,
+		ActorID:      actor.ID,
+		TargetUserID: userID,
+		IP:           actor.IP,
+		Details: map[string]interface{}{
+			"previousRole": previous,
+			"newRole":      role,
+			"reason":       reason,
+		},
+	})
+}
+
+// AssignJurisdictions sets the jurisdictions an official manages.
+func (s *RoleService) AssignJurisdictions(ctx context.Context, actor Actor, userID primitive.ObjectID, jurisdictionIDs []primitive.ObjectID) error {
+	if !actor.Can(models.PermManageOfficials) {
+		return ErrForbidden
+	}
+
+	user, err := s.authService.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	count, err := s.jurisdictionCollection.CountDocuments(ctx, bson.M{"_id": bson.M{"$in": jurisdictionIDs}})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(jurisdictionIDs) {
+		return errors.New("unknown jurisdiction")
+	}
+
+	update := repository.UserUpdate{JurisdictionIDs: jurisdictionIDs}
+	if update.JurisdictionIDs == nil {
+		update.JurisdictionIDs = []primitive.ObjectID{}
+	}
+	if err := s.authService.UpdateUser(ctx, userID, update); err != nil {
+		return fmt.Errorf("failed to update jurisdictions: %v", err)
+	}
+
+	return s.auditService.Record(ctx, &models.AuditEntry{
+		Action:       AuditJurisdictionsAssigned,
+		ActorID:      actor.ID,
+		TargetUserID: userID,
+		IP:           actor.IP,
+		Details: map[string]interface{}{
+			"previousJurisdictionIds": user.JurisdictionIDs,
+			"jurisdictionIds":         jurisdictionIDs,
+		},
+	})
+}
+
+// RoleHistory returns the role changes made to a user, newest first.
+func (s *RoleService) RoleHistory(ctx context.Context, userID primitive.ObjectID) ([]models.AuditEntry, error) {
+	return s.auditService.List(ctx, AuditFilter{
+		Actions:      []string{AuditRoleGranted, AuditRoleRevoked, AuditJurisdictionsAssigned},
+		TargetUserID: userID,
+	})
+}
@@ -7,12 +7,13 @@ import (
 	"time"
 
 	"github.com/arnoldadero/sautii/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/arnoldadero/sautii/repository"
+	"github.com/arnoldadero/sautii/tracing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type SearchService struct {
-	issueCollection *mongo.Collection
+	issues repository.IssueRepository
 }
 
 type SearchFilters struct {
@@ -32,6 +33,19 @@ type SearchFilters struct {
 	SortOrder string `json:"sortOrder,omitempty"`
 	Page      int64  `json:"page"`
 	Limit     int64  `json:"limit"`
+
+	// Set through ScopeTo, never from client input
+	scoped          bool
+	jurisdictionIDs []primitive.ObjectID
+}
+
+// ScopeTo limits the search to the actor's jurisdictions if the actor is a
+// jurisdiction-scoped official.
+func (f *SearchFilters) ScopeTo(actor Actor) {
+	if actor.JurisdictionScoped() {
+		f.scoped = true
+		f.jurisdictionIDs = actor.JurisdictionIDs
+	}
 }
 
 type SearchResult struct {
@@ -47,190 +61,108 @@ type Facets struct {
 	Tags       map[string]int64 `json:"tags"`
 }
 
-func NewSearchService(db *mongo.Database) *SearchService {
+func NewSearchService(store *repository.Store) *SearchService {
 	return &SearchService{
-		issueCollection: db.Collection("issues"),
+		issues: store.Issues,
 	}
 }
 
-func (s *SearchService) Search(ctx context.Context, filters SearchFilters) (*SearchResult, error) {
-	pipeline := []bson.M{}
-
-	// Match stage for filtering
-	matchStage := bson.M{}
-	
-	// Full-text search
-	if filters.Query != "" {
-		matchStage["$text"] = bson.M{
-			"$search": filters.Query,
-			"$caseSensitive": false,
-			"$diacriticSensitive": false,
-		}
-	}
-
-	// Category filter
-	if len(filters.Categories) > 0 {
-		matchStage["category"] = bson.M{"$in": filters.Categories}
-	}
+func (s *SearchService) Search(ctx context.Context, filters SearchFilters) (_ *SearchResult, err error) {
+	ctx, span := tracing.Start(ctx, "SearchService.Search")
+	defer tracing.End(span, &err)
 
-	// Priority filter
-	if len(filters.Priorities) > 0 {
-		matchStage["priority"] = bson.M{"$in": filters.Priorities}
+	query := repository.IssueQuery{
+		Text:               filters.Query,
+		Categories:         filters.Categories,
+		Priorities:         filters.Priorities,
+		Statuses:           filters.Statuses,
+		StartDate:          filters.StartDate,
+		EndDate:            filters.EndDate,
+		Tags:               filters.Tags,
+		JurisdictionScoped: filters.scoped,
+		JurisdictionIDs:    filters.jurisdictionIDs,
+		Descending:         strings.ToLower(filters.SortOrder) == "desc",
+		Limit:              filters.Limit,
 	}
 
-	// Status filter
-	if len(filters.Statuses) > 0 {
-		matchStage["status"] = bson.M{"$in": filters.Statuses}
-	}
-
-	// Date range filter
-	if filters.StartDate != nil || filters.EndDate != nil {
-		dateFilter := bson.M{}
-		if filters.StartDate != nil {
-			dateFilter["$gte"] = filters.StartDate
-		}
-		if filters.EndDate != nil {
-			dateFilter["$lte"] = filters.EndDate
-		}
-		matchStage["createdAt"] = dateFilter
-	}
-
-	// Tags filter
-	if len(filters.Tags) > 0 {
-		matchStage["tags"] = bson.M{"$all": filters.Tags}
-	}
-
-	// Location filter
 	if filters.Location != nil {
-		matchStage["location"] = bson.M{
-			"$geoWithin": bson.M{
-				"$centerSphere": []interface{}{
-					[]float64{filters.Location.Lng, filters.Location.Lat},
-					filters.Location.Radius / 6371, // Convert km to radians
-				},
-			},
+		query.Near = &repository.GeoRadius{
+			Lat:      filters.Location.Lat,
+			Lng:      filters.Location.Lng,
+			RadiusKm: filters.Location.Radius,
 		}
 	}
 
-	if len(matchStage) > 0 {
-		pipeline = append(pipeline, bson.M{"$match": matchStage})
+	switch strings.ToLower(filters.SortBy) {
+	case "votes", "priority":
+		query.SortBy = strings.ToLower(filters.SortBy)
+	default:
+		query.SortBy = "date"
 	}
 
-	// Facet stage for aggregating counts
-	facetStage := bson.M{
-		"$facet": bson.M{
-			"categories": []bson.M{
-				{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
-			},
-			"priorities": []bson.M{
-				{"$group": bson.M{"_id": "$priority", "count": bson.M{"$sum": 1}}},
-			},
-			"statuses": []bson.M{
-				{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
-			},
-			"tags": []bson.M{
-				{"$unwind": "$tags"},
-				{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
-			},
-			"total": []bson.M{
-				{"$count": "count"},
-			},
-			"issues": []bson.M{
-				{"$sort": s.getSortStage(filters.SortBy, filters.SortOrder)},
-				{"$skip": (filters.Page - 1) * filters.Limit},
-				{"$limit": filters.Limit},
-			},
-		},
+	if filters.Page > 1 {
+		query.Skip = (filters.Page - 1) * filters.Limit
 	}
 
-	pipeline = append(pipeline, facetStage)
-
-	// Execute aggregation
-	cursor, err := s.issueCollection.Aggregate(ctx, pipeline)
+	result, err := s.issues.Search(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute search: %v", err)
-	}
-	defer cursor.Close(ctx)
-
-	var results []bson.M
-	if err := cursor.All(ctx, &results); err != nil {
-		return nil, fmt.Errorf("failed to decode search results: %v", err)
+		return nil, err
 	}
 
-	if len(results) == 0 {
-		return &SearchResult{
-			Issues: []models.Issue{},
-			Total:  0,
-			Facets: make(map[string]Facets),
-		}, nil
-	}
+	return &SearchResult{
+		Issues: result.Issues,
+		Total:  result.Total,
+		Facets: map[string]Facets{
+			"categories": {Categories: result.Categories},
+			"priorities": {Priorities: result.Priorities},
+			"statuses":   {Statuses: result.Statuses},
+			"tags":       {Tags: result.Tags},
+		},
+	}, nil
+}
 
-	// Process results
-	result := results[0]
-	searchResult := &SearchResult{
-		Issues: make([]models.Issue, 0),
-		Facets: s.processFacets(result),
+// OpenIssueCounts returns the number of issues not yet resolved or closed by
+// status, then category. Categories outside IssueCategories are counted as
+// OTHER.
+func (s *SearchService) OpenIssueCounts(ctx context.Context) (map[string]map[string]int64, error) {
+	// Limit 1 because only the facets are needed
+	all, err := s.issues.Search(ctx, repository.IssueQuery{Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count issues: %v", err)
 	}
 
-	// Process total count
-	if totalArr, ok := result["total"].([]interface{}); ok && len(totalArr) > 0 {
-		if totalDoc, ok := totalArr[0].(bson.M); ok {
-			searchResult.Total = totalDoc["count"].(int64)
+	counts := make(map[string]map[string]int64)
+	for status := range all.Statuses {
+		if isResolved(status) {
+			continue
 		}
-	}
-
-	// Process issues
-	if issues, ok := result["issues"].([]interface{}); ok {
-		for _, issue := range issues {
-			var issueModel models.Issue
-			bsonBytes, _ := bson.Marshal(issue)
-			bson.Unmarshal(bsonBytes, &issueModel)
-			searchResult.Issues = append(searchResult.Issues, issueModel)
+		result, err := s.issues.Search(ctx, repository.IssueQuery{Statuses: []string{status}, Limit: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s issues: %v", status, err)
 		}
+		byCategory := make(map[string]int64)
+		for category, n := range result.Categories {
+			byCategory[knownCategory(category)] += n
+		}
+		counts[status] = byCategory
 	}
-
-	return searchResult, nil
+	return counts, nil
 }
 
-func (s *SearchService) getSortStage(sortBy, sortOrder string) bson.D {
-	order := 1
-	if strings.ToLower(sortOrder) == "desc" {
-		order = -1
-	}
-
-	switch strings.ToLower(sortBy) {
-	case "votes":
-		return bson.D{{Key: "voteCount", Value: order}, {Key: "createdAt", Value: -1}}
-	case "priority":
-		return bson.D{{Key: "priority", Value: order}, {Key: "createdAt", Value: -1}}
-	default: // date
-		return bson.D{{Key: "createdAt", Value: order}}
+func isResolved(status string) bool {
+	for _, resolved := range resolvedStatuses {
+		if status == resolved {
+			return true
+		}
 	}
+	return false
 }
 
-func (s *SearchService) processFacets(result bson.M) map[string]Facets {
-	facets := make(map[string]Facets)
-	
-	// Helper function to process facet counts
-	processFacetCounts := func(facetName string) map[string]int64 {
-		counts := make(map[string]int64)
-		if facetArr, ok := result[facetName].([]interface{}); ok {
-			for _, item := range facetArr {
-				if itemMap, ok := item.(bson.M); ok {
-					id := itemMap["_id"].(string)
-					count := itemMap["count"].(int64)
-					counts[id] = count
-				}
-			}
-		}
-		return counts
+// Reindex rebuilds the search indexes. Searches may fail or be slow while it
+// runs.
+func (s *SearchService) Reindex(ctx context.Context) error {
+	if err := s.issues.Reindex(ctx); err != nil {
+		return fmt.Errorf("failed to rebuild search indexes: %v", err)
 	}
-
-	// Process each facet type
-	facets["categories"] = Facets{Categories: processFacetCounts("categories")}
-	facets["priorities"] = Facets{Priorities: processFacetCounts("priorities")}
-	facets["statuses"] = Facets{Statuses: processFacetCounts("statuses")}
-	facets["tags"] = Facets{Tags: processFacetCounts("tags")}
-
-	return facets
+	return nil
 }
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func seedSearchIssues(t *testing.T, store *repository.Store, ward primitive.ObjectID) {
+	t.Helper()
+	now := time.Now()
+	issues := []models.Issue{
+		{Title: "Burst water main", Category: "INFRASTRUCTURE", Priority: "high", Status: "open", Tags: []string{"water"}, JurisdictionIDs: []primitive.ObjectID{ward}},
+		{Title: "Pothole on Moi Avenue", Category: "INFRASTRUCTURE", Priority: "medium", Status: "in_progress", Tags: []string{"roads"}},
+		{Title: "Clinic out of stock", Category: "HEALTHCARE", Priority: "high", Status: "open", JurisdictionIDs: []primitive.ObjectID{ward}},
+		{Title: "Streetlight fixed", Category: "SAFETY", Priority: "low", Status: "resolved", Tags: []string{"roads"}},
+		{Title: "Stray dogs", Category: "wildlife", Priority: "low", Status: "open"},
+	}
+	for i := range issues {
+		issue := issues[i]
+		issue.ID = primitive.NewObjectID()
+		issue.CreatedAt = now.Add(time.Duration(i) * time.Minute)
+		if err := store.Issues.Create(context.Background(), &issue); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func titles(issues []models.Issue) []string {
+	titles := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		titles = append(titles, issue.Title)
+	}
+	return titles
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	ward := primitive.NewObjectID()
+	seedSearchIssues(t, store, ward)
+	searchService := NewSearchService(store)
+
+	t.Run("filters and facets", func(t *testing.T) {
+		result, err := searchService.Search(ctx, SearchFilters{Categories: []string{"INFRASTRUCTURE"}, SortOrder: "desc", Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Pothole on Moi Avenue", "Burst water main"}; !reflect.DeepEqual(titles(result.Issues), want) {
+			t.Errorf("issues = %v, want %v", titles(result.Issues), want)
+		}
+		if result.Total != 2 {
+			t.Errorf("total = %d, want 2", result.Total)
+		}
+		if got := result.Facets["statuses"].Statuses; got["open"] != 1 || got["in_progress"] != 1 {
+			t.Errorf("status facets = %v", got)
+		}
+		if got := result.Facets["tags"].Tags; got["water"] != 1 || got["roads"] != 1 {
+			t.Errorf("tag facets = %v", got)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		result, err := searchService.Search(ctx, SearchFilters{Page: 2, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Clinic out of stock", "Streetlight fixed"}; !reflect.DeepEqual(titles(result.Issues), want) {
+			t.Errorf("issues = %v, want %v", titles(result.Issues), want)
+		}
+		if result.Total != 5 {
+			t.Errorf("total = %d, want 5", result.Total)
+		}
+	})
+
+	t.Run("scoped to official", func(t *testing.T) {
+		filters := SearchFilters{Limit: 10}
+		filters.ScopeTo(Actor{Role: models.RoleOfficial, JurisdictionIDs: []primitive.ObjectID{ward}})
+		result, err := searchService.Search(ctx, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"Burst water main", "Clinic out of stock"}; !reflect.DeepEqual(titles(result.Issues), want) {
+			t.Errorf("issues = %v, want %v", titles(result.Issues), want)
+		}
+
+		// An official without jurisdictions sees nothing
+		filters = SearchFilters{Limit: 10}
+		filters.ScopeTo(Actor{Role: models.RoleOfficial})
+		result, err = searchService.Search(ctx, filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Total != 0 {
+			t.Errorf("official without jurisdictions found %v", titles(result.Issues))
+		}
+	})
+}
+
+func TestOpenIssueCounts(t *testing.T) {
+	store := repository.NewMemoryStore()
+	seedSearchIssues(t, store, primitive.NewObjectID())
+
+	counts, err := NewSearchService(store).OpenIssueCounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]int64{
+		"open":        {"INFRASTRUCTURE": 1, "HEALTHCARE": 1, "OTHER": 1},
+		"in_progress": {"INFRASTRUCTURE": 1},
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("OpenIssueCounts = %v, want %v", counts, want)
+	}
+}
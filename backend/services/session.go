@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrUnknownSession is returned when the request's session can't be
+// identified, such as for tokens issued without a session ID.
+var ErrUnknownSession = errors.New("current session is unknown, log in again to end other sessions")
+
+// ListSessions returns the user's active sessions, most recently used first.
+// currentSessionID marks the session making the request.
+func (s *AuthService) ListSessions(ctx context.Context, userID primitive.ObjectID, currentSessionID string) ([]models.Session, error) {
+	// Each session's current token is its only active one
+	tokens, err := s.tokens.ActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %v", err)
+	}
+
+	sessions := make([]models.Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, models.Session{
+			ID:         token.FamilyID,
+			UserAgent:  token.UserAgent,
+			IP:         token.IP,
+			LastUsedAt: token.LastUsedAt,
+			// The family ID is created at login
+			CreatedAt: token.FamilyID.Timestamp(),
+			ExpiresAt: token.ExpiresAt,
+			Current:   token.FamilyID.Hex() == currentSessionID,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs out one of the user's sessions.
+func (s *AuthService) RevokeSession(ctx context.Context, userID, sessionID primitive.ObjectID) error {
+	tokens, err := s.tokens.ActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.FamilyID == sessionID {
+			return s.revokeTokens(ctx, repository.RefreshTokenFilter{UserID: userID, FamilyID: sessionID})
+		}
+	}
+	return errors.New("session not found")
+}
+
+// RevokeOtherSessions logs out every session except the current one. It
+// fails with ErrUnknownSession rather than logging out the caller too when
+// the current session can't be identified.
+func (s *AuthService) RevokeOtherSessions(ctx context.Context, userID primitive.ObjectID, currentSessionID string) error {
+	current, err := primitive.ObjectIDFromHex(currentSessionID)
+	if err != nil {
+		return ErrUnknownSession
+	}
+	return s.revokeTokens(ctx, repository.RefreshTokenFilter{UserID: userID, ExceptFamilyID: current})
+}
+
+// RevokeAllSessions logs the user out everywhere.
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID primitive.ObjectID) error {
+	if _, err := s.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+	return s.revokeTokens(ctx, repository.RefreshTokenFilter{UserID: userID})
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	// Secrets are accepted in lower case too
+	if got, _ := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", totpStep(time.Unix(59, 0))); got != "287082" {
+		t.Errorf("code for lower case secret = %s, want 287082", got)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+	codeAt := func(step int64) string {
+		t.Helper()
+		code, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", codeAt(current), 0, current, true},
+		{"one step behind", codeAt(current - 1), 0, current - 1, true},
+		{"one step ahead", codeAt(current + 1), 0, current + 1, true},
+		{"two steps behind", codeAt(current - 2), 0, 0, false},
+		{"two steps ahead", codeAt(current + 2), 0, 0, false},
+		{"spaces ignored", codeAt(current)[:3] + " " + codeAt(current)[3:], 0, current, true},
+		{"replayed step", codeAt(current), current, 0, false},
+		{"earlier step after a later one", codeAt(current - 1), current, 0, false},
+		{"later step after an earlier one", codeAt(current + 1), current, current + 1, true},
+		{"too short", codeAt(current)[:5], 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := validateTOTP(rfc6238Secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("validateTOTP = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arnoldadero/sautii/logging"
+	"github.com/arnoldadero/sautii/models"
+	"github.com/arnoldadero/sautii/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+
+	verificationTokenTTL   = 24 * time.Hour
+	verificationResendWait = time.Minute
+	verificationDailyLimit = 5
+)
+
+// Actions that can be restricted for accounts that have not verified their email.
+const (
+	ActionVote        = "vote"
+	ActionComment     = "comment"
+	ActionCreateIssue = "create_issue"
+	ActionMessage     = "message"
+)
+
+var (
+	ErrTooManyRequests    = errors.New("too many requests, please try again later")
+	ErrVerificationNeeded = errors.New("email verification required")
+)
+
+func generateSecureToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// restrictionSet returns the actions unverified accounts may not perform;
+// "none" restricts nothing.
+func restrictionSet(actions []string) map[string]bool {
+	restrictions := make(map[string]bool)
+	for _, action := range actions {
+		if action != "none" {
+			restrictions[action] = true
+		}
+	}
+	return restrictions
+}
+
+func (s *AuthService) issueActionToken(ctx context.Context, userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateSecureToken()
+	if err != nil {
+		return "", err
+	}
+
+	actionToken := &models.ActionToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.tokens.CreateActionToken(ctx, actionToken); err != nil {
+		return "", fmt.Errorf("failed to store token: %v", err)
+	}
+	return token, nil
+}
+
+// consumeActionToken marks a token as used and returns it. A token can only be
+// consumed once.
+func (s *AuthService) consumeActionToken(ctx context.Context, token, purpose string) (*models.ActionToken, error) {
+	stored, err := s.tokens.GetActionToken(ctx, hashToken(token), purpose)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			return nil, errors.New("invalid token")
+		}
+		return nil, err
+	}
+
+	if stored.UsedAt != nil {
+		return nil, errors.New("token has already been used")
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, errors.New("token expired")
+	}
+
+	now := time.Now()
+	used, err := s.tokens.UseActionToken(ctx, stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, errors.New("token has already been used")
+	}
+
+	stored.UsedAt = &now
+	return stored, nil
+}
+
+func (s *AuthService) sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := s.issueActionToken(ctx, user.ID, TokenPurposeEmailVerification, verificationTokenTTL)
+	if err != nil {
+		return err
+	}
+	return s.emailService.SendVerificationEmail(ctx, user.Email, user.Username, token)
+}
+
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	stored, err := s.consumeActionToken(ctx, token, TokenPurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	verified := true
+	if err := s.UpdateUser(ctx, stored.UserID, repository.UserUpdate{IsVerified: &verified, VerifiedAt: &now}); err != nil {
+		return err
+	}
+
+	// Any other outstanding verification links are no longer needed
+	return s.tokens.UseActionTokens(ctx, stored.UserID, TokenPurposeEmailVerification, now)
+}
+
+// ResendVerification emails a new verification link. Requests are throttled
+// to one per minute and a small number per day.
+func (s *AuthService) ResendVerification(ctx context.Context, userID primitive.ObjectID) error {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.IsVerified {
+		return errors.New("email is already verified")
+	}
+
+	recent, err := s.tokens.CountActionTokens(ctx, userID, TokenPurposeEmailVerification, time.Now().Add(-verificationResendWait))
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return ErrTooManyRequests
+	}
+
+	daily, err := s.tokens.CountActionTokens(ctx, userID, TokenPurposeEmailVerification, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return err
+	}
+	if daily >= verificationDailyLimit {
+		return ErrTooManyRequests
+	}
+
+	return s.sendVerificationEmail(ctx, user)
+}
+
+// CanPerform reports whether the user may perform the given action. Actions
+// listed in UNVERIFIED_RESTRICTIONS require a verified email address.
+func (s *AuthService) CanPerform(ctx context.Context, userID primitive.ObjectID, action string) error {
+	if !s.unverifiedRestrictions[action] {
+		return nil
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.IsVerified {
+		return ErrVerificationNeeded
+	}
+	return nil
+}
+
+// registerVerification issues the first verification email for a new account.
+// Failing to send the email does not fail registration; the user can resend.
+func (s *AuthService) registerVerification(ctx context.Context, user *models.User) {
+	if err := s.sendVerificationEmail(ctx, user); err != nil {
+		logging.FromContext(ctx).Error("failed to send verification email", "target_user_id", user.ID.Hex(), "error", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package tracing sets up OpenTelemetry tracing. Spans start at the router,
+// continue through services and MongoDB commands and end at outbound HTTP
+// calls, and W3C trace context is accepted from callers and passed on.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/arnoldadero/sautii/config"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/arnoldadero/sautii"
+
+// Setup installs the W3C trace context propagator and, when an OTLP
+// endpoint is configured, a tracer provider exporting to it. Without one,
+// spans are not recorded but incoming trace context is still passed on.
+// The returned function flushes pending spans and must be called on
+// shutdown.
+func Setup(ctx context.Context, cfg config.Telemetry) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Other OTEL_EXPORTER_OTLP_* variables, such as headers, are read by
+	// the exporter itself
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimRight(cfg.OTLPEndpoint, "/")+"/v1/traces"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of the one in ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records err on the span, if any, and ends it. It is meant to be
+// deferred with a pointer to the function's named error result.
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
+
+// Transport wraps base so that outbound requests are traced and carry the
+// trace context.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// untracedPaths are polled by orchestrators and Prometheus and would drown
+// out the traces of real requests.
+var untracedPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// Middleware starts a span for each request routed by the router, named
+// after the route template and continuing the caller's trace if the request
+// carries a traceparent header.
+func Middleware(service string) mux.MiddlewareFunc {
+	return otelmux.Middleware(service, otelmux.WithFilter(func(r *http.Request) bool {
+		return !untracedPaths[r.URL.Path]
+	}))
+}
+
+// MongoMonitor returns a command monitor that traces every MongoDB command
+// and then passes the events on to next.
+func MongoMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	traced := otelmongo.NewMonitor()
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			traced.Started(ctx, e)
+			next.Started(ctx, e)
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			traced.Succeeded(ctx, e)
+			next.Succeeded(ctx, e)
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			traced.Failed(ctx, e)
+			next.Failed(ctx, e)
+		},
+	}
+}